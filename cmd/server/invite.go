@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+)
+
+const inviteUsage = `Usage: kotatsu-server invite <command>
+
+Commands:
+  create [-uses N] [-expires DURATION] [-note TEXT]
+              Issue an invite code (single use and non-expiring by default)
+  list        List invites
+  revoke ID   Revoke an invite`
+
+func runInvite(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, inviteUsage)
+		os.Exit(2)
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+	if pending, err := database.PendingMigrations(context.Background()); err != nil || pending > 0 {
+		log.Fatalf("Database is not migrated (pending=%d, err=%v); run \"migrate up\" first", pending, err)
+	}
+	st := sqlstore.New(database)
+
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("invite create", flag.ExitOnError)
+		uses := flags.Int("uses", 1, "number of accounts the code can register")
+		expires := flags.Duration("expires", 0, "lifetime of the code, e.g. 72h (0 means never)")
+		note := flags.String("note", "", "free-form note shown in the invite list")
+		flags.Parse(args[1:])
+		if *uses < 1 {
+			log.Fatalf("-uses must be at least 1")
+		}
+
+		id, err := auth.GenerateSessionID()
+		if err != nil {
+			log.Fatalf("Failed to generate invite: %v", err)
+		}
+		code, codeHash, err := auth.GenerateInviteCode()
+		if err != nil {
+			log.Fatalf("Failed to generate invite: %v", err)
+		}
+		now := time.Now()
+		invite := &model.Invite{ID: id[:12], CodeHash: codeHash, Note: *note, MaxUses: *uses, CreatedAt: now.Unix()}
+		if *expires > 0 {
+			expiresAt := now.Add(*expires).Unix()
+			invite.ExpiresAt = &expiresAt
+		}
+		if err := st.CreateInvite(ctx, invite); err != nil {
+			log.Fatalf("Failed to create invite: %v", err)
+		}
+		fmt.Printf("Invite %s created. Code (shown only once): %s\n", invite.ID, code)
+	case "list":
+		invites, err := st.ListInvites(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list invites: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSES\tCREATED AT\tEXPIRES AT\tSTATUS\tNOTE")
+		now := time.Now().Unix()
+		for _, invite := range invites {
+			status, expiresAt := "active", "never"
+			if invite.ExpiresAt != nil {
+				expiresAt = formatUnix(*invite.ExpiresAt)
+			}
+			switch {
+			case invite.RevokedAt != nil:
+				status = "revoked"
+			case invite.ExpiresAt != nil && *invite.ExpiresAt <= now:
+				status = "expired"
+			case invite.Uses >= invite.MaxUses:
+				status = "used up"
+			}
+			fmt.Fprintf(tw, "%s\t%d/%d\t%s\t%s\t%s\t%s\n", invite.ID, invite.Uses, invite.MaxUses,
+				formatUnix(invite.CreatedAt), expiresAt, status, invite.Note)
+		}
+		tw.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, inviteUsage)
+			os.Exit(2)
+		}
+		err := st.RevokeInvite(ctx, args[1], time.Now().Unix())
+		if errors.Is(err, store.ErrNotFound) {
+			log.Fatalf("No active invite with ID %q", args[1])
+		}
+		if err != nil {
+			log.Fatalf("Failed to revoke invite: %v", err)
+		}
+		fmt.Printf("Invite %s revoked\n", args[1])
+	default:
+		fmt.Fprintln(os.Stderr, inviteUsage)
+		os.Exit(2)
+	}
+}
+
+func formatUnix(seconds int64) string {
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
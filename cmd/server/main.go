@@ -1,39 +1,125 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/api"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/events"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/export"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/logging"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/mail"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/metrics"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/templates"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/webhooks"
 )
 
+const usage = `Usage: kotatsu-server [command]
+
+Commands:
+  serve       Start the HTTP server (the default)
+  migrate     Manage schema migrations
+  invite      Manage invite codes
+  user        Manage accounts
+
+Run migrate, invite or user without arguments for their usage.`
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "invite":
+			runInvite(os.Args[2:])
+			return
+		case "user":
+			runUser(os.Args[2:])
+			return
+		default:
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+	}
+	serve()
+}
+
+func serve() {
+	// Initialize Logging
+	debug := isEnvEnabled("DEBUG", false)
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
+		if debug {
+			logLevel = "debug"
+		}
+	}
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat == "" {
+		logFormat = "text"
+	}
+	if _, err := logging.Setup(os.Stderr, logLevel, logFormat); err != nil {
+		log.Fatalf("Invalid logging settings: %v", err)
+	}
+
 	// Initialize Auth
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is required")
+		fatal("JWT_SECRET environment variable is required")
 	}
 	auth.Init(jwtSecret)
+	auth.AccessTokenTTL = durationEnv("ACCESS_TOKEN_TTL", auth.AccessTokenTTL)
+	auth.RefreshTokenTTL = durationEnv("REFRESH_TOKEN_TTL", auth.RefreshTokenTTL)
+	api.TrustProxyHeaders = isEnvEnabled("TRUST_PROXY_HEADERS", false)
 
 	// Initialize Database
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "data/kotatsu.db"
-	}
-	database, err := db.New(dbPath)
+	database, err := openDatabase()
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", "error", err)
 	}
 	defer database.Close()
 
+	if isEnvEnabled("DB_AUTO_MIGRATE", true) {
+		applied, err := database.MigrateUp(context.Background())
+		if err != nil {
+			fatal("Failed to migrate database", "error", err)
+		}
+		if applied > 0 {
+			slog.Info("Applied database migrations", "count", applied)
+		}
+	} else {
+		pending, err := database.PendingMigrations(context.Background())
+		if err != nil {
+			fatal("Failed to check database migrations", "error", err)
+		}
+		if pending > 0 {
+			fatal("Database has pending migrations; run \"migrate up\" first", "count", pending)
+		}
+	}
+
+	registration, err := registrationPolicyFromEnv()
+	if err != nil {
+		fatal("Invalid registration settings", "error", err)
+	}
+	verification, err := verificationPolicyFromEnv()
+	if err != nil {
+		fatal("Invalid verification settings", "error", err)
+	}
+
 	// Initialize Services
+	st := sqlstore.New(database)
 	mailer := mail.NewSenderFromEnv()
 	templatesMgr := templates.NewManager("templates")
 	baseURL := os.Getenv("BASE_URL")
@@ -41,18 +127,70 @@ func main() {
 		baseURL = "http://localhost:8080"
 	}
 
+	dispatcher := &webhooks.Dispatcher{Store: st, Retention: durationEnv("WEBHOOK_RETENTION", 7*24*time.Hour)}
+	if isEnvEnabled("WEBHOOK_ALLOW_PRIVATE", false) {
+		dispatcher.Client = &http.Client{}
+	}
+
 	// Initialize Handlers
 	authHandler := &api.AuthHandler{
-		DB:        database,
-		Mailer:    mailer,
-		Templates: templatesMgr,
-		BaseURL:   baseURL,
+		Users:               st,
+		Sessions:            st,
+		Invites:             st,
+		Registration:        registration,
+		Verification:        verification,
+		DeletionGracePeriod: durationEnv("ACCOUNT_DELETION_GRACE_PERIOD", 7*24*time.Hour),
+		Mailer:              mailer,
+		Templates:           templatesMgr,
+		BaseURL:             baseURL,
+		Webhooks:            dispatcher,
+	}
+	// Sync events are delivered in-process; deployments with several replicas
+	// plug an events.Broker in here.
+	eventsHub := events.NewHub(nil)
+	syncHandler := &api.SyncHandler{History: st, Library: st, Bookmarks: st, Sessions: st, Events: eventsHub, Webhooks: dispatcher}
+	eventsHandler := &api.EventsHandler{Hub: eventsHub, Sessions: st, KeepAlive: durationEnv("EVENTS_KEEPALIVE", 30*time.Second)}
+	userHandler := &api.UserHandler{Users: st, Sessions: st}
+	backupHandler := &api.BackupHandler{History: st, Library: st, Bookmarks: st, Imports: st, Events: eventsHub}
+	timelineHandler := &api.TimelineHandler{Events: st}
+	statsHandler := &api.StatsHandler{History: st, Stats: st}
+	libraryHandler := &api.LibraryHandler{Library: st}
+	webhookHandler := &api.WebhookHandler{Webhooks: st}
+	adminHandler := &api.AdminHandler{Users: st, Sessions: st, Admin: st, Auth: authHandler}
+	webHandler := &api.WebHandler{
+		Users:         st,
+		Sessions:      st,
+		Library:       st,
+		Events:        st,
+		Auth:          authHandler,
+		Templates:     templatesMgr,
+		SecureCookies: strings.HasPrefix(baseURL, "https://"),
+	}
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = "data/exports"
 	}
-	syncHandler := &api.SyncHandler{DB: database}
-	userHandler := &api.UserHandler{DB: database}
+	exportHandler := &api.ExportHandler{
+		Sources:        export.Sources{Users: st, Sessions: st, History: st, Library: st, Bookmarks: st, ReadingLog: st},
+		Exports:        st,
+		Mailer:         mailer,
+		Templates:      templatesMgr,
+		BaseURL:        baseURL,
+		Dir:            exportDir,
+		TTL:            durationEnv("EXPORT_TTL", 72*time.Hour),
+		AsyncThreshold: intEnv("EXPORT_ASYNC_THRESHOLD", 1000),
+		BuildTimeout:   durationEnv("EXPORT_BUILD_TIMEOUT", 30*time.Minute),
+	}
+
+	// Background Jobs
+	go runPurgeJob(context.Background(), st, exportHandler, durationEnv("PURGE_INTERVAL", time.Hour), durationEnv("SESSION_RETENTION", 30*24*time.Hour))
+	go dispatcher.Run(context.Background(), durationEnv("WEBHOOK_POLL_INTERVAL", 10*time.Second))
 
 	// Initialize Middleware
-	middleware := &api.Middleware{DB: database}
+	middleware := &api.Middleware{Users: st, Sessions: st, Verification: verification, LegacyTokens: isEnvEnabled("ACCEPT_LEGACY_TOKENS", true)}
+	requireSync := func(h http.HandlerFunc) http.Handler {
+		return middleware.AuthMiddleware(middleware.RequireVerifiedEmail(h))
+	}
 
 	// Router
 	mux := http.NewServeMux()
@@ -62,42 +200,219 @@ func main() {
 	mux.HandleFunc("GET /", api.Health)
 
 	mux.HandleFunc("POST /auth", authHandler.Login)
+	mux.HandleFunc("POST /register", authHandler.Register)
+	mux.HandleFunc("POST /auth/refresh", authHandler.Refresh)
+	mux.Handle("POST /auth/logout", middleware.AuthMiddleware(http.HandlerFunc(authHandler.Logout)))
 	mux.HandleFunc("POST /forgot-password", authHandler.ForgotPassword)
 	mux.HandleFunc("POST /reset-password", authHandler.ResetPassword)
 	mux.HandleFunc("GET /deeplink/reset-password", authHandler.ResetPasswordDeeplink)
+	mux.HandleFunc("GET /deeplink/verify-email", authHandler.VerifyEmailDeeplink)
+	mux.HandleFunc("POST /deeplink/verify-email", authHandler.VerifyEmailDeeplink)
+	mux.HandleFunc("GET /deeplink/change-email", authHandler.ChangeEmailDeeplink)
+	mux.HandleFunc("POST /deeplink/change-email", authHandler.ChangeEmailDeeplink)
+	mux.HandleFunc("GET /deeplink/cancel-deletion", authHandler.CancelDeletionDeeplink)
+	mux.HandleFunc("POST /deeplink/cancel-deletion", authHandler.CancelDeletionDeeplink)
+	mux.HandleFunc("GET /exports/{token}", exportHandler.Download)
 
 	// Protected Routes
 	mux.Handle("GET /me", middleware.AuthMiddleware(http.HandlerFunc(userHandler.GetMe)))
+	mux.Handle("DELETE /me", middleware.AuthMiddleware(http.HandlerFunc(authHandler.DeleteAccount)))
+	mux.Handle("GET /me/export", middleware.AuthMiddleware(http.HandlerFunc(exportHandler.Export)))
+	mux.Handle("GET /me/library", middleware.AuthMiddleware(http.HandlerFunc(libraryHandler.Search)))
+	mux.Handle("GET /me/stats", middleware.AuthMiddleware(http.HandlerFunc(statsHandler.GetStats)))
+	mux.Handle("GET /me/history/timeline", middleware.AuthMiddleware(http.HandlerFunc(timelineHandler.Timeline)))
+	mux.Handle("GET /me/export/kotatsu", middleware.AuthMiddleware(http.HandlerFunc(backupHandler.ExportKotatsu)))
+	mux.Handle("POST /me/import/kotatsu", requireSync(backupHandler.ImportKotatsu))
+	mux.Handle("GET /me/export/tachiyomi", middleware.AuthMiddleware(http.HandlerFunc(backupHandler.ExportTachiyomi)))
+	mux.Handle("POST /me/import/tachiyomi", requireSync(backupHandler.ImportTachiyomi))
+	mux.Handle("POST /me/verify-email", middleware.AuthMiddleware(http.HandlerFunc(authHandler.ResendVerification)))
+	mux.Handle("POST /me/password", middleware.AuthMiddleware(http.HandlerFunc(authHandler.ChangePassword)))
+	mux.Handle("POST /me/email", middleware.AuthMiddleware(http.HandlerFunc(authHandler.ChangeEmail)))
+	mux.Handle("GET /me/sessions", middleware.AuthMiddleware(http.HandlerFunc(userHandler.ListSessions)))
+	mux.Handle("DELETE /me/sessions/{id}", middleware.AuthMiddleware(http.HandlerFunc(userHandler.RevokeSession)))
+	mux.Handle("POST /me/webhooks", middleware.AuthMiddleware(http.HandlerFunc(webhookHandler.Create)))
+	mux.Handle("GET /me/webhooks", middleware.AuthMiddleware(http.HandlerFunc(webhookHandler.List)))
+	mux.Handle("GET /me/webhooks/{id}", middleware.AuthMiddleware(http.HandlerFunc(webhookHandler.Get)))
+	mux.Handle("PATCH /me/webhooks/{id}", middleware.AuthMiddleware(http.HandlerFunc(webhookHandler.Update)))
+	mux.Handle("DELETE /me/webhooks/{id}", middleware.AuthMiddleware(http.HandlerFunc(webhookHandler.Delete)))
+	mux.Handle("GET /me/webhooks/{id}/deliveries", middleware.AuthMiddleware(http.HandlerFunc(webhookHandler.Deliveries)))
+
+	// Admin Routes
+	mux.Handle("GET /admin/users", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.ListUsers)))
+	mux.Handle("GET /admin/users/{id}", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.GetUser)))
+	mux.Handle("POST /admin/users/{id}/disable", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.DisableUser)))
+	mux.Handle("POST /admin/users/{id}/enable", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.EnableUser)))
+	mux.Handle("POST /admin/users/{id}/password-reset", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.ResetPassword)))
+	mux.Handle("GET /admin/stats", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.Stats)))
+
+	// Web Dashboard
+	if isEnvEnabled("WEB_DASHBOARD", true) {
+		mux.Handle("GET /web", http.RedirectHandler("/web/", http.StatusMovedPermanently))
+		mux.HandleFunc("GET /web/login", webHandler.LoginPage)
+		mux.HandleFunc("POST /web/login", webHandler.Login)
+		mux.Handle("POST /web/logout", webHandler.RequireSession(http.HandlerFunc(webHandler.Logout)))
+		mux.Handle("GET /web/{$}", webHandler.RequireSession(http.HandlerFunc(webHandler.LibraryPage)))
+		mux.Handle("GET /web/history", webHandler.RequireSession(http.HandlerFunc(webHandler.HistoryPage)))
+		mux.Handle("GET /web/account", webHandler.RequireSession(http.HandlerFunc(webHandler.AccountPage)))
+		mux.Handle("POST /web/account/password", webHandler.RequireSession(http.HandlerFunc(webHandler.ChangePassword)))
+		mux.Handle("POST /web/account/sessions/{id}/revoke", webHandler.RequireSession(http.HandlerFunc(webHandler.RevokeSession)))
+	}
 
 	// Sync Routes (Protected)
-	mux.Handle("GET /resource/history", middleware.AuthMiddleware(http.HandlerFunc(syncHandler.GetHistory)))
-	mux.Handle("POST /resource/history", middleware.AuthMiddleware(http.HandlerFunc(syncHandler.PostHistory)))
-	mux.Handle("GET /resource/favourites", middleware.AuthMiddleware(http.HandlerFunc(syncHandler.GetFavourites)))
-	mux.Handle("POST /resource/favourites", middleware.AuthMiddleware(http.HandlerFunc(syncHandler.PostFavourites)))
+	mux.Handle("GET /resource/history", requireSync(syncHandler.GetHistory))
+	mux.Handle("POST /resource/history", requireSync(syncHandler.PostHistory))
+	mux.Handle("GET /resource/favourites", requireSync(syncHandler.GetFavourites))
+	mux.Handle("POST /resource/favourites", requireSync(syncHandler.PostFavourites))
+	mux.Handle("GET /resource/bookmarks", requireSync(syncHandler.GetBookmarks))
+	mux.Handle("POST /resource/bookmarks", requireSync(syncHandler.PostBookmarks))
+	mux.Handle("GET /events", requireSync(eventsHandler.Stream))
+
+	// Metrics
+	metricsEnabled := isEnvEnabled("METRICS_ENABLED", true)
+	if metricsEnabled {
+		metrics.RegisterDBStats(metrics.Default, database.DB)
+		metricsHandler := metrics.Handler(metrics.Default, os.Getenv("METRICS_TOKEN"))
+		if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("GET /metrics", metricsHandler)
+			go func() {
+				slog.Info("Metrics listening", "addr", addr)
+				if err := http.ListenAndServe(addr, metricsMux); err != nil {
+					fatal("Metrics server failed", "error", err)
+				}
+			}()
+		} else {
+			mux.Handle("GET /metrics", metricsHandler)
+		}
+	}
 
 	// Start Server
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	log.Printf("Server starting on port %s...", port)
+	slog.Info("Server starting", "port", port)
 
 	handler := http.Handler(mux)
-	if isDebugEnabled() {
-		log.Println("Debug logging middleware enabled")
+	if metricsEnabled {
+		handler = api.MetricsMiddleware(handler)
+	}
+	if debug && slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		slog.Info("Debug logging middleware enabled")
 		handler = api.LoggingMiddleware(handler)
+	} else if debug {
+		slog.Warn("DEBUG has no effect unless LOG_LEVEL is debug", "log_level", logLevel)
 	}
+	handler = api.RequestIDMiddleware(handler)
 
 	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		log.Fatalf("Server failed: %v", err)
+		fatal("Server failed", "error", err)
 	}
 }
 
-func isDebugEnabled() bool {
-	switch strings.ToLower(strings.TrimSpace(os.Getenv("DEBUG"))) {
+// fatal logs an error and exits, like log.Fatal for the structured logger.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// openDatabase opens DB_PATH without migrating it. DB_DRIVER selects the
+// dialect explicitly; otherwise it is derived from the DSN.
+func openDatabase() (*db.DB, error) {
+	dsn := os.Getenv("DB_PATH")
+	if dsn == "" {
+		dsn = "data/kotatsu.db"
+	}
+
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		return db.Open(dsn)
+	}
+	dialect, err := db.ParseDialect(driver)
+	if err != nil {
+		return nil, err
+	}
+	return db.OpenDialect(dialect, dsn)
+}
+
+// registrationPolicyFromEnv reads REGISTRATION_MODE and ALLOWED_EMAIL_DOMAINS.
+// ALLOW_NEW_REGISTER=false, as known from the original server, closes
+// registration when no mode is set.
+func registrationPolicyFromEnv() (api.RegistrationPolicy, error) {
+	policy := api.RegistrationPolicy{Mode: api.RegistrationOpen}
+	if mode := os.Getenv("REGISTRATION_MODE"); mode != "" {
+		parsed, err := api.ParseRegistrationMode(mode)
+		if err != nil {
+			return policy, err
+		}
+		policy.Mode = parsed
+	} else if !isEnvEnabled("ALLOW_NEW_REGISTER", true) {
+		policy.Mode = api.RegistrationClosed
+	}
+
+	for _, domain := range strings.Split(os.Getenv("ALLOWED_EMAIL_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			policy.AllowedDomains = append(policy.AllowedDomains, domain)
+		}
+	}
+	return policy, nil
+}
+
+// verificationPolicyFromEnv reads UNVERIFIED_GRACE_PERIOD and
+// UNVERIFIED_RESTRICT, a comma-separated list of "sync" and "password-reset".
+func verificationPolicyFromEnv() (api.VerificationPolicy, error) {
+	policy := api.VerificationPolicy{GracePeriod: durationEnv("UNVERIFIED_GRACE_PERIOD", 7*24*time.Hour)}
+	for _, name := range strings.Split(os.Getenv("UNVERIFIED_RESTRICT"), ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "sync":
+			policy.BlockSync = true
+		case "password-reset":
+			policy.BlockPasswordReset = true
+		default:
+			return policy, fmt.Errorf("unknown UNVERIFIED_RESTRICT value %q", name)
+		}
+	}
+	return policy, nil
+}
+
+func isEnvEnabled(name string, fallback bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(name))) {
 	case "1", "true", "yes", "on":
 		return true
-	default:
+	case "0", "false", "no", "off":
 		return false
+	default:
+		return fallback
+	}
+}
+
+// durationEnv parses a Go duration such as "15m" or "720h", keeping the
+// fallback when the variable is unset or invalid.
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("Ignoring invalid setting", "name", name, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+// intEnv parses a non-negative integer, keeping the fallback when the
+// variable is unset or invalid.
+func intEnv(name string, fallback int) int {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		slog.Warn("Ignoring invalid setting", "name", name, "value", value, "default", fallback)
+		return fallback
 	}
+	return n
 }
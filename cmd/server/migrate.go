@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `Usage: kotatsu-server migrate <command>
+
+Commands:
+  status      Show applied and pending migrations
+  up          Apply all pending migrations
+  down [N]    Roll back the last N migrations (default 1)`
+
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		statuses, err := database.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state, appliedAt := "pending", ""
+			if status.Applied {
+				state = "applied"
+				appliedAt = time.UnixMilli(*status.AppliedAt).UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		}
+		tw.Flush()
+	case "up":
+		applied, err := database.MigrateUp(ctx)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q", args[1])
+			}
+		}
+		rolledBack, err := database.MigrateDown(ctx, steps)
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		fmt.Printf("Rolled back %d migration(s)\n", rolledBack)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
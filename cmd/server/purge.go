@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/api"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// runPurgeJob removes accounts whose deletion grace period is over, sessions
+// that ended more than sessionRetention ago and expired data exports, once at
+// startup and then every interval until ctx is cancelled.
+func runPurgeJob(ctx context.Context, st store.MaintenanceStore, exports *api.ExportHandler, interval, sessionRetention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := st.PurgeDeletedUsers(ctx, time.Now().Unix())
+		if err != nil {
+			slog.ErrorContext(ctx, "Account purge failed", "error", err)
+		} else if purged > 0 {
+			slog.InfoContext(ctx, "Purged deleted accounts", "count", purged)
+		}
+		sessions, err := st.PurgeSessions(ctx, time.Now().Add(-sessionRetention).Unix())
+		if err != nil {
+			slog.ErrorContext(ctx, "Session purge failed", "error", err)
+		} else if sessions > 0 {
+			slog.InfoContext(ctx, "Purged ended sessions", "count", sessions)
+		}
+		exports.Cleanup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+)
+
+const userUsage = `Usage: kotatsu-server user <command>
+
+Commands:
+  list [-json]
+              List accounts
+  show (-email EMAIL | -id ID) [-json]
+              Show an account with its active sessions and library size
+  create -email EMAIL [-password PASSWORD] [-verified]
+              Create an account
+  set-password (-email EMAIL | -id ID) [-password PASSWORD]
+              Set a new password and sign out every device
+  set-role (-email EMAIL | -id ID) -role ROLE
+              Make an account an "admin" or a regular "user"
+  revoke-sessions (-email EMAIL | -id ID)
+              Sign out every device of an account
+  delete (-email EMAIL | -id ID)
+              Delete an account and its library right away
+
+Passwords are read from standard input when -password is omitted.`
+
+// userDetails is the output of "user show".
+type userDetails struct {
+	model.User
+	LibraryEntries int             `json:"library_entries"`
+	Sessions       []model.Session `json:"sessions"`
+}
+
+func runUser(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		os.Exit(2)
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+	if pending, err := database.PendingMigrations(context.Background()); err != nil || pending > 0 {
+		log.Fatalf("Database is not migrated (pending=%d, err=%v); run \"migrate up\" first", pending, err)
+	}
+	st := sqlstore.New(database)
+
+	ctx := context.Background()
+	flags := flag.NewFlagSet("user "+args[0], flag.ExitOnError)
+	email := flags.String("email", "", "email of the account")
+	id := flags.Int64("id", 0, "ID of the account")
+	// findUser resolves the account named by -email or -id.
+	findUser := func() *model.User {
+		var user *model.User
+		var err error
+		switch {
+		case *email != "" && *id == 0:
+			user, err = st.GetUserByEmail(ctx, *email)
+		case *id != 0 && *email == "":
+			user, err = st.GetUserByID(ctx, *id)
+		default:
+			log.Fatalf("Exactly one of -email and -id is required")
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			log.Fatalf("No such user")
+		}
+		if err != nil {
+			log.Fatalf("Failed to look up user: %v", err)
+		}
+		return user
+	}
+
+	switch args[0] {
+	case "list":
+		jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+		flags.Parse(args[1:])
+		users, err := st.ListUsers(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list users: %v", err)
+		}
+		if *jsonOutput {
+			printJSON(users)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tEMAIL\tROLE\tCREATED AT\tVERIFIED AT\tDISABLED AT\tDELETION AT")
+		for _, user := range users {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", user.ID, user.Email, user.Role, formatUnix(user.CreatedAt),
+				formatOptionalUnix(user.VerifiedAt), formatOptionalUnix(user.DisabledAt), formatOptionalUnix(user.DeletionScheduledAt))
+		}
+		tw.Flush()
+	case "show":
+		jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+		flags.Parse(args[1:])
+		user := findUser()
+		details := userDetails{User: *user}
+		if details.LibraryEntries, err = st.CountLibraryEntries(ctx, user.ID); err != nil {
+			log.Fatalf("Failed to count library entries: %v", err)
+		}
+		if details.Sessions, err = st.ListSessions(ctx, user.ID, time.Now().Unix()); err != nil {
+			log.Fatalf("Failed to list sessions: %v", err)
+		}
+		if details.Sessions == nil {
+			details.Sessions = []model.Session{}
+		}
+		if *jsonOutput {
+			printJSON(details)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "ID:\t%d\n", user.ID)
+		fmt.Fprintf(tw, "Email:\t%s\n", user.Email)
+		fmt.Fprintf(tw, "Role:\t%s\n", user.Role)
+		fmt.Fprintf(tw, "Created at:\t%s\n", formatUnix(user.CreatedAt))
+		fmt.Fprintf(tw, "Verified at:\t%s\n", formatOptionalUnix(user.VerifiedAt))
+		fmt.Fprintf(tw, "Disabled at:\t%s\n", formatOptionalUnix(user.DisabledAt))
+		fmt.Fprintf(tw, "Deletion at:\t%s\n", formatOptionalUnix(user.DeletionScheduledAt))
+		fmt.Fprintf(tw, "Library entries:\t%d\n", details.LibraryEntries)
+		fmt.Fprintf(tw, "Active sessions:\t%d\n", len(details.Sessions))
+		tw.Flush()
+		if len(details.Sessions) > 0 {
+			fmt.Println()
+			tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "SESSION\tDEVICE\tIP ADDRESS\tLAST SEEN AT")
+			for _, session := range details.Sessions {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", session.ID, session.DeviceName, session.IPAddress, formatUnix(session.LastSeenAt))
+			}
+			tw.Flush()
+		}
+	case "create":
+		password := flags.String("password", "", "password of the account")
+		verified := flags.Bool("verified", false, "mark the email as verified")
+		flags.Parse(args[1:])
+		if *email == "" || *id != 0 {
+			log.Fatalf("-email is required")
+		}
+		hash, err := auth.HashPassword(readPassword(*password))
+		if err != nil {
+			log.Fatalf("Failed to hash password: %v", err)
+		}
+		userID, err := st.CreateUser(ctx, *email, hash)
+		if errors.Is(err, store.ErrAlreadyExists) {
+			log.Fatalf("User %s already exists", *email)
+		}
+		if err != nil {
+			log.Fatalf("Failed to create user: %v", err)
+		}
+		if *verified {
+			if err := st.MarkEmailVerified(ctx, userID, time.Now().Unix()); err != nil {
+				log.Fatalf("Failed to mark email verified: %v", err)
+			}
+		}
+		fmt.Printf("User %d created\n", userID)
+	case "set-password":
+		password := flags.String("password", "", "new password")
+		flags.Parse(args[1:])
+		user := findUser()
+		hash, err := auth.HashPassword(readPassword(*password))
+		if err != nil {
+			log.Fatalf("Failed to hash password: %v", err)
+		}
+		if err := st.UpdatePassword(ctx, user.ID, hash); err != nil {
+			log.Fatalf("Failed to update password: %v", err)
+		}
+		if err := st.ClearResetToken(ctx, user.ID); err != nil {
+			log.Fatalf("Failed to clear reset token: %v", err)
+		}
+		if err := st.RevokeOtherSessions(ctx, user.ID, "", time.Now().Unix()); err != nil {
+			log.Fatalf("Failed to revoke sessions: %v", err)
+		}
+		fmt.Printf("Password of user %d changed, every device signed out\n", user.ID)
+	case "set-role":
+		role := flags.String("role", "", `"admin" or "user"`)
+		flags.Parse(args[1:])
+		if *role != store.RoleAdmin && *role != store.RoleUser {
+			log.Fatalf("-role must be %q or %q", store.RoleAdmin, store.RoleUser)
+		}
+		user := findUser()
+		if err := st.SetUserRole(ctx, user.ID, *role); err != nil {
+			log.Fatalf("Failed to set role: %v", err)
+		}
+		fmt.Printf("User %d is now %s\n", user.ID, *role)
+	case "revoke-sessions":
+		flags.Parse(args[1:])
+		user := findUser()
+		if err := st.RevokeOtherSessions(ctx, user.ID, "", time.Now().Unix()); err != nil {
+			log.Fatalf("Failed to revoke sessions: %v", err)
+		}
+		fmt.Printf("Every device of user %d signed out\n", user.ID)
+	case "delete":
+		flags.Parse(args[1:])
+		user := findUser()
+		// Shared manga nobody references anymore are removed as well, like
+		// in the purge of accounts deleted through DELETE /me.
+		if err := st.PurgeUser(ctx, user.ID); err != nil {
+			log.Fatalf("Failed to delete user: %v", err)
+		}
+		fmt.Printf("User %d (%s) deleted\n", user.ID, user.Email)
+	default:
+		fmt.Fprintln(os.Stderr, userUsage)
+		os.Exit(2)
+	}
+}
+
+// readPassword returns password, or the first line of standard input when it
+// is empty, so that passwords can be kept out of the shell history.
+func readPassword(password string) string {
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		log.Fatalf("Password must not be empty")
+	}
+	return password
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("Failed to encode output: %v", err)
+	}
+}
+
+func formatOptionalUnix(seconds *int64) string {
+	if seconds == nil {
+		return "-"
+	}
+	return formatUnix(*seconds)
+}
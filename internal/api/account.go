@@ -0,0 +1,231 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// validPasswordLength matches the limits of the original server.
+func validPasswordLength(password string) bool {
+	return len(password) >= 2 && len(password) <= 24
+}
+
+// ChangePassword sets a new password after checking the current one and
+// signs out every other device.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := GetSessionID(r)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !h.checkPassword(w, req.CurrentPassword, user.PasswordHash) {
+		return
+	}
+
+	if !validPasswordLength(req.NewPassword) {
+		JSONError(w, "Password should be from 2 to 24 characters long", http.StatusBadRequest)
+		return
+	}
+
+	newHash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		JSONError(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Users.UpdatePassword(r.Context(), user.ID, newHash); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.Users.ClearResetToken(r.Context(), user.ID)
+
+	if err := h.Sessions.RevokeOtherSessions(r.Context(), user.ID, sessionID, time.Now().Unix()); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.passwordChanged(r, user.ID, false)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("Password has been changed")
+}
+
+// ChangeEmail starts an email change. The new address only takes effect once
+// the link mailed to it is opened; the current address is told about the
+// request.
+func (h *AuthHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	newEmail := strings.TrimSpace(req.Email)
+	if !strings.Contains(newEmail, "@") {
+		JSONError(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !h.checkPassword(w, req.Password, user.PasswordHash) {
+		return
+	}
+
+	if strings.EqualFold(newEmail, user.Email) {
+		JSONError(w, "This is already the account email", http.StatusBadRequest)
+		return
+	}
+	if len(h.Registration.AllowedDomains) > 0 && !h.Registration.domainAllowed(newEmail) {
+		JSONError(w, "This email domain is not allowed", http.StatusForbidden)
+		return
+	}
+	if _, err := h.Users.GetUserByEmail(r.Context(), newEmail); err == nil {
+		JSONError(w, "Email is already registered", http.StatusConflict)
+		return
+	} else if !errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	token, hash, err := auth.GenerateResetToken()
+	if err != nil {
+		JSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(verificationTokenTTL).Unix()
+
+	if err := h.Users.SetEmailChangeToken(r.Context(), user.ID, newEmail, hash, expiresAt); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	link := fmt.Sprintf("%s/deeplink/change-email?token=%s", h.BaseURL, token)
+	htmlBody, err := h.Templates.Render("mail/change-email.html", map[string]string{"ConfirmEmailLink": link})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Template render error", "error", err)
+	}
+	if err := h.Mailer.Send(newEmail, "Confirm your new email", "Confirmation link: "+link, htmlBody); err != nil {
+		slog.ErrorContext(r.Context(), "Mail send error", "error", err)
+		JSONError(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		return
+	}
+
+	noticeBody, err := h.Templates.Render("mail/email-change-requested.html", map[string]string{"NewEmail": newEmail})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Template render error", "error", err)
+	}
+	if err := h.Mailer.Send(user.Email, "Email change requested", "Your account email is being changed to "+newEmail, noticeBody); err != nil {
+		slog.ErrorContext(r.Context(), "Mail send error", "error", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode("A confirmation email was sent to the new address")
+}
+
+// ChangeEmailDeeplink serves the confirmation link of an email change. GET
+// asks for confirmation, so that mail scanners following the link change
+// nothing, and the POST of its form completes the change.
+func (h *AuthHandler) ChangeEmailDeeplink(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if token == "" {
+		JSONError(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+
+	valid := false
+	now := time.Now().Unix()
+	user, err := h.Users.GetUserByEmailChangeToken(r.Context(), auth.HashToken(token))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	default:
+		valid = user.PendingEmail != nil && user.EmailChangeTokenExpires != nil && *user.EmailChangeTokenExpires >= now
+	}
+
+	changed := false
+	if valid && r.Method == http.MethodPost {
+		// Following the link proves ownership, so the new address counts as verified.
+		err := h.Users.ChangeEmail(r.Context(), user.ID, *user.PendingEmail, now)
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			valid = false
+		case err != nil:
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		default:
+			changed = true
+		}
+	}
+
+	email := ""
+	if valid {
+		email = *user.PendingEmail
+	}
+	html, err := h.Templates.Render("pages/change-email.html", map[string]any{
+		"Token":   token,
+		"Confirm": valid && !changed,
+		"Changed": changed,
+		"Email":   email,
+	})
+	if err != nil {
+		JSONError(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write([]byte(html))
+}
+
+// checkPassword responds with 403 unless password matches the hash.
+func (h *AuthHandler) checkPassword(w http.ResponseWriter, password, hash string) bool {
+	match, err := auth.VerifyPassword(password, hash)
+	if err != nil {
+		JSONError(w, "Error verifying password", http.StatusInternalServerError)
+		return false
+	}
+	if !match {
+		JSONError(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+	return true
+}
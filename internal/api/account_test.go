@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestChangePasswordAndEmail(t *testing.T) {
+	st := memstore.New()
+	authHandler := newTestAuthHandler(st)
+	mailer := authHandler.Mailer.(*testutil.MockMailSender)
+	userHandler := &UserHandler{Users: st, Sessions: st}
+	middleware := &Middleware{Users: st, Sessions: st}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /deeplink/change-email", authHandler.ChangeEmailDeeplink)
+	mux.HandleFunc("POST /deeplink/change-email", authHandler.ChangeEmailDeeplink)
+	mux.Handle("GET /me", middleware.AuthMiddleware(http.HandlerFunc(userHandler.GetMe)))
+	mux.Handle("POST /me/password", middleware.AuthMiddleware(http.HandlerFunc(authHandler.ChangePassword)))
+	mux.Handle("POST /me/email", middleware.AuthMiddleware(http.HandlerFunc(authHandler.ChangeEmail)))
+
+	do := func(method, target, token string, payload any) *httptest.ResponseRecorder {
+		var body []byte
+		if payload != nil {
+			body, _ = json.Marshal(payload)
+		}
+		req, _ := http.NewRequest(method, target, bytes.NewBuffer(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	phone := loginForTokens(t, authHandler, "owner@example.com", "password")
+	tablet := loginForTokens(t, authHandler, "owner@example.com", "password")
+
+	// Password change requires the current password and signs out other devices.
+	if rr := do("POST", "/me/password", phone.Token, map[string]string{"current_password": "wrong", "new_password": "newpass"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for wrong current password, got %d", rr.Code)
+	}
+	if rr := do("POST", "/me/password", phone.Token, map[string]string{"current_password": "password", "new_password": "x"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for short password, got %d", rr.Code)
+	}
+	if rr := do("POST", "/me/password", phone.Token, map[string]string{"current_password": "password", "new_password": "newpass"}); rr.Code != http.StatusOK {
+		t.Fatalf("expected password change to succeed, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if code := authenticatedStatus(middleware, phone.Token); code != http.StatusOK {
+		t.Fatalf("expected current session to survive, got %d", code)
+	}
+	if code := authenticatedStatus(middleware, tablet.Token); code != http.StatusUnauthorized {
+		t.Fatalf("expected other session to be revoked, got %d", code)
+	}
+	loginForTokens(t, authHandler, "owner@example.com", "newpass")
+
+	// Email change.
+	loginForTokens(t, authHandler, "taken@example.com", "password")
+	if rr := do("POST", "/me/email", phone.Token, map[string]string{"email": "taken@example.com", "password": "newpass"}); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for taken email, got %d", rr.Code)
+	}
+	if rr := do("POST", "/me/email", phone.Token, map[string]string{"email": "moved@example.com", "password": "password"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for wrong password, got %d", rr.Code)
+	}
+
+	sent := len(mailer.SentEmails)
+	if rr := do("POST", "/me/email", phone.Token, map[string]string{"email": "moved@example.com", "password": "newpass"}); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for email change, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if len(mailer.SentEmails) != sent+2 {
+		t.Fatalf("expected confirmation and notice emails, got %+v", mailer.SentEmails[sent:])
+	}
+	confirmation, notice := mailer.SentEmails[sent], mailer.SentEmails[sent+1]
+	if confirmation.To != "moved@example.com" || notice.To != "owner@example.com" {
+		t.Fatalf("unexpected recipients %q and %q", confirmation.To, notice.To)
+	}
+
+	me := func() UserResponse {
+		var resp UserResponse
+		json.NewDecoder(do("GET", "/me", phone.Token, nil).Body).Decode(&resp)
+		return resp
+	}
+	if got := me().Email; got != "owner@example.com" {
+		t.Fatalf("email changed before confirmation: %q", got)
+	}
+
+	// Opening the link only asks for confirmation.
+	_, link, _ := strings.Cut(confirmation.TextBody, "Confirmation link: ")
+	path := strings.TrimPrefix(link, "http://test.local")
+	if rr := do("GET", path, "", nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `method="post"`) {
+		t.Fatalf("expected a confirmation form, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := me().Email; got != "owner@example.com" {
+		t.Fatalf("email changed by opening the link: %q", got)
+	}
+
+	confirm := func() int {
+		linkURL, _ := url.Parse(link)
+		form := url.Values{"token": {linkURL.Query().Get("token")}}
+		req, _ := http.NewRequest("POST", "/deeplink/change-email", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := confirm(); code != http.StatusOK {
+		t.Fatalf("expected confirmation to succeed, got %d", code)
+	}
+	if got := me(); got.Email != "moved@example.com" || !got.EmailVerified {
+		t.Fatalf("expected confirmed and verified new email, got %+v", got)
+	}
+	if code := confirm(); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for reused link, got %d", code)
+	}
+	if rr := do("GET", path, "", nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for reused link, got %d", rr.Code)
+	}
+	loginForTokens(t, authHandler, "moved@example.com", "newpass")
+}
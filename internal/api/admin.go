@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const (
+	defaultAdminUsersLimit = 50
+	maxAdminUsersLimit     = 200
+)
+
+// AdminHandler serves the /admin endpoints, which are mounted behind
+// Middleware.AdminMiddleware.
+type AdminHandler struct {
+	Users    store.UserStore
+	Sessions store.SessionStore
+	Admin    store.AdminStore
+	// Auth sends the password reset emails.
+	Auth *AuthHandler
+}
+
+type AdminUsersResponse struct {
+	Users []model.UserSummary `json:"users"`
+	// NextAfter is the "after" parameter of the next page, null on the last one.
+	NextAfter *int64 `json:"next_after"`
+}
+
+// AdminUserResponse is a user with their active sessions.
+type AdminUserResponse struct {
+	model.UserSummary
+	Sessions []model.Session `json:"sessions"`
+}
+
+// ListUsers pages through the accounts by ID. q matches a part of the email;
+// role and disabled filter the list.
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	filter := store.UserFilter{Query: params.Get("q"), Role: params.Get("role"), Limit: defaultAdminUsersLimit}
+
+	var err error
+	if filter.Disabled, err = optionalBool(params.Get("disabled")); err != nil {
+		JSONError(w, "Invalid disabled", http.StatusBadRequest)
+		return
+	}
+	if value := params.Get("after"); value != "" {
+		if filter.AfterID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			JSONError(w, "Invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := params.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			JSONError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = min(filter.Limit, maxAdminUsersLimit)
+	}
+
+	// One user more than requested tells whether there is a next page.
+	limit := filter.Limit
+	filter.Limit++
+	users, err := h.Admin.SearchUsers(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching users", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := AdminUsersResponse{Users: users}
+	if len(users) > limit {
+		resp.Users = users[:limit]
+		next := resp.Users[limit-1].ID
+		resp.NextAfter = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := h.Admin.GetUserSummary(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching user summary", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := h.Sessions.ListSessions(r.Context(), userID, time.Now().Unix())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing sessions", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []model.Session{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdminUserResponse{UserSummary: *summary, Sessions: sessions})
+}
+
+// DisableUser blocks logins of the account and signs out all its devices.
+// Administrators cannot disable themselves.
+func (h *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	if adminID, _ := GetUserID(r); adminID == user.ID {
+		JSONError(w, "Cannot disable your own account", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().Unix()
+	if err := h.Users.SetUserDisabled(r.Context(), user.ID, &now); err != nil {
+		slog.ErrorContext(r.Context(), "Error disabling user", "user_id", user.ID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Sessions.RevokeOtherSessions(r.Context(), user.ID, "", now); err != nil {
+		slog.ErrorContext(r.Context(), "Error revoking sessions", "user_id", user.ID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Users.SetUserDisabled(r.Context(), user.ID, nil); err != nil {
+		slog.ErrorContext(r.Context(), "Error enabling user", "user_id", user.ID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetPassword mails the user a password reset link, as POST /forgot-password does.
+func (h *AdminHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Auth.sendPasswordReset(r, user); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting password reset", "user_id", user.ID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.Admin.ServerStats(r.Context(), time.Now().Unix())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error computing server stats", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// user loads the account named by the id path value, writing the error
+// response when there is none.
+func (h *AdminHandler) user(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching user", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+func pathUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestAdminAPI(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	for name, st := range map[string]interface {
+		testStore
+		store.LibraryStore
+		store.AdminStore
+	}{
+		"sqlstore": sqlstore.New(database),
+		"memstore": memstore.New(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			authHandler := newTestAuthHandler(st)
+			mailer := authHandler.Mailer.(*testutil.MockMailSender)
+			adminHandler := &AdminHandler{Users: st, Sessions: st, Admin: st, Auth: authHandler}
+			middleware := &Middleware{Users: st, Sessions: st}
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /admin/users", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.ListUsers)))
+			mux.Handle("GET /admin/users/{id}", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.GetUser)))
+			mux.Handle("POST /admin/users/{id}/disable", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.DisableUser)))
+			mux.Handle("POST /admin/users/{id}/enable", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.EnableUser)))
+			mux.Handle("POST /admin/users/{id}/password-reset", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.ResetPassword)))
+			mux.Handle("GET /admin/stats", middleware.AdminMiddleware(http.HandlerFunc(adminHandler.Stats)))
+
+			do := func(method, target, token string, out any) int {
+				t.Helper()
+				req, _ := http.NewRequest(method, target, nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if out != nil {
+					json.NewDecoder(rr.Body).Decode(out)
+				}
+				return rr.Code
+			}
+
+			admin := loginForTokens(t, authHandler, "admin@example.com", "password")
+			reader := loginForTokens(t, authHandler, "reader@example.com", "password")
+			loginForTokens(t, authHandler, "other@example.org", "password")
+			adminUser, _ := st.GetUserByEmail(ctx, "admin@example.com")
+			readerUser, _ := st.GetUserByEmail(ctx, "reader@example.com")
+			if readerUser.Role != store.RoleUser {
+				t.Fatalf("expected new accounts to be users, got %q", readerUser.Role)
+			}
+
+			if code := do("GET", "/admin/stats", admin.Token, nil); code != http.StatusForbidden {
+				t.Fatalf("expected 403 before the promotion, got %d", code)
+			}
+			if err := st.SetUserRole(ctx, adminUser.ID, store.RoleAdmin); err != nil {
+				t.Fatal(err)
+			}
+			if code := do("GET", "/admin/stats", reader.Token, nil); code != http.StatusForbidden {
+				t.Fatalf("expected 403 for a regular user, got %d", code)
+			}
+
+			_, err := st.SyncFavourites(ctx, readerUser.ID,
+				[]model.Category{{ID: 1, Title: "Reading", Order: "NEWEST"}, {ID: 2, Title: "Best", Order: "NEWEST"}},
+				[]model.Favourite{
+					{MangaID: 80, Manga: &model.Manga{ID: 80, Title: "Counted", Source: "test"}, CategoryID: 1, CreatedAt: 100},
+					{MangaID: 80, CategoryID: 2, CreatedAt: 100},
+					{MangaID: 81, Manga: &model.Manga{ID: 81, Title: "Removed", Source: "test"}, CategoryID: 1, CreatedAt: 100, DeletedAt: 200},
+				}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var page AdminUsersResponse
+			if code := do("GET", "/admin/users?limit=2", admin.Token, &page); code != http.StatusOK || len(page.Users) != 2 || page.NextAfter == nil {
+				t.Fatalf("unexpected first page %d %+v", code, page)
+			}
+			do("GET", "/admin/users?limit=2&after="+strconv.FormatInt(*page.NextAfter, 10), admin.Token, &page)
+			if len(page.Users) != 1 || page.Users[0].Email != "other@example.org" || page.NextAfter != nil {
+				t.Fatalf("unexpected last page %+v", page)
+			}
+			do("GET", "/admin/users?q=EXAMPLE.COM&role=user", admin.Token, &page)
+			if len(page.Users) != 1 || page.Users[0].ID != readerUser.ID || page.Users[0].Favourites != 1 || page.Users[0].LastSeenAt == nil {
+				t.Fatalf("unexpected search result %+v", page)
+			}
+
+			var detail AdminUserResponse
+			if code := do("GET", "/admin/users/"+strconv.FormatInt(readerUser.ID, 10), admin.Token, &detail); code != http.StatusOK || len(detail.Sessions) != 1 || detail.FavouritesSyncTimestamp == nil {
+				t.Fatalf("unexpected user detail %d %+v", code, detail)
+			}
+			if code := do("GET", "/admin/users/999", admin.Token, nil); code != http.StatusNotFound {
+				t.Fatalf("expected 404 for an unknown user, got %d", code)
+			}
+
+			// Disabling signs the user out and blocks logins until re-enabled.
+			if code := do("POST", "/admin/users/"+strconv.FormatInt(adminUser.ID, 10)+"/disable", admin.Token, nil); code != http.StatusBadRequest {
+				t.Fatalf("expected 400 when disabling oneself, got %d", code)
+			}
+			if code := do("POST", "/admin/users/"+strconv.FormatInt(readerUser.ID, 10)+"/disable", admin.Token, nil); code != http.StatusNoContent {
+				t.Fatalf("expected 204, got %d", code)
+			}
+			if code := authenticatedStatus(middleware, reader.Token); code != http.StatusUnauthorized {
+				t.Fatalf("expected the disabled user's session to be revoked, got %d", code)
+			}
+			if code := loginStatus(authHandler, "reader@example.com", "password"); code != http.StatusForbidden {
+				t.Fatalf("expected 403 on login of a disabled account, got %d", code)
+			}
+			do("GET", "/admin/users?disabled=true", admin.Token, &page)
+			if len(page.Users) != 1 || page.Users[0].DisabledAt == nil {
+				t.Fatalf("unexpected disabled users %+v", page)
+			}
+			if code := do("POST", "/admin/users/"+strconv.FormatInt(readerUser.ID, 10)+"/enable", admin.Token, nil); code != http.StatusNoContent {
+				t.Fatalf("expected 204, got %d", code)
+			}
+
+			// A session left alive by a refresh racing the disable can neither
+			// be used nor refreshed.
+			racer := loginForTokens(t, authHandler, "reader@example.com", "password")
+			disabledAt := int64(1)
+			if err := st.SetUserDisabled(ctx, readerUser.ID, &disabledAt); err != nil {
+				t.Fatal(err)
+			}
+			if code := authenticatedStatus(middleware, racer.Token); code != http.StatusForbidden {
+				t.Fatalf("expected 403 for a session of a disabled account, got %d", code)
+			}
+			body, _ := json.Marshal(map[string]string{"refresh_token": racer.RefreshToken})
+			req, _ := http.NewRequest("POST", "/auth/refresh", bytes.NewBuffer(body))
+			rr := httptest.NewRecorder()
+			authHandler.Refresh(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("expected the refresh of a disabled account to fail, got %d", rr.Code)
+			}
+			if err := st.SetUserDisabled(ctx, readerUser.ID, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := len(mailer.SentEmails)
+			if code := do("POST", "/admin/users/"+strconv.FormatInt(readerUser.ID, 10)+"/password-reset", admin.Token, nil); code != http.StatusNoContent {
+				t.Fatalf("expected 204, got %d", code)
+			}
+			if len(mailer.SentEmails) != sent+1 || mailer.SentEmails[sent].To != "reader@example.com" {
+				t.Fatalf("expected a reset email, got %+v", mailer.SentEmails[sent:])
+			}
+
+			var stats model.ServerStats
+			do("GET", "/admin/stats", admin.Token, &stats)
+			if stats.Users != 3 || stats.Admins != 1 || stats.Favourites != 2 || stats.Manga != 2 || stats.ActiveUsers.Day != 3 || stats.ActiveSessions != 3 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func loginStatus(handler *AuthHandler, email, password string) int {
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	req, _ := http.NewRequest("POST", "/auth", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	handler.Login(rr, req)
+	return rr.Code
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/templates"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
 )
@@ -50,7 +51,7 @@ func TestForgotPassword(t *testing.T) {
 	tmplMgr := templates.NewManager("../../templates")
 
 	handler := &AuthHandler{
-		DB:        database,
+		Users:     sqlstore.New(database),
 		Mailer:    mailer,
 		Templates: tmplMgr,
 		BaseURL:   "http://test.local",
@@ -77,7 +78,7 @@ func TestForgotPassword(t *testing.T) {
 	}
 
 	// Verify token in DB
-	user, err := database.GetUserByEmail(email)
+	user, err := database.GetUserByEmail(context.Background(), email)
 	if err != nil {
 		t.Fatalf("Failed to get user: %v", err)
 	}
@@ -102,7 +103,7 @@ func TestResetPassword(t *testing.T) {
 	}
 	userID, _ := res.LastInsertId()
 
-	handler := &AuthHandler{DB: database}
+	handler := &AuthHandler{Users: sqlstore.New(database)}
 
 	newPass := "newsecurepassword"
 	payload := map[string]string{
@@ -120,7 +121,7 @@ func TestResetPassword(t *testing.T) {
 	}
 
 	// Verify password changed
-	user, _ := database.GetUserByID(userID)
+	user, _ := database.GetUserByID(context.Background(), userID)
 	match, err := auth.VerifyPassword(newPass, user.PasswordHash)
 	if err != nil {
 		t.Fatalf("Error verifying password: %v", err)
@@ -143,7 +144,7 @@ func TestGetMe(t *testing.T) {
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", email, "hash")
 	userID, _ := res.LastInsertId()
 
-	handler := &UserHandler{DB: database}
+	handler := &UserHandler{Users: sqlstore.New(database)}
 
 	req, _ := http.NewRequest("GET", "/me", nil)
 	// Inject user_id into context (simulating AuthMiddleware)
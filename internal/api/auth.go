@@ -1,58 +1,79 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
-	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/mail"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/metrics"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/templates"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/webhooks"
 )
 
 type AuthHandler struct {
-	DB        *db.DB
-	Mailer    mail.MailSender
-	Templates *templates.Manager
-	BaseURL   string
+	Users        store.UserStore
+	Sessions     store.SessionStore
+	Invites      store.InviteStore
+	Registration RegistrationPolicy
+	Verification VerificationPolicy
+	// DeletionGracePeriod is how long DELETE /me can be cancelled before the
+	// account is purged.
+	DeletionGracePeriod time.Duration
+	Mailer              mail.MailSender
+	Templates           *templates.Manager
+	BaseURL             string
+	// Webhooks, when set, is told about password changes.
+	Webhooks *webhooks.Dispatcher
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	InviteCode string `json:"invite_code"`
+	DeviceName string `json:"device_name"`
 }
 
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// InviteCode is only used when an unknown email is auto-registered.
+	InviteCode string `json:"invite_code"`
+	// DeviceName optionally labels the session in GET /me/sessions.
+	DeviceName string `json:"device_name"`
+}
+
+// TokenResponse is returned by login and refresh. Clients that only read
+// "token" keep working: they log in again once the access token expires.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
 }
 
+// Register creates an account and signs it in.
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" || req.Password == "" {
 		JSONError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	hash, err := auth.HashPassword(req.Password)
-	if err != nil {
-		JSONError(w, "Failed to hash password", http.StatusInternalServerError)
-		return
-	}
-
-	_, err = h.DB.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", req.Email, hash)
+	userID, err := h.registerUser(r.Context(), req.Email, req.Password, req.InviteCode)
 	if err != nil {
-		// Check for duplicate email error (SQLite specific error check could be added here)
-		// For simplicity, generic error
-		JSONError(w, "Failed to register user (email might be taken)", http.StatusInternalServerError)
+		registrationError(w, err)
 		return
 	}
+	h.requestVerification(r, userID, req.Email)
 
-	w.WriteHeader(http.StatusCreated)
+	h.startSession(w, r, http.StatusCreated, userID, req.DeviceName)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -62,33 +83,22 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user model.User
-	row := h.DB.QueryRow("SELECT id, password_hash FROM users WHERE email = ?", req.Email)
-	err := row.Scan(&user.ID, &user.PasswordHash)
+	user, err := h.Users.GetUserByEmail(r.Context(), req.Email)
 
 	// User not found
-	if err == sql.ErrNoRows {
-		// Attempt registration (Auto-register behavior to match original server)
-		// In a real env, check config "ALLOW_NEW_REGISTER". For now, assume true.
-		hash, err := auth.HashPassword(req.Password)
+	if errors.Is(err, store.ErrNotFound) {
+		// Attempt registration (Auto-register behavior to match original server),
+		// subject to the registration policy.
+		userID, err := h.registerUser(r.Context(), req.Email, req.Password, req.InviteCode)
 		if err != nil {
-			JSONError(w, "Internal server error", http.StatusInternalServerError)
+			metrics.Logins.Inc("app", "failure")
+			registrationError(w, err)
 			return
 		}
-		res, err := h.DB.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", req.Email, hash)
-		if err != nil {
-			JSONError(w, "Failed to register user", http.StatusInternalServerError)
-			return
-		}
-		userID, _ := res.LastInsertId()
+		h.requestVerification(r, userID, req.Email)
 
-		token, err := auth.GenerateToken(userID)
-		if err != nil {
-			JSONError(w, "Failed to generate token", http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		metrics.Logins.Inc("app", "success")
+		h.startSession(w, r, http.StatusOK, userID, req.DeviceName)
 		return
 	} else if err != nil {
 		JSONError(w, "Database error", http.StatusInternalServerError)
@@ -103,18 +113,160 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !match {
+		metrics.Logins.Inc("app", "failure")
 		JSONError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := auth.GenerateToken(user.ID)
+	if user.DeletionScheduledAt != nil {
+		metrics.Logins.Inc("app", "failure")
+		JSONError(w, "Account is scheduled for deletion", http.StatusForbidden)
+		return
+	}
+	if user.DisabledAt != nil {
+		metrics.Logins.Inc("app", "failure")
+		JSONError(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+
+	metrics.Logins.Inc("app", "success")
+	h.startSession(w, r, http.StatusOK, user.ID, req.DeviceName)
+}
+
+// Refresh exchanges a refresh token for a new access token. The refresh token
+// is rotated on every use.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		JSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		JSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	session, err := h.Sessions.RotateSession(r.Context(), auth.HashToken(req.RefreshToken), refreshHash, now.Unix(), now.Add(auth.RefreshTokenTTL).Unix())
+	if errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(w, http.StatusOK, session.UserID, session.ID, refreshToken)
+}
+
+// Logout revokes the session of the access token used for the request.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := GetSessionID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID, _ := GetUserID(r)
+	if err := h.Sessions.RevokeSession(r.Context(), userID, sessionID, time.Now().Unix()); err != nil && !errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startSession opens a new session for the user and responds with its tokens.
+// A device that signs in again, as the app does when its access token
+// expires, gets its active session back instead of a new one.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, status int, userID int64, deviceName string) {
+	refreshToken, refreshHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		JSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	deviceName = truncate(strings.TrimSpace(deviceName), maxDeviceNameLength)
+	userAgent := truncate(r.UserAgent(), maxUserAgentLength)
+	if existing, err := h.deviceSession(r, userID, deviceName, userAgent, now.Unix()); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if existing != nil {
+		err := h.Sessions.RenewSession(r.Context(), existing.ID, refreshHash, now.Unix(), now.Add(auth.RefreshTokenTTL).Unix(), clientIP(r))
+		if err == nil {
+			h.writeTokens(w, status, userID, existing.ID, refreshToken)
+			return
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	sessionID, err := auth.GenerateSessionID()
+	if err != nil {
+		JSONError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	session := &model.Session{
+		ID:               sessionID,
+		UserID:           userID,
+		RefreshTokenHash: refreshHash,
+		CreatedAt:        now.Unix(),
+		RefreshedAt:      now.Unix(),
+		ExpiresAt:        now.Add(auth.RefreshTokenTTL).Unix(),
+		DeviceName:       deviceName,
+		UserAgent:        userAgent,
+		IPAddress:        clientIP(r),
+		LastSeenAt:       now.Unix(),
+	}
+	if err := h.Sessions.CreateSession(r.Context(), session); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(w, status, userID, sessionID, refreshToken)
+}
+
+// deviceSession returns the user's active app session with the same device
+// name and user agent, nil if there is none. Requests identifying neither
+// always get a new session.
+func (h *AuthHandler) deviceSession(r *http.Request, userID int64, deviceName, userAgent string, now int64) (*model.Session, error) {
+	if deviceName == "" && userAgent == "" {
+		return nil, nil
+	}
+	sessions, err := h.Sessions.ListSessions(r.Context(), userID, now)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		session := &sessions[i]
+		if session.DeviceName == deviceName && session.UserAgent == userAgent && session.DeviceName != webDeviceName {
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, status int, userID int64, sessionID, refreshToken string) {
+	token, err := auth.GenerateAccessToken(userID, sessionID)
 	if err != nil {
 		JSONError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.AccessTokenTTL / time.Second),
+	})
 }
 
 func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
@@ -126,7 +278,7 @@ func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.DB.GetUserByEmail(req.Email)
+	user, err := h.Users.GetUserByEmail(r.Context(), req.Email)
 	if err != nil {
 		// User not found: return OK safely
 		w.WriteHeader(http.StatusOK)
@@ -134,17 +286,35 @@ func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.Verification.BlockPasswordReset && h.Verification.restricted(user, time.Now()) {
+		// Same response as for unknown users: do not reveal the account state.
+		slog.InfoContext(r.Context(), "Password reset skipped for unverified user", "user_id", user.ID)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode("A password reset email was sent")
+		return
+	}
+
+	if err := h.sendPasswordReset(r, user); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("A password reset email was sent")
+}
+
+// sendPasswordReset stores a new reset token for the user and mails the
+// link. Only storing the token can fail; mail errors are logged.
+func (h *AuthHandler) sendPasswordReset(r *http.Request, user *model.User) error {
 	// Generate reset token
 	token, hash, err := auth.GenerateResetToken()
 	if err != nil {
-		JSONError(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return err
 	}
 	expiresAt := time.Now().Add(1 * time.Hour).Unix()
 
-	if err := h.DB.SetPasswordResetToken(user.ID, hash, expiresAt); err != nil {
-		JSONError(w, "Database error", http.StatusInternalServerError)
-		return
+	if err := h.Users.SetPasswordResetToken(r.Context(), user.ID, hash, expiresAt); err != nil {
+		return err
 	}
 
 	// Send Email
@@ -153,16 +323,14 @@ func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	htmlBody, err := h.Templates.Render("mail/forgot-password.html", map[string]string{"ResetPasswordLink": link})
 	if err != nil {
 		// Just log error, email might fail or send plain text if we had fallback
-		fmt.Printf("Template render error: %v\n", err)
+		slog.ErrorContext(r.Context(), "Template render error", "error", err)
 	}
 
 	err = h.Mailer.Send(user.Email, "Password reset", "Reset link: "+link, htmlBody)
 	if err != nil {
-		fmt.Printf("Mail send error: %v\n", err)
+		slog.ErrorContext(r.Context(), "Mail send error", "user_id", user.ID, "error", err)
 	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode("A password reset email was sent")
+	return nil
 }
 
 func (h *AuthHandler) ResetPasswordDeeplink(w http.ResponseWriter, r *http.Request) {
@@ -196,7 +364,7 @@ func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tokenHash := auth.HashToken(req.ResetToken)
-	user, err := h.DB.GetUserByResetToken(tokenHash)
+	user, err := h.Users.GetUserByResetToken(r.Context(), tokenHash)
 	if err != nil {
 		JSONError(w, "Invalid or expired token", http.StatusBadRequest)
 		return
@@ -207,7 +375,7 @@ func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.Password) < 2 || len(req.Password) > 24 {
+	if !validPasswordLength(req.Password) {
 		JSONError(w, "Password should be from 2 to 24 characters long", http.StatusBadRequest)
 		return
 	}
@@ -218,13 +386,25 @@ func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.DB.UpdatePassword(user.ID, newHash); err != nil {
+	if err := h.Users.UpdatePassword(r.Context(), user.ID, newHash); err != nil {
 		JSONError(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	h.DB.ClearResetToken(user.ID)
+	h.Users.ClearResetToken(r.Context(), user.ID)
+	h.passwordChanged(r, user.ID, true)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode("Password has been reset successfully")
 }
+
+// passwordChanged queues the password.changed webhook event; reset tells
+// whether the password was set through a reset link.
+func (h *AuthHandler) passwordChanged(r *http.Request, userID int64, reset bool) {
+	if h.Webhooks == nil {
+		return
+	}
+	if err := h.Webhooks.Enqueue(r.Context(), userID, webhooks.EventPasswordChanged, map[string]any{"reset": reset}); err != nil {
+		slog.ErrorContext(r.Context(), "Error queueing webhook events", "user_id", userID, "error", err)
+	}
+}
@@ -2,12 +2,20 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/templates"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
 )
 
@@ -16,7 +24,8 @@ func TestLogin(t *testing.T) {
 	defer database.Close()
 
 	// 1. Test Login with Non-Existent User (Should Auto-Register)
-	handler := &AuthHandler{DB: database}
+	st := sqlstore.New(database)
+	handler := newTestAuthHandler(st)
 
 	creds := map[string]string{
 		"email":    "newuser@example.com",
@@ -40,7 +49,7 @@ func TestLogin(t *testing.T) {
 	}
 
 	// Verify User Created
-	_, err := database.GetUserByEmail("newuser@example.com")
+	_, err := database.GetUserByEmail(context.Background(), "newuser@example.com")
 	if err != nil {
 		t.Fatalf("User was not created: %v", err)
 	}
@@ -73,6 +82,25 @@ func TestLogin(t *testing.T) {
 	// but the auto-reg logic in Login covers the core requirement.
 }
 
+// testStore is implemented by both store implementations.
+type testStore interface {
+	store.UserStore
+	store.SessionStore
+	store.InviteStore
+}
+
+// newTestAuthHandler returns an AuthHandler that records sent mail.
+func newTestAuthHandler(st testStore) *AuthHandler {
+	return &AuthHandler{
+		Users:     st,
+		Sessions:  st,
+		Invites:   st,
+		Mailer:    &testutil.MockMailSender{},
+		Templates: templates.NewManager("../../templates"),
+		BaseURL:   "http://test.local",
+	}
+}
+
 func TestVerifyPasswordInternal(t *testing.T) {
 	// Quick check on auth package helpers
 	password := "testpass"
@@ -85,3 +113,353 @@ func TestVerifyPasswordInternal(t *testing.T) {
 		t.Error("Password verification failed")
 	}
 }
+
+func TestLoginAndMiddlewareWithMemStore(t *testing.T) {
+	st := memstore.New()
+	handler := newTestAuthHandler(st)
+
+	body, _ := json.Marshal(map[string]string{"email": "mem@example.com", "password": "securepassword"})
+	req, _ := http.NewRequest("POST", "/auth", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	handler.Login(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("auto-register failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+
+	middleware := &Middleware{Users: st, Sessions: st}
+	protected := middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetUserID(r); !ok {
+			t.Error("expected user ID in context")
+		}
+	}))
+
+	req, _ = http.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+resp["token"])
+	rr = httptest.NewRecorder()
+	protected.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected authenticated request to pass, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Wrong password for the now existing account.
+	body, _ = json.Marshal(map[string]string{"email": "mem@example.com", "password": "wrong"})
+	req, _ = http.NewRequest("POST", "/auth", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	handler.Login(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for wrong password, got %d", rr.Code)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	handler := newTestAuthHandler(st)
+	middleware := &Middleware{Users: st, Sessions: st}
+
+	login := loginForTokens(t, handler, "refresh@example.com", "password")
+	if login.RefreshToken == "" || login.ExpiresIn <= 0 {
+		t.Fatalf("expected refresh token and expiry, got %+v", login)
+	}
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"refresh_token": token})
+		req, _ := http.NewRequest("POST", "/auth/refresh", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		handler.Refresh(rr, req)
+		return rr
+	}
+
+	rr := refresh(login.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var rotated TokenResponse
+	json.NewDecoder(rr.Body).Decode(&rotated)
+	if rotated.RefreshToken == "" || rotated.RefreshToken == login.RefreshToken {
+		t.Fatalf("expected a rotated refresh token, got %q", rotated.RefreshToken)
+	}
+	if code := authenticatedStatus(middleware, rotated.Token); code != http.StatusOK {
+		t.Fatalf("expected refreshed access token to be accepted, got %d", code)
+	}
+
+	// Replaying the old refresh token revokes the whole session.
+	if rr := refresh(login.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for reused refresh token, got %d", rr.Code)
+	}
+	if rr := refresh(rotated.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected session to be revoked after reuse, got %d", rr.Code)
+	}
+	if code := authenticatedStatus(middleware, rotated.Token); code != http.StatusUnauthorized {
+		t.Fatalf("expected access token of revoked session to be rejected, got %d", code)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	handler := newTestAuthHandler(st)
+	middleware := &Middleware{Users: st, Sessions: st}
+
+	phone := loginForTokens(t, handler, "logout@example.com", "password")
+	tablet := loginForTokens(t, handler, "logout@example.com", "password")
+
+	req, _ := http.NewRequest("POST", "/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+phone.Token)
+	rr := httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(handler.Logout)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("logout failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	if code := authenticatedStatus(middleware, phone.Token); code != http.StatusUnauthorized {
+		t.Fatalf("expected logged out token to be rejected, got %d", code)
+	}
+	if code := authenticatedStatus(middleware, tablet.Token); code != http.StatusOK {
+		t.Fatalf("expected other sessions to stay valid, got %d", code)
+	}
+
+	// Tokens without a session cannot be revoked and are refused.
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{UserID: 1}).SignedString(auth.SecretKey)
+	if code := authenticatedStatus(middleware, legacy); code != http.StatusUnauthorized {
+		t.Fatalf("expected token without session to be rejected, got %d", code)
+	}
+}
+
+func TestLegacyTokens(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	handler := newTestAuthHandler(st)
+	middleware := &Middleware{Users: st, Sessions: st, LegacyTokens: true}
+	loginForTokens(t, handler, "legacy@example.com", "password")
+	user, _ := st.GetUserByEmail(context.Background(), "legacy@example.com")
+
+	legacy := func(expiresAt time.Time) string {
+		claims := &auth.Claims{UserID: user.ID}
+		if !expiresAt.IsZero() {
+			claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+		}
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(auth.SecretKey)
+		return token
+	}
+	if code := authenticatedStatus(middleware, legacy(time.Now().Add(24*time.Hour))); code != http.StatusOK {
+		t.Fatalf("expected a legacy token to be accepted until it expires, got %d", code)
+	}
+	if code := authenticatedStatus(middleware, legacy(time.Now().Add(-time.Minute))); code != http.StatusUnauthorized {
+		t.Fatalf("expected an expired legacy token to be rejected, got %d", code)
+	}
+	if code := authenticatedStatus(middleware, legacy(time.Time{})); code != http.StatusUnauthorized {
+		t.Fatalf("expected a legacy token without expiry to be rejected, got %d", code)
+	}
+
+	// Handlers see no session for legacy tokens.
+	req, _ := http.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+legacy(time.Now().Add(time.Hour)))
+	middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetSessionID(r); ok {
+			t.Error("expected no session for a legacy token")
+		}
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	middleware.LegacyTokens = false
+	if code := authenticatedStatus(middleware, legacy(time.Now().Add(24*time.Hour))); code != http.StatusUnauthorized {
+		t.Fatalf("expected legacy tokens to be rejected once disabled, got %d", code)
+	}
+}
+
+func loginForTokens(t *testing.T, handler *AuthHandler, email, password string) TokenResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	req, _ := http.NewRequest("POST", "/auth", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	handler.Login(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var resp TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode login response failed: %v", err)
+	}
+	return resp
+}
+
+func authenticatedStatus(middleware *Middleware, token string) int {
+	req, _ := http.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestSessionManagement(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	authHandler := newTestAuthHandler(st)
+	userHandler := &UserHandler{Users: st, Sessions: st}
+	syncHandler := &SyncHandler{History: st, Library: st, Bookmarks: st, Sessions: st}
+	middleware := &Middleware{Users: st, Sessions: st}
+
+	login := func(email, deviceName, userAgent string) TokenResponse {
+		body, _ := json.Marshal(map[string]string{"email": email, "password": "password", "device_name": deviceName})
+		req, _ := http.NewRequest("POST", "/auth", bytes.NewBuffer(body))
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = "203.0.113.7:4567"
+		rr := httptest.NewRecorder()
+		authHandler.Login(rr, req)
+		var resp TokenResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return resp
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /resource/history", middleware.AuthMiddleware(http.HandlerFunc(syncHandler.PostHistory)))
+	mux.Handle("GET /me/sessions", middleware.AuthMiddleware(http.HandlerFunc(userHandler.ListSessions)))
+	mux.Handle("DELETE /me/sessions/{id}", middleware.AuthMiddleware(http.HandlerFunc(userHandler.RevokeSession)))
+	call := func(method, path, token string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	phone := login("devices@example.com", "Pixel 7", "Kotatsu/7.0 (Android 14)")
+	tablet := login("devices@example.com", "", "Kotatsu/7.0 (Android 13)")
+	stranger := login("stranger@example.com", "", "curl/8.0")
+
+	body, _ := json.Marshal(model.HistoryPackage{})
+	if rr := call("POST", "/resource/history", phone.Token, body); rr.Code != http.StatusOK {
+		t.Fatalf("sync failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr := call("GET", "/me/sessions", phone.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list sessions failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var sessions []SessionResponse
+	json.NewDecoder(rr.Body).Decode(&sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %+v", sessions)
+	}
+	var current, other SessionResponse
+	for _, s := range sessions {
+		if s.Current {
+			current = s
+		} else {
+			other = s
+		}
+	}
+	if current.DeviceName != "Pixel 7" || current.UserAgent != "Kotatsu/7.0 (Android 14)" || current.IPAddress != "203.0.113.7" {
+		t.Fatalf("unexpected current session metadata: %+v", current)
+	}
+	if current.LastHistorySyncAt == nil || current.LastFavouritesSyncAt != nil {
+		t.Fatalf("expected only a history sync on the current session, got %+v", current)
+	}
+	if other.ID == "" || other.LastHistorySyncAt != nil {
+		t.Fatalf("unexpected other session: %+v", other)
+	}
+
+	// Sessions of other users cannot be revoked.
+	if rr := call("DELETE", "/me/sessions/"+other.ID, stranger.Token, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 revoking another user's session, got %d", rr.Code)
+	}
+	if rr := call("DELETE", "/me/sessions/"+other.ID, phone.Token, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("revoke failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if code := authenticatedStatus(middleware, tablet.Token); code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked device to be signed out, got %d", code)
+	}
+	if rr := call("DELETE", "/me/sessions/"+other.ID, phone.Token, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for already revoked session, got %d", rr.Code)
+	}
+}
+
+func TestLoginReusesDeviceSession(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	for name, st := range map[string]interface {
+		testStore
+		store.MaintenanceStore
+	}{
+		"sqlstore": sqlstore.New(database),
+		"memstore": memstore.New(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			handler := newTestAuthHandler(st)
+			middleware := &Middleware{Users: st, Sessions: st}
+			login := func(deviceName, userAgent string) TokenResponse {
+				body, _ := json.Marshal(map[string]string{"email": "reuse@example.com", "password": "password", "device_name": deviceName})
+				req, _ := http.NewRequest("POST", "/auth", bytes.NewBuffer(body))
+				req.Header.Set("User-Agent", userAgent)
+				rr := httptest.NewRecorder()
+				handler.Login(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("login failed: %d body=%s", rr.Code, rr.Body.String())
+				}
+				var resp TokenResponse
+				json.NewDecoder(rr.Body).Decode(&resp)
+				return resp
+			}
+			sessionsOf := func(userID int64) []model.Session {
+				sessions, err := st.ListSessions(ctx, userID, time.Now().Unix())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sessions
+			}
+
+			first := login("Pixel 7", "Kotatsu/7.0")
+			again := login("Pixel 7", "Kotatsu/7.0")
+			login("Pixel 7", "Kotatsu/7.1")
+			user, _ := st.GetUserByEmail(ctx, "reuse@example.com")
+			if sessions := sessionsOf(user.ID); len(sessions) != 2 {
+				t.Fatalf("expected the same device to keep its session, got %+v", sessions)
+			}
+			if code := authenticatedStatus(middleware, again.Token); code != http.StatusOK {
+				t.Fatalf("expected the renewed session to be accepted, got %d", code)
+			}
+			// The renewed session only accepts the newest refresh token.
+			body, _ := json.Marshal(map[string]string{"refresh_token": first.RefreshToken})
+			req, _ := http.NewRequest("POST", "/auth/refresh", bytes.NewBuffer(body))
+			rr := httptest.NewRecorder()
+			handler.Refresh(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("expected the replaced refresh token to be rejected, got %d", rr.Code)
+			}
+
+			// A revoked session is not reused, and is purged once it ended
+			// before the cut-off.
+			for _, session := range sessionsOf(user.ID) {
+				if err := st.RevokeSession(ctx, user.ID, session.ID, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			login("Pixel 7", "Kotatsu/7.0")
+			if sessions := sessionsOf(user.ID); len(sessions) != 1 {
+				t.Fatalf("expected a new session after revocation, got %+v", sessions)
+			}
+			if purged, err := st.PurgeSessions(ctx, 2); err != nil || purged != 2 {
+				t.Fatalf("expected 2 ended sessions to be purged, got %d, %v", purged, err)
+			}
+			if purged, err := st.PurgeSessions(ctx, time.Now().Unix()); err != nil || purged != 0 {
+				t.Fatalf("expected the active session to be kept, got %d, %v", purged, err)
+			}
+			if sessions := sessionsOf(user.ID); len(sessions) != 1 {
+				t.Fatalf("expected the active session to remain, got %+v", sessions)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/events"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/kotatsu"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/tachiyomi"
+)
+
+// maxBackupUpload limits the size of an uploaded (compressed) backup.
+const maxBackupUpload = 64 << 20
+
+// BackupHandler imports and exports the library in the backup formats of
+// other readers.
+type BackupHandler struct {
+	History   store.HistoryStore
+	Library   store.LibraryStore
+	Bookmarks store.BookmarkStore
+	Imports   store.ImportStore
+	// Events, when set, is notified of the resources an import changed. All
+	// devices are told, including the one that uploaded the backup.
+	Events *events.Hub
+}
+
+// TachiyomiImportResult reports what a Tachiyomi backup import added and what it skipped.
+type TachiyomiImportResult struct {
+	Manga      int              `json:"manga"`
+	Categories int              `json:"categories"`
+	Favourites int              `json:"favourites"`
+	History    int              `json:"history"`
+	Skipped    []tachiyomi.Skip `json:"skipped"`
+}
+
+// KotatsuImportResult reports what a Kotatsu backup import merged and which
+// backup sections the server does not store.
+type KotatsuImportResult struct {
+	History    int      `json:"history"`
+	Categories int      `json:"categories"`
+	Favourites int      `json:"favourites"`
+	Bookmarks  int      `json:"bookmarks"`
+	Ignored    []string `json:"ignored"`
+}
+
+// ImportKotatsu merges a backup ZIP of the Kotatsu app, sent as the request
+// body or as the "file" field of a multipart form, into the synchronized
+// library. The app's backups use the same category and manga IDs as its syncs,
+// so rows are written as they are and newer data already on the server wins.
+func (h *BackupHandler) ImportKotatsu(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := readBackupUpload(w, r)
+	if err != nil {
+		JSONError(w, "Missing backup file", http.StatusBadRequest)
+		return
+	}
+	backup, err := kotatsu.Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		JSONError(w, "Invalid backup file", http.StatusBadRequest)
+		return
+	}
+
+	timestamps, err := h.Imports.ImportLibrary(r.Context(), userID, store.LibraryImport{
+		History:    backup.History,
+		Categories: backup.Categories,
+		Favourites: backup.Favourites,
+		Bookmarks:  backup.Bookmarks,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error importing backup", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.publishImport(r, userID, timestamps)
+
+	ignored := backup.Ignored
+	if ignored == nil {
+		ignored = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(KotatsuImportResult{
+		History:    len(backup.History),
+		Categories: len(backup.Categories),
+		Favourites: len(backup.Favourites),
+		Bookmarks:  len(backup.Bookmarks),
+		Ignored:    ignored,
+	})
+}
+
+// ExportKotatsu responds with the user's history, favourites, categories and
+// bookmarks as a backup ZIP the app can restore.
+func (h *BackupHandler) ExportKotatsu(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	history, err := h.History.GetHistory(r.Context(), userID, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching history for export", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	favourites, categories, err := h.Library.GetFavourites(r.Context(), userID, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching favourites for export", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	bookmarks, err := h.Bookmarks.GetBookmarks(r.Context(), userID, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching bookmarks for export", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="kotatsu_%s.bk.zip"`, now.UTC().Format("20060102-1504")))
+	backup := &kotatsu.Backup{History: history, Categories: categories, Favourites: favourites, Bookmarks: bookmarks}
+	if err := kotatsu.Write(w, backup, now.UnixMilli()); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing backup", "user_id", userID, "error", err)
+	}
+}
+
+// ImportTachiyomi merges a Tachiyomi/Mihon backup into the synchronized
+// library. The backup is sent either as the request body or as the "file"
+// field of a multipart form. Rows are written like a sync from another
+// device, so newer data already on the server wins.
+func (h *BackupHandler) ImportTachiyomi(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := readBackupUpload(w, r)
+	if err != nil {
+		JSONError(w, "Missing backup file", http.StatusBadRequest)
+		return
+	}
+	backup, err := tachiyomi.Read(bytes.NewReader(data))
+	if err != nil {
+		JSONError(w, "Invalid backup file", http.StatusBadRequest)
+		return
+	}
+
+	_, categories, err := h.Library.GetFavourites(r.Context(), userID, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching categories for import", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	lib := tachiyomi.ToLibrary(backup, categories, time.Now().UnixMilli())
+
+	// Manga rows are shared by all users. The backup only knows part of what
+	// the app syncs, so it adds missing manga without updating stored ones.
+	var manga []model.Manga
+	seen := make(map[int64]bool)
+	for i := range lib.Favourites {
+		if m := lib.Favourites[i].Manga; !seen[m.ID] {
+			seen[m.ID] = true
+			manga = append(manga, *m)
+		}
+		lib.Favourites[i].Manga = nil
+	}
+	for i := range lib.History {
+		if m := lib.History[i].Manga; !seen[m.ID] {
+			seen[m.ID] = true
+			manga = append(manga, *m)
+		}
+		lib.History[i].Manga = nil
+	}
+	if len(manga) > 0 {
+		if err := h.Library.AddManga(r.Context(), manga); err != nil {
+			slog.ErrorContext(r.Context(), "Error importing manga", "user_id", userID, "error", err)
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	timestamps, err := h.Imports.ImportLibrary(r.Context(), userID, store.LibraryImport{
+		History:    lib.History,
+		Categories: lib.Categories,
+		Favourites: lib.Favourites,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error importing backup", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.publishImport(r, userID, timestamps)
+
+	skipped := lib.Skipped
+	if skipped == nil {
+		skipped = []tachiyomi.Skip{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TachiyomiImportResult{
+		Manga:      lib.Manga,
+		Categories: len(lib.Categories),
+		Favourites: len(lib.Favourites),
+		History:    len(lib.History),
+		Skipped:    skipped,
+	})
+}
+
+// ExportTachiyomi responds with the user's favourites as a Tachiyomi/Mihon
+// backup. Reading progress is not included, see tachiyomi.FromLibrary.
+func (h *BackupHandler) ExportTachiyomi(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	favourites, categories, err := h.Library.GetFavourites(r.Context(), userID, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching favourites for export", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="kotatsu_%s.tachibk"`, time.Now().UTC().Format("2006-01-02")))
+	if err := tachiyomi.Write(w, tachiyomi.FromLibrary(favourites, categories)); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing backup", "user_id", userID, "error", err)
+	}
+}
+
+// readBackupUpload returns an uploaded backup, sent either as the request body
+// or as the "file" field of a multipart form.
+func readBackupUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBackupUpload)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	return io.ReadAll(r.Body)
+}
+
+// publishImport tells the user's devices about the resources an import wrote.
+func (h *BackupHandler) publishImport(r *http.Request, userID int64, timestamps map[string]int64) {
+	for _, resource := range []string{store.SyncResourceFavourites, store.SyncResourceHistory, store.SyncResourceBookmarks} {
+		if timestamp, ok := timestamps[resource]; ok {
+			publishSync(r, h.Events, userID, "", resource, timestamp)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/events"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/kotatsu"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/tachiyomi"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestTachiyomiBackup(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "backup@example.com", "hash")
+	userID, _ := res.LastInsertId()
+	st := sqlstore.New(database)
+	handler := &BackupHandler{History: st, Library: st, Bookmarks: st, Imports: st}
+
+	mangaDex := tachiyomi.SourceID("MangaDex")
+	backup := &tachiyomi.Backup{
+		Categories: []tachiyomi.Category{{Name: "Reading", Order: 0}, {Name: "Later", Order: 1}},
+		Sources:    []tachiyomi.Source{{Name: "MangaDex", SourceID: mangaDex}, {Name: "漫画柜", SourceID: 42}, {Name: "Some Scans", SourceID: 43}},
+		Manga: []tachiyomi.Manga{
+			{
+				Source: mangaDex, URL: "/title/1", Title: "Read one", Author: "Someone",
+				Genres: []string{"Action", "Comedy"}, Status: tachiyomi.StatusOngoing,
+				DateAdded: 1000, Favorite: true, Categories: []int64{0, 1},
+				Chapters: []tachiyomi.Chapter{
+					{URL: "/chapter/2", SourceOrder: 0, LastPageRead: 7},
+					{URL: "/chapter/1", SourceOrder: 1, Read: true},
+				},
+				History: []tachiyomi.History{{URL: "/chapter/2", LastRead: 5000}},
+			},
+			{Source: mangaDex, URL: "/title/2", Title: "Uncategorized", DateAdded: 2000, Favorite: true},
+			{Source: 42, URL: "/comic/3", Title: "Unmappable", Favorite: true},
+			{Source: 43, URL: "/series/4", Title: "Unlisted", Favorite: true},
+			{Source: 0, URL: "local.cbz", Title: "Local", Favorite: true},
+		},
+	}
+
+	var file bytes.Buffer
+	if err := tachiyomi.Write(&file, backup); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", "/me/import/tachiyomi", &file)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr := httptest.NewRecorder()
+	handler.ImportTachiyomi(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("import failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var result TachiyomiImportResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.Manga != 2 || result.Categories != 3 || result.Favourites != 3 || result.History != 1 {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+	if len(result.Skipped) != 3 || result.Skipped[0].Title != "Unmappable" || result.Skipped[1].Title != "Unlisted" || result.Skipped[2].Title != "Local" {
+		t.Fatalf("expected unmappable, unlisted and local manga to be skipped, got %+v", result.Skipped)
+	}
+
+	history, err := st.GetHistory(context.Background(), userID, nil)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expected one history row, got %d err=%v", len(history), err)
+	}
+	h := history[0]
+	if h.UpdatedAt != 5000 || h.Page != 7 || h.Chapters != 2 || h.Percent != 0.5 {
+		t.Fatalf("unexpected history %+v", h)
+	}
+	if h.Manga == nil || h.Manga.Source != "MANGADEX" || len(h.Manga.Tags) != 2 || h.Manga.State == nil || *h.Manga.State != "ONGOING" {
+		t.Fatalf("unexpected manga %+v", h.Manga)
+	}
+
+	// Importing again reuses the categories created by the first import and
+	// leaves the manga rows, by now synced by the app, as they are.
+	if _, err := database.Exec("UPDATE manga SET title = ?, public_url = ?, rating = ? WHERE id = ?",
+		"Synced title", "https://mangadex.org/title/1", 0.75, h.MangaID); err != nil {
+		t.Fatal(err)
+	}
+	file.Reset()
+	tachiyomi.Write(&file, backup)
+	req, _ = http.NewRequest("POST", "/me/import/tachiyomi", &file)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr = httptest.NewRecorder()
+	handler.ImportTachiyomi(rr, req)
+	json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || result.Categories != 0 {
+		t.Fatalf("expected no new categories on re-import, got %d %+v", rr.Code, result)
+	}
+	history, err = st.GetHistory(context.Background(), userID, nil)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expected one history row, got %d err=%v", len(history), err)
+	}
+	if m := history[0].Manga; m == nil || m.Title != "Synced title" || m.PublicURL != "https://mangadex.org/title/1" || m.Rating != 0.75 {
+		t.Fatalf("expected the import to leave the stored manga unchanged, got %+v", m)
+	}
+
+	// The export contains the favourites in their categories.
+	req, _ = http.NewRequest("GET", "/me/export/tachiyomi", nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr = httptest.NewRecorder()
+	handler.ExportTachiyomi(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("export failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	exported, err := tachiyomi.Read(rr.Body)
+	if err != nil {
+		t.Fatalf("invalid exported backup: %v", err)
+	}
+	if len(exported.Manga) != 2 || len(exported.Categories) != 3 || len(exported.Sources) != 1 {
+		t.Fatalf("unexpected exported backup %+v", exported)
+	}
+	if exported.Sources[0].SourceID != tachiyomi.SourceID("MANGADEX") {
+		t.Fatalf("unexpected exported source %+v", exported.Sources[0])
+	}
+	for _, m := range exported.Manga {
+		if m.Title == "Read one" && (len(m.Categories) != 2 || m.DateAdded != 1000 || m.Status != tachiyomi.StatusOngoing) {
+			t.Fatalf("unexpected exported manga %+v", m)
+		}
+	}
+
+	req, _ = http.NewRequest("POST", "/me/import/tachiyomi", bytes.NewBufferString("not a backup"))
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr = httptest.NewRecorder()
+	handler.ImportTachiyomi(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid backup, got %d", rr.Code)
+	}
+}
+
+func TestKotatsuBackup(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "kotatsu@example.com", "hash")
+	userID, _ := res.LastInsertId()
+	st := sqlstore.New(database)
+	hub := events.NewHub(nil)
+	handler := &BackupHandler{History: st, Library: st, Bookmarks: st, Imports: st, Events: hub}
+	sub := hub.Subscribe(userID)
+	defer sub.Close()
+
+	manga := `{"id":10,"title":"Backed up","url":"/10","public_url":"https://example.com/10","rating":0.5,` +
+		`"cover_url":"https://example.com/10.jpg","state":"ONGOING","source":"MANGADEX",` +
+		`"tags":[{"id":100,"title":"Action","key":"action","source":"MANGADEX"}]}`
+	sections := map[string]string{
+		"index":      `[{"app_id":"org.koitharu.kotatsu","app_version":650,"created_at":1}]`,
+		"history":    `[{"manga_id":10,"created_at":1000,"updated_at":2000,"chapter_id":55,"page":4,"scroll":0,"percent":0.25,"chapters":8,"manga":` + manga + `}]`,
+		"categories": `[{"category_id":3,"created_at":500,"sort_key":1,"title":"Reading","order":"NEWEST","track":true,"show_in_lib":true}]`,
+		"favourites": `[{"manga_id":10,"category_id":3,"sort_key":0,"pinned":true,"created_at":1500,"manga":` + manga + `}]`,
+		"bookmarks":  `[{"manga":` + manga + `,"tags":[],"bookmarks":[{"manga_id":10,"page_id":77,"chapter_id":55,"page":3,"scroll":0,"image_url":"https://example.com/p3.jpg","created_at":1800,"percent":0.2}]}]`,
+		"settings":   `{}`,
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range sections {
+		fw, _ := zw.Create(name)
+		fw.Write([]byte(content))
+	}
+	zw.Close()
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "kotatsu.bk.zip")
+	fw.Write(archive.Bytes())
+	mw.Close()
+	req, _ := http.NewRequest("POST", "/me/import/kotatsu", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr := httptest.NewRecorder()
+	handler.ImportKotatsu(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("import failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var result KotatsuImportResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.History != 1 || result.Categories != 1 || result.Favourites != 1 || result.Bookmarks != 1 || strings.Join(result.Ignored, ",") != "settings" {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+
+	// Every device is told about the imported resources.
+	var announced []string
+	for range 3 {
+		select {
+		case event := <-sub.Events():
+			announced = append(announced, event.Resource)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a sync event per imported resource, got %v", announced)
+		}
+	}
+	slices.Sort(announced)
+	if strings.Join(announced, ",") != "bookmarks,favourites,history" {
+		t.Fatalf("unexpected sync events %v", announced)
+	}
+
+	favourites, categories, err := st.GetFavourites(context.Background(), userID, nil)
+	if err != nil || len(favourites) != 1 || len(categories) != 1 {
+		t.Fatalf("expected imported favourite and category, got %d/%d err=%v", len(favourites), len(categories), err)
+	}
+	if !favourites[0].Pinned || favourites[0].CategoryID != 3 || categories[0].Title != "Reading" {
+		t.Fatalf("unexpected favourites %+v %+v", favourites[0], categories[0])
+	}
+
+	req, _ = http.NewRequest("GET", "/me/export/kotatsu", nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr = httptest.NewRecorder()
+	handler.ExportKotatsu(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+	files := readArchive(t, rr.Body.Bytes())
+	if !strings.Contains(files["index"], `"app_id":"org.koitharu.kotatsu"`) || !strings.Contains(files["history"], `"id":10`) {
+		t.Fatalf("unexpected exported sections %v", files)
+	}
+	exported, err := kotatsu.Read(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid exported backup: %v", err)
+	}
+	if len(exported.History) != 1 || exported.History[0].ChapterID != 55 || exported.History[0].Manga.Tags[0].ID != 100 {
+		t.Fatalf("unexpected exported history %+v", exported.History)
+	}
+	if len(exported.Bookmarks) != 1 || exported.Bookmarks[0].PageID != 77 || exported.Bookmarks[0].ImageURL != "https://example.com/p3.jpg" {
+		t.Fatalf("unexpected exported bookmarks %+v", exported.Bookmarks)
+	}
+
+	// Backups without an index or with a section twice are refused.
+	for name, entries := range map[string][][2]string{
+		"no index":          {{"history", sections["history"]}},
+		"duplicate section": {{"index", sections["index"]}, {"history", "[]"}, {"history", sections["history"]}},
+	} {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, entry := range entries {
+			fw, _ := zw.Create(entry[0])
+			fw.Write([]byte(entry[1]))
+		}
+		zw.Close()
+		if _, err := kotatsu.Read(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+			t.Errorf("%s: expected the backup to be refused", name)
+		}
+	}
+
+	req, _ = http.NewRequest("POST", "/me/import/kotatsu", bytes.NewBufferString("not a zip"))
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr = httptest.NewRecorder()
+	handler.ImportKotatsu(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid backup, got %d", rr.Code)
+	}
+}
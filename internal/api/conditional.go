@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// syncETag builds a strong ETag from the user's sync timestamp, which changes
+// on every accepted write. Delta responses carry the requested timestamp too,
+// since their body depends on it.
+func syncETag(timestamp *int64, since *int64) string {
+	var version int64
+	if timestamp != nil {
+		version = *timestamp
+	}
+	if since != nil {
+		return fmt.Sprintf(`"%d-%d"`, version, *since)
+	}
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// setSyncCacheHeaders advertises the library version of a sync response.
+func setSyncCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Authorization, X-Sync-Timestamp")
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches etag, using
+// weak comparison as required for GET.
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersions parses the If-Match header into the library versions the
+// client accepts. A nil slice means the request is unconditional. Weak and
+// malformed tags never match, so they yield an empty, non-nil slice.
+func ifMatchVersions(r *http.Request) []int64 {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	versions := []int64{}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return nil
+		}
+		if len(candidate) < 2 || !strings.HasPrefix(candidate, `"`) || !strings.HasSuffix(candidate, `"`) {
+			continue
+		}
+		tag := strings.Trim(candidate, `"`)
+		// Delta ETags carry the requested timestamp after the version.
+		if i := strings.IndexByte(tag, '-'); i >= 0 {
+			tag = tag[:i]
+		}
+		version, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
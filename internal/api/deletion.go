@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// DeleteAccount schedules the account for deletion after the grace period and
+// signs out every device. The emailed link cancels the deletion until then.
+func (h *AuthHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !h.checkPassword(w, req.Password, user.PasswordHash) {
+		return
+	}
+	if user.DeletionScheduledAt != nil {
+		JSONError(w, "Account is already scheduled for deletion", http.StatusConflict)
+		return
+	}
+
+	token, hash, err := auth.GenerateResetToken()
+	if err != nil {
+		JSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	purgeAt := now.Add(h.DeletionGracePeriod)
+
+	if err := h.Users.ScheduleDeletion(r.Context(), user.ID, purgeAt.Unix(), hash); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Sessions.RevokeOtherSessions(r.Context(), user.ID, "", now.Unix()); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	link := fmt.Sprintf("%s/deeplink/cancel-deletion?token=%s", h.BaseURL, token)
+	date := purgeAt.UTC().Format("2006-01-02 15:04 MST")
+	htmlBody, err := h.Templates.Render("mail/account-deletion.html", map[string]string{"CancelLink": link, "PurgeDate": date})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Template render error", "error", err)
+	}
+	if err := h.Mailer.Send(user.Email, "Account deletion scheduled", "Your account will be deleted on "+date+". Cancel link: "+link, htmlBody); err != nil {
+		slog.ErrorContext(r.Context(), "Mail send error", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int64{"deletion_scheduled_at": purgeAt.Unix()})
+}
+
+// CancelDeletionDeeplink serves the emailed cancellation link. GET asks for
+// confirmation, so that mail scanners following the link change nothing, and
+// the POST of its form cancels the deletion.
+func (h *AuthHandler) CancelDeletionDeeplink(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if token == "" {
+		JSONError(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+
+	valid := false
+	user, err := h.Users.GetUserByDeletionToken(r.Context(), auth.HashToken(token))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	default:
+		valid = user.DeletionScheduledAt != nil && *user.DeletionScheduledAt > time.Now().Unix()
+	}
+
+	cancelled := false
+	if valid && r.Method == http.MethodPost {
+		if err := h.Users.CancelDeletion(r.Context(), user.ID); err != nil {
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		cancelled = true
+	}
+
+	html, err := h.Templates.Render("pages/cancel-deletion.html", map[string]any{
+		"Token":     token,
+		"Confirm":   valid && !cancelled,
+		"Cancelled": cancelled,
+	})
+	if err != nil {
+		JSONError(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write([]byte(html))
+}
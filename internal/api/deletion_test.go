@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestAccountDeletion(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	authHandler := newTestAuthHandler(st)
+	authHandler.DeletionGracePeriod = time.Hour
+	mailer := authHandler.Mailer.(*testutil.MockMailSender)
+	syncHandler := newSQLSyncHandler(database)
+	middleware := &Middleware{Users: st, Sessions: st}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /deeplink/cancel-deletion", authHandler.CancelDeletionDeeplink)
+	mux.HandleFunc("POST /deeplink/cancel-deletion", authHandler.CancelDeletionDeeplink)
+	mux.Handle("DELETE /me", middleware.AuthMiddleware(http.HandlerFunc(authHandler.DeleteAccount)))
+
+	deleteMe := func(token, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"password": password})
+		req, _ := http.NewRequest("DELETE", "/me", bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	login := func(email string) int {
+		body, _ := json.Marshal(map[string]string{"email": email, "password": "password"})
+		req, _ := http.NewRequest("POST", "/auth", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		authHandler.Login(rr, req)
+		return rr.Code
+	}
+
+	leaving := loginForTokens(t, authHandler, "leaving@example.com", "password")
+	loginForTokens(t, authHandler, "staying@example.com", "password")
+	leavingUser, _ := database.GetUserByEmail(context.Background(), "leaving@example.com")
+	stayingUser, _ := database.GetUserByEmail(context.Background(), "staying@example.com")
+
+	shared := model.Manga{ID: 1, Title: "Shared", URL: "/1", PublicURL: "/1", Rating: 1, Source: "test", CoverURL: "/1.jpg"}
+	private := model.Manga{ID: 2, Title: "Private", URL: "/2", PublicURL: "/2", Rating: 1, Source: "test", CoverURL: "/2.jpg",
+		Tags: []model.Tag{{ID: 20, Title: "Private tag", Key: "private", Source: "test"}}}
+	postHistoryPackage(t, syncHandler, leavingUser.ID, model.HistoryPackage{History: []model.History{
+		{MangaID: shared.ID, Manga: &shared, CreatedAt: 1, UpdatedAt: 1},
+		{MangaID: private.ID, Manga: &private, CreatedAt: 1, UpdatedAt: 1},
+	}})
+	postHistoryPackage(t, syncHandler, stayingUser.ID, model.HistoryPackage{History: []model.History{
+		{MangaID: shared.ID, Manga: &shared, CreatedAt: 1, UpdatedAt: 1},
+	}})
+
+	if rr := deleteMe(leaving.Token, "wrong"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for wrong password, got %d", rr.Code)
+	}
+	rr := deleteMe(leaving.Token, "password")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for deletion, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if code := authenticatedStatus(middleware, leaving.Token); code != http.StatusUnauthorized {
+		t.Fatalf("expected sessions to be revoked, got %d", code)
+	}
+	if code := login("leaving@example.com"); code != http.StatusForbidden {
+		t.Fatalf("expected login to be refused during the grace period, got %d", code)
+	}
+
+	// Opening the emailed link only asks for confirmation.
+	notice := mailer.SentEmails[len(mailer.SentEmails)-1]
+	_, link, _ := strings.Cut(notice.TextBody, "Cancel link: ")
+	req, _ := http.NewRequest("GET", strings.TrimPrefix(link, "http://test.local"), nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `method="post"`) {
+		t.Fatalf("expected a confirmation form, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if code := login("leaving@example.com"); code != http.StatusForbidden {
+		t.Fatalf("expected opening the link to keep the deletion, got %d", code)
+	}
+
+	// Submitting the form restores the account.
+	linkURL, _ := url.Parse(link)
+	form := url.Values{"token": {linkURL.Query().Get("token")}}
+	req, _ = http.NewRequest("POST", "/deeplink/cancel-deletion", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected cancellation to succeed, got %d", rr.Code)
+	}
+	if code := login("leaving@example.com"); code != http.StatusOK {
+		t.Fatalf("expected login after cancellation, got %d", code)
+	}
+
+	// Nothing is purged before the grace period ends.
+	leaving = loginForTokens(t, authHandler, "leaving@example.com", "password")
+	if rr := deleteMe(leaving.Token, "password"); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for second deletion, got %d", rr.Code)
+	}
+	if purged, err := st.PurgeDeletedUsers(context.Background(), time.Now().Unix()); err != nil || purged != 0 {
+		t.Fatalf("expected no purge within the grace period, got %d err=%v", purged, err)
+	}
+
+	purged, err := st.PurgeDeletedUsers(context.Background(), time.Now().Add(2*time.Hour).Unix())
+	if err != nil || purged != 1 {
+		t.Fatalf("expected one purged account, got %d err=%v", purged, err)
+	}
+
+	count := func(query string, args ...any) int {
+		var n int
+		if err := database.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+	if n := count("SELECT COUNT(*) FROM users WHERE id = ?", leavingUser.ID); n != 0 {
+		t.Fatalf("expected user to be purged, found %d", n)
+	}
+	if n := count("SELECT COUNT(*) FROM history WHERE user_id = ?", leavingUser.ID); n != 0 {
+		t.Fatalf("expected history to be purged, found %d rows", n)
+	}
+	if n := count("SELECT COUNT(*) FROM sessions WHERE user_id = ?", leavingUser.ID); n != 0 {
+		t.Fatalf("expected sessions to be purged, found %d rows", n)
+	}
+	if n := count("SELECT COUNT(*) FROM manga WHERE id = ?", shared.ID); n != 1 {
+		t.Fatal("expected manga still referenced by another user to be kept")
+	}
+	if n := count("SELECT COUNT(*) FROM manga WHERE id = ?", private.ID); n != 0 {
+		t.Fatal("expected unreferenced manga to be purged")
+	}
+	if n := count("SELECT COUNT(*) FROM tags WHERE id = ?", 20); n != 0 {
+		t.Fatal("expected unreferenced tag to be purged")
+	}
+}
+
+func TestPurgeUser(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	for name, st := range map[string]interface {
+		store.UserStore
+		store.HistoryStore
+		store.MaintenanceStore
+	}{
+		"sqlstore": sqlstore.New(database),
+		"memstore": memstore.New(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			removedID, _ := st.CreateUser(ctx, "removed@example.com", "hash")
+			dueID, _ := st.CreateUser(ctx, "due@example.com", "hash")
+			private := model.Manga{ID: 5, Title: "Private", Source: "test", Tags: []model.Tag{{ID: 50, Title: "Tag", Key: "tag", Source: "test"}}}
+			if _, err := st.SyncHistory(ctx, removedID, "", []model.History{{MangaID: private.ID, Manga: &private, CreatedAt: 1, UpdatedAt: 1}}, nil); err != nil {
+				t.Fatal(err)
+			}
+			// An account whose deletion is due is left to the purge job.
+			if err := st.ScheduleDeletion(ctx, dueID, 1, "due-token"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := st.PurgeUser(ctx, removedID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.GetUserByID(ctx, removedID); !errors.Is(err, store.ErrNotFound) {
+				t.Fatalf("expected the user to be removed, got %v", err)
+			}
+			if _, err := st.GetUserByID(ctx, dueID); err != nil {
+				t.Fatalf("expected other accounts to be kept, got %v", err)
+			}
+			if err := st.PurgeUser(ctx, removedID); !errors.Is(err, store.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound for a removed user, got %v", err)
+			}
+			if name == "sqlstore" {
+				var manga, tags int
+				database.QueryRow("SELECT COUNT(*) FROM manga WHERE id = ?", private.ID).Scan(&manga)
+				database.QueryRow("SELECT COUNT(*) FROM tags WHERE id = ?", 50).Scan(&tags)
+				if manga != 0 || tags != 0 {
+					t.Fatalf("expected unreferenced manga and tags to be removed, got %d/%d", manga, tags)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/events"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+type EventsHandler struct {
+	Hub      *events.Hub
+	Sessions store.SessionStore
+	// KeepAlive is the interval of the comments written to keep idle
+	// connections from being closed by proxies. The session of the stream is
+	// checked at the same interval.
+	KeepAlive time.Duration
+}
+
+// Stream sends a "sync" Server-Sent Event whenever another device of the user
+// commits a sync, with the resource and its new timestamp. Changes made by the
+// calling session are not echoed back.
+func (h *EventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := GetSessionID(r)
+
+	rc := http.NewResponseController(w)
+	sub := h.Hub.Subscribe(userID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := h.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = 30 * time.Second
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if !h.sessionActive(r, sessionID) {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if sessionID != "" && event.SessionID == sessionID {
+				continue
+			}
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(w, "event: sync\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sessionActive tells whether the session that opened the stream was neither
+// revoked nor has expired since. Database errors keep the stream open.
+func (h *EventsHandler) sessionActive(r *http.Request, sessionID string) bool {
+	if h.Sessions == nil || sessionID == "" {
+		return true
+	}
+	session, err := h.Sessions.GetSession(r.Context(), sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Events: failed to check session", "session_id", sessionID, "error", err)
+		return true
+	}
+	return session.RevokedAt == nil && session.ExpiresAt > time.Now().Unix()
+}
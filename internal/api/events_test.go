@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/events"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+)
+
+func TestSyncEventStream(t *testing.T) {
+	st := memstore.New()
+	userID, _ := st.CreateUser(context.Background(), "events@example.com", "hash")
+	hub := events.NewHub(nil)
+	syncHandler := &SyncHandler{History: st, Library: st, Bookmarks: st, Sessions: st, Events: hub}
+	eventsHandler := &EventsHandler{Hub: hub}
+
+	// Stand in for the auth middleware: the session is taken from a header.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		ctx = context.WithValue(ctx, SessionIDKey, r.Header.Get("X-Session"))
+		eventsHandler.Stream(w, r.WithContext(ctx))
+	}))
+	// Registered before the streams, so it runs after they are closed.
+	t.Cleanup(server.Close)
+
+	connect := func(session string) *bufio.Reader {
+		t.Helper()
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("X-Session", session)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		reader := bufio.NewReader(resp.Body)
+		if line, _ := reader.ReadString('\n'); line != ": connected\n" {
+			t.Fatalf("unexpected first line %q", line)
+		}
+		reader.ReadString('\n')
+		return reader
+	}
+	phone := connect("phone")
+	tablet := connect("tablet")
+
+	body, _ := json.Marshal(model.HistoryPackage{History: []model.History{{MangaID: 1, Manga: &model.Manga{ID: 1, Title: "Evented", Source: "test"}, ChapterID: 1, UpdatedAt: 100}}})
+	req, _ := http.NewRequest("POST", "/resource/history", strings.NewReader(string(body)))
+	ctx := context.WithValue(req.Context(), UserIDKey, userID)
+	req = req.WithContext(context.WithValue(ctx, SessionIDKey, "phone"))
+	rr := httptest.NewRecorder()
+	syncHandler.PostHistory(rr, req)
+	var pkg model.HistoryPackage
+	json.NewDecoder(rr.Body).Decode(&pkg)
+	if rr.Code != http.StatusOK || pkg.Timestamp == nil {
+		t.Fatalf("PostHistory failed: %d", rr.Code)
+	}
+
+	lines := make(chan string, 2)
+	go func() {
+		for range 2 {
+			line, _ := tablet.ReadString('\n')
+			lines <- line
+		}
+	}()
+	for _, prefix := range []string{"event: sync", "data: "} {
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, prefix) {
+				t.Fatalf("expected %q, got %q", prefix, line)
+			}
+			if prefix == "data: " {
+				var event events.Event
+				json.Unmarshal([]byte(strings.TrimPrefix(line, prefix)), &event)
+				if event.Resource != "history" || event.Timestamp != *pkg.Timestamp || event.SessionID != "phone" {
+					t.Fatalf("unexpected event %+v", event)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the sync event")
+		}
+	}
+
+	// The device that made the change is not notified of it.
+	done := make(chan string, 1)
+	go func() {
+		line, _ := phone.ReadString('\n')
+		done <- line
+	}()
+	select {
+	case line := <-done:
+		t.Fatalf("expected no event for the originating session, got %q", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSyncEventStreamClosesOnRevoke(t *testing.T) {
+	st := memstore.New()
+	ctx := context.Background()
+	userID, _ := st.CreateUser(ctx, "revoked@example.com", "hash")
+	now := time.Now().Unix()
+	if err := st.CreateSession(ctx, &model.Session{ID: "phone", UserID: userID, RefreshTokenHash: "hash", CreatedAt: now, LastSeenAt: now, ExpiresAt: now + 3600}); err != nil {
+		t.Fatal(err)
+	}
+	eventsHandler := &EventsHandler{Hub: events.NewHub(nil), Sessions: st, KeepAlive: 10 * time.Millisecond}
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	reqCtx := context.WithValue(req.Context(), UserIDKey, userID)
+	req = req.WithContext(context.WithValue(reqCtx, SessionIDKey, "phone"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		eventsHandler.Stream(httptest.NewRecorder(), req)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected the stream of an active session to stay open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := st.RevokeSession(ctx, userID, "phone", time.Now().Unix()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to close once its session is revoked")
+	}
+}
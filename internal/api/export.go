@@ -0,0 +1,267 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/export"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/mail"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/templates"
+)
+
+// ExportHandler serves personal data archives. Small libraries are exported
+// in the request; larger ones are built in the background and a download link
+// is mailed when the archive is ready.
+type ExportHandler struct {
+	Sources   export.Sources
+	Exports   store.ExportStore
+	Mailer    mail.MailSender
+	Templates *templates.Manager
+	BaseURL   string
+	// Dir holds the archives built in the background.
+	Dir string
+	// TTL is how long a mailed download link stays valid.
+	TTL time.Duration
+	// AsyncThreshold is the number of history and favourite entries above
+	// which the archive is built in the background.
+	AsyncThreshold int
+	// BuildTimeout bounds building an archive in the background. Exports
+	// still pending after it, such as those interrupted by a restart, count
+	// as failed. Zero means no limit.
+	BuildTimeout time.Duration
+
+	jobs sync.WaitGroup
+}
+
+// Export responds with the archive, or with 202 and the export record when it
+// is built in the background.
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	entries, err := h.Exports.CountLibraryEntries(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if entries <= h.AsyncThreshold {
+		var buf bytes.Buffer
+		if err := export.WriteArchive(r.Context(), &buf, h.Sources, userID); err != nil {
+			slog.ErrorContext(r.Context(), "Export failed", "user_id", userID, "error", err)
+			JSONError(w, "Export failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", exportDisposition(time.Now()))
+		w.Write(buf.Bytes())
+		return
+	}
+
+	// Requests while an export is being built return that export.
+	pending, err := h.Exports.GetPendingExport(r.Context(), userID)
+	if err == nil && !h.stale(pending, time.Now()) {
+		writeExport(w, pending)
+		return
+	}
+	if err == nil {
+		if err := h.Exports.FinishExport(r.Context(), pending.ID, store.ExportFailed, 0, time.Now().Unix()); err != nil {
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	} else if !errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.Sources.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	id, err := auth.GenerateSessionID()
+	if err != nil {
+		JSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	token, hash, err := auth.GenerateResetToken()
+	if err != nil {
+		JSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	exp := &model.Export{
+		ID:        id,
+		UserID:    userID,
+		TokenHash: hash,
+		Status:    store.ExportPending,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(h.TTL).Unix(),
+	}
+	if err := h.Exports.CreateExport(r.Context(), exp); err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	h.jobs.Add(1)
+	go func() {
+		defer h.jobs.Done()
+		h.build(context.WithoutCancel(r.Context()), exp, token, user.Email)
+	}()
+
+	writeExport(w, exp)
+}
+
+// stale tells whether a pending export has outlived BuildTimeout, so that no
+// build will finish it anymore.
+func (h *ExportHandler) stale(exp *model.Export, now time.Time) bool {
+	return h.BuildTimeout > 0 && time.Unix(exp.CreatedAt, 0).Add(h.BuildTimeout).Before(now)
+}
+
+// build writes the archive of a pending export and mails the download link.
+func (h *ExportHandler) build(ctx context.Context, exp *model.Export, token, email string) {
+	buildCtx := ctx
+	if h.BuildTimeout > 0 {
+		var cancel context.CancelFunc
+		buildCtx, cancel = context.WithTimeout(ctx, h.BuildTimeout)
+		defer cancel()
+	}
+	size, err := h.writeFile(buildCtx, exp)
+	if err != nil {
+		slog.ErrorContext(ctx, "Export failed", "export_id", exp.ID, "user_id", exp.UserID, "error", err)
+		if err := h.Exports.FinishExport(ctx, exp.ID, store.ExportFailed, 0, time.Now().Unix()); err != nil {
+			slog.ErrorContext(ctx, "Failed to record export failure", "export_id", exp.ID, "error", err)
+		}
+		return
+	}
+	if err := h.Exports.FinishExport(ctx, exp.ID, store.ExportReady, size, time.Now().Unix()); err != nil {
+		slog.ErrorContext(ctx, "Failed to record export", "export_id", exp.ID, "error", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/exports/%s", h.BaseURL, token)
+	expires := time.Unix(exp.ExpiresAt, 0).UTC().Format("2006-01-02 15:04 MST")
+	htmlBody, err := h.Templates.Render("mail/export-ready.html", map[string]string{"DownloadLink": link, "ExpiresAt": expires})
+	if err != nil {
+		slog.ErrorContext(ctx, "Template render error", "error", err)
+	}
+	if err := h.Mailer.Send(email, "Your data export is ready", "Download link (valid until "+expires+"): "+link, htmlBody); err != nil {
+		slog.ErrorContext(ctx, "Mail send error", "error", err)
+	}
+}
+
+func (h *ExportHandler) writeFile(ctx context.Context, exp *model.Export) (int64, error) {
+	if err := os.MkdirAll(h.Dir, 0o700); err != nil {
+		return 0, err
+	}
+	path := h.archivePath(exp.ID)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+
+	err = export.WriteArchive(ctx, f, h.Sources, exp.UserID)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Download serves a background export by the token from the mailed link.
+func (h *ExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	exp, err := h.Exports.GetExportByToken(r.Context(), auth.HashToken(r.PathValue("token")))
+	if errors.Is(err, store.ErrNotFound) || (err == nil && (exp.Status != store.ExportReady || exp.ExpiresAt <= time.Now().Unix())) {
+		JSONError(w, "Export not found or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(h.archivePath(exp.ID))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Export archive missing", "export_id", exp.ID, "error", err)
+		JSONError(w, "Export not found or expired", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	created := time.Unix(exp.CreatedAt, 0)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", exportDisposition(created))
+	http.ServeContent(w, r, "", created, f)
+}
+
+// Cleanup removes expired exports and archives no export refers to anymore,
+// such as those of purged accounts.
+func (h *ExportHandler) Cleanup(ctx context.Context) {
+	if _, err := h.Exports.DeleteExpiredExports(ctx, time.Now().Unix()); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete expired exports", "error", err)
+		return
+	}
+
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.ErrorContext(ctx, "Failed to list exports", "error", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".zip")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := h.Exports.GetExport(ctx, id); errors.Is(err, store.ErrNotFound) {
+			if err := os.Remove(filepath.Join(h.Dir, entry.Name())); err != nil {
+				slog.ErrorContext(ctx, "Failed to remove export archive", "file", entry.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// Wait blocks until all background exports have finished.
+func (h *ExportHandler) Wait() {
+	h.jobs.Wait()
+}
+
+func (h *ExportHandler) archivePath(id string) string {
+	return filepath.Join(h.Dir, id+".zip")
+}
+
+func exportDisposition(t time.Time) string {
+	return fmt.Sprintf(`attachment; filename="kotatsu-export-%s.zip"`, t.UTC().Format("2006-01-02"))
+}
+
+func writeExport(w http.ResponseWriter, exp *model.Export) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(exp)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/export"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestPersonalDataExport(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	authHandler := newTestAuthHandler(st)
+	mailer := &testutil.MockMailSender{}
+	middleware := &Middleware{Users: st, Sessions: st}
+	exportHandler := &ExportHandler{
+		Sources:        export.Sources{Users: st, Sessions: st, History: st, Library: st, Bookmarks: st, ReadingLog: st},
+		Exports:        st,
+		Mailer:         mailer,
+		Templates:      authHandler.Templates,
+		BaseURL:        "http://test.local",
+		Dir:            t.TempDir(),
+		TTL:            time.Hour,
+		AsyncThreshold: 10,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /me/export", middleware.AuthMiddleware(http.HandlerFunc(exportHandler.Export)))
+	mux.HandleFunc("GET /exports/{token}", exportHandler.Download)
+	get := func(target, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := loginForTokens(t, authHandler, "export@example.com", "password")
+	user, _ := database.GetUserByEmail(context.Background(), "export@example.com")
+	manga := model.Manga{ID: 5, Title: "Exported", URL: "/5", PublicURL: "/5", Rating: 1, Source: "test", CoverURL: "/5.jpg"}
+	postHistoryPackage(t, newSQLSyncHandler(database), user.ID, model.HistoryPackage{History: []model.History{
+		{MangaID: manga.ID, Manga: &manga, CreatedAt: 1, UpdatedAt: 2, Page: 3},
+	}})
+
+	// A small library is exported in the request.
+	rr := get("/me/export", login.Token)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected inline archive, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	files := readArchive(t, rr.Body.Bytes())
+	for _, name := range []string{"account.json", "history.json", "favourites.json", "bookmarks.json", "reading_log.json", "sessions.json"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("archive is missing %s", name)
+		}
+	}
+	if strings.Contains(files["account.json"], user.PasswordHash) || !strings.Contains(files["account.json"], "export@example.com") {
+		t.Fatalf("unexpected account record: %s", files["account.json"])
+	}
+	var history model.HistoryPackage
+	if err := json.Unmarshal([]byte(files["history.json"]), &history); err != nil || len(history.History) != 1 || history.History[0].Page != 3 {
+		t.Fatalf("unexpected history package: %s err=%v", files["history.json"], err)
+	}
+	if strings.Contains(files["sessions.json"], "refresh_token") {
+		t.Fatalf("sessions must not contain token hashes: %s", files["sessions.json"])
+	}
+
+	// Larger libraries are built in the background and the link is mailed.
+	exportHandler.AsyncThreshold = 0
+	rr = get("/me/export", login.Token)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for background export, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var pending model.Export
+	json.NewDecoder(rr.Body).Decode(&pending)
+	if pending.Status != store.ExportPending || pending.ID == "" {
+		t.Fatalf("unexpected export record %+v", pending)
+	}
+	exportHandler.Wait()
+
+	if len(mailer.SentEmails) != 1 || mailer.SentEmails[0].To != "export@example.com" {
+		t.Fatalf("expected a download email, got %+v", mailer.SentEmails)
+	}
+	_, link, _ := strings.Cut(mailer.SentEmails[0].TextBody, ": ")
+	path := strings.TrimPrefix(link, "http://test.local")
+	rr = get(path, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected download to succeed, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if files := readArchive(t, rr.Body.Bytes()); files["history.json"] == "" {
+		t.Fatal("downloaded archive has no history")
+	}
+	if rr := get("/exports/unknown", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown token, got %d", rr.Code)
+	}
+
+	// Expired exports lose their archive.
+	if _, err := database.Exec("UPDATE exports SET expires_at = ?", time.Now().Unix()-1); err != nil {
+		t.Fatal(err)
+	}
+	if rr := get(path, ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for expired export, got %d", rr.Code)
+	}
+	exportHandler.Cleanup(context.Background())
+	if _, err := os.Stat(exportHandler.archivePath(pending.ID)); !os.IsNotExist(err) {
+		t.Fatalf("expected archive to be removed, stat err=%v", err)
+	}
+
+	// An export left pending by a restart does not block new ones.
+	exportHandler.BuildTimeout = time.Hour
+	interrupted := &model.Export{ID: "interrupted", UserID: user.ID, TokenHash: "interrupted", Status: store.ExportPending,
+		CreatedAt: time.Now().Add(-2 * time.Hour).Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	if err := st.CreateExport(context.Background(), interrupted); err != nil {
+		t.Fatal(err)
+	}
+	rr = get("/me/export", login.Token)
+	var restarted model.Export
+	json.NewDecoder(rr.Body).Decode(&restarted)
+	if rr.Code != http.StatusAccepted || restarted.ID == interrupted.ID {
+		t.Fatalf("expected a new export, got %d %+v", rr.Code, restarted)
+	}
+	exportHandler.Wait()
+	if exp, err := st.GetExport(context.Background(), interrupted.ID); err != nil || exp.Status != store.ExportFailed {
+		t.Fatalf("expected the interrupted export to be failed, got %+v, %v", exp, err)
+	}
+}
+
+func readArchive(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid archive: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	return files
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const (
+	defaultLibraryLimit = 50
+	maxLibraryLimit     = 200
+	// maxSearchTerms bounds the words of a search query sent to the index.
+	maxSearchTerms = 10
+)
+
+type LibraryHandler struct {
+	Library store.LibraryStore
+}
+
+type LibraryResponse struct {
+	Entries    []model.LibraryEntry `json:"entries"`
+	NextCursor *string              `json:"next_cursor"`
+}
+
+// libraryCursor is the opaque cursor handed to clients. It carries the sort
+// order so a cursor cannot be replayed against another one.
+type libraryCursor struct {
+	Sort string
+	store.LibraryCursor
+}
+
+// Search lists the user's favourite manga without downloading the whole
+// favourites package. See the README for the query parameters.
+func (h *LibraryHandler) Search(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	query := store.LibraryQuery{
+		Terms:  searchTerms(params.Get("q")),
+		Source: params.Get("source"),
+		TagKey: params.Get("tag"),
+		State:  params.Get("state"),
+		Sort:   strings.ToUpper(params.Get("sort")),
+		Limit:  defaultLibraryLimit,
+	}
+	switch query.Sort {
+	case "":
+		query.Sort = store.LibraryNewest
+	case store.LibraryNewest, store.LibraryOldest, store.LibraryAlphabetic, store.LibraryAlphabeticReverse, store.LibraryRating:
+	default:
+		JSONError(w, "Invalid sort", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if value := params.Get("category"); value != "" {
+		if query.CategoryID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			JSONError(w, "Invalid category", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.NSFW, err = optionalBool(params.Get("nsfw")); err != nil {
+		JSONError(w, "Invalid nsfw", http.StatusBadRequest)
+		return
+	}
+	if query.Pinned, err = optionalBool(params.Get("pinned")); err != nil {
+		JSONError(w, "Invalid pinned", http.StatusBadRequest)
+		return
+	}
+	if value := params.Get("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit <= 0 {
+			JSONError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = min(query.Limit, maxLibraryLimit)
+	}
+	if value := params.Get("cursor"); value != "" {
+		if query.After, ok = decodeLibraryCursor(value, query.Sort); !ok {
+			JSONError(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// One entry more than requested tells whether there is a next page.
+	limit := query.Limit
+	query.Limit++
+	entries, err := h.Library.SearchLibrary(r.Context(), userID, query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching library", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := LibraryResponse{Entries: entries}
+	if resp.Entries == nil {
+		resp.Entries = []model.LibraryEntry{}
+	}
+	if len(entries) > limit {
+		resp.Entries = entries[:limit]
+		next := encodeLibraryCursor(query.Sort, resp.Entries[limit-1])
+		resp.NextCursor = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// encodeLibraryCursor returns the cursor of the page following entry.
+func encodeLibraryCursor(sort string, entry model.LibraryEntry) string {
+	data, _ := json.Marshal(libraryCursor{Sort: sort, LibraryCursor: store.LibraryCursor{
+		MangaID: entry.Manga.ID,
+		AddedAt: entry.AddedAt,
+		Title:   entry.Manga.Title,
+		Rating:  entry.Manga.Rating,
+	}})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeLibraryCursor parses a cursor, which has to be for the given sort order.
+func decodeLibraryCursor(value, sort string) (*store.LibraryCursor, bool) {
+	var cursor libraryCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.Sort != sort {
+		return nil, false
+	}
+	return &cursor.LibraryCursor, true
+}
+
+// searchTerms splits a search query into lower-case words of letters and
+// digits, which the full-text indexes accept without escaping.
+func searchTerms(q string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+func optionalBool(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestLibrarySearch(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	for name, st := range map[string]interface {
+		store.UserStore
+		store.LibraryStore
+	}{
+		"sqlstore": sqlstore.New(database),
+		"memstore": memstore.New(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			userID, err := st.CreateUser(ctx, "library@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := &LibraryHandler{Library: st}
+
+			author, ongoing, finished, nsfw := "Oda Eiichiro", "ONGOING", "FINISHED", true
+			miura := "Miura"
+			action := model.Tag{ID: 900, Title: "Action", Key: "action", Source: "MANGADEX"}
+			onePiece := &model.Manga{ID: 90, Title: "One Piece", Author: &author, URL: "/90", PublicURL: "/90", Rating: 0.9, Source: "MANGADEX", CoverURL: "/90.jpg", State: &ongoing, Tags: []model.Tag{action}}
+			cake := &model.Manga{ID: 91, Title: "Piece of Cake", URL: "/91", PublicURL: "/91", Rating: 0.5, Source: "OTHER", CoverURL: "/91.jpg", NSFW: &nsfw}
+			berserk := &model.Manga{ID: 92, Title: "Berserk", Author: &miura, URL: "/92", PublicURL: "/92", Rating: 0.95, Source: "MANGADEX", CoverURL: "/92.jpg", State: &finished}
+			_, err = st.SyncFavourites(ctx, userID,
+				[]model.Category{{ID: 1, Title: "Reading", Order: "NEWEST"}, {ID: 2, Title: "Best", Order: "NEWEST"}},
+				[]model.Favourite{
+					{MangaID: 90, Manga: onePiece, CategoryID: 1, CreatedAt: 100},
+					{MangaID: 90, Manga: onePiece, CategoryID: 2, CreatedAt: 300, Pinned: true},
+					{MangaID: 91, Manga: cake, CategoryID: 1, CreatedAt: 200},
+					{MangaID: 92, Manga: berserk, CategoryID: 2, CreatedAt: 400},
+					{MangaID: 93, CategoryID: 1, CreatedAt: 500, DeletedAt: 600},
+				}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			search := func(query string) (int, LibraryResponse) {
+				t.Helper()
+				req, _ := http.NewRequest("GET", "/me/library?"+query, nil)
+				req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+				rr := httptest.NewRecorder()
+				handler.Search(rr, req)
+				var resp LibraryResponse
+				json.NewDecoder(rr.Body).Decode(&resp)
+				return rr.Code, resp
+			}
+			ids := func(query string) []int64 {
+				t.Helper()
+				code, resp := search(query)
+				if code != http.StatusOK {
+					t.Fatalf("search %q failed with %d", query, code)
+				}
+				ids := []int64{}
+				for _, entry := range resp.Entries {
+					ids = append(ids, entry.Manga.ID)
+				}
+				return ids
+			}
+			expect := func(query string, want ...int64) {
+				t.Helper()
+				got := ids(query)
+				if len(got) != len(want) {
+					t.Fatalf("search %q: expected %v, got %v", query, want, got)
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Fatalf("search %q: expected %v, got %v", query, want, got)
+					}
+				}
+			}
+
+			_, resp := search("")
+			if len(resp.Entries) != 3 || resp.NextCursor != nil {
+				t.Fatalf("expected the whole library on one page, got %+v", resp)
+			}
+			entry := resp.Entries[2]
+			if entry.Manga.ID != 90 || entry.AddedAt != 100 || !entry.Pinned || len(entry.Categories) != 2 || len(entry.Manga.Tags) != 1 {
+				t.Fatalf("unexpected entry %+v", entry)
+			}
+
+			expect("", 92, 91, 90)
+			expect("q=piec", 91, 90)
+			expect("q="+url.QueryEscape("One pie"), 90)
+			expect("q=oda", 90)
+			expect("q=naruto")
+			expect("source=MANGADEX", 92, 90)
+			expect("tag=action", 90)
+			expect("category=2", 92, 90)
+			expect("state=FINISHED", 92)
+			expect("nsfw=false", 92, 90)
+			expect("pinned=true", 90)
+			expect("sort=oldest", 90, 91, 92)
+			expect("sort=rating", 92, 90, 91)
+
+			_, page := search("sort=alphabetic&limit=2")
+			if len(page.Entries) != 2 || page.Entries[0].Manga.Title != "Berserk" || page.NextCursor == nil {
+				t.Fatalf("unexpected first page %+v", page)
+			}
+			expect("sort=alphabetic&limit=2&cursor="+*page.NextCursor, 91)
+			if code, _ := search("sort=rating&cursor=" + *page.NextCursor); code != http.StatusBadRequest {
+				t.Fatalf("expected 400 for a cursor of another sort order, got %d", code)
+			}
+			if code, _ := search("sort=popular"); code != http.StatusBadRequest {
+				t.Fatalf("expected 400 for an unknown sort order, got %d", code)
+			}
+		})
+	}
+}
@@ -2,15 +2,66 @@ package api
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/logging"
 )
 
-// LoggingMiddleware logs all HTTP requests with request/response bodies
+// maxRequestIDLength bounds the X-Request-ID accepted from clients and proxies.
+const maxRequestIDLength = 128
+
+// maxLoggedBodySize is the size above which bodies are not logged.
+const maxLoggedBodySize = 10000
+
+// RequestIDMiddleware gives every request an ID, taken from a well-formed
+// X-Request-ID header or generated, and returns it in the X-Request-ID
+// response header. Records logged with the request context carry it.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			buf := make([]byte, 16)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, which cannot
+// forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// LoggingMiddleware logs all HTTP requests with request/response bodies at
+// debug level. Tokens, passwords and emails are redacted from the bodies.
+// Requests pass through untouched while the logger is above debug level.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slog.Default().Enabled(r.Context(), slog.LevelDebug) {
+			next.ServeHTTP(w, r)
+			return
+		}
 		start := time.Now()
 
 		// Read and log request body
@@ -20,10 +71,12 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			r.Body = io.NopCloser(bytes.NewBuffer(requestBody)) // Restore body for handler
 		}
 
-		log.Printf("[%s] %s %s", r.Method, r.URL.Path, r.RemoteAddr)
-		if len(requestBody) > 0 && len(requestBody) < 10000 { // Only log if < 10KB
-			log.Printf("  Request Body: %s", string(requestBody))
+		path := redactPath(r.URL.Path)
+		attrs := []any{"method", r.Method, "path", path, "remote_addr", r.RemoteAddr}
+		if body, ok := redactBody(r.Header.Get("Content-Type"), requestBody); ok {
+			attrs = append(attrs, "body", body)
 		}
+		slog.DebugContext(r.Context(), "Request", attrs...)
 
 		// Create a response writer wrapper to capture status code and body
 		wrapped := &responseWriter{
@@ -36,19 +89,114 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(wrapped, r)
 
 		// Log response
-		duration := time.Since(start)
-		log.Printf("[%s] %s - %d (%v)", r.Method, r.URL.Path, wrapped.statusCode, duration)
-		if wrapped.body.Len() > 0 && wrapped.body.Len() < 10000 { // Only log if < 10KB
-			log.Printf("  Response Body: %s", wrapped.body.String())
+		attrs = []any{"method", r.Method, "path", path, "status", wrapped.statusCode, "duration", time.Since(start)}
+		if !wrapped.truncated {
+			if body, ok := redactBody(wrapped.Header().Get("Content-Type"), wrapped.body.Bytes()); ok {
+				attrs = append(attrs, "body", body)
+			}
 		}
+		slog.DebugContext(r.Context(), "Response", attrs...)
 	})
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code and body
+// secretPathPrefixes precede path segments that are credentials, such as the
+// download tokens of data exports.
+var secretPathPrefixes = []string{"/exports/"}
+
+// redactPath returns the request path for the log with its secret segments
+// replaced.
+func redactPath(path string) string {
+	for _, prefix := range secretPathPrefixes {
+		if rest, ok := strings.CutPrefix(path, prefix); ok && rest != "" {
+			if _, tail, found := strings.Cut(rest, "/"); found {
+				return prefix + redacted + "/" + tail
+			}
+			return prefix + redacted
+		}
+	}
+	return path
+}
+
+// sensitiveFields are parts of the JSON fields and form values that are
+// never logged, such as "refresh_token" or "current_password".
+var sensitiveFields = []string{"token", "password", "secret", "email", "invite_code"}
+
+var emailPattern = regexp.MustCompile(`[^\s"'<>@]+@[^\s"'<>@]+\.[A-Za-z]{2,}`)
+
+const redacted = "[REDACTED]"
+
+// redactBody returns a JSON or form body for the log with its sensitive
+// values replaced. Other bodies, such as HTML pages and backups, and bodies
+// above maxLoggedBodySize are not logged.
+func redactBody(contentType string, body []byte) (string, bool) {
+	if len(body) == 0 || len(body) > maxLoggedBodySize {
+		return "", false
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", false
+		}
+		for key := range values {
+			if sensitiveField(key) {
+				values[key] = []string{redacted}
+			}
+		}
+		return values.Encode(), true
+	case mediaType == "application/json" || (mediaType == "" && json.Valid(body)):
+		var value any
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return "", false
+		}
+		data, _ := json.Marshal(redactJSON(value))
+		return string(data), true
+	}
+	return "", false
+}
+
+func redactJSON(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if sensitiveField(key) {
+				value[key] = redacted
+			} else {
+				value[key] = redactJSON(field)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactJSON(item)
+		}
+	case string:
+		// Emails also appear in messages and values of other fields.
+		return emailPattern.ReplaceAllString(value, redacted)
+	}
+	return value
+}
+
+func sensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range sensitiveFields {
+		if strings.Contains(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseWriter wraps http.ResponseWriter to capture status code and body.
+// Bodies above maxLoggedBodySize, such as event streams and export archives,
+// are not kept since they are not logged anyway.
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
 	body       *bytes.Buffer
+	truncated  bool
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -57,6 +205,27 @@ func (rw *responseWriter) WriteHeader(code int) {
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
-	rw.body.Write(b) // Capture response body
+	if !rw.truncated {
+		if rw.body.Len()+len(b) > maxLoggedBodySize {
+			rw.truncated = true
+			rw.body = &bytes.Buffer{}
+		} else {
+			rw.body.Write(b) // Capture response body
+		}
+	}
 	return rw.ResponseWriter.Write(b)
 }
+
+// Flush lets streaming handlers such as the event stream work behind the
+// logging middleware.
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer, e.g. to
+// set deadlines.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/logging"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	if _, err := logging.Setup(&buf, "debug", "json"); err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(previous)
+
+	var seen string
+	handler := RequestIDMiddleware(LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+		slog.ErrorContext(r.Context(), "Handler failed")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"secret-access","user":{"id":1,"email":"reader@example.com"}}`))
+	})))
+
+	// A well-formed incoming ID is kept.
+	req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email":"reader@example.com","password":"hunter22"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "abc-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("X-Request-ID") != "abc-123" || seen != "abc-123" {
+		t.Fatalf("expected request ID abc-123, got header %q, context %q", rec.Header().Get("X-Request-ID"), seen)
+	}
+
+	logs := buf.String()
+	if strings.Count(logs, `"request_id":"abc-123"`) != 3 {
+		t.Fatalf("expected the request ID in every record:\n%s", logs)
+	}
+	for _, leaked := range []string{"reader@example.com", "hunter22", "secret-access"} {
+		if strings.Contains(logs, leaked) {
+			t.Fatalf("%q leaked into the logs:\n%s", leaked, logs)
+		}
+	}
+
+	// A malformed incoming ID is replaced.
+	req = httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("X-Request-ID", "forged\nline")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if id := rec.Header().Get("X-Request-ID"); len(id) != 32 || id != seen {
+		t.Fatalf("expected a generated request ID, got header %q, context %q", id, seen)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+		logged      bool
+	}{
+		{"application/json", `{"email":"a@b.io","password":"p","manga":[{"title":"T"}]}`, `{"email":"[REDACTED]","manga":[{"title":"T"}],"password":"[REDACTED]"}`, true},
+		{"application/json; charset=utf-8", `{"error":"No account for a@b.io"}`, `{"error":"No account for [REDACTED]"}`, true},
+		{"", `{"refresh_token":"r"}`, `{"refresh_token":"[REDACTED]"}`, true},
+		{"application/x-www-form-urlencoded", "csrf_token=c&current_password=p&name=x", "csrf_token=%5BREDACTED%5D&current_password=%5BREDACTED%5D&name=x", true},
+		{"text/html", "<p>a@b.io</p>", "", false},
+		{"application/json", "", "", false},
+	}
+	for _, tt := range tests {
+		got, logged := redactBody(tt.contentType, []byte(tt.body))
+		if got != tt.want || logged != tt.logged {
+			t.Errorf("redactBody(%q, %q) = %q, %v; want %q, %v", tt.contentType, tt.body, got, logged, tt.want, tt.logged)
+		}
+	}
+}
+
+func TestLoggingResponseWriterLimit(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: rec, statusCode: http.StatusOK, body: &bytes.Buffer{}}
+	chunk := []byte(strings.Repeat("x", 1000) + "\n")
+	for range 2 * maxLoggedBodySize / len(chunk) {
+		rw.Write(chunk)
+	}
+	if !rw.truncated || rw.body.Len() != 0 {
+		t.Fatalf("expected long bodies not to be buffered, kept %d bytes", rw.body.Len())
+	}
+	if rec.Body.Len() != 2*maxLoggedBodySize/len(chunk)*len(chunk) {
+		t.Fatalf("expected the whole body to reach the client, got %d bytes", rec.Body.Len())
+	}
+}
+
+func TestLoggingRedactsPath(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	if _, err := logging.Setup(&buf, "debug", "text"); err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(previous)
+
+	handler := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/exports/secret-download-token", nil))
+	if strings.Contains(buf.String(), "secret-download-token") || !strings.Contains(buf.String(), "path=/exports/[REDACTED]") {
+		t.Fatalf("expected the export token to be redacted:\n%s", buf.String())
+	}
+
+	for path, want := range map[string]string{
+		"/exports/abc":     "/exports/[REDACTED]",
+		"/exports/abc/zip": "/exports/[REDACTED]/zip",
+		"/exports/":        "/exports/",
+		"/me/exports":      "/me/exports",
+	} {
+		if got := redactPath(path); got != want {
+			t.Errorf("redactPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestLoggingMiddlewareLevel(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	if _, err := logging.Setup(&buf, "info", "text"); err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(previous)
+
+	// Above debug level the writer is handed through and nothing is logged.
+	rec := httptest.NewRecorder()
+	LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if w != rec {
+			t.Errorf("expected the response writer not to be wrapped, got %T", w)
+		}
+	})).ServeHTTP(rec, httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email":"a@b.io"}`)))
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing logged above debug level:\n%s", buf.String())
+	}
+
+	// At debug level the wrapper still exposes the writer it wraps.
+	if _, err := logging.Setup(&buf, "debug", "text"); err != nil {
+		t.Fatal(err)
+	}
+	LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok || unwrapper.Unwrap() != rec {
+			t.Errorf("expected the wrapper to unwrap to the response writer, got %T", w)
+		}
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/me", nil))
+	if !strings.Contains(buf.String(), "msg=Request") {
+		t.Fatalf("expected the request to be logged at debug level:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics-test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		JSONError(w, "Not found", http.StatusNotFound)
+	})
+	mux.HandleFunc("GET /metrics-test-stream", func(w http.ResponseWriter, r *http.Request) {
+		// Streaming handlers need the writer to keep supporting flushes.
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush failed behind the middleware: %v", err)
+		}
+	})
+	mux.Handle("GET /metrics", metrics.Handler(metrics.Default, "metrics-token"))
+	server := httptest.NewServer(MetricsMiddleware(mux))
+	defer server.Close()
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/metrics-test-stream"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer metrics-token")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	for _, want := range []string{
+		"# TYPE kotatsu_http_requests_total counter\n",
+		`kotatsu_http_requests_total{route="GET /metrics-test/{id}",status="404"} 2` + "\n",
+		`kotatsu_http_requests_total{route="GET /metrics-test-stream",status="200"} 1` + "\n",
+		"# TYPE kotatsu_http_request_duration_seconds histogram\n",
+		`kotatsu_http_request_duration_seconds_bucket{route="GET /metrics-test/{id}",status="404",le="+Inf"} 2` + "\n",
+		`kotatsu_http_request_duration_seconds_count{route="GET /metrics-test/{id}",status="404"} 2` + "\n",
+		"# TYPE kotatsu_db_tx_retries_total counter\nkotatsu_db_tx_retries_total 0\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("expected %q in the metrics:\n%s", want, body)
+		}
+	}
+}
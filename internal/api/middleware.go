@@ -2,20 +2,40 @@ package api
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
-	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey    contextKey = "userID"
+	SessionIDKey contextKey = "sessionID"
+)
+
+// sessionTouchInterval throttles last-seen updates to one write per session
+// and minute instead of one per request.
+const sessionTouchInterval = 60
+
+// TrustProxyHeaders makes client IPs come from X-Forwarded-For or X-Real-IP.
+// Only enable it behind a reverse proxy that sets these headers.
+var TrustProxyHeaders bool
 
 type Middleware struct {
-	DB *db.DB
+	Users        store.UserStore
+	Sessions     store.SessionStore
+	Verification VerificationPolicy
+	// LegacyTokens accepts the tokens issued before sessions existed until
+	// they expire. They carry no session and cannot be revoked.
+	LegacyTokens bool
 }
 
 func (m *Middleware) AuthMiddleware(next http.Handler) http.Handler {
@@ -38,27 +58,121 @@ func (m *Middleware) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Tokens issued before sessions existed carry no session and cannot be
+		// revoked, so they are only accepted while LegacyTokens is set.
+		if claims.SessionID == "" {
+			if !m.LegacyTokens || claims.ExpiresAt == nil {
+				JSONError(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			session, err := m.Sessions.GetSession(r.Context(), claims.SessionID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				slog.ErrorContext(r.Context(), "AuthMiddleware: DB error checking session", "user_id", claims.UserID, "error", err)
+				JSONError(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if session == nil || session.UserID != claims.UserID || session.RevokedAt != nil || session.ExpiresAt <= time.Now().Unix() {
+				JSONError(w, "Session revoked", http.StatusUnauthorized)
+				return
+			}
+
+			if now := time.Now().Unix(); now-session.LastSeenAt >= sessionTouchInterval {
+				if err := m.Sessions.TouchSession(r.Context(), session.ID, now, clientIP(r), truncate(r.UserAgent(), maxUserAgentLength)); err != nil {
+					slog.WarnContext(r.Context(), "AuthMiddleware: failed to update session", "session_id", session.ID, "error", err)
+				}
+			}
+		}
+
 		// Verify user exists in database
 		// This handles cases where client has valid token but DB was wiped
-		exists, err := m.DB.UserExists(claims.UserID)
+		user, err := m.Users.GetUserByID(r.Context(), claims.UserID)
+		if errors.Is(err, store.ErrNotFound) {
+			slog.InfoContext(r.Context(), "AuthMiddleware: user not found in DB", "user_id", claims.UserID)
+			JSONError(w, "User not found", http.StatusUnauthorized)
+			return
+		}
 		if err != nil {
-			log.Printf("AuthMiddleware: DB error checking user %d: %v", claims.UserID, err)
+			slog.ErrorContext(r.Context(), "AuthMiddleware: DB error checking user", "user_id", claims.UserID, "error", err)
 			JSONError(w, "Database error", http.StatusInternalServerError)
 			return
 		}
-		if !exists {
-			log.Printf("AuthMiddleware: User %d not found in DB", claims.UserID)
-			JSONError(w, "User not found", http.StatusUnauthorized)
+		// A refresh racing the disable can leave a session of the account
+		// alive, so the account itself is checked as well.
+		if user.DisabledAt != nil {
+			JSONError(w, "Account is disabled", http.StatusForbidden)
 			return
 		}
-		log.Printf("AuthMiddleware: User %d validated", claims.UserID)
 
 		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+		if claims.SessionID != "" {
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// AdminMiddleware authenticates the request like AuthMiddleware and only lets
+// administrators through.
+func (m *Middleware) AdminMiddleware(next http.Handler) http.Handler {
+	return m.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := GetUserID(r)
+		user, err := m.Users.GetUserByID(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "AdminMiddleware: DB error fetching user", "user_id", userID, "error", err)
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if user.Role != store.RoleAdmin || user.DisabledAt != nil {
+			JSONError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
 func GetUserID(r *http.Request) (int64, bool) {
 	userID, ok := r.Context().Value(UserIDKey).(int64)
 	return userID, ok
 }
+
+func GetSessionID(r *http.Request) (string, bool) {
+	sessionID, ok := r.Context().Value(SessionIDKey).(string)
+	return sessionID, ok
+}
+
+// clientIP returns the address of the client that sent the request.
+func clientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return truncate(strings.TrimSpace(first), maxIPAddressLength)
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return truncate(strings.TrimSpace(realIP), maxIPAddressLength)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return truncate(r.RemoteAddr, maxIPAddressLength)
+	}
+	return host
+}
+
+// Column sizes of the session metadata.
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 512
+	maxIPAddressLength  = 64
+)
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// RegistrationMode controls who may create an account.
+type RegistrationMode string
+
+const (
+	RegistrationOpen   RegistrationMode = "open"
+	RegistrationInvite RegistrationMode = "invite"
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+// ParseRegistrationMode maps a REGISTRATION_MODE value to a RegistrationMode.
+func ParseRegistrationMode(name string) (RegistrationMode, error) {
+	switch mode := RegistrationMode(strings.ToLower(strings.TrimSpace(name))); mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+		return mode, nil
+	default:
+		return "", errors.New("unknown registration mode " + strconv.Quote(name))
+	}
+}
+
+// RegistrationPolicy decides whether new accounts may be created, both by
+// POST /register and by login auto-registration. The zero value allows
+// open registration.
+type RegistrationPolicy struct {
+	Mode RegistrationMode
+	// AllowedDomains restricts registration to these email domains when non-empty.
+	AllowedDomains []string
+}
+
+var (
+	errRegistrationClosed    = errors.New("registration is closed")
+	errEmailDomainNotAllowed = errors.New("email domain is not allowed")
+	errInviteRequired        = errors.New("invite code required")
+	errInvalidPassword       = errors.New("password length out of range")
+)
+
+func (p RegistrationPolicy) check(email, inviteCode string) error {
+	if p.Mode == RegistrationClosed {
+		return errRegistrationClosed
+	}
+	if len(p.AllowedDomains) > 0 && !p.domainAllowed(email) {
+		return errEmailDomainNotAllowed
+	}
+	if p.Mode == RegistrationInvite && strings.TrimSpace(inviteCode) == "" {
+		return errInviteRequired
+	}
+	return nil
+}
+
+func (p RegistrationPolicy) domainAllowed(email string) bool {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range p.AllowedDomains {
+		if domain == strings.ToLower(strings.TrimSpace(allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// registerUser creates an account if the registration policy allows it,
+// consuming an invite use in invite-only mode.
+func (h *AuthHandler) registerUser(ctx context.Context, email, password, inviteCode string) (int64, error) {
+	if err := h.Registration.check(email, inviteCode); err != nil {
+		return 0, err
+	}
+	if !validPasswordLength(password) {
+		return 0, errInvalidPassword
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	if h.Registration.Mode == RegistrationInvite {
+		return h.Invites.CreateUserWithInvite(ctx, email, hash, auth.HashInviteCode(inviteCode), time.Now().Unix())
+	}
+	return h.Users.CreateUser(ctx, email, hash)
+}
+
+// registrationError responds to a failed registerUser call.
+func registrationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errRegistrationClosed):
+		JSONError(w, "Registration is closed", http.StatusForbidden)
+	case errors.Is(err, errEmailDomainNotAllowed):
+		JSONError(w, "Registration is not allowed for this email domain", http.StatusForbidden)
+	case errors.Is(err, errInviteRequired), errors.Is(err, store.ErrInvalidInvite):
+		JSONError(w, "A valid invite code is required", http.StatusForbidden)
+	case errors.Is(err, errInvalidPassword):
+		JSONError(w, "Password should be from 2 to 24 characters long", http.StatusBadRequest)
+	case errors.Is(err, store.ErrAlreadyExists):
+		JSONError(w, "Email is already registered", http.StatusConflict)
+	default:
+		JSONError(w, "Failed to register user", http.StatusInternalServerError)
+	}
+}
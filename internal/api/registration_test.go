@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestRegistrationPolicy(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	handler := newTestAuthHandler(st)
+
+	post := func(handle http.HandlerFunc, payload map[string]string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		handle(rr, req)
+		return rr
+	}
+
+	// Closed: neither endpoint creates accounts, existing users still log in.
+	if rr := post(handler.Login, map[string]string{"email": "existing@example.com", "password": "password"}); rr.Code != http.StatusOK {
+		t.Fatalf("open auto-registration failed: %d", rr.Code)
+	}
+	handler.Registration = RegistrationPolicy{Mode: RegistrationClosed}
+	if rr := post(handler.Login, map[string]string{"email": "new@example.com", "password": "password"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for auto-registration while closed, got %d", rr.Code)
+	}
+	if rr := post(handler.Register, map[string]string{"email": "new@example.com", "password": "password"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for registration while closed, got %d", rr.Code)
+	}
+	if rr := post(handler.Login, map[string]string{"email": "existing@example.com", "password": "password"}); rr.Code != http.StatusOK {
+		t.Fatalf("expected existing user to log in while closed, got %d", rr.Code)
+	}
+
+	// Domain allowlist.
+	handler.Registration = RegistrationPolicy{Mode: RegistrationOpen, AllowedDomains: []string{"example.org"}}
+	if rr := post(handler.Register, map[string]string{"email": "user@other.com", "password": "password"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for disallowed domain, got %d", rr.Code)
+	}
+	rr := post(handler.Register, map[string]string{"email": "user@Example.org", "password": "password"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for allowed domain, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var tokens TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil || tokens.Token == "" {
+		t.Fatalf("expected tokens after registration, got %+v err=%v", tokens, err)
+	}
+	if rr := post(handler.Register, map[string]string{"email": "user@Example.org", "password": "password"}); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate email, got %d", rr.Code)
+	}
+
+	// Passwords follow the same length rules as password changes.
+	if rr := post(handler.Register, map[string]string{"email": "long@example.org", "password": strings.Repeat("p", 25)}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a too long password, got %d", rr.Code)
+	}
+	if rr := post(handler.Login, map[string]string{"email": "short@example.org", "password": "p"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for auto-registration with a too short password, got %d", rr.Code)
+	}
+	if _, err := st.GetUserByEmail(context.Background(), "short@example.org"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected no account for a rejected password, got %v", err)
+	}
+
+	// Invite-only with a single-use code.
+	code, codeHash, err := auth.GenerateInviteCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateInvite(context.Background(), &model.Invite{ID: "inv1", CodeHash: codeHash, MaxUses: 1, CreatedAt: time.Now().Unix()}); err != nil {
+		t.Fatalf("create invite failed: %v", err)
+	}
+	handler.Registration = RegistrationPolicy{Mode: RegistrationInvite}
+	if rr := post(handler.Login, map[string]string{"email": "invited@example.com", "password": "password"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without invite code, got %d", rr.Code)
+	}
+	if rr := post(handler.Register, map[string]string{"email": "invited@example.com", "password": "password", "invite_code": "WRONG-CODE"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for unknown invite code, got %d", rr.Code)
+	}
+	// Codes are accepted regardless of case and dashes.
+	if rr := post(handler.Login, map[string]string{"email": "invited@example.com", "password": "password", "invite_code": " " + strings.ToLower(strings.ReplaceAll(code, "-", ""))}); rr.Code != http.StatusOK {
+		t.Fatalf("expected invite registration to succeed, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := post(handler.Register, map[string]string{"email": "second@example.com", "password": "password", "invite_code": code}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected used up invite to be rejected, got %d", rr.Code)
+	}
+
+	invites, err := st.ListInvites(context.Background())
+	if err != nil || len(invites) != 1 || invites[0].Uses != 1 {
+		t.Fatalf("expected one invite use, got %+v err=%v", invites, err)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// maxCachedStats bounds the number of users whose stats are cached.
+const maxCachedStats = 10000
+
+// StatsHandler serves reading statistics. They only change with the history,
+// so they are cached per user until the history sync timestamp moves or, since
+// activity and streaks are relative to the current day, the day changes.
+type StatsHandler struct {
+	History store.HistoryStore
+	Stats   store.StatsStore
+
+	mu       sync.Mutex
+	cache    map[int64]cachedStats
+	cacheDay string
+}
+
+type cachedStats struct {
+	timestamp int64
+	day       string
+	stats     *model.ReadingStats
+}
+
+func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	timestamp, err := h.History.HistoryTimestamp(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching history timestamp", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	key := cachedStats{day: now.UTC().Format(time.DateOnly)}
+	if timestamp != nil {
+		key.timestamp = *timestamp
+	}
+
+	h.mu.Lock()
+	cached, ok := h.cache[userID]
+	h.mu.Unlock()
+	stats := cached.stats
+	if !ok || cached.timestamp != key.timestamp || cached.day != key.day {
+		stats, err = h.Stats.ReadingStats(r.Context(), userID, now.UnixMilli())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error computing stats", "user_id", userID, "error", err)
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		key.stats = stats
+		h.mu.Lock()
+		// Entries of past days cannot be served anymore.
+		if h.cache == nil || h.cacheDay != key.day {
+			h.cache = make(map[int64]cachedStats)
+			h.cacheDay = key.day
+		}
+		if _, ok := h.cache[userID]; !ok && len(h.cache) >= maxCachedStats {
+			for id := range h.cache {
+				delete(h.cache, id)
+				break
+			}
+		}
+		h.cache[userID] = key
+		h.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestReadingStats(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	for name, st := range map[string]interface {
+		store.UserStore
+		store.HistoryStore
+		store.StatsStore
+	}{
+		"sqlstore": sqlstore.New(database),
+		"memstore": memstore.New(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			userID, err := st.CreateUser(ctx, "stats@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := &StatsHandler{History: st, Stats: st}
+			getStats := func() model.ReadingStats {
+				t.Helper()
+				req, _ := http.NewRequest("GET", "/me/stats", nil)
+				req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+				rr := httptest.NewRecorder()
+				handler.GetStats(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("GetStats failed: %d body=%s", rr.Code, rr.Body.String())
+				}
+				var stats model.ReadingStats
+				json.NewDecoder(rr.Body).Decode(&stats)
+				return stats
+			}
+			sync := func(history ...model.History) {
+				t.Helper()
+				if _, err := st.SyncHistory(ctx, userID, "", history, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if stats := getStats(); stats.Totals.MangaStarted != 0 || stats.Sources == nil || stats.Activity.Days == nil {
+				t.Fatalf("expected empty stats with empty lists, got %+v", stats)
+			}
+
+			now := time.Now().UnixMilli()
+			day := int64(24 * time.Hour / time.Millisecond)
+			action := model.Tag{ID: 800, Title: "Action", Key: "action", Source: "MANGADEX"}
+			comedy := model.Tag{ID: 801, Title: "Comedy", Key: "comedy", Source: "MANGADEX"}
+			finished := model.Manga{ID: 80, Title: "Finished", URL: "/80", PublicURL: "/80", Source: "MANGADEX", CoverURL: "/80.jpg", Tags: []model.Tag{action}}
+			started := model.Manga{ID: 81, Title: "Started", URL: "/81", PublicURL: "/81", Source: "MANGADEX", CoverURL: "/81.jpg", Tags: []model.Tag{action, comedy}}
+			unknown := model.Manga{ID: 82, Title: "Unknown source", URL: "/82", PublicURL: "/82", CoverURL: "/82.jpg"}
+			dropped := model.Manga{ID: 83, Title: "Dropped", URL: "/83", PublicURL: "/83", Source: "OTHER", CoverURL: "/83.jpg"}
+
+			sync(model.History{MangaID: 80, Manga: &finished, ChapterID: 1, Percent: 0.1, Chapters: 10, CreatedAt: now - 3*day, UpdatedAt: now - 3*day})
+			sync(model.History{MangaID: 80, ChapterID: 2, Percent: 1, Chapters: 10, CreatedAt: now - 3*day, UpdatedAt: now - day},
+				model.History{MangaID: 83, Manga: &dropped, ChapterID: 4, Percent: 0.5, Chapters: 2, CreatedAt: now - day, UpdatedAt: now - day, DeletedAt: now})
+			sync(model.History{MangaID: 81, Manga: &started, ChapterID: 5, Percent: 0.25, Chapters: 4, CreatedAt: now, UpdatedAt: now},
+				model.History{MangaID: 82, Manga: &unknown, ChapterID: 7, Percent: -1, Chapters: 0, CreatedAt: now, UpdatedAt: now})
+
+			stats := getStats()
+			if stats.Totals != (model.ReadingTotals{MangaStarted: 3, MangaCompleted: 1, ChaptersRead: 11}) {
+				t.Fatalf("unexpected totals %+v", stats.Totals)
+			}
+			if len(stats.Sources) != 1 || stats.Sources[0] != (model.SourceStats{Source: "MANGADEX", MangaStarted: 2, MangaCompleted: 1, ChaptersRead: 11}) {
+				t.Fatalf("unexpected sources %+v", stats.Sources)
+			}
+			if len(stats.Tags) != 2 || stats.Tags[0].Title != "Action" || stats.Tags[0].MangaStarted != 2 || stats.Tags[1].Title != "Comedy" {
+				t.Fatalf("unexpected tags %+v", stats.Tags)
+			}
+			today := time.UnixMilli(now).UTC().Format(time.DateOnly)
+			days := stats.Activity.Days
+			if len(days) != 3 || days[2] != (model.ActivityStats{Date: today, Manga: 2, Chapters: 2}) {
+				t.Fatalf("unexpected daily activity %+v", days)
+			}
+			if len(stats.Activity.Weeks) == 0 || time.Now().UTC().Sub(mustParseDate(t, stats.Activity.Weeks[len(stats.Activity.Weeks)-1].Date)) >= 7*24*time.Hour {
+				t.Fatalf("unexpected weekly activity %+v", stats.Activity.Weeks)
+			}
+			if stats.Streaks != (model.ReadingStreaks{Current: 2, Longest: 2}) {
+				t.Fatalf("unexpected streaks %+v", stats.Streaks)
+			}
+
+			// Events without a read time do not extend streaks.
+			undated := model.Manga{ID: 84, Title: "Undated", URL: "/84", PublicURL: "/84", Source: "MANGADEX", CoverURL: "/84.jpg"}
+			for i := range int64(3) {
+				sync(model.History{MangaID: 84, Manga: &undated, ChapterID: 10 + i, CreatedAt: 0, UpdatedAt: i * day})
+			}
+			if stats := getStats(); stats.Streaks != (model.ReadingStreaks{Current: 2, Longest: 2}) {
+				t.Fatalf("unexpected streaks with undated events %+v", stats.Streaks)
+			}
+
+			// A sync moves the history timestamp and invalidates the cached stats.
+			sync(model.History{MangaID: 81, ChapterID: 6, Percent: 1, Chapters: 4, CreatedAt: now, UpdatedAt: now + 1})
+			if stats := getStats(); stats.Totals.MangaCompleted != 2 {
+				t.Fatalf("expected refreshed stats, got %+v", stats.Totals)
+			}
+
+			// Entries of other users from past days are dropped with the day.
+			handler.cache[userID] = cachedStats{day: "2000-01-01"}
+			handler.cache[userID+1] = cachedStats{day: "2000-01-01"}
+			handler.cacheDay = "2000-01-01"
+			getStats()
+			if _, ok := handler.cache[userID+1]; ok || len(handler.cache) != 1 {
+				t.Fatalf("expected stale entries to be dropped, got %+v", handler.cache)
+			}
+		})
+	}
+}
+
+func mustParseDate(t *testing.T, value string) time.Time {
+	t.Helper()
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		t.Fatalf("invalid date %q: %v", value, err)
+	}
+	if date.Weekday() != time.Monday {
+		t.Fatalf("expected week to start on Monday, got %s", value)
+	}
+	return date
+}
@@ -1,93 +1,76 @@
 package api
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"sort"
-	"strings"
+	"strconv"
 	"time"
 
-	mysqlDriver "github.com/go-sql-driver/mysql"
-	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/events"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/metrics"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/webhooks"
 )
 
 type SyncHandler struct {
-	DB *db.DB
+	History   store.HistoryStore
+	Library   store.LibraryStore
+	Bookmarks store.BookmarkStore
+	Sessions  store.SessionStore
+	// Events, when set, is notified of every committed sync write.
+	Events *events.Hub
+	// Webhooks, when set, queues the changes of every sync write for the
+	// user's webhooks.
+	Webhooks *webhooks.Dispatcher
 }
 
-func (h *SyncHandler) isMySQL() bool {
-	return strings.Contains(strings.ToLower(fmt.Sprintf("%T", h.DB.Driver())), "mysql")
-}
-
-func isMySQLRetryableTxError(err error) bool {
-	var mysqlErr *mysqlDriver.MySQLError
-	if !errors.As(err, &mysqlErr) {
-		return false
+func (h *SyncHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
-	// 1213: deadlock, 1205: lock wait timeout
-	return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
-}
 
-func (h *SyncHandler) withTxRetry(isMySQL bool, fn func(tx *sql.Tx) error) error {
-	maxAttempts := 1
-	if isMySQL {
-		maxAttempts = 3
+	since, err := syncSince(r)
+	if err != nil {
+		JSONError(w, "Invalid timestamp", http.StatusBadRequest)
+		return
 	}
 
-	var err error
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		tx, txErr := h.DB.Begin()
-		if txErr != nil {
-			return txErr
-		}
-
-		err = fn(tx)
-		if err == nil {
-			if commitErr := tx.Commit(); commitErr == nil {
-				return nil
-			} else {
-				err = commitErr
-			}
-		}
-
-		_ = tx.Rollback()
-		if !isMySQL || !isMySQLRetryableTxError(err) || attempt == maxAttempts {
-			return err
-		}
-
-		time.Sleep(time.Duration(attempt*50) * time.Millisecond)
+	// Read the version before the rows: if a write lands in between, the client
+	// merely receives some rows again on its next delta sync.
+	timestamp, err := h.History.HistoryTimestamp(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching history timestamp", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
 	}
-
-	return err
-}
-
-func (h *SyncHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
-	userID, ok := GetUserID(r)
-	if !ok {
-		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+	etag := syncETag(timestamp, since)
+	setSyncCacheHeaders(w, etag)
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	history, err := h.fetchHistory(userID)
+	history, err := h.History.GetHistory(r.Context(), userID, since)
 	if err != nil {
-		log.Printf("Error fetching history: %v", err)
+		slog.ErrorContext(r.Context(), "Error fetching history", "error", err)
 		JSONError(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	timestamp := h.getTimestamp(userID, "history_sync_timestamp")
 	resp := model.HistoryPackage{
 		History:   history,
 		Timestamp: timestamp,
 	}
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
 	}
 }
 
@@ -98,38 +81,38 @@ func (h *SyncHandler) PostHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	since, err := syncSince(r)
+	if err != nil {
+		JSONError(w, "Invalid timestamp", http.StatusBadRequest)
+		return
+	}
+
 	var req model.HistoryPackage
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		JSONError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	metrics.SyncItems.Observe(float64(len(req.History)), store.SyncResourceHistory, "history")
 
-	isMySQL := h.isMySQL()
-	now := time.Now().UnixMilli()
-	err := h.withTxRetry(isMySQL, func(tx *sql.Tx) error {
-		for _, item := range req.History {
-			if item.Manga != nil {
-				if err := upsertManga(tx, item.Manga, isMySQL); err != nil {
-					return err
-				}
-			}
-			if err := upsertHistory(tx, userID, item, isMySQL); err != nil {
-				return err
-			}
-		}
-		_, err := tx.Exec("UPDATE users SET history_sync_timestamp = ? WHERE id = ?", now, userID)
-		return err
-	})
+	sessionID, _ := GetSessionID(r)
+	now, err := h.History.SyncHistory(r.Context(), userID, sessionID, req.History, ifMatchVersions(r))
+	if errors.Is(err, store.ErrPreconditionFailed) {
+		JSONError(w, "History was modified by another device", http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
-		log.Printf("Error persisting history sync (user_id=%d): %v", userID, err)
+		slog.ErrorContext(r.Context(), "Error persisting history sync", "user_id", userID, "error", err)
 		JSONError(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
+	h.recordSync(r, store.SyncResourceHistory, now)
+	h.historyWebhooks(r, userID, now)
+
 	// Fetch updated history to return
-	history, err := h.fetchHistory(userID)
+	history, err := h.History.GetHistory(r.Context(), userID, since)
 	if err != nil {
-		log.Printf("Error fetching updated history: %v", err)
+		slog.ErrorContext(r.Context(), "Error fetching updated history", "error", err)
 		// Transaction committed, but failed to fetch. Return 204 or partial error?
 		// Original logic returns the package.
 		JSONError(w, "Database error", http.StatusInternalServerError)
@@ -141,6 +124,7 @@ func (h *SyncHandler) PostHistory(w http.ResponseWriter, r *http.Request) {
 		Timestamp: &now,
 	}
 
+	setSyncCacheHeaders(w, syncETag(&now, since))
 	w.WriteHeader(http.StatusOK) // Explicitly set 200, though Encode likely does it
 	json.NewEncoder(w).Encode(resp)
 }
@@ -152,14 +136,32 @@ func (h *SyncHandler) GetFavourites(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	favourites, categories, err := h.fetchFavouritesAndCategories(userID)
+	since, err := syncSince(r)
 	if err != nil {
-		log.Printf("Error fetching favourites: %v", err)
+		JSONError(w, "Invalid timestamp", http.StatusBadRequest)
+		return
+	}
+
+	timestamp, err := h.Library.FavouritesTimestamp(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching favourites timestamp", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	etag := syncETag(timestamp, since)
+	setSyncCacheHeaders(w, etag)
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	favourites, categories, err := h.Library.GetFavourites(r.Context(), userID, since)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching favourites", "error", err)
 		JSONError(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	timestamp := h.getTimestamp(userID, "favourites_sync_timestamp")
 	resp := model.FavouritesPackage{
 		Favourites: favourites,
 		Categories: categories,
@@ -172,80 +174,42 @@ func (h *SyncHandler) GetFavourites(w http.ResponseWriter, r *http.Request) {
 func (h *SyncHandler) PostFavourites(w http.ResponseWriter, r *http.Request) {
 	userID, ok := GetUserID(r)
 	if !ok {
-		log.Println("PostFavourites: Failed to get userID from context")
 		JSONError(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	log.Printf("PostFavourites: Starting for user %d", userID)
+
+	since, err := syncSince(r)
+	if err != nil {
+		JSONError(w, "Invalid timestamp", http.StatusBadRequest)
+		return
+	}
 
 	var req model.FavouritesPackage
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		JSONError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	metrics.SyncItems.Observe(float64(len(req.Favourites)), store.SyncResourceFavourites, "favourites")
+	metrics.SyncItems.Observe(float64(len(req.Categories)), store.SyncResourceFavourites, "categories")
 
-	isMySQL := h.isMySQL()
-	now := time.Now().UnixMilli()
-
-	// Stable lock order reduces deadlock probability on concurrent sync requests.
-	sort.Slice(req.Categories, func(i, j int) bool {
-		return req.Categories[i].ID < req.Categories[j].ID
-	})
-	sort.Slice(req.Favourites, func(i, j int) bool {
-		if req.Favourites[i].MangaID != req.Favourites[j].MangaID {
-			return req.Favourites[i].MangaID < req.Favourites[j].MangaID
-		}
-		return req.Favourites[i].CategoryID < req.Favourites[j].CategoryID
-	})
-
-	err := h.withTxRetry(isMySQL, func(tx *sql.Tx) error {
-		for _, category := range req.Categories {
-			if err := upsertCategory(tx, userID, category, isMySQL); err != nil {
-				return err
-			}
-		}
-
-		for _, fav := range req.Favourites {
-			log.Printf("Processing favourite: manga_id=%d, category_id=%d, has_manga_object=%v", fav.MangaID, fav.CategoryID, fav.Manga != nil)
-
-			if fav.Manga != nil {
-				if err := upsertManga(tx, fav.Manga, isMySQL); err != nil {
-					return err
-				}
-				log.Printf("Successfully upserted manga %d", fav.MangaID)
-			} else if fav.MangaID != 0 {
-				// Ensure manga record exists for foreign key constraint
-				if err := ensureMangaExists(tx, fav.MangaID, isMySQL); err != nil {
-					return err
-				}
-				log.Printf("Ensured manga %d exists", fav.MangaID)
-			}
-
-			// Ensure category exists before inserting favourite
-			// This handles race conditions when multiple devices sync simultaneously
-			if err := ensureCategoryExists(tx, fav.CategoryID, userID, isMySQL); err != nil {
-				return err
-			}
-			log.Printf("Ensured category %d exists for user %d", fav.CategoryID, userID)
-
-			if err := upsertFavourite(tx, userID, fav, isMySQL); err != nil {
-				return err
-			}
-			log.Printf("Successfully upserted favourite: manga_id=%d, category_id=%d", fav.MangaID, fav.CategoryID)
-		}
-
-		_, err := tx.Exec("UPDATE users SET favourites_sync_timestamp = ? WHERE id = ?", now, userID)
-		return err
-	})
+	knownCategories := h.knownCategories(r, userID)
+	now, err := h.Library.SyncFavourites(r.Context(), userID, req.Categories, req.Favourites, ifMatchVersions(r))
+	if errors.Is(err, store.ErrPreconditionFailed) {
+		JSONError(w, "Favourites were modified by another device", http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
-		log.Printf("Error persisting favourites sync (user_id=%d): %v", userID, err)
+		slog.ErrorContext(r.Context(), "Error persisting favourites sync", "user_id", userID, "error", err)
 		JSONError(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	favourites, categories, err := h.fetchFavouritesAndCategories(userID)
+	h.recordSync(r, store.SyncResourceFavourites, now)
+	h.favouriteWebhooks(r, userID, now, knownCategories)
+
+	favourites, categories, err := h.Library.GetFavourites(r.Context(), userID, since)
 	if err != nil {
-		log.Printf("Error fetching updated favourites: %v", err)
+		slog.ErrorContext(r.Context(), "Error fetching updated favourites", "error", err)
 		JSONError(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -256,352 +220,244 @@ func (h *SyncHandler) PostFavourites(w http.ResponseWriter, r *http.Request) {
 		Timestamp:  &now,
 	}
 
+	setSyncCacheHeaders(w, syncETag(&now, since))
 	json.NewEncoder(w).Encode(resp)
 }
 
-// Helpers
-
-func (h *SyncHandler) getTimestamp(userID int64, column string) *int64 {
-	var timestamp sql.NullInt64
-	query := ""
-	switch column {
-	case "history_sync_timestamp":
-		query = "SELECT history_sync_timestamp FROM users WHERE id = ?"
-	case "favourites_sync_timestamp":
-		query = "SELECT favourites_sync_timestamp FROM users WHERE id = ?"
-	default:
-		return nil
-	}
-	h.DB.QueryRow(query, userID).Scan(&timestamp)
-	if timestamp.Valid {
-		return &timestamp.Int64
+func (h *SyncHandler) GetBookmarks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
-	return nil
-}
 
-func upsertManga(tx *sql.Tx, manga *model.Manga, isMySQL bool) error {
-	query := `INSERT INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(id) DO UPDATE SET
-	title=excluded.title, alt_title=excluded.alt_title, url=excluded.url, public_url=excluded.public_url, rating=excluded.rating, content_rating=excluded.content_rating,
-	cover_url=excluded.cover_url, large_cover_url=excluded.large_cover_url, state=excluded.state, author=excluded.author, source=excluded.source, nsfw=excluded.nsfw`
-	if isMySQL {
-		query = `INSERT INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-		title=VALUES(title), alt_title=VALUES(alt_title), url=VALUES(url), public_url=VALUES(public_url), rating=VALUES(rating), content_rating=VALUES(content_rating),
-		cover_url=VALUES(cover_url), large_cover_url=VALUES(large_cover_url), state=VALUES(state), author=VALUES(author), source=VALUES(source), nsfw=VALUES(nsfw)`
-	}
-
-	_, err := tx.Exec(query, manga.ID, manga.Title, manga.AltTitle, manga.URL, manga.PublicURL, manga.Rating, manga.ContentRating, manga.CoverURL, manga.LargeCoverURL, manga.State, manga.Author, manga.Source, manga.NSFW)
+	since, err := syncSince(r)
 	if err != nil {
-		return err
+		JSONError(w, "Invalid timestamp", http.StatusBadRequest)
+		return
 	}
 
-	for _, tag := range manga.Tags {
-		if err := upsertTag(tx, tag, isMySQL); err != nil {
-			return err
-		}
-		tagLinkQuery := "INSERT OR IGNORE INTO manga_tags (manga_id, tag_id) VALUES (?, ?)"
-		if isMySQL {
-			tagLinkQuery = "INSERT IGNORE INTO manga_tags (manga_id, tag_id) VALUES (?, ?)"
-		}
-		if _, err := tx.Exec(tagLinkQuery, manga.ID, tag.ID); err != nil {
-			return err
-		}
+	timestamp, err := h.Bookmarks.BookmarksTimestamp(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching bookmarks timestamp", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	etag := syncETag(timestamp, since)
+	setSyncCacheHeaders(w, etag)
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
-	return nil
-}
-
-// ensureMangaExists inserts a placeholder manga record if it doesn't exist
-// This is needed when the app sends manga_id without manga object (for already-synced manga)
-func ensureMangaExists(tx *sql.Tx, mangaID int64, isMySQL bool) error {
-	query := `INSERT INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(id) DO NOTHING`
-	if isMySQL {
-		query = `INSERT IGNORE INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	}
-	_, err := tx.Exec(query, mangaID, "", "", "", "", -1.0, "", "", "", "", "", "", false)
-	return err
-}
-
-// ensureCategoryExists inserts a placeholder category if it doesn't exist
-// This handles cases where favourites reference categories that haven't been synced yet
-func ensureCategoryExists(tx *sql.Tx, categoryID int64, userID int64, isMySQL bool) error {
-	query := `INSERT INTO categories (id, user_id, created_at, sort_key, title, ` + "`order`" + `, track, show_in_lib, deleted_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(id, user_id) DO NOTHING`
-	if isMySQL {
-		query = "INSERT IGNORE INTO categories (id, user_id, created_at, sort_key, title, `order`, track, show_in_lib, deleted_at)\n" +
-			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
-	}
-	_, err := tx.Exec(query, categoryID, userID, 0, 0, "Unknown", "NEWEST", true, true, 0)
-	return err
-}
 
-func upsertTag(tx *sql.Tx, tag model.Tag, isMySQL bool) error {
-	query := "INSERT INTO tags (id, title, `key`, source, pinned) VALUES (?, ?, ?, ?, ?)\n" +
-		"ON CONFLICT(id) DO UPDATE SET title=excluded.title, `key`=excluded.`key`, source=excluded.source, pinned=excluded.pinned"
-	if isMySQL {
-		query = "INSERT INTO tags (id, title, `key`, source, pinned) VALUES (?, ?, ?, ?, ?)\n" +
-			"ON DUPLICATE KEY UPDATE title=VALUES(title), `key`=VALUES(`key`), source=VALUES(source), pinned=VALUES(pinned)"
+	bookmarks, err := h.Bookmarks.GetBookmarks(r.Context(), userID, since)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching bookmarks", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
 	}
-	_, err := tx.Exec(query, tag.ID, tag.Title, tag.Key, tag.Source, tag.Pinned)
-	return err
-}
 
-func upsertHistory(tx *sql.Tx, userID int64, history model.History, isMySQL bool) error {
-	query := `INSERT INTO history (manga_id, user_id, created_at, updated_at, chapter_id, page, scroll, percent, chapters, deleted_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(user_id, manga_id) DO UPDATE SET
-	created_at=excluded.created_at, updated_at=excluded.updated_at, chapter_id=excluded.chapter_id, page=excluded.page,
-	scroll=excluded.scroll, percent=excluded.percent, chapters=excluded.chapters, deleted_at=excluded.deleted_at
-	WHERE excluded.updated_at >= history.updated_at`
-	if isMySQL {
-		query = `INSERT INTO history (manga_id, user_id, created_at, updated_at, chapter_id, page, scroll, percent, chapters, deleted_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-		created_at=IF(VALUES(updated_at) >= updated_at, VALUES(created_at), created_at),
-		chapter_id=IF(VALUES(updated_at) >= updated_at, VALUES(chapter_id), chapter_id),
-		page=IF(VALUES(updated_at) >= updated_at, VALUES(page), page),
-		scroll=IF(VALUES(updated_at) >= updated_at, VALUES(scroll), scroll),
-		percent=IF(VALUES(updated_at) >= updated_at, VALUES(percent), percent),
-		chapters=IF(VALUES(updated_at) >= updated_at, VALUES(chapters), chapters),
-		deleted_at=IF(VALUES(updated_at) >= updated_at, VALUES(deleted_at), deleted_at),
-		updated_at=IF(VALUES(updated_at) >= updated_at, VALUES(updated_at), updated_at)`
-	}
-	_, err := tx.Exec(query, history.MangaID, userID, history.CreatedAt, history.UpdatedAt, history.ChapterID, history.Page, history.Scroll, history.Percent, history.Chapters, history.DeletedAt)
-	return err
-}
+	resp := model.BookmarksPackage{
+		Bookmarks: bookmarks,
+		Timestamp: timestamp,
+	}
 
-func upsertCategory(tx *sql.Tx, userID int64, cat model.Category, isMySQL bool) error {
-	query := "INSERT INTO categories (id, user_id, created_at, sort_key, title, `order`, track, show_in_lib, deleted_at)\n" +
-		`VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(id, user_id) DO UPDATE SET
-		created_at=excluded.created_at, sort_key=excluded.sort_key, title=excluded.title, ` + "`order`=excluded.`order`," + `
-		track=excluded.track, show_in_lib=excluded.show_in_lib, deleted_at=excluded.deleted_at
-		WHERE excluded.created_at > categories.created_at
-		OR (excluded.created_at = categories.created_at AND COALESCE(excluded.deleted_at, 0) >= COALESCE(categories.deleted_at, 0))`
-	if isMySQL {
-		query = `INSERT INTO categories (id, user_id, created_at, sort_key, title, ` + "`order`" + `, track, show_in_lib, deleted_at)
-    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-    ON DUPLICATE KEY UPDATE
-    sort_key=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(sort_key), sort_key),
-    title=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(title), title),
-    ` + "`order`" + `=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(` + "`order`" + `), ` + "`order`" + `),
-    track=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(track), track),
-    show_in_lib=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(show_in_lib), show_in_lib),
-    deleted_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(deleted_at), deleted_at),
-    created_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(created_at), created_at)`
-	}
-	_, err := tx.Exec(query, cat.ID, userID, cat.CreatedAt, cat.SortKey, cat.Title, cat.Order, cat.Track, cat.ShowInLib, cat.DeletedAt)
-	return err
+	json.NewEncoder(w).Encode(resp)
 }
 
-func upsertFavourite(tx *sql.Tx, userID int64, fav model.Favourite, isMySQL bool) error {
-	query := `INSERT INTO favourites (manga_id, category_id, user_id, sort_key, pinned, created_at, deleted_at)
-    VALUES (?, ?, ?, ?, ?, ?, ?)
-    ON CONFLICT(manga_id, category_id, user_id) DO UPDATE SET
-    category_id=excluded.category_id,
-    sort_key=excluded.sort_key, pinned=excluded.pinned,
-    created_at=excluded.created_at, deleted_at=excluded.deleted_at
-    WHERE excluded.created_at > favourites.created_at OR (excluded.created_at = favourites.created_at AND excluded.deleted_at > favourites.deleted_at)`
-	if isMySQL {
-		query = `INSERT INTO favourites (manga_id, category_id, user_id, sort_key, pinned, created_at, deleted_at)
-    VALUES (?, ?, ?, ?, ?, ?, ?)
-    ON DUPLICATE KEY UPDATE
-    category_id=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(category_id), category_id),
-    sort_key=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(sort_key), sort_key),
-    pinned=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(pinned), pinned),
-    deleted_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(deleted_at), deleted_at),
-    created_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(created_at), created_at)`
-	}
-	_, err := tx.Exec(query, fav.MangaID, fav.CategoryID, userID, fav.SortKey, fav.Pinned, fav.CreatedAt, fav.DeletedAt)
-	return err
-}
+func (h *SyncHandler) PostBookmarks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-func (h *SyncHandler) fetchHistory(userID int64) ([]model.History, error) {
-	rows, err := h.DB.Query(`SELECT manga_id, created_at, updated_at, chapter_id, page, scroll, percent, chapters, deleted_at FROM history WHERE user_id = ?`, userID)
+	since, err := syncSince(r)
 	if err != nil {
-		return nil, err
+		JSONError(w, "Invalid timestamp", http.StatusBadRequest)
+		return
 	}
-	defer rows.Close()
 
-	var history []model.History
-	mangaSet := make(map[int64]struct{})
-	for rows.Next() {
-		var hItem model.History
-		hItem.UserID = userID
-		if err := rows.Scan(&hItem.MangaID, &hItem.CreatedAt, &hItem.UpdatedAt, &hItem.ChapterID, &hItem.Page, &hItem.Scroll, &hItem.Percent, &hItem.Chapters, &hItem.DeletedAt); err != nil {
-			return nil, err
-		}
-		mangaSet[hItem.MangaID] = struct{}{}
-		history = append(history, hItem)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	var req model.BookmarksPackage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
+	metrics.SyncItems.Observe(float64(len(req.Bookmarks)), store.SyncResourceBookmarks, "bookmarks")
 
-	mangaByID, err := h.fetchMangaMap(keysFromSet(mangaSet))
-	if err != nil {
-		return nil, err
+	now, err := h.Bookmarks.SyncBookmarks(r.Context(), userID, req.Bookmarks, ifMatchVersions(r))
+	if errors.Is(err, store.ErrPreconditionFailed) {
+		JSONError(w, "Bookmarks were modified by another device", http.StatusPreconditionFailed)
+		return
 	}
-	for i := range history {
-		history[i].Manga = mangaByID[history[i].MangaID]
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error persisting bookmarks sync", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
-	return history, nil
-}
+	h.recordSync(r, store.SyncResourceBookmarks, now)
 
-func (h *SyncHandler) fetchFavouritesAndCategories(userID int64) ([]model.Favourite, []model.Category, error) {
-	// Categories
-	rows, err := h.DB.Query("SELECT id, created_at, sort_key, title, `order`, track, show_in_lib, deleted_at FROM categories WHERE user_id = ?", userID)
+	bookmarks, err := h.Bookmarks.GetBookmarks(r.Context(), userID, since)
 	if err != nil {
-		return nil, nil, err
+		slog.ErrorContext(r.Context(), "Error fetching updated bookmarks", "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
 	}
-	defer rows.Close()
 
-	var categories []model.Category
-	for rows.Next() {
-		var cat model.Category
-		cat.UserID = userID
-		if err := rows.Scan(&cat.ID, &cat.CreatedAt, &cat.SortKey, &cat.Title, &cat.Order, &cat.Track, &cat.ShowInLib, &cat.DeletedAt); err != nil {
-			return nil, nil, err
-		}
-		categories = append(categories, cat)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, nil, err
+	resp := model.BookmarksPackage{
+		Bookmarks: bookmarks,
+		Timestamp: &now,
 	}
 
-	// Favourites
-	favRows, err := h.DB.Query(`SELECT manga_id, category_id, sort_key, pinned, created_at, deleted_at FROM favourites WHERE user_id = ?`, userID)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer favRows.Close()
+	setSyncCacheHeaders(w, syncETag(&now, since))
+	json.NewEncoder(w).Encode(resp)
+}
 
-	var favourites []model.Favourite
-	mangaSet := make(map[int64]struct{})
-	for favRows.Next() {
-		var fav model.Favourite
-		fav.UserID = userID
-		if err := favRows.Scan(&fav.MangaID, &fav.CategoryID, &fav.SortKey, &fav.Pinned, &fav.CreatedAt, &fav.DeletedAt); err != nil {
-			return nil, nil, err
-		}
-		mangaSet[fav.MangaID] = struct{}{}
-		favourites = append(favourites, fav)
-	}
-	if err := favRows.Err(); err != nil {
-		return nil, nil, err
-	}
+// Helpers
 
-	mangaByID, err := h.fetchMangaMap(keysFromSet(mangaSet))
-	if err != nil {
-		return nil, nil, err
+// recordSync notes on the caller's session that it synced resource and tells
+// the user's other devices about the new timestamp. Failures are logged only,
+// as the sync itself already succeeded.
+func (h *SyncHandler) recordSync(r *http.Request, resource string, timestamp int64) {
+	userID, _ := GetUserID(r)
+	sessionID, ok := GetSessionID(r)
+	publishSync(r, h.Events, userID, sessionID, resource, timestamp)
+	if !ok {
+		return
 	}
-	for i := range favourites {
-		favourites[i].Manga = mangaByID[favourites[i].MangaID]
+	if err := h.Sessions.RecordSessionSync(r.Context(), sessionID, resource, time.Now().Unix()); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording sync", "resource", resource, "session_id", sessionID, "error", err)
 	}
-
-	return favourites, categories, nil
 }
 
-func keysFromSet(set map[int64]struct{}) []int64 {
-	keys := make([]int64, 0, len(set))
-	for id := range set {
-		keys = append(keys, id)
+// publishSync tells the user's devices, except the one of sessionID, that
+// resource changed at timestamp. Failures are logged only.
+func publishSync(r *http.Request, hub *events.Hub, userID int64, sessionID, resource string, timestamp int64) {
+	if hub == nil {
+		return
+	}
+	event := events.Event{UserID: userID, Resource: resource, Timestamp: timestamp, SessionID: sessionID}
+	if err := hub.Publish(r.Context(), event); err != nil {
+		slog.ErrorContext(r.Context(), "Error publishing sync event", "resource", resource, "user_id", userID, "error", err)
 	}
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i] < keys[j]
-	})
-	return keys
 }
 
-func makePlaceholders(n int) string {
-	if n <= 0 {
-		return ""
+// historyWebhooks queues a history.updated event with the rows accepted by
+// the sync stamped with timestamp. Failures are logged only.
+func (h *SyncHandler) historyWebhooks(r *http.Request, userID int64, timestamp int64) {
+	if h.Webhooks == nil {
+		return
+	}
+	ctx := r.Context()
+	if ok, err := h.Webhooks.Subscribed(ctx, userID, webhooks.EventHistoryUpdated); err != nil || !ok {
+		logWebhookError(r.Context(), userID, err)
+		return
+	}
+	since := timestamp - 1
+	history, err := h.History.GetHistory(ctx, userID, &since)
+	if err != nil || len(history) == 0 {
+		logWebhookError(r.Context(), userID, err)
+		return
 	}
-	return strings.TrimRight(strings.Repeat("?,", n), ",")
+	data := map[string]any{"timestamp": timestamp, "history": history}
+	logWebhookError(r.Context(), userID, h.Webhooks.Enqueue(ctx, userID, webhooks.EventHistoryUpdated, data))
 }
 
-func (h *SyncHandler) fetchMangaMap(mangaIDs []int64) (map[int64]*model.Manga, error) {
-	mangaByID := make(map[int64]*model.Manga, len(mangaIDs))
-	if len(mangaIDs) == 0 {
-		return mangaByID, nil
+// knownCategories returns the IDs of the user's categories before a sync
+// when a webhook wants category.created, which accepted rows of other
+// categories are.
+func (h *SyncHandler) knownCategories(r *http.Request, userID int64) map[int64]bool {
+	if h.Webhooks == nil {
+		return nil
 	}
-
-	args := make([]any, 0, len(mangaIDs))
-	for _, id := range mangaIDs {
-		args = append(args, id)
+	if ok, err := h.Webhooks.Subscribed(r.Context(), userID, webhooks.EventCategoryCreated); err != nil || !ok {
+		logWebhookError(r.Context(), userID, err)
+		return nil
 	}
-
-	query := `SELECT id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw
-		FROM manga WHERE id IN (` + makePlaceholders(len(mangaIDs)) + `)`
-	rows, err := h.DB.Query(query, args...)
+	ids, err := h.Library.CategoryIDs(r.Context(), userID)
 	if err != nil {
-		return nil, err
+		logWebhookError(r.Context(), userID, err)
+		return nil
+	}
+	known := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
 	}
-	defer rows.Close()
+	return known
+}
 
-	for rows.Next() {
-		var m model.Manga
-		if err := rows.Scan(&m.ID, &m.Title, &m.AltTitle, &m.URL, &m.PublicURL, &m.Rating, &m.ContentRating, &m.CoverURL, &m.LargeCoverURL, &m.State, &m.Author, &m.Source, &m.NSFW); err != nil {
-			return nil, err
-		}
-		mCopy := m
-		mangaByID[m.ID] = &mCopy
+// favouriteWebhooks queues favourite.added, favourite.removed and
+// category.created events with the rows accepted by the sync stamped with
+// timestamp. Categories are only reported as created when knownCategories
+// looked them up. Failures are logged only.
+func (h *SyncHandler) favouriteWebhooks(r *http.Request, userID int64, timestamp int64, knownCategories map[int64]bool) {
+	if h.Webhooks == nil {
+		return
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	ctx := r.Context()
+	ok, err := h.Webhooks.Subscribed(ctx, userID, webhooks.EventFavouriteAdded, webhooks.EventFavouriteRemoved, webhooks.EventCategoryCreated)
+	if err != nil || !ok {
+		logWebhookError(r.Context(), userID, err)
+		return
 	}
-
-	tagsByMangaID, err := h.fetchTagsByMangaIDs(mangaIDs)
+	since := timestamp - 1
+	favourites, categories, err := h.Library.GetFavourites(ctx, userID, &since)
 	if err != nil {
-		return nil, err
+		logWebhookError(r.Context(), userID, err)
+		return
 	}
-	for mangaID, tags := range tagsByMangaID {
-		if manga := mangaByID[mangaID]; manga != nil {
-			manga.Tags = tags
+
+	var added, removed []model.Favourite
+	for _, favourite := range favourites {
+		if favourite.DeletedAt == 0 {
+			added = append(added, favourite)
+		} else {
+			removed = append(removed, favourite)
 		}
 	}
-
-	return mangaByID, nil
-}
-
-func (h *SyncHandler) fetchTagsByMangaIDs(mangaIDs []int64) (map[int64][]model.Tag, error) {
-	tagsByMangaID := make(map[int64][]model.Tag, len(mangaIDs))
-	if len(mangaIDs) == 0 {
-		return tagsByMangaID, nil
+	var created []model.Category
+	for _, category := range categories {
+		if knownCategories != nil && !knownCategories[category.ID] {
+			created = append(created, category)
+		}
 	}
 
-	args := make([]any, 0, len(mangaIDs))
-	for _, id := range mangaIDs {
-		args = append(args, id)
+	if len(added) > 0 {
+		data := map[string]any{"timestamp": timestamp, "favourites": added}
+		logWebhookError(r.Context(), userID, h.Webhooks.Enqueue(ctx, userID, webhooks.EventFavouriteAdded, data))
+	}
+	if len(removed) > 0 {
+		data := map[string]any{"timestamp": timestamp, "favourites": removed}
+		logWebhookError(r.Context(), userID, h.Webhooks.Enqueue(ctx, userID, webhooks.EventFavouriteRemoved, data))
+	}
+	if len(created) > 0 {
+		data := map[string]any{"timestamp": timestamp, "categories": created}
+		logWebhookError(r.Context(), userID, h.Webhooks.Enqueue(ctx, userID, webhooks.EventCategoryCreated, data))
 	}
+}
 
-	query := "SELECT mt.manga_id, t.id, t.title, t.`key`, t.source, t.pinned " +
-		"FROM tags t JOIN manga_tags mt ON t.id = mt.tag_id " +
-		"WHERE mt.manga_id IN (" + makePlaceholders(len(mangaIDs)) + ")"
-	rows, err := h.DB.Query(query, args...)
+func logWebhookError(ctx context.Context, userID int64, err error) {
 	if err != nil {
-		return nil, err
+		slog.ErrorContext(ctx, "Error queueing webhook events", "user_id", userID, "error", err)
 	}
-	defer rows.Close()
+}
 
-	for rows.Next() {
-		var mangaID int64
-		var t model.Tag
-		if err := rows.Scan(&mangaID, &t.ID, &t.Title, &t.Key, &t.Source, &t.Pinned); err != nil {
-			return nil, err
-		}
-		tagsByMangaID[mangaID] = append(tagsByMangaID[mangaID], t)
+// syncSince extracts the client's last known sync timestamp from the
+// "timestamp" query parameter or the X-Sync-Timestamp header. A nil result
+// means the client wants the full package.
+func syncSince(r *http.Request) (*int64, error) {
+	value := r.URL.Query().Get("timestamp")
+	if value == "" {
+		value = r.Header.Get("X-Sync-Timestamp")
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	if value == "" {
+		return nil, nil
 	}
 
-	return tagsByMangaID, nil
+	since, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || since < 0 {
+		return nil, fmt.Errorf("invalid sync timestamp %q", value)
+	}
+	return &since, nil
 }
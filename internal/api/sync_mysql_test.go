@@ -23,7 +23,7 @@ func TestPostHistoryOlderUpdateDoesNotOverwriteNewerMySQL(t *testing.T) {
 		t.Fatalf("insert user failed: %v", err)
 	}
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	manga := model.Manga{
 		ID: 777, Title: "Ordering", URL: "http://ordering", PublicURL: "http://ordering", Rating: 5, Source: "test", CoverURL: "http://cover",
@@ -57,7 +57,7 @@ func TestSyncFavouritesOlderCategoryDoesNotOverwriteMySQL(t *testing.T) {
 		t.Fatalf("insert user failed: %v", err)
 	}
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	deletedAt := int64(0)
 	catPhone1 := model.Category{
@@ -111,7 +111,7 @@ func TestSyncFavouritesTombstoneNotOverwrittenByOlderStateMySQL(t *testing.T) {
 		t.Fatalf("insert user failed: %v", err)
 	}
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	deletedAt := int64(0)
 	category := model.Category{
@@ -191,7 +191,7 @@ func TestPostFavouritesEnsuresMissingCategoryAndMangaMySQL(t *testing.T) {
 		t.Fatalf("insert user failed: %v", err)
 	}
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	payload := model.FavouritesPackage{
 		Favourites: []model.Favourite{
@@ -231,7 +231,7 @@ func TestPostFavouritesEnsuresMissingCategoryAndMangaMySQL(t *testing.T) {
 
 func TestMySQLIntegrationSmokeUsesCurrentTime(t *testing.T) {
 	database := testutil.SetupMySQLTestDB(t)
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	res, err := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "smoke-mysql@example.com", "hash")
 	if err != nil {
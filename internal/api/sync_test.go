@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
 )
 
+func newSQLSyncHandler(database *db.DB) *SyncHandler {
+	st := sqlstore.New(database)
+	return &SyncHandler{History: st, Library: st, Bookmarks: st, Sessions: st}
+}
+
 func TestSyncHistory(t *testing.T) {
 	database := testutil.SetupTestDB(t)
 	defer database.Close()
@@ -22,7 +31,7 @@ func TestSyncHistory(t *testing.T) {
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "hist@example.com", "hash")
 	userID, _ := res.LastInsertId()
 
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	// 1. Post History
 	now := time.Now().UnixMilli()
@@ -85,7 +94,7 @@ func TestSyncFavourites(t *testing.T) {
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "fav@example.com", "hash")
 	userID, _ := res.LastInsertId()
 
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	// 1. Post Favourites & Categories
 	now := time.Now().UnixMilli()
@@ -142,7 +151,7 @@ func TestSyncFavouritesOlderCategoryDoesNotOverwrite(t *testing.T) {
 
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "multi-device-cat@example.com", "hash")
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	deletedAt := int64(0)
 	catPhone1 := model.Category{
@@ -194,7 +203,7 @@ func TestSyncFavouritesTombstoneNotOverwrittenByOlderState(t *testing.T) {
 
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "multi-device-fav@example.com", "hash")
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	deletedAt := int64(0)
 	category := model.Category{
@@ -270,7 +279,7 @@ func TestSyncHandlersUnauthorized(t *testing.T) {
 	database := testutil.SetupTestDB(t)
 	defer database.Close()
 
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	tests := []struct {
 		name   string
@@ -305,7 +314,7 @@ func TestPostSyncHandlersRejectInvalidJSON(t *testing.T) {
 
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "badjson@example.com", "hash")
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	tests := []struct {
 		name   string
@@ -337,7 +346,7 @@ func TestPostHistoryOlderUpdateDoesNotOverwriteNewer(t *testing.T) {
 
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "history-order@example.com", "hash")
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	manga := model.Manga{
 		ID: 777, Title: "Ordering", URL: "http://ordering", PublicURL: "http://ordering", Rating: 5, Source: "test", CoverURL: "http://cover",
@@ -369,7 +378,7 @@ func TestPostFavouritesEnsuresMissingCategoryAndManga(t *testing.T) {
 
 	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "placeholder@example.com", "hash")
 	userID, _ := res.LastInsertId()
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 
 	payload := model.FavouritesPackage{
 		Favourites: []model.Favourite{
@@ -416,7 +425,7 @@ func TestGetFavouritesIsUserScoped(t *testing.T) {
 	res2, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "u2@example.com", "hash")
 	userID2, _ := res2.LastInsertId()
 
-	handler := &SyncHandler{DB: database}
+	handler := newSQLSyncHandler(database)
 	now := time.Now().UnixMilli()
 
 	payload1 := model.FavouritesPackage{
@@ -468,7 +477,7 @@ func TestGetFavouritesIsUserScoped(t *testing.T) {
 	}
 }
 
-func postHistoryPackage(t *testing.T, handler *SyncHandler, userID int64, payload model.HistoryPackage) {
+func postHistoryPackage(t *testing.T, handler *SyncHandler, userID int64, payload model.HistoryPackage) model.HistoryPackage {
 	t.Helper()
 
 	body, _ := json.Marshal(payload)
@@ -479,9 +488,15 @@ func postHistoryPackage(t *testing.T, handler *SyncHandler, userID int64, payloa
 	if rr.Code != http.StatusOK {
 		t.Fatalf("PostHistory failed: %d body=%s", rr.Code, rr.Body.String())
 	}
+
+	var resp model.HistoryPackage
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode PostHistory response failed: %v", err)
+	}
+	return resp
 }
 
-func postFavouritesPackage(t *testing.T, handler *SyncHandler, userID int64, payload model.FavouritesPackage) {
+func postFavouritesPackage(t *testing.T, handler *SyncHandler, userID int64, payload model.FavouritesPackage) model.FavouritesPackage {
 	t.Helper()
 
 	body, _ := json.Marshal(payload)
@@ -492,4 +507,356 @@ func postFavouritesPackage(t *testing.T, handler *SyncHandler, userID int64, pay
 	if rr.Code != http.StatusOK {
 		t.Fatalf("PostFavourites failed: %d body=%s", rr.Code, rr.Body.String())
 	}
+
+	var resp model.FavouritesPackage
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode PostFavourites response failed: %v", err)
+	}
+	return resp
+}
+
+func postBookmarksPackage(t *testing.T, handler *SyncHandler, userID int64, payload model.BookmarksPackage) model.BookmarksPackage {
+	t.Helper()
+
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest("POST", "/resource/bookmarks", bytes.NewBuffer(body))
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr := httptest.NewRecorder()
+	handler.PostBookmarks(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PostBookmarks failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var resp model.BookmarksPackage
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode PostBookmarks response failed: %v", err)
+	}
+	return resp
+}
+
+func TestGetHistoryDeltaSinceTimestamp(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "delta@example.com", "hash")
+	userID, _ := res.LastInsertId()
+	handler := newSQLSyncHandler(database)
+
+	first := model.Manga{ID: 1, Title: "First", URL: "/1", PublicURL: "/1", Rating: 1, Source: "test", CoverURL: "/1.jpg"}
+	second := model.Manga{ID: 2, Title: "Second", URL: "/2", PublicURL: "/2", Rating: 1, Source: "test", CoverURL: "/2.jpg"}
+
+	initial := postHistoryPackage(t, handler, userID, model.HistoryPackage{History: []model.History{
+		{MangaID: 1, Manga: &first, CreatedAt: 100, UpdatedAt: 100, ChapterID: 1, Chapters: 10},
+		{MangaID: 2, Manga: &second, CreatedAt: 100, UpdatedAt: 100, ChapterID: 1, Chapters: 10},
+	}})
+	if initial.Timestamp == nil {
+		t.Fatal("expected sync timestamp")
+	}
+
+	// Another device deletes the second manga from history.
+	postHistoryPackage(t, handler, userID, model.HistoryPackage{History: []model.History{
+		{MangaID: 2, CreatedAt: 100, UpdatedAt: 200, ChapterID: 1, Chapters: 10, DeletedAt: 200},
+	}})
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/resource/history?timestamp=%d", *initial.Timestamp), nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr := httptest.NewRecorder()
+	handler.GetHistory(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GetHistory failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var resp model.HistoryPackage
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(resp.History) != 1 || resp.History[0].MangaID != 2 || resp.History[0].DeletedAt != 200 {
+		t.Fatalf("expected only the tombstoned history row, got %+v", resp.History)
+	}
+	if resp.Timestamp == nil || *resp.Timestamp <= *initial.Timestamp {
+		t.Fatalf("expected timestamp to advance past %d, got %v", *initial.Timestamp, resp.Timestamp)
+	}
+
+	// A stale write that loses last-writer-wins must not show up as a change.
+	postHistoryPackage(t, handler, userID, model.HistoryPackage{History: []model.History{
+		{MangaID: 1, CreatedAt: 100, UpdatedAt: 50, ChapterID: 1, Chapters: 10},
+	}})
+	reqHeader, _ := http.NewRequest("GET", "/resource/history", nil)
+	reqHeader.Header.Set("X-Sync-Timestamp", fmt.Sprintf("%d", *resp.Timestamp))
+	reqHeader = reqHeader.WithContext(context.WithValue(reqHeader.Context(), UserIDKey, userID))
+	rrHeader := httptest.NewRecorder()
+	handler.GetHistory(rrHeader, reqHeader)
+
+	var respHeader model.HistoryPackage
+	if err := json.NewDecoder(rrHeader.Body).Decode(&respHeader); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(respHeader.History) != 0 {
+		t.Fatalf("expected no changes, got %+v", respHeader.History)
+	}
+}
+
+func TestGetFavouritesDeltaSinceTimestamp(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "delta-fav@example.com", "hash")
+	userID, _ := res.LastInsertId()
+	handler := newSQLSyncHandler(database)
+
+	manga := model.Manga{ID: 10, Title: "Fav", URL: "/10", PublicURL: "/10", Rating: 1, Source: "test", CoverURL: "/10.jpg"}
+	initial := postFavouritesPackage(t, handler, userID, model.FavouritesPackage{
+		Categories: []model.Category{
+			{ID: 1, Title: "One", Order: "NEWEST", Track: true, ShowInLib: true, CreatedAt: 100},
+			{ID: 2, Title: "Two", Order: "NEWEST", Track: true, ShowInLib: true, CreatedAt: 100},
+		},
+		Favourites: []model.Favourite{{MangaID: 10, Manga: &manga, CategoryID: 1, CreatedAt: 100}},
+	})
+
+	postFavouritesPackage(t, handler, userID, model.FavouritesPackage{
+		Categories: []model.Category{{ID: 2, Title: "Renamed", Order: "NEWEST", Track: true, ShowInLib: true, CreatedAt: 200}},
+	})
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/resource/favourites?timestamp=%d", *initial.Timestamp), nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr := httptest.NewRecorder()
+	handler.GetFavourites(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GetFavourites failed: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var resp model.FavouritesPackage
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(resp.Favourites) != 0 {
+		t.Fatalf("expected no favourite changes, got %+v", resp.Favourites)
+	}
+	if len(resp.Categories) != 1 || resp.Categories[0].Title != "Renamed" {
+		t.Fatalf("expected only the renamed category, got %+v", resp.Categories)
+	}
+}
+
+func TestGetHistoryRejectsInvalidTimestamp(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	handler := newSQLSyncHandler(database)
+	req, _ := http.NewRequest("GET", "/resource/history?timestamp=yesterday", nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, int64(1)))
+	rr := httptest.NewRecorder()
+	handler.GetHistory(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSyncConditionalRequests(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "etag@example.com", "hash")
+	userID, _ := res.LastInsertId()
+	handler := newSQLSyncHandler(database)
+
+	manga := model.Manga{ID: 5, Title: "Etag", URL: "/5", PublicURL: "/5", Rating: 1, Source: "test", CoverURL: "/5.jpg"}
+	postHistoryPackage(t, handler, userID, model.HistoryPackage{History: []model.History{
+		{MangaID: 5, Manga: &manga, CreatedAt: 100, UpdatedAt: 100, ChapterID: 1, Chapters: 10},
+	}})
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/resource/history", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		handler.GetHistory(rr, req)
+		return rr
+	}
+
+	rr := get("")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with ETag, got %d etag=%q", rr.Code, etag)
+	}
+
+	if rr := get(etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("expected empty 304 for matching If-None-Match, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	post := func(ifMatch string, updatedAt int64) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(model.HistoryPackage{History: []model.History{
+			{MangaID: 5, CreatedAt: 100, UpdatedAt: updatedAt, ChapterID: 2, Chapters: 10},
+		}})
+		req, _ := http.NewRequest("POST", "/resource/history", bytes.NewBuffer(body))
+		req.Header.Set("If-Match", ifMatch)
+		req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		handler.PostHistory(rr, req)
+		return rr
+	}
+
+	rrPost := post(etag, 200)
+	if rrPost.Code != http.StatusOK {
+		t.Fatalf("expected 200 for current If-Match, got %d body=%s", rrPost.Code, rrPost.Body.String())
+	}
+	newETag := rrPost.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("expected a new ETag after write, got %q", newETag)
+	}
+
+	if rr := post(etag, 300); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var updatedAt int64
+	if err := database.QueryRow("SELECT updated_at FROM history WHERE user_id = ? AND manga_id = ?", userID, 5).Scan(&updatedAt); err != nil {
+		t.Fatalf("failed to read history row: %v", err)
+	}
+	if updatedAt != 200 {
+		t.Fatalf("rejected write must not be persisted, got updated_at=%d", updatedAt)
+	}
+
+	if rr := get(etag); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for outdated If-None-Match, got %d", rr.Code)
+	}
+}
+
+func TestSyncHandlersWithMemStore(t *testing.T) {
+	st := memstore.New()
+	userID, err := st.CreateUser(context.Background(), "mem@example.com", "hash")
+	if err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+	handler := &SyncHandler{History: st, Library: st, Bookmarks: st, Sessions: st}
+
+	manga := model.Manga{ID: 9, Title: "In Memory", URL: "/9", PublicURL: "/9", Source: "test", CoverURL: "/9.jpg",
+		Tags: []model.Tag{{ID: 1, Title: "Tag", Key: "tag", Source: "test"}}}
+	first := postHistoryPackage(t, handler, userID, model.HistoryPackage{History: []model.History{
+		{MangaID: 9, Manga: &manga, CreatedAt: 100, UpdatedAt: 200, ChapterID: 2, Chapters: 10},
+	}})
+	if len(first.History) != 1 || first.History[0].Manga == nil || len(first.History[0].Manga.Tags) != 1 {
+		t.Fatalf("expected history with manga and tags, got %+v", first.History)
+	}
+
+	// Older updates lose, but still advance the sync timestamp.
+	second := postHistoryPackage(t, handler, userID, model.HistoryPackage{History: []model.History{
+		{MangaID: 9, CreatedAt: 100, UpdatedAt: 150, ChapterID: 1, Chapters: 10},
+	}})
+	if *second.Timestamp <= *first.Timestamp {
+		t.Fatalf("expected increasing timestamps, got %d then %d", *first.Timestamp, *second.Timestamp)
+	}
+	if second.History[0].ChapterID != 2 {
+		t.Fatalf("stale update must not overwrite newer history, got chapter %d", second.History[0].ChapterID)
+	}
+
+	delta := postHistoryPackage(t, handler, userID, model.HistoryPackage{})
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/resource/history?timestamp=%d", *delta.Timestamp), nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	rr := httptest.NewRecorder()
+	handler.GetHistory(rr, req)
+	var resp model.HistoryPackage
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(resp.History) != 0 {
+		t.Fatalf("expected empty delta, got %+v", resp.History)
+	}
+
+	favs := postFavouritesPackage(t, handler, userID, model.FavouritesPackage{
+		Favourites: []model.Favourite{{MangaID: 9, CategoryID: 3, CreatedAt: 100}},
+	})
+	if len(favs.Favourites) != 1 || len(favs.Categories) != 1 || favs.Categories[0].Title != "Unknown" {
+		t.Fatalf("expected favourite with placeholder category, got %+v", favs)
+	}
+
+	body, _ := json.Marshal(model.FavouritesPackage{})
+	reqPost, _ := http.NewRequest("POST", "/resource/favourites", bytes.NewBuffer(body))
+	reqPost.Header.Set("If-Match", `"1"`)
+	reqPost = reqPost.WithContext(context.WithValue(reqPost.Context(), UserIDKey, userID))
+	rrPost := httptest.NewRecorder()
+	handler.PostFavourites(rrPost, reqPost)
+	if rrPost.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d body=%s", rrPost.Code, rrPost.Body.String())
+	}
+}
+
+func TestSyncBookmarks(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	memStore := memstore.New()
+	memUserID, _ := memStore.CreateUser(context.Background(), "bookmarks@example.com", "hash")
+	res, _ := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "bookmarks@example.com", "hash")
+	sqlUserID, _ := res.LastInsertId()
+
+	for name, tc := range map[string]struct {
+		handler *SyncHandler
+		userID  int64
+	}{
+		"sqlstore": {newSQLSyncHandler(database), sqlUserID},
+		"memstore": {&SyncHandler{History: memStore, Library: memStore, Bookmarks: memStore, Sessions: memStore}, memUserID},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler, userID := tc.handler, tc.userID
+			manga := model.Manga{ID: 40, Title: "Bookmarked", URL: "/40", PublicURL: "/40", Rating: 1, Source: "test", CoverURL: "/40.jpg"}
+
+			first := postBookmarksPackage(t, handler, userID, model.BookmarksPackage{Bookmarks: []model.Bookmark{
+				{MangaID: 40, Manga: &manga, PageID: 1, ChapterID: 7, Page: 3, ImageURL: "/40/3.jpg", Percent: 0.1, CreatedAt: 100},
+				{MangaID: 40, PageID: 2, ChapterID: 7, Page: 5, ImageURL: "/40/5.jpg", Percent: 0.2, CreatedAt: 100},
+			}})
+			if len(first.Bookmarks) != 2 || first.Bookmarks[0].Manga == nil || first.Timestamp == nil {
+				t.Fatalf("expected two bookmarks with manga, got %+v", first)
+			}
+
+			// A tombstone wins over the same bookmark, an older copy does not
+			// bring it back.
+			postBookmarksPackage(t, handler, userID, model.BookmarksPackage{Bookmarks: []model.Bookmark{
+				{MangaID: 40, PageID: 1, ChapterID: 7, Page: 3, CreatedAt: 100, DeletedAt: 300},
+			}})
+			delta := postBookmarksPackage(t, handler, userID, model.BookmarksPackage{Bookmarks: []model.Bookmark{
+				{MangaID: 40, PageID: 1, ChapterID: 7, Page: 3, CreatedAt: 50},
+			}})
+
+			req, _ := http.NewRequest("GET", fmt.Sprintf("/resource/bookmarks?timestamp=%d", *first.Timestamp), nil)
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+			rr := httptest.NewRecorder()
+			handler.GetBookmarks(rr, req)
+			var resp model.BookmarksPackage
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response failed: %v", err)
+			}
+			if len(resp.Bookmarks) != 1 || resp.Bookmarks[0].PageID != 1 || resp.Bookmarks[0].DeletedAt != 300 {
+				t.Fatalf("expected only the tombstone in the delta, got %+v", resp.Bookmarks)
+			}
+			if *resp.Timestamp != *delta.Timestamp {
+				t.Fatalf("expected timestamp %d, got %d", *delta.Timestamp, *resp.Timestamp)
+			}
+
+			get := func(etag string) *httptest.ResponseRecorder {
+				req, _ := http.NewRequest("GET", "/resource/bookmarks", nil)
+				if etag != "" {
+					req.Header.Set("If-None-Match", etag)
+				}
+				req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+				rr := httptest.NewRecorder()
+				handler.GetBookmarks(rr, req)
+				return rr
+			}
+			if rr := get(get("").Header().Get("ETag")); rr.Code != http.StatusNotModified {
+				t.Fatalf("expected 304 for current ETag, got %d", rr.Code)
+			}
+
+			body, _ := json.Marshal(model.BookmarksPackage{})
+			req, _ = http.NewRequest("POST", "/resource/bookmarks", bytes.NewBuffer(body))
+			req.Header.Set("If-Match", `"1"`)
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+			rr = httptest.NewRecorder()
+			handler.PostBookmarks(rr, req)
+			if rr.Code != http.StatusPreconditionFailed {
+				t.Fatalf("expected 412 for outdated If-Match, got %d", rr.Code)
+			}
+		})
+	}
 }
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const (
+	defaultTimelineLimit = 100
+	maxTimelineLimit     = 1000
+)
+
+type TimelineHandler struct {
+	Events store.ReadingLogStore
+}
+
+type TimelineResponse struct {
+	Events []model.ReadingEvent `json:"events"`
+}
+
+// Timeline lists what the user read, newest first. The optional from and to
+// parameters take Unix milliseconds, RFC 3339 times or YYYY-MM-DD dates (UTC);
+// a date as to includes the whole day. manga_id narrows the list to one manga
+// and limit caps its length.
+func (h *TimelineHandler) Timeline(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := store.ReadingEventFilter{Limit: defaultTimelineLimit}
+	var err error
+	if filter.From, err = timelineBound(query.Get("from"), false); err != nil {
+		JSONError(w, "Invalid from", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = timelineBound(query.Get("to"), true); err != nil {
+		JSONError(w, "Invalid to", http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("manga_id"); value != "" {
+		if filter.MangaID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			JSONError(w, "Invalid manga_id", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			JSONError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = min(filter.Limit, maxTimelineLimit)
+	}
+
+	events, err := h.Events.ListReadingEvents(r.Context(), userID, filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching reading events", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []model.ReadingEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TimelineResponse{Events: events})
+}
+
+// timelineBound parses a from or to parameter into Unix milliseconds, 0 when
+// it is empty. A date given as the upper bound is moved to the end of the day
+// because the filter's upper bound is exclusive.
+func timelineBound(value string, upper bool) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if ms < 0 {
+			return 0, fmt.Errorf("negative time %d", ms)
+		}
+		return ms, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t.UnixMilli(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
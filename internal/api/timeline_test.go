@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestHistoryTimeline(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	sqlStore := sqlstore.New(database)
+	memStore := memstore.New()
+	for name, st := range map[string]interface {
+		store.UserStore
+		store.SessionStore
+		store.HistoryStore
+		store.ReadingLogStore
+	}{
+		"sqlstore": sqlStore,
+		"memstore": memStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			userID, err := st.CreateUser(ctx, "timeline@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().Unix()
+			session := &model.Session{ID: "timeline-session", UserID: userID, RefreshTokenHash: "hash", CreatedAt: now, RefreshedAt: now, ExpiresAt: now + 3600, DeviceName: "Pixel"}
+			if err := st.CreateSession(ctx, session); err != nil {
+				t.Fatal(err)
+			}
+			syncHandler := &SyncHandler{History: st, Sessions: st}
+			handler := &TimelineHandler{Events: st}
+
+			post := func(history ...model.History) {
+				t.Helper()
+				body, _ := json.Marshal(model.HistoryPackage{History: history})
+				req, _ := http.NewRequest("POST", "/resource/history", bytes.NewBuffer(body))
+				ctx := context.WithValue(req.Context(), UserIDKey, userID)
+				req = req.WithContext(context.WithValue(ctx, SessionIDKey, session.ID))
+				rr := httptest.NewRecorder()
+				syncHandler.PostHistory(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("PostHistory failed: %d body=%s", rr.Code, rr.Body.String())
+				}
+			}
+			timeline := func(query string) (int, []model.ReadingEvent) {
+				t.Helper()
+				req, _ := http.NewRequest("GET", "/me/history/timeline?"+query, nil)
+				req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+				rr := httptest.NewRecorder()
+				handler.Timeline(rr, req)
+				var resp TimelineResponse
+				json.NewDecoder(rr.Body).Decode(&resp)
+				return rr.Code, resp.Events
+			}
+
+			day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC).UnixMilli()
+			manga := model.Manga{ID: 70, Title: "Timeline", URL: "/70", PublicURL: "/70", Source: "test", CoverURL: "/70.jpg"}
+			other := model.Manga{ID: 71, Title: "Other", URL: "/71", PublicURL: "/71", Source: "test", CoverURL: "/71.jpg"}
+			post(model.History{MangaID: 70, Manga: &manga, ChapterID: 1, Page: 1, CreatedAt: day, UpdatedAt: day})
+			post(model.History{MangaID: 70, ChapterID: 1, Page: 1, Percent: 0.5, CreatedAt: day, UpdatedAt: day + 1000}) // same position
+			post(model.History{MangaID: 70, ChapterID: 2, Page: 4, CreatedAt: day, UpdatedAt: day + 2000})
+			post(model.History{MangaID: 70, ChapterID: 3, Page: 0, CreatedAt: day, UpdatedAt: day - 1000}) // older than stored
+			post(model.History{MangaID: 71, Manga: &other, ChapterID: 9, Page: 2, CreatedAt: day, UpdatedAt: day + 86400000})
+
+			code, events := timeline("")
+			if code != http.StatusOK || len(events) != 3 {
+				t.Fatalf("expected three events, got %d %+v", code, events)
+			}
+			if events[0].MangaID != 71 || events[1].ChapterID != 2 || events[2].ChapterID != 1 {
+				t.Fatalf("expected events newest first, got %+v", events)
+			}
+			if events[1].Manga == nil || events[1].Manga.Title != "Timeline" || events[1].DeviceName == nil || *events[1].DeviceName != "Pixel" {
+				t.Fatalf("expected manga and device name, got %+v", events[1])
+			}
+
+			if _, events := timeline("from=2024-03-10&to=2024-03-10"); len(events) != 2 {
+				t.Fatalf("expected two events on the day, got %+v", events)
+			}
+			if _, events := timeline("from=2024-03-11T00:00:00Z"); len(events) != 1 || events[0].MangaID != 71 {
+				t.Fatalf("expected one event after the day, got %+v", events)
+			}
+			if _, events := timeline("manga_id=70&limit=1"); len(events) != 1 || events[0].ChapterID != 2 {
+				t.Fatalf("expected the latest event of manga 70, got %+v", events)
+			}
+			if code, _ := timeline("from=yesterday"); code != http.StatusBadRequest {
+				t.Fatalf("expected 400 for invalid from, got %d", code)
+			}
+		})
+	}
+}
@@ -2,18 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
-	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
 )
 
 type UserHandler struct {
-	DB *db.DB
+	Users    store.UserStore
+	Sessions store.SessionStore
 }
 
 type UserResponse struct {
-	ID    int64  `json:"id"`
-	Email string `json:"email"`
+	ID            int64  `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
@@ -24,17 +28,86 @@ func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.DB.GetUserByID(userID)
+	user, err := h.Users.GetUserByID(r.Context(), userID)
 	if err != nil {
 		JSONError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	resp := UserResponse{
-		ID:    user.ID,
-		Email: user.Email,
+		ID:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.VerifiedAt != nil,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// SessionResponse describes a device signed in to the account. Times are Unix seconds.
+type SessionResponse struct {
+	ID                   string `json:"id"`
+	DeviceName           string `json:"device_name"`
+	UserAgent            string `json:"user_agent"`
+	IPAddress            string `json:"ip_address"`
+	CreatedAt            int64  `json:"created_at"`
+	LastSeenAt           int64  `json:"last_seen_at"`
+	LastHistorySyncAt    *int64 `json:"last_history_sync_at"`
+	LastFavouritesSyncAt *int64 `json:"last_favourites_sync_at"`
+	LastBookmarksSyncAt  *int64 `json:"last_bookmarks_sync_at"`
+	Current              bool   `json:"current"`
+}
+
+func (h *UserHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	currentID, _ := GetSessionID(r)
+
+	sessions, err := h.Sessions.ListSessions(r.Context(), userID, time.Now().Unix())
+	if err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, SessionResponse{
+			ID:                   session.ID,
+			DeviceName:           session.DeviceName,
+			UserAgent:            session.UserAgent,
+			IPAddress:            session.IPAddress,
+			CreatedAt:            session.CreatedAt,
+			LastSeenAt:           session.LastSeenAt,
+			LastHistorySyncAt:    session.LastHistorySyncAt,
+			LastFavouritesSyncAt: session.LastFavouritesSyncAt,
+			LastBookmarksSyncAt:  session.LastBookmarksSyncAt,
+			Current:              session.ID == currentID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.Sessions.RevokeSession(r.Context(), userID, r.PathValue("id"), time.Now().Unix())
+	if errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
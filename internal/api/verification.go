@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// verificationTokenTTL is how long an email verification link stays valid.
+const verificationTokenTTL = 48 * time.Hour
+
+// verificationResendInterval limits how often POST /me/verify-email sends mail.
+const verificationResendInterval = time.Minute
+
+// VerificationPolicy restricts accounts that have not verified their email
+// address once GracePeriod has passed since registration. The zero value
+// restricts nothing.
+type VerificationPolicy struct {
+	GracePeriod time.Duration
+	// BlockSync rejects history and favourites sync for restricted accounts.
+	BlockSync bool
+	// BlockPasswordReset stops reset emails from being sent to restricted accounts.
+	BlockPasswordReset bool
+}
+
+// restricted reports whether the grace period of an unverified user is over.
+func (p VerificationPolicy) restricted(user *model.User, now time.Time) bool {
+	if user.VerifiedAt != nil {
+		return false
+	}
+	return now.Unix() >= user.CreatedAt+int64(p.GracePeriod/time.Second)
+}
+
+// sendVerificationEmail issues a new verification token for the user and
+// mails the link to confirm it, replacing any earlier token.
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, userID int64, email string) error {
+	token, hash, err := auth.GenerateResetToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(verificationTokenTTL).Unix()
+
+	if err := h.Users.SetVerificationToken(ctx, userID, hash, expiresAt); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/deeplink/verify-email?token=%s", h.BaseURL, token)
+
+	htmlBody, err := h.Templates.Render("mail/verify-email.html", map[string]string{"VerifyEmailLink": link})
+	if err != nil {
+		slog.ErrorContext(ctx, "Template render error", "error", err)
+	}
+
+	return h.Mailer.Send(email, "Verify your email", "Verification link: "+link, htmlBody)
+}
+
+// requestVerification sends the verification email for a new account. A
+// failure only delays verification, so it does not fail the registration.
+func (h *AuthHandler) requestVerification(r *http.Request, userID int64, email string) {
+	if err := h.sendVerificationEmail(r.Context(), userID, email); err != nil {
+		slog.ErrorContext(r.Context(), "Verification email error", "user_id", userID, "error", err)
+	}
+}
+
+// ResendVerification mails a new verification link to the current user.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		JSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.VerifiedAt != nil {
+		JSONError(w, "Email address is already verified", http.StatusConflict)
+		return
+	}
+
+	if user.VerificationTokenExpires != nil {
+		sentAt := time.Unix(*user.VerificationTokenExpires, 0).Add(-verificationTokenTTL)
+		if time.Since(sentAt) < verificationResendInterval {
+			JSONError(w, "A verification email was sent recently", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if err := h.sendVerificationEmail(r.Context(), user.ID, user.Email); err != nil {
+		slog.ErrorContext(r.Context(), "Verification email error", "user_id", user.ID, "error", err)
+		JSONError(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("A verification email was sent")
+}
+
+// VerifyEmailDeeplink serves the verification link. GET asks for
+// confirmation, so that mail scanners following the link change nothing, and
+// the POST of its form marks the address as verified.
+func (h *AuthHandler) VerifyEmailDeeplink(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if token == "" {
+		JSONError(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+
+	valid := false
+	now := time.Now().Unix()
+	user, err := h.Users.GetUserByVerificationToken(r.Context(), auth.HashToken(token))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	default:
+		valid = user.VerificationTokenExpires != nil && *user.VerificationTokenExpires >= now
+	}
+
+	verified := false
+	if valid && r.Method == http.MethodPost {
+		if err := h.Users.MarkEmailVerified(r.Context(), user.ID, now); err != nil {
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		verified = true
+	}
+
+	html, err := h.Templates.Render("pages/verify-email.html", map[string]any{
+		"Token":    token,
+		"Confirm":  valid && !verified,
+		"Verified": verified,
+	})
+	if err != nil {
+		JSONError(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write([]byte(html))
+}
+
+// RequireVerifiedEmail rejects sync requests from accounts restricted by the
+// verification policy. It must be wrapped by AuthMiddleware.
+func (m *Middleware) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.Verification.BlockSync {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, ok := GetUserID(r)
+		if !ok {
+			JSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		user, err := m.Users.GetUserByID(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "RequireVerifiedEmail: DB error loading user", "user_id", userID, "error", err)
+			JSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if m.Verification.restricted(user, time.Now()) {
+			JSONError(w, "Email address is not verified", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
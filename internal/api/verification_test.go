@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+func TestEmailVerification(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	st := sqlstore.New(database)
+	policy := VerificationPolicy{BlockSync: true, BlockPasswordReset: true}
+	authHandler := newTestAuthHandler(st)
+	authHandler.Verification = policy
+	mailer := authHandler.Mailer.(*testutil.MockMailSender)
+	userHandler := &UserHandler{Users: st, Sessions: st}
+	syncHandler := &SyncHandler{History: st, Library: st, Bookmarks: st, Sessions: st}
+	middleware := &Middleware{Users: st, Sessions: st, Verification: policy}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /forgot-password", authHandler.ForgotPassword)
+	mux.HandleFunc("GET /deeplink/verify-email", authHandler.VerifyEmailDeeplink)
+	mux.HandleFunc("POST /deeplink/verify-email", authHandler.VerifyEmailDeeplink)
+	mux.Handle("GET /me", middleware.AuthMiddleware(http.HandlerFunc(userHandler.GetMe)))
+	mux.Handle("POST /me/verify-email", middleware.AuthMiddleware(http.HandlerFunc(authHandler.ResendVerification)))
+	mux.Handle("GET /resource/history", middleware.AuthMiddleware(middleware.RequireVerifiedEmail(http.HandlerFunc(syncHandler.GetHistory))))
+
+	do := func(method, target, token string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, target, bytes.NewBuffer(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	emailVerified := func(token string) bool {
+		var me UserResponse
+		if err := json.NewDecoder(do("GET", "/me", token, nil).Body).Decode(&me); err != nil {
+			t.Fatalf("decode /me failed: %v", err)
+		}
+		return me.EmailVerified
+	}
+
+	// Auto-registration mails a verification link.
+	login := loginForTokens(t, authHandler, "verify@example.com", "password")
+	if len(mailer.SentEmails) != 1 || mailer.SentEmails[0].To != "verify@example.com" {
+		t.Fatalf("expected a verification email, got %+v", mailer.SentEmails)
+	}
+	_, link, _ := strings.Cut(mailer.SentEmails[0].TextBody, "Verification link: ")
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Path != "/deeplink/verify-email" || parsed.Query().Get("token") == "" {
+		t.Fatalf("unexpected verification link %q", link)
+	}
+	if emailVerified(login.Token) {
+		t.Fatal("expected new account to be unverified")
+	}
+
+	// Without a grace period the unverified account is restricted right away.
+	if rr := do("GET", "/resource/history", login.Token, nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for unverified sync, got %d", rr.Code)
+	}
+	body, _ := json.Marshal(map[string]string{"email": "verify@example.com"})
+	if rr := do("POST", "/forgot-password", "", body); rr.Code != http.StatusOK {
+		t.Fatalf("expected forgot-password to answer 200, got %d", rr.Code)
+	}
+	if len(mailer.SentEmails) != 1 {
+		t.Fatalf("expected no reset email for unverified account, got %d emails", len(mailer.SentEmails))
+	}
+
+	// Resending right after sign-up is throttled.
+	if rr := do("POST", "/me/verify-email", login.Token, nil); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for immediate resend, got %d", rr.Code)
+	}
+
+	if rr := do("GET", "/deeplink/verify-email?token=bogus", "", nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown token, got %d", rr.Code)
+	}
+	// Opening the link only asks for confirmation.
+	if rr := do("GET", link[len("http://test.local"):], "", nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `method="post"`) {
+		t.Fatalf("expected a confirmation form, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if emailVerified(login.Token) {
+		t.Fatal("expected opening the link not to verify the account")
+	}
+	verify := func() int {
+		form := url.Values{"token": {parsed.Query().Get("token")}}
+		req, _ := http.NewRequest("POST", "/deeplink/verify-email", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := verify(); code != http.StatusOK {
+		t.Fatalf("expected verification to succeed, got %d", code)
+	}
+	if !emailVerified(login.Token) {
+		t.Fatal("expected account to be verified")
+	}
+
+	// The token is single use.
+	if code := verify(); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for reused token, got %d", code)
+	}
+	if rr := do("GET", link[len("http://test.local"):], "", nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for reused token, got %d", rr.Code)
+	}
+
+	if rr := do("GET", "/resource/history", login.Token, nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected sync after verification, got %d", rr.Code)
+	}
+	if rr := do("POST", "/me/verify-email", login.Token, nil); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for resend after verification, got %d", rr.Code)
+	}
+	if rr := do("POST", "/forgot-password", "", body); rr.Code != http.StatusOK || len(mailer.SentEmails) != 2 {
+		t.Fatalf("expected reset email after verification, got %d emails", len(mailer.SentEmails))
+	}
+}
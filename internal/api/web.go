@@ -0,0 +1,633 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/metrics"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/templates"
+)
+
+const (
+	// webSessionCookie holds "<session id>.<secret>" of a dashboard session.
+	webSessionCookie = "kotatsu_session"
+	// webLoginCookie holds the secret the login form's CSRF token is derived
+	// from, before there is a session.
+	webLoginCookie = "kotatsu_login"
+
+	webLayout        = "web/layout.html"
+	webDeviceName    = "Web browser"
+	webLibraryLimit  = 48
+	webTimelineLimit = 100
+	// maxWebFormSize bounds the body of the dashboard forms.
+	maxWebFormSize = 64 << 10
+)
+
+const (
+	webUserKey   contextKey = "webUser"
+	csrfTokenKey contextKey = "csrfToken"
+)
+
+// WebHandler serves the dashboard under /web/, where users can browse their
+// library from a browser. It is signed in to with a session cookie instead of
+// bearer tokens; its sessions are listed and revoked like the app's.
+type WebHandler struct {
+	Users    store.UserStore
+	Sessions store.SessionStore
+	Library  store.LibraryStore
+	Events   store.ReadingLogStore
+	// Auth is told about password changes made from the dashboard.
+	Auth      *AuthHandler
+	Templates *templates.Manager
+	// SecureCookies marks the cookies Secure. Enable it when the server is
+	// reached over HTTPS.
+	SecureCookies bool
+}
+
+// webPage is the data every dashboard page is rendered with.
+type webPage struct {
+	Title     string
+	Section   string
+	Email     string
+	CSRFToken string
+	Notice    string
+	Error     string
+}
+
+type webLibraryPage struct {
+	webPage
+	Query      string
+	CategoryID int64
+	Categories []model.Category
+	Entries    []webLibraryEntry
+	NextURL    string
+}
+
+type webLibraryEntry struct {
+	Manga      *model.Manga
+	Categories []string
+	Pinned     bool
+}
+
+type webHistoryPage struct {
+	webPage
+	Days    []webHistoryDay
+	NextURL string
+}
+
+type webHistoryDay struct {
+	Date   string
+	Events []webHistoryEvent
+}
+
+type webHistoryEvent struct {
+	model.ReadingEvent
+	Time    string
+	Percent int
+}
+
+type webAccountPage struct {
+	webPage
+	User     *model.User
+	Created  string
+	Sessions []webSession
+}
+
+type webSession struct {
+	model.Session
+	LastSeen string
+	Current  bool
+}
+
+// webNotices are the messages shown after a redirect, keyed by the notice
+// query parameter.
+var webNotices = map[string]string{
+	"password": "Your password has been changed. Other devices have been signed out.",
+	"session":  "The session has been signed out.",
+}
+
+// LoginPage shows the sign-in form, or the library to a signed-in user.
+func (h *WebHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	session, _, err := h.session(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching web session", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if session != nil {
+		http.Redirect(w, r, "/web/", http.StatusSeeOther)
+		return
+	}
+	h.renderLogin(w, r, http.StatusOK, "")
+}
+
+// Login checks the credentials of the sign-in form and opens a session.
+// Unlike POST /auth, unknown emails are not registered.
+func (h *WebHandler) Login(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(webLoginCookie)
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebFormSize)
+	if err != nil || !validCSRF(r, cookie.Value) {
+		http.Error(w, "Invalid or expired form, reload the page", http.StatusForbidden)
+		return
+	}
+
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	user, err := h.Users.GetUserByEmail(r.Context(), email)
+	if errors.Is(err, store.ErrNotFound) {
+		metrics.Logins.Inc("web", "failure")
+		h.renderLogin(w, r, http.StatusUnauthorized, "Invalid email or password")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching user for web login", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	match, err := auth.VerifyPassword(r.PostFormValue("password"), user.PasswordHash)
+	if err != nil {
+		http.Error(w, "Error verifying password", http.StatusInternalServerError)
+		return
+	}
+	if !match {
+		metrics.Logins.Inc("web", "failure")
+		h.renderLogin(w, r, http.StatusUnauthorized, "Invalid email or password")
+		return
+	}
+	if user.DeletionScheduledAt != nil {
+		metrics.Logins.Inc("web", "failure")
+		h.renderLogin(w, r, http.StatusForbidden, "This account is scheduled for deletion")
+		return
+	}
+	if user.DisabledAt != nil {
+		metrics.Logins.Inc("web", "failure")
+		h.renderLogin(w, r, http.StatusForbidden, "This account is disabled")
+		return
+	}
+
+	sessionID, err := auth.GenerateSessionID()
+	if err != nil {
+		http.Error(w, "Failed to generate session", http.StatusInternalServerError)
+		return
+	}
+	secret, _, err := auth.GenerateRefreshToken()
+	if err != nil {
+		http.Error(w, "Failed to generate session", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(auth.RefreshTokenTTL)
+	session := &model.Session{
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: webSessionHash(secret),
+		CreatedAt:        now.Unix(),
+		RefreshedAt:      now.Unix(),
+		ExpiresAt:        expiresAt.Unix(),
+		DeviceName:       webDeviceName,
+		UserAgent:        truncate(r.UserAgent(), maxUserAgentLength),
+		IPAddress:        clientIP(r),
+		LastSeenAt:       now.Unix(),
+	}
+	if err := h.Sessions.CreateSession(r.Context(), session); err != nil {
+		slog.ErrorContext(r.Context(), "Error creating web session", "user_id", user.ID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	metrics.Logins.Inc("web", "success")
+	h.setCookie(w, webSessionCookie, sessionID+"."+secret, expiresAt)
+	h.setCookie(w, webLoginCookie, "", time.Time{})
+	http.Redirect(w, r, "/web/", http.StatusSeeOther)
+}
+
+// Logout revokes the dashboard session.
+func (h *WebHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	userID, _ := GetUserID(r)
+	sessionID, _ := GetSessionID(r)
+	if err := h.Sessions.RevokeSession(r.Context(), userID, sessionID, time.Now().Unix()); err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Error revoking web session", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.setCookie(w, webSessionCookie, "", time.Time{})
+	http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+}
+
+// RequireSession lets requests with a valid session cookie through, with the
+// user and session in the context like AuthMiddleware, and sends the others
+// to the sign-in page. Forms posted to the wrapped handlers have to carry the
+// session's CSRF token.
+func (h *WebHandler) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, secret, err := h.session(r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching web session", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		var user *model.User
+		if session != nil {
+			user, err = h.Users.GetUserByID(r.Context(), session.UserID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				slog.ErrorContext(r.Context(), "Error fetching user for web session", "user_id", session.UserID, "error", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if user == nil || user.DisabledAt != nil {
+			h.setCookie(w, webSessionCookie, "", time.Time{})
+			http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			r.Body = http.MaxBytesReader(w, r.Body, maxWebFormSize)
+			if !validCSRF(r, secret) {
+				http.Error(w, "Invalid or expired form, reload the page", http.StatusForbidden)
+				return
+			}
+		}
+
+		if now := time.Now().Unix(); now-session.LastSeenAt >= sessionTouchInterval {
+			if err := h.Sessions.TouchSession(r.Context(), session.ID, now, clientIP(r), truncate(r.UserAgent(), maxUserAgentLength)); err != nil {
+				slog.WarnContext(r.Context(), "RequireSession: failed to update session", "session_id", session.ID, "error", err)
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), UserIDKey, user.ID)
+		ctx = context.WithValue(ctx, SessionIDKey, session.ID)
+		ctx = context.WithValue(ctx, webUserKey, user)
+		ctx = context.WithValue(ctx, csrfTokenKey, csrfToken(secret))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// LibraryPage shows the user's favourite manga as a grid of covers, optionally
+// narrowed to a category or a search.
+func (h *WebHandler) LibraryPage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	page := webLibraryPage{
+		webPage: h.page(r, "Library", "library"),
+		Query:   strings.TrimSpace(params.Get("q")),
+	}
+	query := store.LibraryQuery{
+		Terms: searchTerms(page.Query),
+		Sort:  store.LibraryNewest,
+		Limit: webLibraryLimit + 1,
+	}
+	if value := params.Get("category"); value != "" {
+		categoryID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid category", http.StatusBadRequest)
+			return
+		}
+		page.CategoryID, query.CategoryID = categoryID, categoryID
+	}
+	if value := params.Get("cursor"); value != "" {
+		if query.After, ok = decodeLibraryCursor(value, query.Sort); !ok {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var err error
+	page.Categories, err = h.Library.ListCategories(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching categories", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	entries, err := h.Library.SearchLibrary(r.Context(), userID, query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error searching library", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(entries) > webLibraryLimit {
+		entries = entries[:webLibraryLimit]
+		next := url.Values{"cursor": {encodeLibraryCursor(query.Sort, entries[webLibraryLimit-1])}}
+		if page.Query != "" {
+			next.Set("q", page.Query)
+		}
+		if page.CategoryID != 0 {
+			next.Set("category", strconv.FormatInt(page.CategoryID, 10))
+		}
+		page.NextURL = "/web/?" + next.Encode()
+	}
+	titles := make(map[int64]string, len(page.Categories))
+	for _, category := range page.Categories {
+		titles[category.ID] = category.Title
+	}
+	for _, entry := range entries {
+		item := webLibraryEntry{Manga: entry.Manga, Pinned: entry.Pinned}
+		for _, id := range entry.Categories {
+			if title, ok := titles[id]; ok {
+				item.Categories = append(item.Categories, title)
+			}
+		}
+		page.Entries = append(page.Entries, item)
+	}
+
+	h.render(w, r, http.StatusOK, "web/library.html", page)
+}
+
+// HistoryPage shows the reading log as a timeline grouped by day (UTC). The
+// before parameter, in Unix milliseconds, continues an earlier page.
+func (h *WebHandler) HistoryPage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter := store.ReadingEventFilter{Limit: webTimelineLimit}
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before <= 0 {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+		filter.To = before
+	}
+	events, err := h.Events.ListReadingEvents(r.Context(), userID, filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching reading events", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	page := webHistoryPage{webPage: h.page(r, "History", "history")}
+	for _, event := range events {
+		readAt := time.UnixMilli(event.ReadAt).UTC()
+		date := readAt.Format("Monday, 2 January 2006")
+		if len(page.Days) == 0 || page.Days[len(page.Days)-1].Date != date {
+			page.Days = append(page.Days, webHistoryDay{Date: date})
+		}
+		day := &page.Days[len(page.Days)-1]
+		day.Events = append(day.Events, webHistoryEvent{
+			ReadingEvent: event,
+			Time:         readAt.Format("15:04"),
+			Percent:      int(event.Percent * 100),
+		})
+	}
+	if len(events) == webTimelineLimit {
+		page.NextURL = "/web/history?before=" + strconv.FormatInt(events[len(events)-1].ReadAt, 10)
+	}
+
+	h.render(w, r, http.StatusOK, "web/history.html", page)
+}
+
+// AccountPage shows the account details, the signed-in devices and the password
+// form.
+func (h *WebHandler) AccountPage(w http.ResponseWriter, r *http.Request) {
+	h.renderAccount(w, r, http.StatusOK, "")
+}
+
+// ChangePassword handles the password form of the account page. Like
+// POST /me/password, it signs out every other device.
+func (h *WebHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := GetSessionID(r)
+	user := r.Context().Value(webUserKey).(*model.User)
+
+	match, err := auth.VerifyPassword(r.PostFormValue("current_password"), user.PasswordHash)
+	if err != nil {
+		http.Error(w, "Error verifying password", http.StatusInternalServerError)
+		return
+	}
+	newPassword := r.PostFormValue("new_password")
+	switch {
+	case !match:
+		h.renderAccount(w, r, http.StatusForbidden, "Current password is incorrect")
+		return
+	case !validPasswordLength(newPassword):
+		h.renderAccount(w, r, http.StatusBadRequest, "Password should be from 2 to 24 characters long")
+		return
+	case newPassword != r.PostFormValue("confirm_password"):
+		h.renderAccount(w, r, http.StatusBadRequest, "The new passwords do not match")
+		return
+	}
+
+	newHash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Users.UpdatePassword(r.Context(), userID, newHash); err != nil {
+		slog.ErrorContext(r.Context(), "Error updating password", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.Users.ClearResetToken(r.Context(), userID)
+	if err := h.Sessions.RevokeOtherSessions(r.Context(), userID, sessionID, time.Now().Unix()); err != nil {
+		slog.ErrorContext(r.Context(), "Error revoking sessions", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if h.Auth != nil {
+		h.Auth.passwordChanged(r, userID, false)
+	}
+
+	http.Redirect(w, r, "/web/account?notice=password", http.StatusSeeOther)
+}
+
+// RevokeSession signs out one of the devices listed on the account page.
+func (h *WebHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.Sessions.RevokeSession(r.Context(), userID, r.PathValue("id"), time.Now().Unix())
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error revoking session", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/web/account?notice=session", http.StatusSeeOther)
+}
+
+func (h *WebHandler) renderAccount(w http.ResponseWriter, r *http.Request, status int, message string) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	currentID, _ := GetSessionID(r)
+
+	sessions, err := h.Sessions.ListSessions(r.Context(), userID, time.Now().Unix())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching sessions", "user_id", userID, "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	user := r.Context().Value(webUserKey).(*model.User)
+	page := webAccountPage{
+		webPage: h.page(r, "Account", "account"),
+		User:    user,
+		Created: formatWebTime(user.CreatedAt),
+	}
+	page.Error = message
+	for _, session := range sessions {
+		page.Sessions = append(page.Sessions, webSession{
+			Session:  session,
+			LastSeen: formatWebTime(session.LastSeenAt),
+			Current:  session.ID == currentID,
+		})
+	}
+
+	h.render(w, r, status, "web/account.html", page)
+}
+
+// renderLogin shows the sign-in form, issuing the cookie its CSRF token is
+// derived from when the browser has none.
+func (h *WebHandler) renderLogin(w http.ResponseWriter, r *http.Request, status int, message string) {
+	var secret string
+	if cookie, err := r.Cookie(webLoginCookie); err == nil && cookie.Value != "" {
+		secret = cookie.Value
+	} else {
+		var err error
+		if secret, err = auth.GenerateSessionID(); err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		h.setCookie(w, webLoginCookie, secret, time.Now().Add(24*time.Hour))
+	}
+
+	h.render(w, r, status, "web/login.html", webPage{
+		Title:     "Sign in",
+		Section:   "login",
+		CSRFToken: csrfToken(secret),
+		Error:     message,
+	})
+}
+
+// page returns the data shared by the pages of a signed-in user.
+func (h *WebHandler) page(r *http.Request, title, section string) webPage {
+	page := webPage{
+		Title:     title,
+		Section:   section,
+		Notice:    webNotices[r.URL.Query().Get("notice")],
+		CSRFToken: r.Context().Value(csrfTokenKey).(string),
+	}
+	if user, ok := r.Context().Value(webUserKey).(*model.User); ok {
+		page.Email = user.Email
+	}
+	return page
+}
+
+func (h *WebHandler) render(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	html, err := h.Templates.RenderPage(webLayout, name, data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Template render error", "template", name, "error", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	// Covers are hot-linked from the manga sources, which may refuse
+	// requests with a foreign referrer.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src http: https: data:; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write([]byte(html))
+}
+
+// session returns the active session of the request's cookie and its secret,
+// or a nil session.
+func (h *WebHandler) session(r *http.Request) (*model.Session, string, error) {
+	cookie, err := r.Cookie(webSessionCookie)
+	if err != nil {
+		return nil, "", nil
+	}
+	id, secret, ok := strings.Cut(cookie.Value, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, "", nil
+	}
+	session, err := h.Sessions.GetSession(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(webSessionHash(secret))) != 1 ||
+		session.RevokedAt != nil || session.ExpiresAt <= time.Now().Unix() {
+		return nil, "", nil
+	}
+	return session, secret, nil
+}
+
+// setCookie sets a cookie scoped to the dashboard; an empty value deletes it.
+func (h *WebHandler) setCookie(w http.ResponseWriter, name, value string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/web",
+		Expires:  expires,
+		Secure:   h.SecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// webSessionHash is stored as the refresh token hash of a dashboard session.
+// The prefix keeps the cookie secret from being usable with /auth/refresh.
+func webSessionHash(secret string) string {
+	return auth.HashToken("web:" + secret)
+}
+
+// csrfToken derives the token forms have to send back from the secret of a
+// cookie, which other sites can neither read nor predict.
+func csrfToken(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validCSRF(r *http.Request, secret string) bool {
+	token := r.PostFormValue("csrf_token")
+	return secret != "" && token != "" && hmac.Equal([]byte(token), []byte(csrfToken(secret)))
+}
+
+// formatWebTime formats Unix seconds for the dashboard.
+func formatWebTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format("2 Jan 2006 15:04 UTC")
+}
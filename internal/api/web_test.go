@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+)
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`)
+
+func TestWebDashboard(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	for name, st := range map[string]interface {
+		testStore
+		store.HistoryStore
+		store.LibraryStore
+		store.ReadingLogStore
+	}{
+		"sqlstore": sqlstore.New(database),
+		"memstore": memstore.New(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			authHandler := newTestAuthHandler(st)
+			webHandler := &WebHandler{Users: st, Sessions: st, Library: st, Events: st, Auth: authHandler, Templates: authHandler.Templates}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /auth/refresh", authHandler.Refresh)
+			mux.HandleFunc("GET /web/login", webHandler.LoginPage)
+			mux.HandleFunc("POST /web/login", webHandler.Login)
+			mux.Handle("POST /web/logout", webHandler.RequireSession(http.HandlerFunc(webHandler.Logout)))
+			mux.Handle("GET /web/{$}", webHandler.RequireSession(http.HandlerFunc(webHandler.LibraryPage)))
+			mux.Handle("GET /web/history", webHandler.RequireSession(http.HandlerFunc(webHandler.HistoryPage)))
+			mux.Handle("GET /web/account", webHandler.RequireSession(http.HandlerFunc(webHandler.AccountPage)))
+			mux.Handle("POST /web/account/password", webHandler.RequireSession(http.HandlerFunc(webHandler.ChangePassword)))
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{Jar: jar}
+			get := func(path string) (*http.Response, string) {
+				t.Helper()
+				resp, err := client.Get(server.URL + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				return resp, string(body)
+			}
+			post := func(path string, form url.Values) (*http.Response, string) {
+				t.Helper()
+				resp, err := client.PostForm(server.URL+path, form)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				return resp, string(body)
+			}
+			csrf := func(body string) string {
+				t.Helper()
+				match := csrfFieldPattern.FindStringSubmatch(body)
+				if match == nil {
+					t.Fatalf("no CSRF token in %s", body)
+				}
+				return match[1]
+			}
+
+			app := loginForTokens(t, authHandler, "web@example.com", "password")
+			user, _ := st.GetUserByEmail(ctx, "web@example.com")
+			_, err := st.SyncFavourites(ctx, user.ID,
+				[]model.Category{{ID: 1, Title: "Reading", Order: "NEWEST"}},
+				[]model.Favourite{{MangaID: 10, Manga: &model.Manga{ID: 10, Title: "Dashboard Manga", CoverURL: "https://covers.example/10.jpg", Source: "test"}, CategoryID: 1, CreatedAt: 100}},
+				nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = st.SyncHistory(ctx, user.ID, "", []model.History{{MangaID: 10, Manga: &model.Manga{ID: 10, Title: "Dashboard Manga", CoverURL: "https://covers.example/10.jpg", Source: "test"}, ChapterID: 1, Page: 7, Percent: 0.5, UpdatedAt: 1_700_000_000_000}}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, body := get("/web/")
+			if resp.Request.URL.Path != "/web/login" || resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected the sign-in page, got %d at %s", resp.StatusCode, resp.Request.URL.Path)
+			}
+			loginToken := csrf(body)
+
+			if resp, _ := post("/web/login", url.Values{"email": {"web@example.com"}, "password": {"password"}}); resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("expected 403 without a CSRF token, got %d", resp.StatusCode)
+			}
+			if resp, _ := post("/web/login", url.Values{"csrf_token": {loginToken}, "email": {"web@example.com"}, "password": {"wrong"}}); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("expected 401 for a wrong password, got %d", resp.StatusCode)
+			}
+			if resp, _ := post("/web/login", url.Values{"csrf_token": {loginToken}, "email": {"unknown@example.com"}, "password": {"password"}}); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("expected 401 for an unknown email, got %d", resp.StatusCode)
+			}
+			if _, err := st.GetUserByEmail(ctx, "unknown@example.com"); err == nil {
+				t.Fatal("expected the dashboard not to register unknown emails")
+			}
+
+			resp, body = post("/web/login", url.Values{"csrf_token": {loginToken}, "email": {"web@example.com"}, "password": {"password"}})
+			if resp.Request.URL.Path != "/web/" || resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected the library after signing in, got %d at %s", resp.StatusCode, resp.Request.URL.Path)
+			}
+			for _, want := range []string{"Dashboard Manga", `src="https://covers.example/10.jpg"`, "Reading"} {
+				if !strings.Contains(body, want) {
+					t.Fatalf("expected %q in the library page", want)
+				}
+			}
+			if _, body := get("/web/?q=nothing"); strings.Contains(body, "Dashboard Manga") {
+				t.Fatal("expected the search to filter the library")
+			}
+
+			if _, body := get("/web/history"); !strings.Contains(body, "Dashboard Manga") || !strings.Contains(body, "Page 7 · 50%") {
+				t.Fatalf("unexpected history page: %s", body)
+			}
+
+			// The cookie only works with the dashboard.
+			var secret string
+			for _, cookie := range jar.Cookies(&url.URL{Scheme: "http", Host: strings.TrimPrefix(server.URL, "http://"), Path: "/web/"}) {
+				if cookie.Name == webSessionCookie {
+					_, secret, _ = strings.Cut(cookie.Value, ".")
+				}
+			}
+			refresh, _ := json.Marshal(map[string]string{"refresh_token": secret})
+			if resp, _ := client.Post(server.URL+"/auth/refresh", "application/json", bytes.NewReader(refresh)); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("expected the cookie secret to be refused as a refresh token, got %d", resp.StatusCode)
+			}
+
+			_, body = get("/web/account")
+			if !strings.Contains(body, "web@example.com") || !strings.Contains(body, "Web browser") {
+				t.Fatalf("unexpected account page: %s", body)
+			}
+			sessionToken := csrf(body)
+			if sessionToken == loginToken {
+				t.Fatal("expected the session to have its own CSRF token")
+			}
+			form := url.Values{"current_password": {"password"}, "new_password": {"changed"}, "confirm_password": {"changed"}}
+			if resp, _ := post("/web/account/password", form); resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("expected 403 without a CSRF token, got %d", resp.StatusCode)
+			}
+			form.Set("csrf_token", loginToken)
+			if resp, _ := post("/web/account/password", form); resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("expected 403 with the login CSRF token, got %d", resp.StatusCode)
+			}
+			form.Set("csrf_token", sessionToken)
+			form.Set("current_password", "wrong")
+			if resp, _ := post("/web/account/password", form); resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("expected 403 for a wrong current password, got %d", resp.StatusCode)
+			}
+			form.Set("current_password", "password")
+			resp, body = post("/web/account/password", form)
+			if resp.Request.URL.Path != "/web/account" || !strings.Contains(body, "Your password has been changed") {
+				t.Fatalf("unexpected response to the password change: %d %s", resp.StatusCode, body)
+			}
+			if resp, _ := client.Post(server.URL+"/auth/refresh", "application/json", strings.NewReader(`{"refresh_token":"`+app.RefreshToken+`"}`)); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("expected the app session to be revoked, got %d", resp.StatusCode)
+			}
+
+			if resp, _ := post("/web/logout", url.Values{"csrf_token": {sessionToken}}); resp.Request.URL.Path != "/web/login" {
+				t.Fatalf("expected the sign-in page after signing out, got %s", resp.Request.URL.Path)
+			}
+			if resp, _ := get("/web/account"); resp.Request.URL.Path != "/web/login" {
+				t.Fatalf("expected the session to be revoked, got %s", resp.Request.URL.Path)
+			}
+		})
+	}
+}
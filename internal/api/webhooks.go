@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/webhooks"
+)
+
+const (
+	maxWebhooksPerUser     = 10
+	maxWebhookURLLength    = 2048
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+type WebhookHandler struct {
+	Webhooks store.WebhookStore
+}
+
+type webhookRequest struct {
+	URL     *string   `json:"url"`
+	Events  *[]string `json:"events"`
+	Enabled *bool     `json:"enabled"`
+}
+
+// CreatedWebhook is the response of POST /me/webhooks, the only one that
+// reveals the signing secret.
+type CreatedWebhook struct {
+	model.Webhook
+	Secret string `json:"secret"`
+}
+
+// Create registers a webhook; it is enabled unless the request says otherwise.
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == nil || req.Events == nil {
+		JSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	webhook := model.Webhook{UserID: userID, Enabled: true, CreatedAt: time.Now().Unix()}
+	if !applyWebhookRequest(w, &webhook, req) {
+		return
+	}
+
+	existing, err := h.Webhooks.ListWebhooks(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing webhooks", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		JSONError(w, "Too many webhooks", http.StatusConflict)
+		return
+	}
+
+	if webhook.ID, err = auth.GenerateSessionID(); err != nil {
+		JSONError(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if webhook.Secret, err = auth.GenerateWebhookSecret(); err != nil {
+		JSONError(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Webhooks.CreateWebhook(r.Context(), &webhook); err != nil {
+		slog.ErrorContext(r.Context(), "Error creating webhook", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreatedWebhook{Webhook: webhook, Secret: webhook.Secret})
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	list, err := h.Webhooks.ListWebhooks(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing webhooks", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// Update changes the URL, events or enabled flag of a webhook; omitted fields
+// are kept.
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		JSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !applyWebhookRequest(w, webhook, req) {
+		return
+	}
+	if err := h.Webhooks.UpdateWebhook(r.Context(), webhook); err != nil {
+		slog.ErrorContext(r.Context(), "Error updating webhook", "user_id", webhook.UserID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.Webhooks.DeleteWebhook(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting webhook", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries lists the latest deliveries of a webhook, newest first, with the
+// outcome of their last attempt. The limit parameter defaults to 50.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			JSONError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxDeliveriesLimit)
+	}
+
+	deliveries, err := h.Webhooks.ListWebhookDeliveries(r.Context(), webhook.ID, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing webhook deliveries", "user_id", webhook.UserID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// webhook loads the caller's webhook named by the id path value, writing the
+// error response when there is none.
+func (h *WebhookHandler) webhook(w http.ResponseWriter, r *http.Request) (*model.Webhook, bool) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		JSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	webhook, err := h.Webhooks.GetWebhook(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		JSONError(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching webhook", "user_id", userID, "error", err)
+		JSONError(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return webhook, true
+}
+
+// applyWebhookRequest validates the fields set in req and copies them to
+// webhook, writing the error response when one is invalid.
+func applyWebhookRequest(w http.ResponseWriter, webhook *model.Webhook, req webhookRequest) bool {
+	if req.URL != nil {
+		u, err := url.Parse(*req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(*req.URL) > maxWebhookURLLength {
+			JSONError(w, "Invalid url", http.StatusBadRequest)
+			return false
+		}
+		webhook.URL = *req.URL
+	}
+	if req.Events != nil {
+		events := []string{}
+		for _, event := range *req.Events {
+			if !slices.Contains(webhooks.Events, event) {
+				JSONError(w, "Unknown event "+strconv.Quote(event), http.StatusBadRequest)
+				return false
+			}
+			if !slices.Contains(events, event) {
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			JSONError(w, "At least one event is required", http.StatusBadRequest)
+			return false
+		}
+		webhook.Events = events
+	}
+	if req.Enabled != nil {
+		webhook.Enabled = *req.Enabled
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/memstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store/sqlstore"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/testutil"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/webhooks"
+)
+
+func TestWebhooks(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	defer database.Close()
+
+	for name, st := range map[string]interface {
+		store.UserStore
+		store.SessionStore
+		store.HistoryStore
+		store.LibraryStore
+		store.BookmarkStore
+		store.WebhookStore
+	}{
+		"sqlstore": sqlstore.New(database),
+		"memstore": memstore.New(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			userID, err := st.CreateUser(ctx, "webhooks@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			var received []*http.Request
+			var bodies [][]byte
+			fail := true
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				body, _ := io.ReadAll(r.Body)
+				received = append(received, r)
+				bodies = append(bodies, body)
+				if fail {
+					http.Error(w, "try again", http.StatusServiceUnavailable)
+				}
+			}))
+			defer receiver.Close()
+
+			dispatcher := &webhooks.Dispatcher{Store: st, Client: receiver.Client()}
+			handler := &WebhookHandler{Webhooks: st}
+			syncHandler := &SyncHandler{History: st, Library: st, Bookmarks: st, Sessions: st, Webhooks: dispatcher}
+
+			call := func(method, path, id string, body any, fn http.HandlerFunc) *httptest.ResponseRecorder {
+				t.Helper()
+				data, _ := json.Marshal(body)
+				req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+				req.SetPathValue("id", id)
+				req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+				rr := httptest.NewRecorder()
+				fn(rr, req)
+				return rr
+			}
+
+			for _, body := range []map[string]any{
+				{"url": "ftp://example.com", "events": []string{webhooks.EventHistoryUpdated}},
+				{"url": receiver.URL, "events": []string{"manga.read"}},
+				{"url": receiver.URL, "events": []string{}},
+			} {
+				if rr := call("POST", "/me/webhooks", "", body, handler.Create); rr.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400 for %v, got %d", body, rr.Code)
+				}
+			}
+
+			rr := call("POST", "/me/webhooks", "", map[string]any{
+				"url":    receiver.URL,
+				"events": []string{webhooks.EventHistoryUpdated, webhooks.EventFavouriteAdded, webhooks.EventCategoryCreated},
+			}, handler.Create)
+			var created CreatedWebhook
+			json.NewDecoder(rr.Body).Decode(&created)
+			if rr.Code != http.StatusCreated || created.ID == "" || len(created.Secret) != 64 || !created.Enabled {
+				t.Fatalf("unexpected create response %d %+v", rr.Code, created)
+			}
+
+			rr = call("GET", "/me/webhooks", "", nil, handler.List)
+			if strings.Contains(rr.Body.String(), created.Secret) {
+				t.Fatal("the secret must only be returned on creation")
+			}
+
+			manga := &model.Manga{ID: 70, Title: "Hooked", Source: "test"}
+			history, _ := json.Marshal(model.HistoryPackage{History: []model.History{{MangaID: 70, Manga: manga, ChapterID: 1, UpdatedAt: 100}}})
+			req, _ := http.NewRequest("POST", "/resource/history", bytes.NewReader(history))
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+			syncHandler.PostHistory(httptest.NewRecorder(), req)
+
+			favourites, _ := json.Marshal(model.FavouritesPackage{
+				Categories: []model.Category{{ID: 7, Title: "Hooked", Order: "NEWEST", CreatedAt: 10}},
+				Favourites: []model.Favourite{{MangaID: 70, Manga: manga, CategoryID: 7, CreatedAt: 20}},
+			})
+			req, _ = http.NewRequest("POST", "/resource/favourites", bytes.NewReader(favourites))
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+			syncHandler.PostFavourites(httptest.NewRecorder(), req)
+
+			// The first attempts are rejected and scheduled for a retry.
+			if attempted, err := dispatcher.DeliverDue(ctx); err != nil || attempted != 3 {
+				t.Fatalf("expected 3 attempts, got %d: %v", attempted, err)
+			}
+			rr = call("GET", "/me/webhooks/x/deliveries", created.ID, nil, handler.Deliveries)
+			var deliveries []model.WebhookDelivery
+			json.NewDecoder(rr.Body).Decode(&deliveries)
+			if len(deliveries) != 3 {
+				t.Fatalf("expected 3 deliveries, got %+v", deliveries)
+			}
+			events := map[string]bool{}
+			for _, delivery := range deliveries {
+				events[delivery.Event] = true
+				if delivery.Status != store.WebhookPending || delivery.Attempts != 1 || delivery.ResponseStatus == nil ||
+					*delivery.ResponseStatus != http.StatusServiceUnavailable || delivery.NextAttemptAt <= time.Now().Unix() {
+					t.Fatalf("unexpected delivery after a failed attempt %+v", delivery)
+				}
+			}
+			if !events[webhooks.EventHistoryUpdated] || !events[webhooks.EventFavouriteAdded] || !events[webhooks.EventCategoryCreated] {
+				t.Fatalf("unexpected events %v", events)
+			}
+
+			for i, r := range received {
+				timestamp := r.Header.Get("X-Kotatsu-Timestamp")
+				if r.Header.Get("X-Kotatsu-Signature") != "sha256="+webhooks.Sign(created.Secret, timestamp, bodies[i]) {
+					t.Fatalf("invalid signature on %s", r.Header.Get("X-Kotatsu-Event"))
+				}
+				var payload webhooks.Payload
+				json.Unmarshal(bodies[i], &payload)
+				if payload.ID == "" || payload.Event != r.Header.Get("X-Kotatsu-Event") {
+					t.Fatalf("unexpected payload %s", bodies[i])
+				}
+			}
+
+			// Nothing is due until the backoff has passed.
+			if attempted, _ := dispatcher.DeliverDue(ctx); attempted != 0 {
+				t.Fatalf("expected no attempts during the backoff, got %d", attempted)
+			}
+			mu.Lock()
+			fail = false
+			mu.Unlock()
+			due, err := st.ClaimWebhookDeliveries(ctx, time.Now().Add(time.Hour).Unix(), 0, 10)
+			if err != nil || len(due) != 3 || due[0].Webhook == nil || due[0].Webhook.Secret != created.Secret {
+				t.Fatalf("unexpected claimed deliveries %+v: %v", due, err)
+			}
+			// The lease of 0 makes them due again right away.
+			if attempted, err := dispatcher.DeliverDue(ctx); err != nil || attempted != 3 {
+				t.Fatalf("expected 3 retries, got %d: %v", attempted, err)
+			}
+			deliveries, _ = st.ListWebhookDeliveries(ctx, created.ID, 10)
+			for _, delivery := range deliveries {
+				if delivery.Status != store.WebhookDelivered || delivery.Attempts != 2 || delivery.LastError != nil {
+					t.Fatalf("unexpected delivery after a successful attempt %+v", delivery)
+				}
+			}
+
+			// Disabled webhooks receive nothing.
+			rr = call("PATCH", "/me/webhooks/x", created.ID, map[string]any{"enabled": false}, handler.Update)
+			var updated model.Webhook
+			json.NewDecoder(rr.Body).Decode(&updated)
+			if rr.Code != http.StatusOK || updated.Enabled || updated.URL != receiver.URL || len(updated.Events) != 3 {
+				t.Fatalf("unexpected update response %d %+v", rr.Code, updated)
+			}
+			req, _ = http.NewRequest("POST", "/resource/history", bytes.NewReader(history))
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+			syncHandler.PostHistory(httptest.NewRecorder(), req)
+			if deliveries, _ = st.ListWebhookDeliveries(ctx, created.ID, 10); len(deliveries) != 3 {
+				t.Fatalf("expected no delivery for a disabled webhook, got %d", len(deliveries))
+			}
+
+			if rr := call("DELETE", "/me/webhooks/x", created.ID, nil, handler.Delete); rr.Code != http.StatusNoContent {
+				t.Fatalf("expected 204, got %d", rr.Code)
+			}
+			if rr := call("GET", "/me/webhooks/x", created.ID, nil, handler.Get); rr.Code != http.StatusNotFound {
+				t.Fatalf("expected 404 after deletion, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 5: 8 * time.Minute, 11: 6 * time.Hour} {
+		if got := webhooks.RetryDelay(attempts); got != want {
+			t.Fatalf("RetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
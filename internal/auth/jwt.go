@@ -10,8 +10,15 @@ import (
 
 var SecretKey []byte
 
+// Token lifetimes, overridable with ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL.
+var (
+	AccessTokenTTL  = time.Hour
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
 type Claims struct {
-	UserID int64 `json:"user_id"`
+	UserID    int64  `json:"user_id"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -19,12 +26,21 @@ func Init(secret string) {
 	SecretKey = []byte(secret)
 }
 
-func GenerateToken(userID int64) (string, error) {
+// GenerateAccessToken issues a short-lived token bound to a session, so that
+// revoking the session invalidates it before it expires.
+func GenerateAccessToken(userID int64, sessionID string) (string, error) {
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * 30 * time.Hour)), // 30 days
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
@@ -3,19 +3,62 @@ package auth
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base32"
 	"encoding/hex"
+	"strings"
+	"unicode"
 )
 
 // GenerateResetToken creates a random token and returns it along with its SHA256 hash.
 // The raw token is sent to the user, the hash is stored in the DB.
 func GenerateResetToken() (string, string, error) {
-	bytes := make([]byte, 32)
+	token, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// GenerateRefreshToken creates a refresh token and its SHA256 hash.
+// Only the hash is stored, like reset tokens.
+func GenerateRefreshToken() (string, string, error) {
+	return GenerateResetToken()
+}
+
+// GenerateSessionID creates a random, non-guessable session identifier.
+func GenerateSessionID() (string, error) {
+	return randomHex(16)
+}
+
+// GenerateInviteCode creates a human-friendly invite code such as
+// "ABCD-EFGH-IJKL-MNOP" and the hash of its normalized form.
+func GenerateInviteCode() (string, string, error) {
+	bytes := make([]byte, 10)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", "", err
 	}
-	token := hex.EncodeToString(bytes)
-	hash := HashToken(token)
-	return token, hash, nil
+	raw := base32.StdEncoding.EncodeToString(bytes)
+	code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+	return code, HashInviteCode(code), nil
+}
+
+// HashInviteCode hashes an invite code, ignoring case, spaces and dashes.
+func HashInviteCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, code)
+	return HashToken(normalized)
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
 }
 
 // HashToken calculates the SHA256 hash of a token.
@@ -23,3 +66,8 @@ func HashToken(token string) string {
 	hash := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(hash[:])
 }
+
+// GenerateWebhookSecret creates the key webhook payloads are signed with.
+func GenerateWebhookSecret() (string, error) {
+	return randomHex(32)
+}
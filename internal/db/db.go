@@ -3,46 +3,63 @@ package db
 import (
 	"context"
 	"database/sql"
-	_ "embed"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
 	_ "modernc.org/sqlite"
 )
 
-//go:embed schema.sql
-var schemaSQLite string
-
-//go:embed schema_mysql.sql
-var schemaMySQL string
-
 type DB struct {
 	*sql.DB
+	Dialect Dialect
 }
 
+// New opens the database and applies all pending migrations.
 func New(dsn string) (*DB, error) {
+	database, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := database.MigrateUp(context.Background()); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return database, nil
+}
+
+// Open opens the database without touching its schema. The dialect is taken
+// from the DSN's URL scheme (postgres://, mysql://, sqlite://) when present,
+// otherwise it is guessed from the DSN format.
+func Open(dsn string) (*DB, error) {
+	return OpenDialect(detectDialect(dsn), dsn)
+}
+
+// OpenDialect opens the database using an explicitly selected dialect.
+func OpenDialect(dialect Dialect, dsn string) (*DB, error) {
 	var db *sql.DB
 	var err error
-	var dbType string
 
-	// Determine database type based on DSN format
-	// MySQL DSN examples: user:password@tcp(host:port)/dbname, user:password@/dbname
-	// SQLite DSN: file path (e.g., data/kotatsu.db, /path/to/db.sqlite, :memory:)
-
-	// Simple heuristic: if DSN contains '@' it's likely MySQL
-	isMySQL := strings.Contains(dsn, "@")
+	dsn, err = normalizeDSN(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
 
-	if isMySQL {
-		// MySQL database
-		dbType = "mysql"
+	switch dialect {
+	case DialectMySQL:
 		db, err = sql.Open("mysql", dsn)
-	} else {
+	case DialectPostgres:
+		db, err = sql.Open("pgx", dsn)
+	case DialectSQLite:
 		// SQLite database - ensure directory exists (unless it's :memory:)
-		dbType = "sqlite"
 		if dsn != ":memory:" {
 			dir := filepath.Dir(dsn)
 			if err := os.MkdirAll(dir, 0755); err != nil {
@@ -70,6 +87,8 @@ func New(dsn string) (*DB, error) {
 		dsn += strings.Join(pragmas, "&")
 
 		db, err = sql.Open("sqlite", dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database dialect %q", dialect)
 	}
 
 	if err != nil {
@@ -82,43 +101,66 @@ func New(dsn string) (*DB, error) {
 	}
 
 	// Apply SQLite-specific optimizations
-	if dbType == "sqlite" {
+	if dialect == DialectSQLite {
 		// SQLite needs more connections to handle nested queries (N+1) in fetchFavourites
 		// and concurrent requests, preventing deadlock (e.g. Reader holds Conn1, needs Conn2).
 		db.SetMaxOpenConns(25)
 	}
 
-	if err := initSchema(db, dbType); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
-	}
-
-	return &DB{db}, nil
+	return &DB{DB: db, Dialect: dialect}, nil
 }
 
-func initSchema(db *sql.DB, dbType string) error {
-	var schema string
-	if dbType == "mysql" {
-		schema = schemaMySQL
-	} else {
-		schema = schemaSQLite
+// detectDialect guesses the dialect of a DSN. URL schemes win; otherwise a DSN
+// containing '@' is taken for MySQL (user:password@tcp(host:port)/dbname) and
+// anything else for an SQLite file path (data/kotatsu.db, :memory:).
+func detectDialect(dsn string) Dialect {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return DialectPostgres
+	case strings.HasPrefix(dsn, "mysql://"):
+		return DialectMySQL
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return DialectSQLite
+	case strings.Contains(dsn, "@"):
+		return DialectMySQL
+	default:
+		return DialectSQLite
 	}
+}
 
-	for _, stmt := range strings.Split(schema, ";") {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" {
-			continue
+// normalizeDSN strips URL schemes the drivers do not understand. A mysql://
+// URL is converted to the go-sql-driver format.
+func normalizeDSN(dialect Dialect, dsn string) (string, error) {
+	switch dialect {
+	case DialectSQLite:
+		return strings.TrimPrefix(dsn, "sqlite://"), nil
+	case DialectMySQL:
+		if !strings.HasPrefix(dsn, "mysql://") {
+			return dsn, nil
 		}
-
-		if _, err := db.Exec(stmt); err != nil {
-			return err
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid mysql URL: %w", err)
 		}
+		cfg := mysqlDriver.NewConfig()
+		cfg.User = u.User.Username()
+		cfg.Passwd, _ = u.User.Password()
+		cfg.Net = "tcp"
+		cfg.Addr = u.Host
+		cfg.DBName = strings.TrimPrefix(u.Path, "/")
+		if len(u.Query()) > 0 {
+			cfg.Params = make(map[string]string)
+			for key := range u.Query() {
+				cfg.Params[key] = u.Query().Get(key)
+			}
+		}
+		return cfg.FormatDSN(), nil
+	default:
+		return dsn, nil
 	}
-
-	return nil
 }
 
-func (db *DB) WithTx(ctx context.Context, fn func(*sql.Tx) error) error {
+func (db *DB) WithTx(ctx context.Context, fn func(*Tx) error) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -131,71 +173,184 @@ func (db *DB) WithTx(ctx context.Context, fn func(*sql.Tx) error) error {
 
 	return tx.Commit()
 }
-func (db *DB) GetUserByEmail(email string) (*model.User, error) {
-	var user model.User
-	query := `SELECT id, email, password_hash, nickname, favourites_sync_timestamp, history_sync_timestamp, password_reset_token_hash, password_reset_token_expires_at FROM users WHERE email = ?`
-	row := db.QueryRow(query, email)
-	err := row.Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.Nickname,
-		&user.FavouritesSyncTimestamp, &user.HistorySyncTimestamp,
-		&user.PasswordResetTokenHash, &user.PasswordResetTokenExpires,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+func (db *DB) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return scanUser(db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ?`, email))
 }
 
-func (db *DB) SetPasswordResetToken(userID int64, tokenHash string, expiresAt int64) error {
+func (db *DB) SetPasswordResetToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error {
 	query := `UPDATE users SET password_reset_token_hash = ?, password_reset_token_expires_at = ? WHERE id = ?`
-	_, err := db.Exec(query, tokenHash, expiresAt, userID)
+	_, err := db.ExecContext(ctx, query, tokenHash, expiresAt, userID)
 	return err
 }
 
-func (db *DB) GetUserByResetToken(tokenHash string) (*model.User, error) {
-	var user model.User
-	query := `SELECT id, email, password_hash, nickname, favourites_sync_timestamp, history_sync_timestamp, password_reset_token_hash, password_reset_token_expires_at FROM users WHERE password_reset_token_hash = ?`
-	row := db.QueryRow(query, tokenHash)
-	err := row.Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.Nickname,
-		&user.FavouritesSyncTimestamp, &user.HistorySyncTimestamp,
-		&user.PasswordResetTokenHash, &user.PasswordResetTokenExpires,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+func (db *DB) GetUserByResetToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	return scanUser(db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE password_reset_token_hash = ?`, tokenHash))
+}
+
+func (db *DB) SetVerificationToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error {
+	query := `UPDATE users SET verification_token_hash = ?, verification_token_expires_at = ? WHERE id = ?`
+	_, err := db.ExecContext(ctx, query, tokenHash, expiresAt, userID)
+	return err
+}
+
+func (db *DB) GetUserByVerificationToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	return scanUser(db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE verification_token_hash = ?`, tokenHash))
+}
+
+// MarkEmailVerified records the verification and clears the token.
+func (db *DB) MarkEmailVerified(ctx context.Context, userID int64, verifiedAt int64) error {
+	query := `UPDATE users SET verified_at = ?, verification_token_hash = NULL, verification_token_expires_at = NULL WHERE id = ?`
+	_, err := db.ExecContext(ctx, query, verifiedAt, userID)
+	return err
+}
+
+// SetEmailChangeToken stores the address the user wants to switch to until
+// the token sent there is confirmed.
+func (db *DB) SetEmailChangeToken(ctx context.Context, userID int64, newEmail, tokenHash string, expiresAt int64) error {
+	query := `UPDATE users SET pending_email = ?, email_change_token_hash = ?, email_change_token_expires_at = ? WHERE id = ?`
+	_, err := db.ExecContext(ctx, query, newEmail, tokenHash, expiresAt, userID)
+	return err
+}
+
+func (db *DB) GetUserByEmailChangeToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	return scanUser(db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email_change_token_hash = ?`, tokenHash))
+}
+
+// ChangeEmail switches the user to a confirmed address and clears the
+// pending change.
+func (db *DB) ChangeEmail(ctx context.Context, userID int64, email string, verifiedAt int64) error {
+	query := `UPDATE users SET email = ?, verified_at = ?, pending_email = NULL, email_change_token_hash = NULL, email_change_token_expires_at = NULL WHERE id = ?`
+	_, err := db.ExecContext(ctx, query, email, verifiedAt, userID)
+	return err
+}
+
+// ScheduleDeletion marks the user for purging at purgeAt. The token cancels
+// the deletion until then.
+func (db *DB) ScheduleDeletion(ctx context.Context, userID int64, purgeAt int64, tokenHash string) error {
+	query := `UPDATE users SET deletion_scheduled_at = ?, deletion_token_hash = ? WHERE id = ?`
+	_, err := db.ExecContext(ctx, query, purgeAt, tokenHash, userID)
+	return err
+}
+
+func (db *DB) GetUserByDeletionToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	return scanUser(db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE deletion_token_hash = ?`, tokenHash))
+}
+
+func (db *DB) CancelDeletion(ctx context.Context, userID int64) error {
+	query := `UPDATE users SET deletion_scheduled_at = NULL, deletion_token_hash = NULL WHERE id = ?`
+	_, err := db.ExecContext(ctx, query, userID)
+	return err
 }
 
-func (db *DB) UpdatePassword(userID int64, passwordHash string) error {
+func (db *DB) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
 	query := `UPDATE users SET password_hash = ? WHERE id = ?`
-	_, err := db.Exec(query, passwordHash, userID)
+	_, err := db.ExecContext(ctx, query, passwordHash, userID)
+	return err
+}
+
+func (db *DB) SetUserRole(ctx context.Context, userID int64, role string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, role, userID)
+	return err
+}
+
+// SetUserDisabled disables the user at disabledAt, or enables it when nil.
+func (db *DB) SetUserDisabled(ctx context.Context, userID int64, disabledAt *int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET disabled_at = ? WHERE id = ?`, disabledAt, userID)
 	return err
 }
 
-func (db *DB) ClearResetToken(userID int64) error {
+func (db *DB) ClearResetToken(ctx context.Context, userID int64) error {
 	query := `UPDATE users SET password_reset_token_hash = NULL, password_reset_token_expires_at = NULL WHERE id = ?`
-	_, err := db.Exec(query, userID)
+	_, err := db.ExecContext(ctx, query, userID)
 	return err
 }
 
-func (db *DB) UserExists(id int64) (bool, error) {
+// CreateUser inserts a user and returns its ID.
+func (db *DB) CreateUser(ctx context.Context, email, passwordHash string) (int64, error) {
+	return createUser(ctx, db, db.Dialect, email, passwordHash)
+}
+
+// CreateUser inserts a user within the transaction, see DB.CreateUser.
+func (tx *Tx) CreateUser(ctx context.Context, email, passwordHash string) (int64, error) {
+	return createUser(ctx, tx, tx.Dialect, email, passwordHash)
+}
+
+// execQueryer is implemented by DB and Tx.
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func createUser(ctx context.Context, q execQueryer, dialect Dialect, email, passwordHash string) (int64, error) {
+	if dialect == DialectMySQL {
+		res, err := q.ExecContext(ctx, "INSERT INTO users (email, password_hash, created_at) VALUES (?, ?, ?)", email, passwordHash, time.Now().Unix())
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+
+	// The pgx driver does not implement LastInsertId; SQLite supports RETURNING as well.
+	var id int64
+	err := q.QueryRowContext(ctx, "INSERT INTO users (email, password_hash, created_at) VALUES (?, ?, ?) RETURNING id", email, passwordHash, time.Now().Unix()).Scan(&id)
+	return id, err
+}
+
+func (db *DB) UserExists(ctx context.Context, id int64) (bool, error) {
 	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", id).Scan(&exists)
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", id).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (db *DB) GetUserByID(id int64) (*model.User, error) {
+func (db *DB) GetUserByID(ctx context.Context, id int64) (*model.User, error) {
+	return scanUser(db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// ListUsers returns every user ordered by ID.
+func (db *DB) ListUsers(ctx context.Context) ([]model.User, error) {
+	return db.QueryUsers(ctx, `ORDER BY id`)
+}
+
+// QueryUsers returns the users selected by the WHERE, ORDER BY and LIMIT
+// clauses in conditions.
+func (db *DB) QueryUsers(ctx context.Context, conditions string, args ...any) ([]model.User, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+userColumns+` FROM users `+conditions, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// userColumns lists the users columns read by scanUser.
+const userColumns = `id, email, password_hash, nickname, favourites_sync_timestamp, history_sync_timestamp, bookmarks_sync_timestamp,
+	password_reset_token_hash, password_reset_token_expires_at, created_at, verified_at,
+	verification_token_hash, verification_token_expires_at,
+	pending_email, email_change_token_hash, email_change_token_expires_at,
+	deletion_scheduled_at, deletion_token_hash, role, disabled_at`
+
+func scanUser(row interface{ Scan(...any) error }) (*model.User, error) {
 	var user model.User
-	query := `SELECT id, email, password_hash, nickname, favourites_sync_timestamp, history_sync_timestamp, password_reset_token_hash, password_reset_token_expires_at FROM users WHERE id = ?`
-	row := db.QueryRow(query, id)
 	err := row.Scan(
 		&user.ID, &user.Email, &user.PasswordHash, &user.Nickname,
-		&user.FavouritesSyncTimestamp, &user.HistorySyncTimestamp,
+		&user.FavouritesSyncTimestamp, &user.HistorySyncTimestamp, &user.BookmarksSyncTimestamp,
 		&user.PasswordResetTokenHash, &user.PasswordResetTokenExpires,
+		&user.CreatedAt, &user.VerifiedAt,
+		&user.VerificationTokenHash, &user.VerificationTokenExpires,
+		&user.PendingEmail, &user.EmailChangeTokenHash, &user.EmailChangeTokenExpires,
+		&user.DeletionScheduledAt, &user.DeletionTokenHash, &user.Role, &user.DisabledAt,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,431 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockName identifies the MySQL named lock and the Postgres advisory
+// lock held while migrating, so replicas starting at the same time apply each
+// migration exactly once.
+const migrationLockName = "kotatsu_schema_migrations"
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	triggerRe    = regexp.MustCompile(`(?i)^\s*CREATE\s+(TEMP\s+|TEMPORARY\s+)?TRIGGER\b`)
+	triggerEndRe = regexp.MustCompile(`(?i)\bEND\s*$`)
+)
+
+// Migration is a single schema change with its rollback.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes whether a known migration has been applied.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *int64
+}
+
+// Migrations returns the migrations embedded for the given dialect, ordered by version.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %w", dialect, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+func (db *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(ctx, db.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies all pending migrations in order and returns how many ran.
+func (db *DB) MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		if err := db.adoptLegacySchema(ctx, conn); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			ran, err := db.applyMigration(ctx, conn, m, true)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			if ran {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown rolls back the given number of most recently applied migrations.
+func (db *DB) MigrateDown(ctx context.Context, steps int) (int, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be rolled back", m.Version, m.Name)
+			}
+			ran, err := db.applyMigration(ctx, conn, m, false)
+			if err != nil {
+				return fmt.Errorf("rollback %d_%s: %w", m.Version, m.Name, err)
+			}
+			if ran {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// PendingMigrations returns how many embedded migrations have not been applied yet.
+func (db *DB) PendingMigrations(ctx context.Context) (int, error) {
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (db *DB) ensureMigrationsTable(ctx context.Context) error {
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at INTEGER NOT NULL
+)`
+	switch db.Dialect {
+	case DialectMySQL:
+		query = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at BIGINT NOT NULL
+)`
+	case DialectPostgres:
+		query = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at BIGINT NOT NULL
+)`
+	}
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, q queryer) (map[int64]int64, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]int64)
+	for rows.Next() {
+		var version, appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// withMigrationLock runs fn on a dedicated connection while holding the
+// migration lock. MySQL and Postgres use a session lock for the whole run;
+// SQLite relies on BEGIN IMMEDIATE in applyMigration, which already excludes
+// other writers.
+func (db *DB) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch db.Dialect {
+	case DialectMySQL:
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", migrationLockName).Scan(&acquired); err != nil {
+			return err
+		}
+		if !acquired.Valid || acquired.Int64 != 1 {
+			return errors.New("timed out waiting for the migration lock")
+		}
+		defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+	case DialectPostgres:
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", migrationLockName); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", migrationLockName)
+	}
+
+	return fn(conn)
+}
+
+// applyMigration runs one migration in a transaction and records it. The
+// applied state is re-checked inside the transaction, so a migration that
+// another replica finished while we were waiting is skipped.
+// MySQL commits DDL implicitly, so there the named lock provides the
+// exclusion and a failing migration may leave partial changes behind.
+func (db *DB) applyMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool) (bool, error) {
+	begin, commit, rollback := "BEGIN", "COMMIT", "ROLLBACK"
+	if db.Dialect == DialectSQLite {
+		begin = "BEGIN IMMEDIATE"
+	}
+	if _, err := conn.ExecContext(ctx, begin); err != nil {
+		return false, err
+	}
+	done := false
+	defer func() {
+		if !done {
+			conn.ExecContext(context.Background(), rollback)
+		}
+	}()
+
+	var count int
+	if err := conn.QueryRowContext(ctx, db.Dialect.Rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), m.Version).Scan(&count); err != nil {
+		return false, err
+	}
+	if (count > 0) == up {
+		done = true
+		_, err := conn.ExecContext(ctx, commit)
+		return false, err
+	}
+
+	script := m.Up
+	if !up {
+		script = m.Down
+	}
+	for _, stmt := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return false, err
+		}
+	}
+
+	var err error
+	if up {
+		_, err = conn.ExecContext(ctx, db.Dialect.Rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), m.Version, m.Name, time.Now().UnixMilli())
+	} else {
+		_, err = conn.ExecContext(ctx, db.Dialect.Rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	done = true
+	if _, err := conn.ExecContext(ctx, commit); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// adoptLegacySchema records the migrations already reflected in a database
+// created before schema_migrations existed, when the schema was applied with
+// CREATE TABLE IF NOT EXISTS on every start.
+func (db *DB) adoptLegacySchema(ctx context.Context, conn *sql.Conn) error {
+	var count int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 || !columnExists(ctx, conn, "users", "id") {
+		return nil
+	}
+
+	legacy := []struct {
+		version int64
+		name    string
+		present bool
+	}{
+		{1, "initial", true},
+		{2, "sync_modified_at", columnExists(ctx, conn, "history", "modified_at")},
+	}
+	// Another SQLite process may adopt concurrently; the other dialects hold a session lock.
+	insert := "INSERT OR IGNORE INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"
+	if db.Dialect != DialectSQLite {
+		insert = db.Dialect.Rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)")
+	}
+	now := time.Now().UnixMilli()
+	for _, m := range legacy {
+		if !m.present {
+			break
+		}
+		if _, err := conn.ExecContext(ctx, insert, m.version, m.name, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func columnExists(ctx context.Context, conn *sql.Conn, table, column string) bool {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s LIMIT 0", column, table))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// splitStatements splits a migration script on semicolons that end a
+// statement, ignoring those inside quotes and "--" comments. The body of a
+// CREATE TRIGGER statement runs until the semicolon following its END.
+func splitStatements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	var quote rune
+	inComment := false
+
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		current.Reset()
+	}
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case inComment:
+			if r == '\n' {
+				inComment = false
+				current.WriteRune(r)
+			}
+			continue
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			inComment = true
+			continue
+		case r == ';':
+			if triggerRe.MatchString(current.String()) && !triggerEndRe.MatchString(current.String()) {
+				break
+			}
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	flush()
+
+	return stmts
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	database, err := Open(filepath.Join(t.TempDir(), "kotatsu.db"))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer database.Close()
+
+	migrations, err := Migrations(database.Dialect)
+	if err != nil {
+		t.Fatalf("load migrations failed: %v", err)
+	}
+
+	applied, err := database.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("migrate up failed: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Fatalf("expected %d migrations applied, got %d", len(migrations), applied)
+	}
+
+	// Running again is a no-op.
+	if applied, err := database.MigrateUp(ctx); err != nil || applied != 0 {
+		t.Fatalf("expected no-op second run, got applied=%d err=%v", applied, err)
+	}
+
+	if pending, err := database.PendingMigrations(ctx); err != nil || pending != 0 {
+		t.Fatalf("expected no pending migrations, got %d err=%v", pending, err)
+	}
+
+	rolledBack, err := database.MigrateDown(ctx, len(migrations))
+	if err != nil {
+		t.Fatalf("migrate down failed: %v", err)
+	}
+	if rolledBack != len(migrations) {
+		t.Fatalf("expected %d migrations rolled back, got %d", len(migrations), rolledBack)
+	}
+	if _, err := database.Exec("SELECT 1 FROM users"); err == nil {
+		t.Fatal("expected users table to be dropped")
+	}
+}
+
+func TestMigrateAdoptsLegacySchema(t *testing.T) {
+	ctx := context.Background()
+	database, err := Open(filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer database.Close()
+
+	// Simulate a deployment created by the former CREATE TABLE IF NOT EXISTS bootstrap.
+	migrations, err := Migrations(database.Dialect)
+	if err != nil {
+		t.Fatalf("load migrations failed: %v", err)
+	}
+	for _, stmt := range splitStatements(migrations[0].Up) {
+		if _, err := database.Exec(stmt); err != nil {
+			t.Fatalf("legacy schema failed: %v", err)
+		}
+	}
+	if _, err := database.Exec("INSERT INTO users (email, password_hash) VALUES (?, ?)", "legacy@example.com", "hash"); err != nil {
+		t.Fatalf("seed failed: %v", err)
+	}
+
+	if _, err := database.MigrateUp(ctx); err != nil {
+		t.Fatalf("migrate up failed: %v", err)
+	}
+
+	var modifiedAt int64
+	if err := database.QueryRow("SELECT COALESCE(MAX(modified_at), 0) FROM history").Scan(&modifiedAt); err != nil {
+		t.Fatalf("expected later migrations to upgrade legacy schema: %v", err)
+	}
+	if _, err := database.GetUserByEmail(context.Background(), "legacy@example.com"); err != nil {
+		t.Fatalf("legacy data lost: %v", err)
+	}
+	var verifiedAt *int64
+	if err := database.QueryRow("SELECT verified_at FROM users WHERE email = ?", "legacy@example.com").Scan(&verifiedAt); err != nil || verifiedAt == nil {
+		t.Fatalf("expected existing accounts to count as verified, got %v, %v", verifiedAt, err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- leading comment; not a statement
+CREATE TABLE a (v TEXT DEFAULT 'x;y');
+INSERT INTO a (v) VALUES ("semi;colon"); -- trailing
+`
+	stmts := splitStatements(script)
+	if len(stmts) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %q", len(stmts), stmts)
+	}
+	if stmts[0] != "CREATE TABLE a (v TEXT DEFAULT 'x;y')" {
+		t.Fatalf("unexpected first statement %q", stmts[0])
+	}
+}
+
+func TestSplitStatementsTrigger(t *testing.T) {
+	script := `CREATE TRIGGER t AFTER INSERT ON a BEGIN
+    INSERT INTO b (v) VALUES (new.v);
+    DELETE FROM c;
+END;
+INSERT INTO a (v) VALUES ('end;');
+`
+	stmts := splitStatements(script)
+	if len(stmts) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %q", len(stmts), stmts)
+	}
+	if !strings.HasSuffix(stmts[0], "DELETE FROM c;\nEND") {
+		t.Fatalf("expected the trigger body to stay in one statement, got %q", stmts[0])
+	}
+}
@@ -0,0 +1,11 @@
+ALTER TABLE history
+    DROP INDEX idx_history_user_modified,
+    DROP COLUMN modified_at;
+
+ALTER TABLE favourites
+    DROP INDEX idx_favourites_user_modified,
+    DROP COLUMN modified_at;
+
+ALTER TABLE categories
+    DROP INDEX idx_categories_user_modified,
+    DROP COLUMN modified_at;
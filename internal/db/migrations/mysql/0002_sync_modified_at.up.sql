@@ -0,0 +1,11 @@
+ALTER TABLE categories
+    ADD COLUMN modified_at BIGINT NOT NULL DEFAULT 0,
+    ADD INDEX idx_categories_user_modified (user_id, modified_at);
+
+ALTER TABLE favourites
+    ADD COLUMN modified_at BIGINT NOT NULL DEFAULT 0,
+    ADD INDEX idx_favourites_user_modified (user_id, modified_at);
+
+ALTER TABLE history
+    ADD COLUMN modified_at BIGINT NOT NULL DEFAULT 0,
+    ADD INDEX idx_history_user_modified (user_id, modified_at);
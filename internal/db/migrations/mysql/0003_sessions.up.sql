@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    refresh_token_hash VARCHAR(128) NOT NULL UNIQUE,
+    previous_refresh_token_hash VARCHAR(128),
+    created_at BIGINT NOT NULL,
+    refreshed_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    revoked_at BIGINT,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_sessions_user_id (user_id),
+    INDEX idx_sessions_previous_refresh_token_hash (previous_refresh_token_hash)
+);
@@ -0,0 +1,7 @@
+ALTER TABLE sessions
+    DROP COLUMN last_favourites_sync_at,
+    DROP COLUMN last_history_sync_at,
+    DROP COLUMN last_seen_at,
+    DROP COLUMN ip_address,
+    DROP COLUMN user_agent,
+    DROP COLUMN device_name;
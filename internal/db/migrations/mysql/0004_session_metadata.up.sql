@@ -0,0 +1,7 @@
+ALTER TABLE sessions
+    ADD COLUMN device_name VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ADD COLUMN ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN last_history_sync_at BIGINT,
+    ADD COLUMN last_favourites_sync_at BIGINT;
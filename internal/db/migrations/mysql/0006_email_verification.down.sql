@@ -0,0 +1,6 @@
+ALTER TABLE users
+    DROP INDEX idx_users_verification_token_hash,
+    DROP COLUMN verification_token_expires_at,
+    DROP COLUMN verification_token_hash,
+    DROP COLUMN verified_at,
+    DROP COLUMN created_at;
@@ -0,0 +1,10 @@
+ALTER TABLE users
+    ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN verified_at BIGINT,
+    ADD COLUMN verification_token_hash VARCHAR(128),
+    ADD COLUMN verification_token_expires_at BIGINT,
+    ADD UNIQUE INDEX idx_users_verification_token_hash (verification_token_hash);
+
+-- Existing accounts were never sent a verification link, so they count as
+-- verified rather than being restricted once the grace period is over.
+UPDATE users SET created_at = UNIX_TIMESTAMP(), verified_at = UNIX_TIMESTAMP();
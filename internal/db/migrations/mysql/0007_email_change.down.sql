@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP INDEX idx_users_email_change_token_hash,
+    DROP COLUMN email_change_token_expires_at,
+    DROP COLUMN email_change_token_hash,
+    DROP COLUMN pending_email;
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN pending_email VARCHAR(320),
+    ADD COLUMN email_change_token_hash VARCHAR(128),
+    ADD COLUMN email_change_token_expires_at BIGINT,
+    ADD UNIQUE INDEX idx_users_email_change_token_hash (email_change_token_hash);
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP INDEX idx_users_deletion_scheduled_at,
+    DROP INDEX idx_users_deletion_token_hash,
+    DROP COLUMN deletion_token_hash,
+    DROP COLUMN deletion_scheduled_at;
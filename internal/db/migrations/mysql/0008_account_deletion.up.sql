@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN deletion_scheduled_at BIGINT,
+    ADD COLUMN deletion_token_hash VARCHAR(128),
+    ADD UNIQUE INDEX idx_users_deletion_token_hash (deletion_token_hash),
+    ADD INDEX idx_users_deletion_scheduled_at (deletion_scheduled_at);
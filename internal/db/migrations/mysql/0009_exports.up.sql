@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS exports (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token_hash VARCHAR(128) NOT NULL UNIQUE,
+    status VARCHAR(16) NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL,
+    completed_at BIGINT,
+    expires_at BIGINT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_exports_user_id (user_id),
+    INDEX idx_exports_expires_at (expires_at)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS bookmarks;
+ALTER TABLE sessions DROP COLUMN last_bookmarks_sync_at;
+ALTER TABLE users DROP COLUMN bookmarks_sync_timestamp;
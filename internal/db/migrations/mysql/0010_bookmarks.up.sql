@@ -0,0 +1,21 @@
+ALTER TABLE users ADD COLUMN bookmarks_sync_timestamp BIGINT;
+ALTER TABLE sessions ADD COLUMN last_bookmarks_sync_at BIGINT;
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+    manga_id BIGINT NOT NULL,
+    page_id BIGINT NOT NULL,
+    chapter_id BIGINT NOT NULL,
+    page INT NOT NULL,
+    scroll DOUBLE NOT NULL,
+    image_url TEXT NOT NULL,
+    percent DOUBLE NOT NULL,
+    created_at BIGINT NOT NULL,
+    deleted_at BIGINT NOT NULL,
+    modified_at BIGINT NOT NULL DEFAULT 0,
+    user_id BIGINT NOT NULL,
+    PRIMARY KEY (user_id, manga_id, page_id),
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_bookmarks_manga_id (manga_id),
+    INDEX idx_bookmarks_user_modified (user_id, modified_at)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS reading_events (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    manga_id BIGINT NOT NULL,
+    chapter_id BIGINT NOT NULL,
+    page INT NOT NULL,
+    percent DOUBLE NOT NULL,
+    session_id VARCHAR(64),
+    read_at BIGINT NOT NULL,
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_reading_events_user_read_at (user_id, read_at),
+    INDEX idx_reading_events_manga_id (manga_id)
+);
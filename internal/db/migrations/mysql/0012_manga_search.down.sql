@@ -0,0 +1 @@
+ALTER TABLE manga DROP INDEX idx_manga_search;
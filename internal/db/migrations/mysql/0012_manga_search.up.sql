@@ -0,0 +1 @@
+ALTER TABLE manga ADD FULLTEXT INDEX idx_manga_search (title, alt_title, author);
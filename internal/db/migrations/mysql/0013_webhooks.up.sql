@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(128) NOT NULL,
+    events VARCHAR(512) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at BIGINT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_webhooks_user_id (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    webhook_id VARCHAR(64) NOT NULL,
+    event VARCHAR(64) NOT NULL,
+    payload MEDIUMTEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at BIGINT NOT NULL,
+    last_attempt_at BIGINT,
+    response_status INT,
+    last_error TEXT,
+    created_at BIGINT NOT NULL,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE,
+    INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+    INDEX idx_webhook_deliveries_webhook_id (webhook_id, created_at),
+    INDEX idx_webhook_deliveries_created_at (created_at)
+);
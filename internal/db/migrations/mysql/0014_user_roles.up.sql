@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user',
+    ADD COLUMN disabled_at BIGINT;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS history;
+DROP TABLE IF EXISTS favourites;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS manga_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS manga;
+DROP TABLE IF EXISTS users;
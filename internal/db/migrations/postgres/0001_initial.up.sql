@@ -0,0 +1,90 @@
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    email VARCHAR(320) NOT NULL UNIQUE,
+    password_hash VARCHAR(128) NOT NULL,
+    nickname VARCHAR(100),
+    favourites_sync_timestamp BIGINT,
+    history_sync_timestamp BIGINT,
+    password_reset_token_hash VARCHAR(128) UNIQUE,
+    password_reset_token_expires_at BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS manga (
+    id BIGINT PRIMARY KEY,
+    title TEXT NOT NULL,
+    alt_title TEXT,
+    url TEXT NOT NULL,
+    public_url TEXT NOT NULL,
+    rating DOUBLE PRECISION NOT NULL,
+    content_rating VARCHAR(50),
+    cover_url TEXT NOT NULL,
+    large_cover_url TEXT,
+    state VARCHAR(50),
+    author TEXT,
+    source VARCHAR(100) NOT NULL,
+    nsfw BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id BIGINT PRIMARY KEY,
+    title TEXT NOT NULL,
+    "key" TEXT NOT NULL,
+    source VARCHAR(100) NOT NULL,
+    pinned BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS manga_tags (
+    manga_id BIGINT NOT NULL,
+    tag_id BIGINT NOT NULL,
+    PRIMARY KEY (manga_id, tag_id),
+    FOREIGN KEY (manga_id) REFERENCES manga(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id)
+);
+
+CREATE TABLE IF NOT EXISTS categories (
+    id BIGINT NOT NULL,
+    created_at BIGINT NOT NULL,
+    sort_key BIGINT NOT NULL,
+    title TEXT NOT NULL,
+    "order" VARCHAR(50) NOT NULL,
+    user_id BIGINT NOT NULL,
+    track BOOLEAN NOT NULL,
+    show_in_lib BOOLEAN NOT NULL,
+    deleted_at BIGINT,
+    PRIMARY KEY (id, user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS favourites (
+    manga_id BIGINT NOT NULL,
+    category_id BIGINT NOT NULL,
+    sort_key BIGINT NOT NULL,
+    pinned BOOLEAN NOT NULL,
+    created_at BIGINT NOT NULL,
+    deleted_at BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    PRIMARY KEY (manga_id, category_id, user_id),
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id, user_id) REFERENCES categories(id, user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS history (
+    manga_id BIGINT NOT NULL,
+    created_at BIGINT NOT NULL,
+    updated_at BIGINT NOT NULL,
+    chapter_id BIGINT NOT NULL,
+    page INTEGER NOT NULL,
+    scroll DOUBLE PRECISION NOT NULL,
+    percent DOUBLE PRECISION NOT NULL,
+    chapters INTEGER NOT NULL,
+    deleted_at BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    PRIMARY KEY (user_id, manga_id),
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_manga_tags_tag_id ON manga_tags(tag_id);
+CREATE INDEX IF NOT EXISTS idx_favourites_user_id ON favourites(user_id);
+CREATE INDEX IF NOT EXISTS idx_history_manga_id ON history(manga_id);
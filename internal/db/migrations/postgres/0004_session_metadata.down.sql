@@ -0,0 +1,6 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_favourites_sync_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_history_sync_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_seen_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_name;
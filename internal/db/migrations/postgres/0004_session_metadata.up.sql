@@ -0,0 +1,6 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_name VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_history_sync_at BIGINT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_favourites_sync_at BIGINT;
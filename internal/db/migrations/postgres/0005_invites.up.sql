@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS invites (
+    id VARCHAR(64) PRIMARY KEY,
+    code_hash VARCHAR(128) NOT NULL UNIQUE,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT,
+    revoked_at BIGINT
+);
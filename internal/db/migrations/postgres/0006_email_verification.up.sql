@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at BIGINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_token_hash VARCHAR(128);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_token_expires_at BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_verification_token_hash ON users(verification_token_hash);
+
+-- Existing accounts were never sent a verification link, so they count as
+-- verified rather than being restricted once the grace period is over.
+UPDATE users SET created_at = CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT), verified_at = CAST(EXTRACT(EPOCH FROM NOW()) AS BIGINT);
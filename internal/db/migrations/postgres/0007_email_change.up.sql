@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(320);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_change_token_hash VARCHAR(128);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_change_token_expires_at BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_change_token_hash ON users(email_change_token_hash);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+DROP INDEX IF EXISTS idx_users_deletion_token_hash;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_token_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS exports (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token_hash VARCHAR(128) NOT NULL UNIQUE,
+    status VARCHAR(16) NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL,
+    completed_at BIGINT,
+    expires_at BIGINT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_exports_user_id ON exports(user_id);
+CREATE INDEX IF NOT EXISTS idx_exports_expires_at ON exports(expires_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS bookmarks;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_bookmarks_sync_at;
+ALTER TABLE users DROP COLUMN IF EXISTS bookmarks_sync_timestamp;
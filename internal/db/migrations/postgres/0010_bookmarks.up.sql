@@ -0,0 +1,22 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bookmarks_sync_timestamp BIGINT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_bookmarks_sync_at BIGINT;
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+    manga_id BIGINT NOT NULL,
+    page_id BIGINT NOT NULL,
+    chapter_id BIGINT NOT NULL,
+    page INTEGER NOT NULL,
+    scroll DOUBLE PRECISION NOT NULL,
+    image_url TEXT NOT NULL,
+    percent DOUBLE PRECISION NOT NULL,
+    created_at BIGINT NOT NULL,
+    deleted_at BIGINT NOT NULL,
+    modified_at BIGINT NOT NULL DEFAULT 0,
+    user_id BIGINT NOT NULL,
+    PRIMARY KEY (user_id, manga_id, page_id),
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_manga_id ON bookmarks(manga_id);
+CREATE INDEX IF NOT EXISTS idx_bookmarks_user_modified ON bookmarks(user_id, modified_at);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS reading_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    manga_id BIGINT NOT NULL,
+    chapter_id BIGINT NOT NULL,
+    page INTEGER NOT NULL,
+    percent DOUBLE PRECISION NOT NULL,
+    session_id VARCHAR(64),
+    read_at BIGINT NOT NULL,
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reading_events_user_read_at ON reading_events(user_id, read_at);
+CREATE INDEX IF NOT EXISTS idx_reading_events_manga_id ON reading_events(manga_id);
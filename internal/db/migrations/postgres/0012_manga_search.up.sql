@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_manga_search ON manga
+    USING GIN (to_tsvector('simple', title || ' ' || COALESCE(alt_title, '') || ' ' || COALESCE(author, '')));
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_history_user_modified;
+DROP INDEX IF EXISTS idx_favourites_user_modified;
+DROP INDEX IF EXISTS idx_categories_user_modified;
+
+ALTER TABLE history DROP COLUMN modified_at;
+ALTER TABLE favourites DROP COLUMN modified_at;
+ALTER TABLE categories DROP COLUMN modified_at;
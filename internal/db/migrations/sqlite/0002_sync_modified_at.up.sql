@@ -0,0 +1,7 @@
+ALTER TABLE categories ADD COLUMN modified_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE favourites ADD COLUMN modified_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE history ADD COLUMN modified_at INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_categories_user_modified ON categories(user_id, modified_at);
+CREATE INDEX IF NOT EXISTS idx_favourites_user_modified ON favourites(user_id, modified_at);
+CREATE INDEX IF NOT EXISTS idx_history_user_modified ON history(user_id, modified_at);
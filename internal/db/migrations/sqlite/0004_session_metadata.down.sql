@@ -0,0 +1,6 @@
+ALTER TABLE sessions DROP COLUMN last_favourites_sync_at;
+ALTER TABLE sessions DROP COLUMN last_history_sync_at;
+ALTER TABLE sessions DROP COLUMN last_seen_at;
+ALTER TABLE sessions DROP COLUMN ip_address;
+ALTER TABLE sessions DROP COLUMN user_agent;
+ALTER TABLE sessions DROP COLUMN device_name;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_users_verification_token_hash;
+
+ALTER TABLE users DROP COLUMN verification_token_expires_at;
+ALTER TABLE users DROP COLUMN verification_token_hash;
+ALTER TABLE users DROP COLUMN verified_at;
+ALTER TABLE users DROP COLUMN created_at;
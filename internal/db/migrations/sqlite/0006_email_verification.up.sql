@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN verified_at INTEGER;
+ALTER TABLE users ADD COLUMN verification_token_hash TEXT;
+ALTER TABLE users ADD COLUMN verification_token_expires_at INTEGER;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_verification_token_hash ON users(verification_token_hash);
+
+-- Existing accounts were never sent a verification link, so they count as
+-- verified rather than being restricted once the grace period is over.
+UPDATE users SET created_at = CAST(strftime('%s', 'now') AS INTEGER), verified_at = CAST(strftime('%s', 'now') AS INTEGER);
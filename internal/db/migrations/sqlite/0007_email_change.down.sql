@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_email_change_token_hash;
+
+ALTER TABLE users DROP COLUMN email_change_token_expires_at;
+ALTER TABLE users DROP COLUMN email_change_token_hash;
+ALTER TABLE users DROP COLUMN pending_email;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN pending_email TEXT;
+ALTER TABLE users ADD COLUMN email_change_token_hash TEXT;
+ALTER TABLE users ADD COLUMN email_change_token_expires_at INTEGER;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_change_token_hash ON users(email_change_token_hash);
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN deletion_scheduled_at INTEGER;
+ALTER TABLE users ADD COLUMN deletion_token_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_deletion_token_hash ON users(deletion_token_hash);
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users(deletion_scheduled_at);
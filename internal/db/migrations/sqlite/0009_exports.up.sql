@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS exports (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL,
+    size INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    completed_at INTEGER,
+    expires_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_exports_user_id ON exports(user_id);
+CREATE INDEX IF NOT EXISTS idx_exports_expires_at ON exports(expires_at);
@@ -0,0 +1,22 @@
+ALTER TABLE users ADD COLUMN bookmarks_sync_timestamp INTEGER;
+ALTER TABLE sessions ADD COLUMN last_bookmarks_sync_at INTEGER;
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+    manga_id INTEGER NOT NULL,
+    page_id INTEGER NOT NULL,
+    chapter_id INTEGER NOT NULL,
+    page INTEGER NOT NULL,
+    scroll REAL NOT NULL,
+    image_url TEXT NOT NULL,
+    percent REAL NOT NULL,
+    created_at INTEGER NOT NULL,
+    deleted_at INTEGER NOT NULL,
+    modified_at INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, manga_id, page_id),
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_manga_id ON bookmarks(manga_id);
+CREATE INDEX IF NOT EXISTS idx_bookmarks_user_modified ON bookmarks(user_id, modified_at);
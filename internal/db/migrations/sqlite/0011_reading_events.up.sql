@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS reading_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    manga_id INTEGER NOT NULL,
+    chapter_id INTEGER NOT NULL,
+    page INTEGER NOT NULL,
+    percent REAL NOT NULL,
+    session_id TEXT,
+    read_at INTEGER NOT NULL,
+    FOREIGN KEY (manga_id) REFERENCES manga(id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reading_events_user_read_at ON reading_events(user_id, read_at);
+CREATE INDEX IF NOT EXISTS idx_reading_events_manga_id ON reading_events(manga_id);
@@ -0,0 +1,16 @@
+CREATE VIRTUAL TABLE IF NOT EXISTS manga_fts USING fts5(title, alt_title, author, content='manga', content_rowid='id');
+
+CREATE TRIGGER IF NOT EXISTS manga_fts_insert AFTER INSERT ON manga BEGIN
+    INSERT INTO manga_fts (rowid, title, alt_title, author) VALUES (new.id, new.title, new.alt_title, new.author);
+END;
+
+CREATE TRIGGER IF NOT EXISTS manga_fts_delete AFTER DELETE ON manga BEGIN
+    INSERT INTO manga_fts (manga_fts, rowid, title, alt_title, author) VALUES ('delete', old.id, old.title, old.alt_title, old.author);
+END;
+
+CREATE TRIGGER IF NOT EXISTS manga_fts_update AFTER UPDATE ON manga BEGIN
+    INSERT INTO manga_fts (manga_fts, rowid, title, alt_title, author) VALUES ('delete', old.id, old.title, old.alt_title, old.author);
+    INSERT INTO manga_fts (rowid, title, alt_title, author) VALUES (new.id, new.title, new.alt_title, new.author);
+END;
+
+INSERT INTO manga_fts (manga_fts) VALUES ('rebuild');
@@ -0,0 +1,116 @@
+// Package events notifies a user's connected devices when another device
+// synced, so they can pull the change right away instead of on their next poll.
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// subscriptionBuffer is the number of events a slow subscriber may fall
+// behind before further events are dropped for it. Events only tell a device
+// to sync, so a newer one makes the dropped ones redundant.
+const subscriptionBuffer = 16
+
+// Event announces a committed sync write.
+type Event struct {
+	UserID int64 `json:"-"`
+	// Resource is one of the store.SyncResource constants.
+	Resource string `json:"resource"`
+	// Timestamp is the new sync timestamp of the resource.
+	Timestamp int64 `json:"timestamp"`
+	// SessionID is the session that wrote the change, "" when not known.
+	SessionID string `json:"session_id"`
+}
+
+// Broker carries events between the replicas of a deployment. A Hub without
+// a broker only reaches the subscribers of its own process.
+type Broker interface {
+	// Publish sends the event to every replica, this one included.
+	Publish(ctx context.Context, event Event) error
+	// Listen calls deliver with every event published by any replica until
+	// ctx is done or the broker fails.
+	Listen(ctx context.Context, deliver func(Event)) error
+}
+
+// Hub fans events out to the subscribers of this process.
+type Hub struct {
+	broker Broker
+
+	mu          sync.Mutex
+	subscribers map[int64]map[*Subscription]struct{}
+}
+
+// NewHub returns a hub publishing through broker, or in-process only when
+// broker is nil. With a broker, Run has to be running for events to arrive.
+func NewHub(broker Broker) *Hub {
+	return &Hub{broker: broker, subscribers: make(map[int64]map[*Subscription]struct{})}
+}
+
+// Run delivers the events received from the broker until ctx is done.
+func (h *Hub) Run(ctx context.Context) error {
+	if h.broker == nil {
+		<-ctx.Done()
+		return nil
+	}
+	return h.broker.Listen(ctx, h.deliver)
+}
+
+// Publish sends the event to the user's subscribers on every replica.
+func (h *Hub) Publish(ctx context.Context, event Event) error {
+	if h.broker == nil {
+		h.deliver(event)
+		return nil
+	}
+	return h.broker.Publish(ctx, event)
+}
+
+// Subscribe starts receiving the user's events. The subscription has to be
+// closed when the subscriber goes away.
+func (h *Hub) Subscribe(userID int64) *Subscription {
+	sub := &Subscription{hub: h, userID: userID, events: make(chan Event, subscriptionBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) deliver(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers[event.UserID] {
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+// Subscription receives the events of one user.
+type Subscription struct {
+	hub    *Hub
+	userID int64
+	events chan Event
+}
+
+// Events returns the channel the events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subscribers[s.userID][s]; !ok {
+		return
+	}
+	delete(s.hub.subscribers[s.userID], s)
+	if len(s.hub.subscribers[s.userID]) == 0 {
+		delete(s.hub.subscribers, s.userID)
+	}
+	close(s.events)
+}
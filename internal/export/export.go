@@ -0,0 +1,99 @@
+// Package export writes the personal data archive served by GET /me/export.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// Sources are the stores the archive is read from.
+type Sources struct {
+	Users      store.UserStore
+	Sessions   store.SessionStore
+	History    store.HistoryStore
+	Library    store.LibraryStore
+	Bookmarks  store.BookmarkStore
+	ReadingLog store.ReadingLogStore
+}
+
+// WriteArchive writes a ZIP with everything stored about the user: the
+// account record, the full history, favourites and bookmarks packages as
+// served by the sync endpoints, the reading log and every session. Password
+// and token hashes are left out.
+func WriteArchive(ctx context.Context, w io.Writer, src Sources, userID int64) error {
+	user, err := src.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("load account: %w", err)
+	}
+
+	historyTimestamp, err := src.History.HistoryTimestamp(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("load history timestamp: %w", err)
+	}
+	history, err := src.History.GetHistory(ctx, userID, nil)
+	if err != nil {
+		return fmt.Errorf("load history: %w", err)
+	}
+
+	favouritesTimestamp, err := src.Library.FavouritesTimestamp(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("load favourites timestamp: %w", err)
+	}
+	favourites, categories, err := src.Library.GetFavourites(ctx, userID, nil)
+	if err != nil {
+		return fmt.Errorf("load favourites: %w", err)
+	}
+
+	bookmarksTimestamp, err := src.Bookmarks.BookmarksTimestamp(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("load bookmarks timestamp: %w", err)
+	}
+	bookmarks, err := src.Bookmarks.GetBookmarks(ctx, userID, nil)
+	if err != nil {
+		return fmt.Errorf("load bookmarks: %w", err)
+	}
+
+	events, err := src.ReadingLog.ListReadingEvents(ctx, userID, store.ReadingEventFilter{})
+	if err != nil {
+		return fmt.Errorf("load reading log: %w", err)
+	}
+
+	sessions, err := src.Sessions.ListAllSessions(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("load sessions: %w", err)
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"account.json", user},
+		{"history.json", model.HistoryPackage{History: history, Timestamp: historyTimestamp}},
+		{"favourites.json", model.FavouritesPackage{Categories: categories, Favourites: favourites, Timestamp: favouritesTimestamp}},
+		{"bookmarks.json", model.BookmarksPackage{Bookmarks: bookmarks, Timestamp: bookmarksTimestamp}},
+		{"reading_log.json", events},
+		{"sessions.json", sessions},
+	}
+
+	modified := time.Now()
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return fmt.Errorf("write %s: %w", file.name, err)
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,420 @@
+// Package kotatsu reads and writes the backup ZIP of the Kotatsu app. Every
+// section of the backup is a ZIP entry holding a JSON array: "index" with the
+// app metadata, "history", "categories", "favourites", "bookmarks", and
+// sections the server does not store, such as "settings".
+package kotatsu
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+)
+
+// AppID identifies backups written by the Kotatsu app.
+const AppID = "org.koitharu.kotatsu"
+
+// MaxEntrySize limits the decompressed size of a single backup section.
+const MaxEntrySize = 128 << 20
+
+// MaxBackupSize limits the decompressed size of all imported sections
+// together.
+const MaxBackupSize = 256 << 20
+
+// Section names.
+const (
+	SectionIndex      = "index"
+	SectionHistory    = "history"
+	SectionCategories = "categories"
+	SectionFavourites = "favourites"
+	SectionBookmarks  = "bookmarks"
+)
+
+// Backup is the content of a backup ZIP in the server's models.
+type Backup struct {
+	History    []model.History
+	Categories []model.Category
+	Favourites []model.Favourite
+	Bookmarks  []model.Bookmark
+	// Ignored lists the sections of a read backup that were not imported.
+	Ignored []string
+}
+
+type indexEntry struct {
+	AppID      string `json:"app_id"`
+	AppVersion int    `json:"app_version"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// The app's backup entries name IDs differently than the sync packages.
+type mangaEntry struct {
+	ID            int64      `json:"id"`
+	Title         string     `json:"title"`
+	AltTitle      *string    `json:"alt_title,omitempty"`
+	URL           string     `json:"url"`
+	PublicURL     string     `json:"public_url"`
+	Rating        float64    `json:"rating"`
+	NSFW          *bool      `json:"nsfw,omitempty"`
+	ContentRating *string    `json:"content_rating,omitempty"`
+	CoverURL      string     `json:"cover_url"`
+	LargeCoverURL *string    `json:"large_cover_url,omitempty"`
+	State         *string    `json:"state,omitempty"`
+	Author        *string    `json:"author,omitempty"`
+	Source        string     `json:"source"`
+	Tags          []tagEntry `json:"tags"`
+}
+
+type tagEntry struct {
+	ID     int64  `json:"id"`
+	Title  string `json:"title"`
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Pinned *bool  `json:"pinned,omitempty"`
+}
+
+type historyEntry struct {
+	MangaID   int64       `json:"manga_id"`
+	CreatedAt int64       `json:"created_at"`
+	UpdatedAt int64       `json:"updated_at"`
+	ChapterID int64       `json:"chapter_id"`
+	Page      int         `json:"page"`
+	Scroll    float64     `json:"scroll"`
+	Percent   float64     `json:"percent"`
+	Chapters  int         `json:"chapters"`
+	Manga     *mangaEntry `json:"manga"`
+}
+
+type categoryEntry struct {
+	ID        int64  `json:"category_id"`
+	CreatedAt int64  `json:"created_at"`
+	SortKey   int    `json:"sort_key"`
+	Title     string `json:"title"`
+	Order     string `json:"order"`
+	Track     bool   `json:"track"`
+	ShowInLib bool   `json:"show_in_lib"`
+}
+
+type favouriteEntry struct {
+	MangaID    int64       `json:"manga_id"`
+	CategoryID int64       `json:"category_id"`
+	SortKey    int         `json:"sort_key"`
+	Pinned     bool        `json:"pinned"`
+	CreatedAt  int64       `json:"created_at"`
+	Manga      *mangaEntry `json:"manga"`
+}
+
+// bookmarksEntry groups the bookmarks of one manga.
+type bookmarksEntry struct {
+	Manga     *mangaEntry     `json:"manga"`
+	Tags      []tagEntry      `json:"tags"`
+	Bookmarks []bookmarkEntry `json:"bookmarks"`
+}
+
+type bookmarkEntry struct {
+	MangaID   int64   `json:"manga_id"`
+	PageID    int64   `json:"page_id"`
+	ChapterID int64   `json:"chapter_id"`
+	Page      int     `json:"page"`
+	Scroll    float64 `json:"scroll"`
+	ImageURL  string  `json:"image_url"`
+	CreatedAt int64   `json:"created_at"`
+	Percent   float64 `json:"percent"`
+}
+
+// Read decodes a backup ZIP. Entries without their manga are dropped since
+// the server cannot store them. Backups without an index, with a section
+// twice or larger than MaxBackupSize once decompressed are rejected.
+func Read(r io.ReaderAt, size int64) (*Backup, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var b Backup
+	seen := make(map[string]bool)
+	budget := int64(MaxBackupSize)
+	readEntry := func(f *zip.File, v any) error {
+		n, err := readEntry(f, v, min(budget, MaxEntrySize))
+		budget -= n
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if seen[f.Name] {
+			return nil, fmt.Errorf("%s: duplicate section", f.Name)
+		}
+		seen[f.Name] = true
+		switch f.Name {
+		case SectionIndex:
+			var index []indexEntry
+			if err := readEntry(f, &index); err != nil {
+				return nil, err
+			}
+			if len(index) == 0 || index[0].AppID != AppID {
+				return nil, fmt.Errorf("not a Kotatsu backup")
+			}
+		case SectionHistory:
+			var entries []historyEntry
+			if err := readEntry(f, &entries); err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Manga == nil {
+					continue
+				}
+				b.History = append(b.History, model.History{
+					MangaID:   e.MangaID,
+					Manga:     e.Manga.model(),
+					CreatedAt: e.CreatedAt,
+					UpdatedAt: e.UpdatedAt,
+					ChapterID: e.ChapterID,
+					Page:      e.Page,
+					Scroll:    e.Scroll,
+					Percent:   e.Percent,
+					Chapters:  e.Chapters,
+				})
+			}
+		case SectionCategories:
+			var entries []categoryEntry
+			if err := readEntry(f, &entries); err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				b.Categories = append(b.Categories, model.Category{
+					ID:        e.ID,
+					CreatedAt: e.CreatedAt,
+					SortKey:   e.SortKey,
+					Title:     e.Title,
+					Order:     e.Order,
+					Track:     e.Track,
+					ShowInLib: e.ShowInLib,
+				})
+			}
+		case SectionFavourites:
+			var entries []favouriteEntry
+			if err := readEntry(f, &entries); err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Manga == nil {
+					continue
+				}
+				b.Favourites = append(b.Favourites, model.Favourite{
+					MangaID:    e.MangaID,
+					Manga:      e.Manga.model(),
+					CategoryID: e.CategoryID,
+					SortKey:    e.SortKey,
+					Pinned:     e.Pinned,
+					CreatedAt:  e.CreatedAt,
+				})
+			}
+		case SectionBookmarks:
+			var entries []bookmarksEntry
+			if err := readEntry(f, &entries); err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Manga == nil {
+					continue
+				}
+				if len(e.Manga.Tags) == 0 {
+					e.Manga.Tags = e.Tags
+				}
+				manga := e.Manga.model()
+				for _, bm := range e.Bookmarks {
+					b.Bookmarks = append(b.Bookmarks, model.Bookmark{
+						MangaID:   manga.ID,
+						Manga:     manga,
+						PageID:    bm.PageID,
+						ChapterID: bm.ChapterID,
+						Page:      bm.Page,
+						Scroll:    bm.Scroll,
+						ImageURL:  bm.ImageURL,
+						Percent:   bm.Percent,
+						CreatedAt: bm.CreatedAt,
+					})
+				}
+			}
+		default:
+			b.Ignored = append(b.Ignored, f.Name)
+		}
+	}
+	if !seen[SectionIndex] {
+		return nil, fmt.Errorf("not a Kotatsu backup: no %s", SectionIndex)
+	}
+	sort.Strings(b.Ignored)
+	return &b, nil
+}
+
+// readEntry decodes the JSON of a section of at most limit bytes and returns
+// how many bytes it decompressed.
+func readEntry(f *zip.File, v any, limit int64) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return int64(len(data)), fmt.Errorf("%s: %w", f.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return int64(len(data)), fmt.Errorf("%s: section exceeds the size limit", f.Name)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return int64(len(data)), fmt.Errorf("%s: %w", f.Name, err)
+	}
+	return int64(len(data)), nil
+}
+
+// Write encodes a backup ZIP the app can restore. Tombstones are left out.
+func Write(w io.Writer, b *Backup, createdAt int64) error {
+	history := []historyEntry{}
+	for _, h := range b.History {
+		if h.DeletedAt != 0 || h.Manga == nil {
+			continue
+		}
+		history = append(history, historyEntry{
+			MangaID:   h.MangaID,
+			CreatedAt: h.CreatedAt,
+			UpdatedAt: h.UpdatedAt,
+			ChapterID: h.ChapterID,
+			Page:      h.Page,
+			Scroll:    h.Scroll,
+			Percent:   h.Percent,
+			Chapters:  h.Chapters,
+			Manga:     newMangaEntry(h.Manga),
+		})
+	}
+	categories := []categoryEntry{}
+	for _, c := range b.Categories {
+		if c.DeletedAt != nil {
+			continue
+		}
+		categories = append(categories, categoryEntry{
+			ID:        c.ID,
+			CreatedAt: c.CreatedAt,
+			SortKey:   c.SortKey,
+			Title:     c.Title,
+			Order:     c.Order,
+			Track:     c.Track,
+			ShowInLib: c.ShowInLib,
+		})
+	}
+	favourites := []favouriteEntry{}
+	for _, f := range b.Favourites {
+		if f.DeletedAt != 0 || f.Manga == nil {
+			continue
+		}
+		favourites = append(favourites, favouriteEntry{
+			MangaID:    f.MangaID,
+			CategoryID: f.CategoryID,
+			SortKey:    f.SortKey,
+			Pinned:     f.Pinned,
+			CreatedAt:  f.CreatedAt,
+			Manga:      newMangaEntry(f.Manga),
+		})
+	}
+	bookmarks := []bookmarksEntry{}
+	bookmarksByManga := make(map[int64]int)
+	for _, bm := range b.Bookmarks {
+		if bm.DeletedAt != 0 || bm.Manga == nil {
+			continue
+		}
+		i, ok := bookmarksByManga[bm.MangaID]
+		if !ok {
+			manga := newMangaEntry(bm.Manga)
+			i = len(bookmarks)
+			bookmarksByManga[bm.MangaID] = i
+			bookmarks = append(bookmarks, bookmarksEntry{Manga: manga, Tags: manga.Tags})
+		}
+		bookmarks[i].Bookmarks = append(bookmarks[i].Bookmarks, bookmarkEntry{
+			MangaID:   bm.MangaID,
+			PageID:    bm.PageID,
+			ChapterID: bm.ChapterID,
+			Page:      bm.Page,
+			Scroll:    bm.Scroll,
+			ImageURL:  bm.ImageURL,
+			CreatedAt: bm.CreatedAt,
+			Percent:   bm.Percent,
+		})
+	}
+
+	sections := []struct {
+		name string
+		data any
+	}{
+		{SectionIndex, []indexEntry{{AppID: AppID, AppVersion: 1, CreatedAt: createdAt}}},
+		{SectionHistory, history},
+		{SectionCategories, categories},
+		{SectionFavourites, favourites},
+		{SectionBookmarks, bookmarks},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, section := range sections {
+		data, err := json.Marshal(section.data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", section.name, err)
+		}
+		fw, err := zw.Create(section.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func newMangaEntry(m *model.Manga) *mangaEntry {
+	e := &mangaEntry{
+		ID:            m.ID,
+		Title:         m.Title,
+		AltTitle:      m.AltTitle,
+		URL:           m.URL,
+		PublicURL:     m.PublicURL,
+		Rating:        m.Rating,
+		NSFW:          m.NSFW,
+		ContentRating: m.ContentRating,
+		CoverURL:      m.CoverURL,
+		LargeCoverURL: m.LargeCoverURL,
+		State:         m.State,
+		Author:        m.Author,
+		Source:        m.Source,
+		Tags:          []tagEntry{},
+	}
+	for _, t := range m.Tags {
+		e.Tags = append(e.Tags, tagEntry{ID: t.ID, Title: t.Title, Key: t.Key, Source: t.Source, Pinned: t.Pinned})
+	}
+	return e
+}
+
+func (e *mangaEntry) model() *model.Manga {
+	m := &model.Manga{
+		ID:            e.ID,
+		Title:         e.Title,
+		AltTitle:      e.AltTitle,
+		URL:           e.URL,
+		PublicURL:     e.PublicURL,
+		Rating:        e.Rating,
+		NSFW:          e.NSFW,
+		ContentRating: e.ContentRating,
+		CoverURL:      e.CoverURL,
+		LargeCoverURL: e.LargeCoverURL,
+		State:         e.State,
+		Author:        e.Author,
+		Source:        e.Source,
+	}
+	for _, t := range e.Tags {
+		m.Tags = append(m.Tags, model.Tag{ID: t.ID, Title: t.Title, Key: t.Key, Source: t.Source, Pinned: t.Pinned})
+	}
+	return m
+}
@@ -2,9 +2,11 @@ package mail
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/smtp"
 	"os"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/metrics"
 )
 
 type MailSender interface {
@@ -14,7 +16,7 @@ type MailSender interface {
 type ConsoleMailSender struct{}
 
 func (s *ConsoleMailSender) Send(to string, subject string, textBody string, htmlBody string) error {
-	log.Printf("=== MOCK EMAIL ===\nTo: %s\nSubject: %s\nText Body: %s\nHTML Body: %s\n==================", to, subject, textBody, htmlBody)
+	slog.Info("Mock email", "to", to, "subject", subject, "text_body", textBody, "html_body", htmlBody)
 	return nil
 }
 
@@ -61,6 +63,7 @@ func (s *SmtpMailSender) Send(to string, subject string, textBody string, htmlBo
 
 	err := smtp.SendMail(address, auth, s.config.From, []string{to}, msg)
 	if err != nil {
+		metrics.MailFailures.Inc()
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 	return nil
@@ -0,0 +1,272 @@
+// Package metrics collects the server's metrics and exposes them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry is a set of metrics written together, in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes every metric of the registry in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// Handler serves the registry. With a token, requests have to carry it as a
+// bearer token.
+func Handler(r *Registry, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token != "" {
+			given, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	family
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec registers a counter with the given label names.
+func NewCounterVec(r *Registry, name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{family: family{name, help, "counter", labels}, series: make(map[string]*counterSeries)}
+	if len(labels) == 0 {
+		// A counter without labels is exposed before its first increment.
+		c.Add(0)
+	}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the series with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the series with the given
+// label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labels: slices.Clone(values)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		c.sample(w, "", s.labels, "", s.value)
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram with the given upper bounds, in
+// increasing order, and label names.
+func NewHistogramVec(r *Registry, name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{family: family{name, help, "histogram", labels}, buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the series with the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			h.sample(w, "_bucket", s.labels, formatFloat(bound), float64(s.counts[i]))
+		}
+		h.sample(w, "_bucket", s.labels, "+Inf", float64(s.count))
+		h.sample(w, "_sum", s.labels, "", s.sum)
+		h.sample(w, "_count", s.labels, "", float64(s.count))
+	}
+}
+
+// funcMetric reads its value when the registry is written.
+type funcMetric struct {
+	family
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn.
+func NewGaugeFunc(r *Registry, name, help string, fn func() float64) {
+	r.register(&funcMetric{family{name, help, "gauge", nil}, fn})
+}
+
+// NewCounterFunc registers a counter whose value is read from fn, for totals
+// kept elsewhere.
+func NewCounterFunc(r *Registry, name, help string, fn func() float64) {
+	r.register(&funcMetric{family{name, help, "counter", nil}, fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.header(w)
+	f.sample(w, "", nil, "", f.fn())
+}
+
+// family holds what the metrics of one name share.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+}
+
+// sample writes one line of the metric; le is the bucket bound of histograms.
+func (f *family) sample(w *bufio.Writer, suffix string, values []string, le string, value float64) {
+	w.WriteString(f.name + suffix)
+	if len(values) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, value := range values {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(f.labels[i] + `="` + escapeLabel(value) + `"`)
+		}
+		if le != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(`le="` + le + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
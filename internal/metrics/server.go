@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"database/sql"
+)
+
+// Default holds the metrics of the server.
+var Default = &Registry{}
+
+var (
+	// DurationBuckets are the upper bounds, in seconds, of request latencies.
+	DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// SizeBuckets are the upper bounds of the number of items in a sync.
+	SizeBuckets = []float64{0, 1, 10, 50, 100, 500, 1000, 5000, 10000, 50000}
+)
+
+var (
+	HTTPRequests = NewCounterVec(Default, "kotatsu_http_requests_total",
+		"HTTP requests by route pattern and status code.", "route", "status")
+	HTTPDuration = NewHistogramVec(Default, "kotatsu_http_request_duration_seconds",
+		"Time to serve HTTP requests by route pattern and status code.", DurationBuckets, "route", "status")
+
+	// SyncItems counts the items of uploaded sync packages. Kind is the
+	// collection of the package, such as "favourites" or "categories".
+	SyncItems = NewHistogramVec(Default, "kotatsu_sync_items",
+		"Items per uploaded sync package by resource and kind.", SizeBuckets, "resource", "kind")
+
+	TxRetries = NewCounterVec(Default, "kotatsu_db_tx_retries_total",
+		"Transactions retried after a conflict with a concurrent transaction.")
+	// TxConflicts counts transactions aborted by a conflict, whether they
+	// were retried or not. Reason is "deadlock", "lock_timeout" or
+	// "serialization".
+	TxConflicts = NewCounterVec(Default, "kotatsu_db_tx_conflicts_total",
+		"Transactions aborted by a conflict with a concurrent transaction, by reason.", "reason")
+
+	// Logins counts sign-ins by client ("app" or "web") and result
+	// ("success" or "failure").
+	Logins = NewCounterVec(Default, "kotatsu_logins_total",
+		"Sign-in attempts by client and result.", "client", "result")
+
+	MailFailures = NewCounterVec(Default, "kotatsu_mail_send_failures_total",
+		"Emails that could not be handed to the mail server.")
+)
+
+// RegisterDBStats registers the connection pool statistics of a database.
+func RegisterDBStats(r *Registry, db *sql.DB) {
+	gauge := func(name, help string, fn func(sql.DBStats) float64) {
+		NewGaugeFunc(r, name, help, func() float64 { return fn(db.Stats()) })
+	}
+	counter := func(name, help string, fn func(sql.DBStats) float64) {
+		NewCounterFunc(r, name, help, func() float64 { return fn(db.Stats()) })
+	}
+
+	gauge("kotatsu_db_max_open_connections", "Maximum number of open connections to the database.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("kotatsu_db_open_connections", "Established connections to the database, in use or idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("kotatsu_db_in_use_connections", "Connections currently in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("kotatsu_db_idle_connections", "Idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("kotatsu_db_wait_count_total", "Connections waited for.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("kotatsu_db_wait_duration_seconds_total", "Time blocked waiting for a connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("kotatsu_db_max_idle_closed_total", "Connections closed because of the idle connection limit.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("kotatsu_db_max_idle_time_closed_total", "Connections closed because of the maximum idle time.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("kotatsu_db_max_lifetime_closed_total", "Connections closed because of the maximum lifetime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
@@ -7,8 +7,126 @@ type User struct {
 	Nickname                  *string `json:"nickname" db:"nickname"`
 	FavouritesSyncTimestamp   *int64  `json:"favourites_sync_timestamp" db:"favourites_sync_timestamp"`
 	HistorySyncTimestamp      *int64  `json:"history_sync_timestamp" db:"history_sync_timestamp"`
+	BookmarksSyncTimestamp    *int64  `json:"bookmarks_sync_timestamp" db:"bookmarks_sync_timestamp"`
 	PasswordResetTokenHash    *string `json:"-" db:"password_reset_token_hash"`
 	PasswordResetTokenExpires *int64  `json:"-" db:"password_reset_token_expires_at"`
+	CreatedAt                 int64   `json:"created_at" db:"created_at"`
+	VerifiedAt                *int64  `json:"verified_at" db:"verified_at"`
+	VerificationTokenHash     *string `json:"-" db:"verification_token_hash"`
+	VerificationTokenExpires  *int64  `json:"-" db:"verification_token_expires_at"`
+	PendingEmail              *string `json:"-" db:"pending_email"`
+	EmailChangeTokenHash      *string `json:"-" db:"email_change_token_hash"`
+	EmailChangeTokenExpires   *int64  `json:"-" db:"email_change_token_expires_at"`
+	DeletionScheduledAt       *int64  `json:"deletion_scheduled_at" db:"deletion_scheduled_at"`
+	DeletionTokenHash         *string `json:"-" db:"deletion_token_hash"`
+	Role                      string  `json:"role" db:"role"`
+	DisabledAt                *int64  `json:"disabled_at" db:"disabled_at"`
+}
+
+// UserSummary is an account with the size of its library as listed by the
+// admin API. Tombstones are not counted; favourites count distinct manga.
+type UserSummary struct {
+	User
+	History    int    `json:"history"`
+	Favourites int    `json:"favourites"`
+	Bookmarks  int    `json:"bookmarks"`
+	LastSeenAt *int64 `json:"last_seen_at"`
+}
+
+// ServerStats is the server-wide overview of GET /admin/stats. Library
+// counts exclude tombstones.
+type ServerStats struct {
+	Users            int         `json:"users"`
+	VerifiedUsers    int         `json:"verified_users"`
+	DisabledUsers    int         `json:"disabled_users"`
+	Admins           int         `json:"admins"`
+	PendingDeletions int         `json:"pending_deletions"`
+	ActiveUsers      ActiveUsers `json:"active_users"`
+	ActiveSessions   int         `json:"active_sessions"`
+	Manga            int         `json:"manga"`
+	History          int         `json:"history"`
+	Favourites       int         `json:"favourites"`
+	Bookmarks        int         `json:"bookmarks"`
+	ReadingEvents    int         `json:"reading_events"`
+	Webhooks         int         `json:"webhooks"`
+}
+
+// ActiveUsers counts the users with a session seen in the last day, week
+// and 30 days.
+type ActiveUsers struct {
+	Day   int `json:"day"`
+	Week  int `json:"week"`
+	Month int `json:"month"`
+}
+
+type Session struct {
+	ID                       string  `json:"id" db:"id"`
+	UserID                   int64   `json:"-" db:"user_id"`
+	RefreshTokenHash         string  `json:"-" db:"refresh_token_hash"`
+	PreviousRefreshTokenHash *string `json:"-" db:"previous_refresh_token_hash"`
+	CreatedAt                int64   `json:"created_at" db:"created_at"`
+	RefreshedAt              int64   `json:"refreshed_at" db:"refreshed_at"`
+	ExpiresAt                int64   `json:"expires_at" db:"expires_at"`
+	RevokedAt                *int64  `json:"revoked_at" db:"revoked_at"`
+	DeviceName               string  `json:"device_name" db:"device_name"`
+	UserAgent                string  `json:"user_agent" db:"user_agent"`
+	IPAddress                string  `json:"ip_address" db:"ip_address"`
+	LastSeenAt               int64   `json:"last_seen_at" db:"last_seen_at"`
+	LastHistorySyncAt        *int64  `json:"last_history_sync_at" db:"last_history_sync_at"`
+	LastFavouritesSyncAt     *int64  `json:"last_favourites_sync_at" db:"last_favourites_sync_at"`
+	LastBookmarksSyncAt      *int64  `json:"last_bookmarks_sync_at" db:"last_bookmarks_sync_at"`
+}
+
+type Invite struct {
+	ID        string `json:"id" db:"id"`
+	CodeHash  string `json:"-" db:"code_hash"`
+	Note      string `json:"note" db:"note"`
+	MaxUses   int    `json:"max_uses" db:"max_uses"`
+	Uses      int    `json:"uses" db:"uses"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
+	ExpiresAt *int64 `json:"expires_at" db:"expires_at"`
+	RevokedAt *int64 `json:"revoked_at" db:"revoked_at"`
+}
+
+// Export is a personal data archive requested through GET /me/export.
+type Export struct {
+	ID          string `json:"id" db:"id"`
+	UserID      int64  `json:"-" db:"user_id"`
+	TokenHash   string `json:"-" db:"token_hash"`
+	Status      string `json:"status" db:"status"`
+	Size        int64  `json:"size" db:"size"`
+	CreatedAt   int64  `json:"created_at" db:"created_at"`
+	CompletedAt *int64 `json:"completed_at" db:"completed_at"`
+	ExpiresAt   int64  `json:"expires_at" db:"expires_at"`
+}
+
+// Webhook is an endpoint registered through /me/webhooks. Events lists the
+// event names it is subscribed to; the secret is only shown on creation.
+type Webhook struct {
+	ID        string   `json:"id" db:"id"`
+	UserID    int64    `json:"-" db:"user_id"`
+	URL       string   `json:"url" db:"url"`
+	Secret    string   `json:"-" db:"secret"`
+	Events    []string `json:"events" db:"events"`
+	Enabled   bool     `json:"enabled" db:"enabled"`
+	CreatedAt int64    `json:"created_at" db:"created_at"`
+}
+
+// WebhookDelivery is one queued event of a webhook and the outcome of its
+// latest attempt. Webhook is only set on deliveries claimed for sending.
+type WebhookDelivery struct {
+	ID             int64    `json:"id" db:"id"`
+	WebhookID      string   `json:"webhook_id" db:"webhook_id"`
+	Webhook        *Webhook `json:"-" db:"-"`
+	Event          string   `json:"event" db:"event"`
+	Payload        string   `json:"-" db:"payload"`
+	Status         string   `json:"status" db:"status"`
+	Attempts       int      `json:"attempts" db:"attempts"`
+	NextAttemptAt  int64    `json:"next_attempt_at" db:"next_attempt_at"`
+	LastAttemptAt  *int64   `json:"last_attempt_at" db:"last_attempt_at"`
+	ResponseStatus *int     `json:"response_status" db:"response_status"`
+	LastError      *string  `json:"last_error" db:"last_error"`
+	CreatedAt      int64    `json:"created_at" db:"created_at"`
 }
 
 type Manga struct {
@@ -59,6 +177,15 @@ type Favourite struct {
 	DeletedAt  int64  `json:"deleted_at" db:"deleted_at"`
 }
 
+// LibraryEntry is a favourite manga with the categories it is in. AddedAt is
+// the earliest created_at of its favourite rows.
+type LibraryEntry struct {
+	Manga      *Manga  `json:"manga"`
+	Categories []int64 `json:"categories"`
+	Pinned     bool    `json:"pinned"`
+	AddedAt    int64   `json:"added_at"`
+}
+
 type History struct {
 	MangaID   int64   `json:"manga_id" db:"manga_id"`
 	Manga     *Manga  `json:"manga,omitempty" db:"-"`
@@ -73,6 +200,86 @@ type History struct {
 	DeletedAt int64   `json:"deleted_at" db:"deleted_at"`
 }
 
+// Bookmark marks a page of a manga chapter; PageID identifies it within the manga.
+type Bookmark struct {
+	MangaID   int64   `json:"manga_id" db:"manga_id"`
+	Manga     *Manga  `json:"manga,omitempty" db:"-"`
+	UserID    int64   `json:"-" db:"user_id"`
+	PageID    int64   `json:"page_id" db:"page_id"`
+	ChapterID int64   `json:"chapter_id" db:"chapter_id"`
+	Page      int     `json:"page" db:"page"`
+	Scroll    float64 `json:"scroll" db:"scroll"`
+	ImageURL  string  `json:"image_url" db:"image_url"`
+	Percent   float64 `json:"percent" db:"percent"`
+	CreatedAt int64   `json:"created_at" db:"created_at"`
+	DeletedAt int64   `json:"deleted_at" db:"deleted_at"`
+}
+
+// ReadingEvent records an accepted history change that moved the reading
+// position of a manga. ReadAt is the client's updated_at in milliseconds.
+type ReadingEvent struct {
+	ID         int64   `json:"id" db:"id"`
+	UserID     int64   `json:"-" db:"user_id"`
+	MangaID    int64   `json:"manga_id" db:"manga_id"`
+	Manga      *Manga  `json:"manga,omitempty" db:"-"`
+	ChapterID  int64   `json:"chapter_id" db:"chapter_id"`
+	Page       int     `json:"page" db:"page"`
+	Percent    float64 `json:"percent" db:"percent"`
+	SessionID  *string `json:"-" db:"session_id"`
+	DeviceName *string `json:"device_name" db:"-"`
+	ReadAt     int64   `json:"read_at" db:"read_at"`
+}
+
+// ReadingStats summarizes a user's reading. Chapter counts are estimated from
+// the percent of each history row. Days are UTC and weeks start on Monday;
+// both are dated YYYY-MM-DD and only listed when something was read.
+type ReadingStats struct {
+	Totals   ReadingTotals   `json:"totals"`
+	Sources  []SourceStats   `json:"sources"`
+	Tags     []TagStats      `json:"tags"`
+	Activity ReadingActivity `json:"activity"`
+	Streaks  ReadingStreaks  `json:"streaks"`
+}
+
+type ReadingTotals struct {
+	MangaStarted   int   `json:"manga_started"`
+	MangaCompleted int   `json:"manga_completed"`
+	ChaptersRead   int64 `json:"chapters_read"`
+}
+
+type SourceStats struct {
+	Source         string `json:"source"`
+	MangaStarted   int    `json:"manga_started"`
+	MangaCompleted int    `json:"manga_completed"`
+	ChaptersRead   int64  `json:"chapters_read"`
+}
+
+type TagStats struct {
+	ID           int64  `json:"id"`
+	Title        string `json:"title"`
+	Source       string `json:"source"`
+	MangaStarted int    `json:"manga_started"`
+}
+
+type ReadingActivity struct {
+	Days  []ActivityStats `json:"days"`
+	Weeks []ActivityStats `json:"weeks"`
+}
+
+// ActivityStats counts the distinct manga and chapters read in a day or week.
+type ActivityStats struct {
+	Date     string `json:"date"`
+	Manga    int    `json:"manga"`
+	Chapters int    `json:"chapters"`
+}
+
+// ReadingStreaks are runs of consecutive days with reading events. The
+// current streak ends today or yesterday and is 0 otherwise.
+type ReadingStreaks struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
 type FavouritesPackage struct {
 	Categories []Category  `json:"categories"`
 	Favourites []Favourite `json:"favourites"`
@@ -83,3 +290,8 @@ type HistoryPackage struct {
 	History   []History `json:"history"`
 	Timestamp *int64    `json:"timestamp"`
 }
+
+type BookmarksPackage struct {
+	Bookmarks []Bookmark `json:"bookmarks"`
+	Timestamp *int64     `json:"timestamp"`
+}
@@ -0,0 +1,1866 @@
+// Package memstore is an in-memory implementation of the store interfaces.
+// It keeps the same last-writer-wins and sync timestamp semantics as sqlstore
+// and is meant for tests and throwaway instances: nothing is persisted.
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+type historyKey struct {
+	userID  int64
+	mangaID int64
+}
+
+type categoryKey struct {
+	userID     int64
+	categoryID int64
+}
+
+type favouriteKey struct {
+	userID     int64
+	mangaID    int64
+	categoryID int64
+}
+
+type bookmarkKey struct {
+	userID  int64
+	mangaID int64
+	pageID  int64
+}
+
+// Rows are stored with the sync timestamp of the write that last changed them.
+type historyRow struct {
+	model.History
+	modifiedAt int64
+}
+
+type categoryRow struct {
+	model.Category
+	modifiedAt int64
+}
+
+type favouriteRow struct {
+	model.Favourite
+	modifiedAt int64
+}
+
+type bookmarkRow struct {
+	model.Bookmark
+	modifiedAt int64
+}
+
+// Store holds every record in maps guarded by a single mutex.
+type Store struct {
+	mu sync.Mutex
+
+	lastUserID int64
+	users      map[int64]*model.User
+	sessions   map[string]*model.Session
+	invites    map[string]*model.Invite
+	exports    map[string]*model.Export
+
+	manga     map[int64]model.Manga
+	tags      map[int64]model.Tag
+	mangaTags map[int64]map[int64]struct{}
+
+	history    map[historyKey]historyRow
+	categories map[categoryKey]categoryRow
+	favourites map[favouriteKey]favouriteRow
+	bookmarks  map[bookmarkKey]bookmarkRow
+
+	lastEventID   int64
+	readingEvents []model.ReadingEvent
+
+	webhooks          map[string]*model.Webhook
+	lastDeliveryID    int64
+	webhookDeliveries []*model.WebhookDelivery
+}
+
+var (
+	_ store.UserStore        = (*Store)(nil)
+	_ store.InviteStore      = (*Store)(nil)
+	_ store.SessionStore     = (*Store)(nil)
+	_ store.HistoryStore     = (*Store)(nil)
+	_ store.LibraryStore     = (*Store)(nil)
+	_ store.BookmarkStore    = (*Store)(nil)
+	_ store.ImportStore      = (*Store)(nil)
+	_ store.ReadingLogStore  = (*Store)(nil)
+	_ store.StatsStore       = (*Store)(nil)
+	_ store.WebhookStore     = (*Store)(nil)
+	_ store.ExportStore      = (*Store)(nil)
+	_ store.AdminStore       = (*Store)(nil)
+	_ store.MaintenanceStore = (*Store)(nil)
+)
+
+func New() *Store {
+	return &Store{
+		users:      make(map[int64]*model.User),
+		sessions:   make(map[string]*model.Session),
+		invites:    make(map[string]*model.Invite),
+		exports:    make(map[string]*model.Export),
+		manga:      make(map[int64]model.Manga),
+		tags:       make(map[int64]model.Tag),
+		mangaTags:  make(map[int64]map[int64]struct{}),
+		history:    make(map[historyKey]historyRow),
+		categories: make(map[categoryKey]categoryRow),
+		favourites: make(map[favouriteKey]favouriteRow),
+		bookmarks:  make(map[bookmarkKey]bookmarkRow),
+		webhooks:   make(map[string]*model.Webhook),
+	}
+}
+
+// Users
+
+func (s *Store) CreateUser(ctx context.Context, email, passwordHash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return 0, store.ErrAlreadyExists
+		}
+	}
+	s.lastUserID++
+	s.users[s.lastUserID] = &model.User{ID: s.lastUserID, Email: email, PasswordHash: passwordHash, Role: store.RoleUser, CreatedAt: time.Now().Unix()}
+	return s.lastUserID, nil
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id int64) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return copyUser(user), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) GetUserByResetToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.PasswordResetTokenHash != nil && *user.PasswordResetTokenHash == tokenHash {
+			return copyUser(user), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) UserExists(ctx context.Context, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[id]
+	return ok, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context) ([]model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]model.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, *user)
+	}
+	slices.SortFunc(users, func(a, b model.User) int { return cmp.Compare(a.ID, b.ID) })
+	return users, nil
+}
+
+func (s *Store) SetUserRole(ctx context.Context, userID int64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.Role = role
+	}
+	return nil
+}
+
+func (s *Store) SetUserDisabled(ctx context.Context, userID int64, disabledAt *int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.DisabledAt = disabledAt
+	}
+	return nil
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.PasswordHash = passwordHash
+	}
+	return nil
+}
+
+func (s *Store) SetPasswordResetToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.PasswordResetTokenHash = &tokenHash
+		user.PasswordResetTokenExpires = &expiresAt
+	}
+	return nil
+}
+
+func (s *Store) ClearResetToken(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.PasswordResetTokenHash = nil
+		user.PasswordResetTokenExpires = nil
+	}
+	return nil
+}
+
+func (s *Store) SetVerificationToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.VerificationTokenHash = &tokenHash
+		user.VerificationTokenExpires = &expiresAt
+	}
+	return nil
+}
+
+func (s *Store) GetUserByVerificationToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.VerificationTokenHash != nil && *user.VerificationTokenHash == tokenHash {
+			return copyUser(user), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) MarkEmailVerified(ctx context.Context, userID int64, verifiedAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.VerifiedAt = &verifiedAt
+		user.VerificationTokenHash = nil
+		user.VerificationTokenExpires = nil
+	}
+	return nil
+}
+
+func (s *Store) ScheduleDeletion(ctx context.Context, userID int64, purgeAt int64, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.DeletionScheduledAt = &purgeAt
+		user.DeletionTokenHash = &tokenHash
+	}
+	return nil
+}
+
+func (s *Store) GetUserByDeletionToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.DeletionTokenHash != nil && *user.DeletionTokenHash == tokenHash {
+			return copyUser(user), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) CancelDeletion(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.DeletionScheduledAt = nil
+		user.DeletionTokenHash = nil
+	}
+	return nil
+}
+
+func (s *Store) SetEmailChangeToken(ctx context.Context, userID int64, newEmail, tokenHash string, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.PendingEmail = &newEmail
+		user.EmailChangeTokenHash = &tokenHash
+		user.EmailChangeTokenExpires = &expiresAt
+	}
+	return nil
+}
+
+func (s *Store) GetUserByEmailChangeToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.EmailChangeTokenHash != nil && *user.EmailChangeTokenHash == tokenHash {
+			return copyUser(user), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) ChangeEmail(ctx context.Context, userID int64, email string, verifiedAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, user := range s.users {
+		if id != userID && user.Email == email {
+			return store.ErrAlreadyExists
+		}
+	}
+	if user, ok := s.users[userID]; ok {
+		user.Email = email
+		user.VerifiedAt = &verifiedAt
+		user.PendingEmail = nil
+		user.EmailChangeTokenHash = nil
+		user.EmailChangeTokenExpires = nil
+	}
+	return nil
+}
+
+// Invites
+
+func (s *Store) CreateInvite(ctx context.Context, invite *model.Invite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.invites {
+		if existing.ID == invite.ID || existing.CodeHash == invite.CodeHash {
+			return store.ErrAlreadyExists
+		}
+	}
+	stored := *invite
+	s.invites[invite.ID] = &stored
+	return nil
+}
+
+func (s *Store) ListInvites(ctx context.Context) ([]model.Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var invites []model.Invite
+	for _, invite := range s.invites {
+		invites = append(invites, *invite)
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		if invites[i].CreatedAt != invites[j].CreatedAt {
+			return invites[i].CreatedAt > invites[j].CreatedAt
+		}
+		return invites[i].ID < invites[j].ID
+	})
+	return invites, nil
+}
+
+func (s *Store) RevokeInvite(ctx context.Context, id string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invite, ok := s.invites[id]
+	if !ok || invite.RevokedAt != nil {
+		return store.ErrNotFound
+	}
+	invite.RevokedAt = &now
+	return nil
+}
+
+func (s *Store) CreateUserWithInvite(ctx context.Context, email, passwordHash, codeHash string, now int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return 0, store.ErrAlreadyExists
+		}
+	}
+	var invite *model.Invite
+	for _, candidate := range s.invites {
+		if candidate.CodeHash == codeHash {
+			invite = candidate
+		}
+	}
+	if invite == nil || invite.RevokedAt != nil || invite.Uses >= invite.MaxUses || (invite.ExpiresAt != nil && *invite.ExpiresAt <= now) {
+		return 0, store.ErrInvalidInvite
+	}
+
+	invite.Uses++
+	s.lastUserID++
+	s.users[s.lastUserID] = &model.User{ID: s.lastUserID, Email: email, PasswordHash: passwordHash, Role: store.RoleUser, CreatedAt: time.Now().Unix()}
+	return s.lastUserID, nil
+}
+
+// Sessions
+
+func (s *Store) CreateSession(ctx context.Context, session *model.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.UserID]; !ok {
+		return store.ErrNotFound
+	}
+	if _, ok := s.sessions[session.ID]; ok {
+		return store.ErrAlreadyExists
+	}
+	stored := *session
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (s *Store) GetSession(ctx context.Context, id string) (*model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	c := *session
+	return &c, nil
+}
+
+func (s *Store) RotateSession(ctx context.Context, refreshTokenHash, newRefreshTokenHash string, now, expiresAt int64) (*model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.RefreshTokenHash == refreshTokenHash {
+			if session.RevokedAt != nil || session.ExpiresAt <= now {
+				return nil, store.ErrNotFound
+			}
+			if user, ok := s.users[session.UserID]; !ok || user.DisabledAt != nil {
+				return nil, store.ErrNotFound
+			}
+			previous := refreshTokenHash
+			session.PreviousRefreshTokenHash = &previous
+			session.RefreshTokenHash = newRefreshTokenHash
+			session.RefreshedAt = now
+			session.ExpiresAt = expiresAt
+			c := *session
+			return &c, nil
+		}
+	}
+
+	// A rotated token presented again means two parties hold it.
+	for _, session := range s.sessions {
+		if session.PreviousRefreshTokenHash != nil && *session.PreviousRefreshTokenHash == refreshTokenHash && session.RevokedAt == nil {
+			revokedAt := now
+			session.RevokedAt = &revokedAt
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) ListSessions(ctx context.Context, userID int64, now int64) ([]model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sessions []model.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt > now {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].LastSeenAt != sessions[j].LastSeenAt {
+			return sessions[i].LastSeenAt > sessions[j].LastSeenAt
+		}
+		return sessions[i].CreatedAt > sessions[j].CreatedAt
+	})
+	return sessions, nil
+}
+
+func (s *Store) ListAllSessions(ctx context.Context, userID int64) ([]model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sessions []model.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt != sessions[j].CreatedAt {
+			return sessions[i].CreatedAt < sessions[j].CreatedAt
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (s *Store) RenewSession(ctx context.Context, id, refreshTokenHash string, now, expiresAt int64, ipAddress string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.RevokedAt != nil || session.ExpiresAt <= now {
+		return store.ErrNotFound
+	}
+	session.RefreshTokenHash = refreshTokenHash
+	session.PreviousRefreshTokenHash = nil
+	session.RefreshedAt = now
+	session.ExpiresAt = expiresAt
+	session.IPAddress = ipAddress
+	session.LastSeenAt = now
+	return nil
+}
+
+func (s *Store) TouchSession(ctx context.Context, id string, now int64, ipAddress, userAgent string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok {
+		session.LastSeenAt = now
+		session.IPAddress = ipAddress
+		session.UserAgent = userAgent
+	}
+	return nil
+}
+
+func (s *Store) RecordSessionSync(ctx context.Context, id string, resource string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	switch resource {
+	case store.SyncResourceHistory:
+		session.LastHistorySyncAt = &now
+	case store.SyncResourceFavourites:
+		session.LastFavouritesSyncAt = &now
+	case store.SyncResourceBookmarks:
+		session.LastBookmarksSyncAt = &now
+	default:
+		return fmt.Errorf("unknown sync resource %q", resource)
+	}
+	return nil
+}
+
+func (s *Store) RevokeSession(ctx context.Context, userID int64, id string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.UserID != userID || session.RevokedAt != nil {
+		return store.ErrNotFound
+	}
+	session.RevokedAt = &now
+	return nil
+}
+
+func (s *Store) RevokeOtherSessions(ctx context.Context, userID int64, keepID string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID && id != keepID && session.RevokedAt == nil {
+			session.RevokedAt = copyInt64(&now)
+		}
+	}
+	return nil
+}
+
+// History
+
+func (s *Store) HistoryTimestamp(ctx context.Context, userID int64) (*int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		return copyInt64(user.HistorySyncTimestamp), nil
+	}
+	return nil, nil
+}
+
+func (s *Store) GetHistory(ctx context.Context, userID int64, since *int64) ([]model.History, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var history []model.History
+	for key, row := range s.history {
+		if key.userID != userID || (since != nil && row.modifiedAt <= *since) {
+			continue
+		}
+		item := row.History
+		item.Manga = s.mangaWithTags(item.MangaID)
+		history = append(history, item)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].MangaID < history[j].MangaID
+	})
+	return history, nil
+}
+
+func (s *Store) SyncHistory(ctx context.Context, userID int64, sessionID string, history []model.History, expected []int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncHistory(userID, sessionID, history, expected)
+}
+
+func (s *Store) syncHistory(userID int64, sessionID string, history []model.History, expected []int64) (int64, error) {
+	user, ok := s.users[userID]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	now, err := reserveSyncTimestamp(&user.HistorySyncTimestamp, expected)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range history {
+		if item.Manga != nil {
+			s.upsertManga(item.Manga)
+		}
+		key := historyKey{userID: userID, mangaID: item.MangaID}
+		current, ok := s.history[key]
+		if ok && item.UpdatedAt < current.UpdatedAt {
+			continue
+		}
+		moved := !ok || current.DeletedAt != 0 || item.ChapterID != current.ChapterID || item.Page != current.Page
+		if item.DeletedAt == 0 && moved {
+			s.lastEventID++
+			event := model.ReadingEvent{
+				ID: s.lastEventID, UserID: userID, MangaID: item.MangaID,
+				ChapterID: item.ChapterID, Page: item.Page, Percent: item.Percent, ReadAt: item.UpdatedAt,
+			}
+			if sessionID != "" {
+				event.SessionID = &sessionID
+			}
+			s.readingEvents = append(s.readingEvents, event)
+		}
+		item.UserID = userID
+		item.Manga = nil
+		s.history[key] = historyRow{History: item, modifiedAt: now}
+	}
+	return now, nil
+}
+
+// Reading log
+
+func (s *Store) ListReadingEvents(ctx context.Context, userID int64, filter store.ReadingEventFilter) ([]model.ReadingEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []model.ReadingEvent
+	for _, event := range s.readingEvents {
+		if event.UserID != userID ||
+			(filter.From != 0 && event.ReadAt < filter.From) ||
+			(filter.To != 0 && event.ReadAt >= filter.To) ||
+			(filter.MangaID != 0 && event.MangaID != filter.MangaID) {
+			continue
+		}
+		if event.SessionID != nil {
+			if session, ok := s.sessions[*event.SessionID]; ok {
+				name := session.DeviceName
+				event.DeviceName = &name
+			}
+		}
+		event.Manga = s.mangaWithTags(event.MangaID)
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].ReadAt != events[j].ReadAt {
+			return events[i].ReadAt > events[j].ReadAt
+		}
+		return events[i].ID > events[j].ID
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// Stats
+
+const dayMillis = 24 * 60 * 60 * 1000
+
+func (s *Store) ReadingStats(ctx context.Context, userID int64, now int64) (*model.ReadingStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := &model.ReadingStats{
+		Sources:  []model.SourceStats{},
+		Tags:     []model.TagStats{},
+		Activity: model.ReadingActivity{Days: []model.ActivityStats{}, Weeks: []model.ActivityStats{}},
+	}
+
+	var chaptersRead float64
+	sourceChapters := make(map[string]float64)
+	sources := make(map[string]*model.SourceStats)
+	tags := make(map[int64]*model.TagStats)
+	for key, row := range s.history {
+		if key.userID != userID || row.DeletedAt != 0 {
+			continue
+		}
+		completed := 0
+		if row.Percent >= store.CompletedPercent {
+			completed = 1
+		}
+		chapters := 0.0
+		if row.Percent > 0 {
+			chapters = row.Percent * float64(row.Chapters)
+		}
+		stats.Totals.MangaStarted++
+		stats.Totals.MangaCompleted += completed
+		chaptersRead += chapters
+
+		if source := s.manga[key.mangaID].Source; source != "" {
+			if sources[source] == nil {
+				sources[source] = &model.SourceStats{Source: source}
+			}
+			sources[source].MangaStarted++
+			sources[source].MangaCompleted += completed
+			sourceChapters[source] += chapters
+		}
+		for tagID := range s.mangaTags[key.mangaID] {
+			if tags[tagID] == nil {
+				tag := s.tags[tagID]
+				tags[tagID] = &model.TagStats{ID: tag.ID, Title: tag.Title, Source: tag.Source}
+			}
+			tags[tagID].MangaStarted++
+		}
+	}
+	stats.Totals.ChaptersRead = int64(math.Round(chaptersRead))
+
+	for _, source := range sources {
+		source.ChaptersRead = int64(math.Round(sourceChapters[source.Source]))
+		stats.Sources = append(stats.Sources, *source)
+	}
+	sort.Slice(stats.Sources, func(i, j int) bool {
+		a, b := stats.Sources[i], stats.Sources[j]
+		if a.MangaStarted != b.MangaStarted {
+			return a.MangaStarted > b.MangaStarted
+		}
+		return a.Source < b.Source
+	})
+	for _, tag := range tags {
+		stats.Tags = append(stats.Tags, *tag)
+	}
+	sort.Slice(stats.Tags, func(i, j int) bool {
+		a, b := stats.Tags[i], stats.Tags[j]
+		if a.MangaStarted != b.MangaStarted {
+			return a.MangaStarted > b.MangaStarted
+		}
+		return a.Title < b.Title
+	})
+	if len(stats.Tags) > store.StatsTags {
+		stats.Tags = stats.Tags[:store.StatsTags]
+	}
+
+	// Days and weeks are numbered like in sqlstore: day 0 is 1970-01-01, a
+	// Thursday, and weeks start three days earlier on Monday.
+	today := now / dayMillis
+	thisWeek := (today + 3) / 7
+	type bucket struct {
+		manga    map[int64]struct{}
+		chapters map[int64]struct{}
+	}
+	days := make(map[int64]*bucket)
+	weeks := make(map[int64]*bucket)
+	add := func(buckets map[int64]*bucket, key int64, event model.ReadingEvent) {
+		if buckets[key] == nil {
+			buckets[key] = &bucket{manga: make(map[int64]struct{}), chapters: make(map[int64]struct{})}
+		}
+		buckets[key].manga[event.MangaID] = struct{}{}
+		buckets[key].chapters[event.ChapterID] = struct{}{}
+	}
+	readingDays := make(map[int64]struct{})
+	for _, event := range s.readingEvents {
+		if event.UserID != userID || event.ReadAt <= 0 {
+			continue
+		}
+		day := event.ReadAt / dayMillis
+		readingDays[day] = struct{}{}
+		if day > today-store.StatsDays {
+			add(days, day, event)
+		}
+		if week := (day + 3) / 7; week > thisWeek-store.StatsWeeks {
+			add(weeks, week, event)
+		}
+	}
+	activity := func(buckets map[int64]*bucket, firstDay func(int64) int64) []model.ActivityStats {
+		keys := make([]int64, 0, len(buckets))
+		for key := range buckets {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		result := make([]model.ActivityStats, 0, len(keys))
+		for _, key := range keys {
+			result = append(result, model.ActivityStats{
+				Date:     time.UnixMilli(firstDay(key) * dayMillis).UTC().Format(time.DateOnly),
+				Manga:    len(buckets[key].manga),
+				Chapters: len(buckets[key].chapters),
+			})
+		}
+		return result
+	}
+	stats.Activity.Days = activity(days, func(day int64) int64 { return day })
+	stats.Activity.Weeks = activity(weeks, func(week int64) int64 { return week*7 - 3 })
+
+	sorted := make([]int64, 0, len(readingDays))
+	for day := range readingDays {
+		sorted = append(sorted, day)
+	}
+	slices.Sort(sorted)
+	run := 0
+	for i, day := range sorted {
+		if i > 0 && sorted[i-1] == day-1 {
+			run++
+		} else {
+			run = 1
+		}
+		stats.Streaks.Longest = max(stats.Streaks.Longest, run)
+		if i == len(sorted)-1 && day >= today-1 {
+			stats.Streaks.Current = run
+		}
+	}
+	return stats, nil
+}
+
+// Favourites and categories
+
+func (s *Store) FavouritesTimestamp(ctx context.Context, userID int64) (*int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		return copyInt64(user.FavouritesSyncTimestamp), nil
+	}
+	return nil, nil
+}
+
+func (s *Store) GetFavourites(ctx context.Context, userID int64, since *int64) ([]model.Favourite, []model.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var categories []model.Category
+	for key, row := range s.categories {
+		if key.userID != userID || (since != nil && row.modifiedAt <= *since) {
+			continue
+		}
+		categories = append(categories, row.Category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].ID < categories[j].ID
+	})
+
+	var favourites []model.Favourite
+	for key, row := range s.favourites {
+		if key.userID != userID || (since != nil && row.modifiedAt <= *since) {
+			continue
+		}
+		fav := row.Favourite
+		fav.Manga = s.mangaWithTags(fav.MangaID)
+		favourites = append(favourites, fav)
+	}
+	sort.Slice(favourites, func(i, j int) bool {
+		if favourites[i].MangaID != favourites[j].MangaID {
+			return favourites[i].MangaID < favourites[j].MangaID
+		}
+		return favourites[i].CategoryID < favourites[j].CategoryID
+	})
+
+	return favourites, categories, nil
+}
+
+func (s *Store) SyncFavourites(ctx context.Context, userID int64, categories []model.Category, favourites []model.Favourite, expected []int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncFavourites(userID, categories, favourites, expected)
+}
+
+func (s *Store) syncFavourites(userID int64, categories []model.Category, favourites []model.Favourite, expected []int64) (int64, error) {
+	user, ok := s.users[userID]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	now, err := reserveSyncTimestamp(&user.FavouritesSyncTimestamp, expected)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, cat := range categories {
+		key := categoryKey{userID: userID, categoryID: cat.ID}
+		if current, ok := s.categories[key]; ok && !categoryWins(cat, current.Category) {
+			continue
+		}
+		cat.UserID = userID
+		s.categories[key] = categoryRow{Category: cat, modifiedAt: now}
+	}
+
+	for _, fav := range favourites {
+		if fav.Manga != nil {
+			s.upsertManga(fav.Manga)
+		} else if _, ok := s.manga[fav.MangaID]; !ok && fav.MangaID != 0 {
+			// Placeholder matching the one sqlstore inserts for the foreign key.
+			s.manga[fav.MangaID] = model.Manga{ID: fav.MangaID, Rating: -1}
+		}
+
+		// Favourites may reference categories that have not been synced yet.
+		catKey := categoryKey{userID: userID, categoryID: fav.CategoryID}
+		if _, ok := s.categories[catKey]; !ok {
+			deletedAt := int64(0)
+			s.categories[catKey] = categoryRow{
+				Category: model.Category{
+					ID: fav.CategoryID, UserID: userID, Title: "Unknown", Order: "NEWEST",
+					Track: true, ShowInLib: true, DeletedAt: &deletedAt,
+				},
+				modifiedAt: now,
+			}
+		}
+
+		key := favouriteKey{userID: userID, mangaID: fav.MangaID, categoryID: fav.CategoryID}
+		if current, ok := s.favourites[key]; ok && !favouriteWins(fav, current.Favourite) {
+			continue
+		}
+		fav.UserID = userID
+		fav.Manga = nil
+		s.favourites[key] = favouriteRow{Favourite: fav, modifiedAt: now}
+	}
+	return now, nil
+}
+
+func (s *Store) SearchLibrary(ctx context.Context, userID int64, query store.LibraryQuery) ([]model.LibraryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byManga := make(map[int64]*model.LibraryEntry)
+	for key, row := range s.favourites {
+		if key.userID != userID || row.DeletedAt != 0 {
+			continue
+		}
+		if (query.CategoryID != 0 && key.categoryID != query.CategoryID) || (query.Pinned != nil && row.Pinned != *query.Pinned) {
+			continue
+		}
+		entry := byManga[key.mangaID]
+		if entry == nil {
+			entry = &model.LibraryEntry{AddedAt: row.CreatedAt}
+			byManga[key.mangaID] = entry
+		}
+		entry.AddedAt = min(entry.AddedAt, row.CreatedAt)
+	}
+
+	var entries []model.LibraryEntry
+	for mangaID, entry := range byManga {
+		manga := s.mangaWithTags(mangaID)
+		if manga == nil || !libraryMatches(manga, query) {
+			continue
+		}
+		entry.Manga = manga
+		entries = append(entries, *entry)
+	}
+
+	// Sort ascending by the sort key and the manga ID, then reverse for
+	// the descending orders, like the SQL ORDER BY.
+	compare := func(a, b model.LibraryEntry) int {
+		switch query.Sort {
+		case store.LibraryAlphabetic, store.LibraryAlphabeticReverse:
+			if c := strings.Compare(a.Manga.Title, b.Manga.Title); c != 0 {
+				return c
+			}
+		case store.LibraryRating:
+			if a.Manga.Rating != b.Manga.Rating {
+				return cmp.Compare(a.Manga.Rating, b.Manga.Rating)
+			}
+		default:
+			if a.AddedAt != b.AddedAt {
+				return cmp.Compare(a.AddedAt, b.AddedAt)
+			}
+		}
+		return cmp.Compare(a.Manga.ID, b.Manga.ID)
+	}
+	descending := query.Sort != store.LibraryOldest && query.Sort != store.LibraryAlphabetic
+	slices.SortFunc(entries, func(a, b model.LibraryEntry) int {
+		if descending {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+
+	if query.After != nil {
+		after := model.LibraryEntry{
+			Manga:   &model.Manga{ID: query.After.MangaID, Title: query.After.Title, Rating: query.After.Rating},
+			AddedAt: query.After.AddedAt,
+		}
+		i := 0
+		for i < len(entries) {
+			c := compare(entries[i], after)
+			if (descending && c < 0) || (!descending && c > 0) {
+				break
+			}
+			i++
+		}
+		entries = entries[i:]
+	}
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+
+	for i := range entries {
+		entries[i].Categories = []int64{}
+		for key, row := range s.favourites {
+			if key.userID == userID && key.mangaID == entries[i].Manga.ID && row.DeletedAt == 0 {
+				entries[i].Categories = append(entries[i].Categories, key.categoryID)
+				entries[i].Pinned = entries[i].Pinned || row.Pinned
+			}
+		}
+		slices.Sort(entries[i].Categories)
+	}
+	return entries, nil
+}
+
+func (s *Store) ListCategories(ctx context.Context, userID int64) ([]model.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var categories []model.Category
+	for key, row := range s.categories {
+		if key.userID != userID || (row.DeletedAt != nil && *row.DeletedAt != 0) {
+			continue
+		}
+		categories = append(categories, row.Category)
+	}
+	slices.SortFunc(categories, func(a, b model.Category) int {
+		if a.SortKey != b.SortKey {
+			return cmp.Compare(a.SortKey, b.SortKey)
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return categories, nil
+}
+
+func (s *Store) CategoryIDs(ctx context.Context, userID int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int64
+	for key := range s.categories {
+		if key.userID == userID {
+			ids = append(ids, key.categoryID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *Store) AddManga(ctx context.Context, manga []model.Manga) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range manga {
+		if _, ok := s.manga[m.ID]; ok {
+			continue
+		}
+		links := make(map[int64]struct{}, len(m.Tags))
+		for _, tag := range m.Tags {
+			if _, ok := s.tags[tag.ID]; !ok {
+				s.tags[tag.ID] = tag
+			}
+			links[tag.ID] = struct{}{}
+		}
+		s.mangaTags[m.ID] = links
+		m.Tags = nil
+		s.manga[m.ID] = m
+	}
+	return nil
+}
+
+// libraryMatches applies the manga filters of a library query. Search terms
+// have to be prefixes of words in the title, alternative title or author.
+func libraryMatches(manga *model.Manga, query store.LibraryQuery) bool {
+	if query.Source != "" && manga.Source != query.Source {
+		return false
+	}
+	if query.State != "" && (manga.State == nil || *manga.State != query.State) {
+		return false
+	}
+	if query.NSFW != nil && (manga.NSFW != nil && *manga.NSFW) != *query.NSFW {
+		return false
+	}
+	if query.TagKey != "" && !slices.ContainsFunc(manga.Tags, func(tag model.Tag) bool { return tag.Key == query.TagKey }) {
+		return false
+	}
+
+	text := manga.Title
+	if manga.AltTitle != nil {
+		text += " " + *manga.AltTitle
+	}
+	if manga.Author != nil {
+		text += " " + *manga.Author
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, term := range query.Terms {
+		if !slices.ContainsFunc(words, func(word string) bool { return strings.HasPrefix(word, term) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bookmarks
+
+func (s *Store) BookmarksTimestamp(ctx context.Context, userID int64) (*int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		return copyInt64(user.BookmarksSyncTimestamp), nil
+	}
+	return nil, nil
+}
+
+func (s *Store) GetBookmarks(ctx context.Context, userID int64, since *int64) ([]model.Bookmark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bookmarks []model.Bookmark
+	for key, row := range s.bookmarks {
+		if key.userID != userID || (since != nil && row.modifiedAt <= *since) {
+			continue
+		}
+		item := row.Bookmark
+		item.Manga = s.mangaWithTags(item.MangaID)
+		bookmarks = append(bookmarks, item)
+	}
+	sort.Slice(bookmarks, func(i, j int) bool {
+		if bookmarks[i].MangaID != bookmarks[j].MangaID {
+			return bookmarks[i].MangaID < bookmarks[j].MangaID
+		}
+		return bookmarks[i].PageID < bookmarks[j].PageID
+	})
+	return bookmarks, nil
+}
+
+func (s *Store) SyncBookmarks(ctx context.Context, userID int64, bookmarks []model.Bookmark, expected []int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncBookmarks(userID, bookmarks, expected)
+}
+
+func (s *Store) syncBookmarks(userID int64, bookmarks []model.Bookmark, expected []int64) (int64, error) {
+	user, ok := s.users[userID]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	now, err := reserveSyncTimestamp(&user.BookmarksSyncTimestamp, expected)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range bookmarks {
+		if item.Manga != nil {
+			s.upsertManga(item.Manga)
+		} else if _, ok := s.manga[item.MangaID]; !ok {
+			s.manga[item.MangaID] = model.Manga{ID: item.MangaID, Rating: -1}
+		}
+		key := bookmarkKey{userID: userID, mangaID: item.MangaID, pageID: item.PageID}
+		if current, ok := s.bookmarks[key]; ok && !bookmarkWins(item, current.Bookmark) {
+			continue
+		}
+		item.UserID = userID
+		item.Manga = nil
+		s.bookmarks[key] = bookmarkRow{Bookmark: item, modifiedAt: now}
+	}
+	return now, nil
+}
+
+// Imports
+
+func (s *Store) ImportLibrary(ctx context.Context, userID int64, data store.LibraryImport) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return nil, store.ErrNotFound
+	}
+	timestamps := make(map[string]int64)
+	if len(data.Categories) > 0 || len(data.Favourites) > 0 {
+		now, err := s.syncFavourites(userID, data.Categories, data.Favourites, nil)
+		if err != nil {
+			return nil, err
+		}
+		timestamps[store.SyncResourceFavourites] = now
+	}
+	if len(data.History) > 0 {
+		now, err := s.syncHistory(userID, "", data.History, nil)
+		if err != nil {
+			return nil, err
+		}
+		timestamps[store.SyncResourceHistory] = now
+	}
+	if len(data.Bookmarks) > 0 {
+		now, err := s.syncBookmarks(userID, data.Bookmarks, nil)
+		if err != nil {
+			return nil, err
+		}
+		timestamps[store.SyncResourceBookmarks] = now
+	}
+	return timestamps, nil
+}
+
+// Helpers
+
+// reserveSyncTimestamp advances a user's sync timestamp the same way sqlstore
+// does: to the current time, or by one if that would not move it forward.
+func reserveSyncTimestamp(timestamp **int64, expected []int64) (int64, error) {
+	var current int64
+	if *timestamp != nil {
+		current = **timestamp
+	}
+	if expected != nil && !slices.Contains(expected, current) {
+		return 0, store.ErrPreconditionFailed
+	}
+
+	now := time.Now().UnixMilli()
+	if current >= now {
+		now = current + 1
+	}
+	*timestamp = &now
+	return now, nil
+}
+
+func categoryWins(incoming, current model.Category) bool {
+	if incoming.CreatedAt != current.CreatedAt {
+		return incoming.CreatedAt > current.CreatedAt
+	}
+	return derefInt64(incoming.DeletedAt) >= derefInt64(current.DeletedAt)
+}
+
+func favouriteWins(incoming, current model.Favourite) bool {
+	if incoming.CreatedAt != current.CreatedAt {
+		return incoming.CreatedAt > current.CreatedAt
+	}
+	return incoming.DeletedAt > current.DeletedAt
+}
+
+func bookmarkWins(incoming, current model.Bookmark) bool {
+	if incoming.CreatedAt != current.CreatedAt {
+		return incoming.CreatedAt > current.CreatedAt
+	}
+	return incoming.DeletedAt > current.DeletedAt
+}
+
+// Exports
+
+func (s *Store) CreateExport(ctx context.Context, export *model.Export) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.exports[export.ID]; ok {
+		return store.ErrAlreadyExists
+	}
+	e := *export
+	s.exports[export.ID] = &e
+	return nil
+}
+
+func (s *Store) GetExport(ctx context.Context, id string) (*model.Export, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	export, ok := s.exports[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	e := *export
+	return &e, nil
+}
+
+func (s *Store) GetExportByToken(ctx context.Context, tokenHash string) (*model.Export, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, export := range s.exports {
+		if export.TokenHash == tokenHash {
+			e := *export
+			return &e, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) GetPendingExport(ctx context.Context, userID int64) (*model.Export, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending *model.Export
+	for _, export := range s.exports {
+		if export.UserID == userID && export.Status == store.ExportPending && (pending == nil || export.CreatedAt > pending.CreatedAt) {
+			pending = export
+		}
+	}
+	if pending == nil {
+		return nil, store.ErrNotFound
+	}
+	e := *pending
+	return &e, nil
+}
+
+func (s *Store) FinishExport(ctx context.Context, id string, status string, size int64, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if export, ok := s.exports[id]; ok {
+		export.Status = status
+		export.Size = size
+		export.CompletedAt = &now
+	}
+	return nil
+}
+
+func (s *Store) DeleteExpiredExports(ctx context.Context, now int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, export := range s.exports {
+		if export.ExpiresAt <= now {
+			delete(s.exports, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *Store) CountLibraryEntries(ctx context.Context, userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for key := range s.history {
+		if key.userID == userID {
+			count++
+		}
+	}
+	for key := range s.favourites {
+		if key.userID == userID {
+			count++
+		}
+	}
+	for key := range s.bookmarks {
+		if key.userID == userID {
+			count++
+		}
+	}
+	for _, event := range s.readingEvents {
+		if event.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Webhooks
+
+func (s *Store) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[webhook.ID]; ok {
+		return store.ErrAlreadyExists
+	}
+	w := *webhook
+	w.Events = slices.Clone(webhook.Events)
+	s.webhooks[w.ID] = &w
+	return nil
+}
+
+func (s *Store) ListWebhooks(ctx context.Context, userID int64) ([]model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks := []model.Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.UserID == userID {
+			w := *webhook
+			w.Events = slices.Clone(webhook.Events)
+			webhooks = append(webhooks, w)
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b model.Webhook) int {
+		return cmp.Or(cmp.Compare(a.CreatedAt, b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	return webhooks, nil
+}
+
+func (s *Store) GetWebhook(ctx context.Context, userID int64, id string) (*model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return nil, store.ErrNotFound
+	}
+	w := *webhook
+	w.Events = slices.Clone(webhook.Events)
+	return &w, nil
+}
+
+func (s *Store) UpdateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.webhooks[webhook.ID]; ok && current.UserID == webhook.UserID {
+		current.URL = webhook.URL
+		current.Events = slices.Clone(webhook.Events)
+		current.Enabled = webhook.Enabled
+	}
+	return nil
+}
+
+func (s *Store) DeleteWebhook(ctx context.Context, userID int64, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return store.ErrNotFound
+	}
+	delete(s.webhooks, id)
+	s.webhookDeliveries = slices.DeleteFunc(s.webhookDeliveries, func(delivery *model.WebhookDelivery) bool {
+		return delivery.WebhookID == id
+	})
+	return nil
+}
+
+func (s *Store) EnqueueWebhookDeliveries(ctx context.Context, userID int64, event, payload string, now int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := 0
+	for _, webhook := range s.webhooks {
+		if webhook.UserID != userID || !webhook.Enabled || !slices.Contains(webhook.Events, event) {
+			continue
+		}
+		s.lastDeliveryID++
+		s.webhookDeliveries = append(s.webhookDeliveries, &model.WebhookDelivery{
+			ID:            s.lastDeliveryID,
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        store.WebhookPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		queued++
+	}
+	return queued, nil
+}
+
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID string, limit int) ([]model.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []model.WebhookDelivery{}
+	for _, delivery := range slices.Backward(s.webhookDeliveries) {
+		if len(deliveries) == limit {
+			break
+		}
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil int64, limit int) ([]model.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*model.WebhookDelivery
+	for _, delivery := range s.webhookDeliveries {
+		webhook := s.webhooks[delivery.WebhookID]
+		if delivery.Status == store.WebhookPending && delivery.NextAttemptAt <= now && webhook != nil && webhook.Enabled {
+			due = append(due, delivery)
+		}
+	}
+	slices.SortStableFunc(due, func(a, b *model.WebhookDelivery) int {
+		return cmp.Compare(a.NextAttemptAt, b.NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := []model.WebhookDelivery{}
+	for _, delivery := range due {
+		delivery.NextAttemptAt = leaseUntil
+		d := *delivery
+		w := *s.webhooks[d.WebhookID]
+		w.Events = slices.Clone(w.Events)
+		d.Webhook = &w
+		claimed = append(claimed, d)
+	}
+	return claimed, nil
+}
+
+func (s *Store) FinishWebhookAttempt(ctx context.Context, delivery *model.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, current := range s.webhookDeliveries {
+		if current.ID == delivery.ID {
+			current.Status = delivery.Status
+			current.Attempts = delivery.Attempts
+			current.NextAttemptAt = delivery.NextAttemptAt
+			current.LastAttemptAt = delivery.LastAttemptAt
+			current.ResponseStatus = delivery.ResponseStatus
+			current.LastError = delivery.LastError
+		}
+	}
+	return nil
+}
+
+func (s *Store) DeleteWebhookDeliveries(ctx context.Context, before int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := len(s.webhookDeliveries)
+	s.webhookDeliveries = slices.DeleteFunc(s.webhookDeliveries, func(delivery *model.WebhookDelivery) bool {
+		return delivery.CreatedAt < before
+	})
+	return count - len(s.webhookDeliveries), nil
+}
+
+// Admin
+
+func (s *Store) SearchUsers(ctx context.Context, filter store.UserFilter) ([]model.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := strings.ToLower(filter.Query)
+	var users []*model.User
+	for _, user := range s.users {
+		switch {
+		case user.ID <= filter.AfterID:
+		case query != "" && !strings.Contains(strings.ToLower(user.Email), query):
+		case filter.Role != "" && user.Role != filter.Role:
+		case filter.Disabled != nil && *filter.Disabled != (user.DisabledAt != nil):
+		default:
+			users = append(users, user)
+		}
+	}
+	slices.SortFunc(users, func(a, b *model.User) int { return cmp.Compare(a.ID, b.ID) })
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+
+	summaries := []model.UserSummary{}
+	for _, user := range users {
+		summaries = append(summaries, s.userSummary(user))
+	}
+	return summaries, nil
+}
+
+func (s *Store) GetUserSummary(ctx context.Context, userID int64) (*model.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	summary := s.userSummary(user)
+	return &summary, nil
+}
+
+func (s *Store) userSummary(user *model.User) model.UserSummary {
+	summary := model.UserSummary{User: *user}
+	for key, row := range s.history {
+		if key.userID == user.ID && row.DeletedAt == 0 {
+			summary.History++
+		}
+	}
+	favourites := make(map[int64]bool)
+	for key, row := range s.favourites {
+		if key.userID == user.ID && row.DeletedAt == 0 {
+			favourites[key.mangaID] = true
+		}
+	}
+	summary.Favourites = len(favourites)
+	for key, row := range s.bookmarks {
+		if key.userID == user.ID && row.DeletedAt == 0 {
+			summary.Bookmarks++
+		}
+	}
+	for _, session := range s.sessions {
+		if session.UserID == user.ID && (summary.LastSeenAt == nil || session.LastSeenAt > *summary.LastSeenAt) {
+			lastSeenAt := session.LastSeenAt
+			summary.LastSeenAt = &lastSeenAt
+		}
+	}
+	return summary
+}
+
+func (s *Store) ServerStats(ctx context.Context, now int64) (*model.ServerStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := &model.ServerStats{Users: len(s.users), Manga: len(s.manga), ReadingEvents: len(s.readingEvents), Webhooks: len(s.webhooks)}
+	for _, user := range s.users {
+		if user.VerifiedAt != nil {
+			stats.VerifiedUsers++
+		}
+		if user.DisabledAt != nil {
+			stats.DisabledUsers++
+		}
+		if user.Role == store.RoleAdmin {
+			stats.Admins++
+		}
+		if user.DeletionScheduledAt != nil {
+			stats.PendingDeletions++
+		}
+	}
+	lastSeen := make(map[int64]int64)
+	for _, session := range s.sessions {
+		if session.RevokedAt == nil && session.ExpiresAt > now {
+			stats.ActiveSessions++
+		}
+		lastSeen[session.UserID] = max(lastSeen[session.UserID], session.LastSeenAt)
+	}
+	for _, seenAt := range lastSeen {
+		if seenAt >= now-24*60*60 {
+			stats.ActiveUsers.Day++
+		}
+		if seenAt >= now-7*24*60*60 {
+			stats.ActiveUsers.Week++
+		}
+		if seenAt >= now-30*24*60*60 {
+			stats.ActiveUsers.Month++
+		}
+	}
+	for _, row := range s.history {
+		if row.DeletedAt == 0 {
+			stats.History++
+		}
+	}
+	for _, row := range s.favourites {
+		if row.DeletedAt == 0 {
+			stats.Favourites++
+		}
+	}
+	for _, row := range s.bookmarks {
+		if row.DeletedAt == 0 {
+			stats.Bookmarks++
+		}
+	}
+	return stats, nil
+}
+
+// Maintenance
+
+func (s *Store) PurgeDeletedUsers(ctx context.Context, now int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := make(map[int64]bool)
+	for id, user := range s.users {
+		if user.DeletionScheduledAt != nil && *user.DeletionScheduledAt <= now {
+			purged[id] = true
+			delete(s.users, id)
+		}
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+	s.removeUserData(purged)
+	return len(purged), nil
+}
+
+func (s *Store) PurgeSessions(ctx context.Context, before int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, session := range s.sessions {
+		if session.ExpiresAt <= before || (session.RevokedAt != nil && *session.RevokedAt <= before) {
+			delete(s.sessions, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *Store) PurgeUser(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.users, userID)
+	s.removeUserData(map[int64]bool{userID: true})
+	return nil
+}
+
+// removeUserData deletes what belongs to the purged users, and the manga and
+// tags nobody references anymore, like the cascades of the SQL schema.
+func (s *Store) removeUserData(purged map[int64]bool) {
+	for id, session := range s.sessions {
+		if purged[session.UserID] {
+			delete(s.sessions, id)
+		}
+	}
+	for id, export := range s.exports {
+		if purged[export.UserID] {
+			delete(s.exports, id)
+		}
+	}
+	for id, webhook := range s.webhooks {
+		if purged[webhook.UserID] {
+			delete(s.webhooks, id)
+		}
+	}
+	s.webhookDeliveries = slices.DeleteFunc(s.webhookDeliveries, func(delivery *model.WebhookDelivery) bool {
+		return s.webhooks[delivery.WebhookID] == nil
+	})
+	referenced := make(map[int64]bool)
+	for key := range s.history {
+		if purged[key.userID] {
+			delete(s.history, key)
+		} else {
+			referenced[key.mangaID] = true
+		}
+	}
+	for key := range s.categories {
+		if purged[key.userID] {
+			delete(s.categories, key)
+		}
+	}
+	for key := range s.favourites {
+		if purged[key.userID] {
+			delete(s.favourites, key)
+		} else {
+			referenced[key.mangaID] = true
+		}
+	}
+	for key := range s.bookmarks {
+		if purged[key.userID] {
+			delete(s.bookmarks, key)
+		} else {
+			referenced[key.mangaID] = true
+		}
+	}
+	events := s.readingEvents[:0]
+	for _, event := range s.readingEvents {
+		if !purged[event.UserID] {
+			events = append(events, event)
+			referenced[event.MangaID] = true
+		}
+	}
+	s.readingEvents = events
+
+	for mangaID := range s.manga {
+		if !referenced[mangaID] {
+			delete(s.manga, mangaID)
+			delete(s.mangaTags, mangaID)
+		}
+	}
+	usedTags := make(map[int64]bool)
+	for _, links := range s.mangaTags {
+		for tagID := range links {
+			usedTags[tagID] = true
+		}
+	}
+	for tagID := range s.tags {
+		if !usedTags[tagID] {
+			delete(s.tags, tagID)
+		}
+	}
+}
+
+// upsertManga stores the manga and its tags. Like the SQL tables, tag links
+// are only ever added.
+func (s *Store) upsertManga(manga *model.Manga) {
+	m := *manga
+	m.Tags = nil
+	s.manga[m.ID] = m
+
+	links := s.mangaTags[m.ID]
+	if links == nil {
+		links = make(map[int64]struct{})
+		s.mangaTags[m.ID] = links
+	}
+	for _, tag := range manga.Tags {
+		s.tags[tag.ID] = tag
+		links[tag.ID] = struct{}{}
+	}
+}
+
+func (s *Store) mangaWithTags(mangaID int64) *model.Manga {
+	m, ok := s.manga[mangaID]
+	if !ok {
+		return nil
+	}
+	for tagID := range s.mangaTags[mangaID] {
+		m.Tags = append(m.Tags, s.tags[tagID])
+	}
+	sort.Slice(m.Tags, func(i, j int) bool {
+		return m.Tags[i].ID < m.Tags[j].ID
+	})
+	return &m
+}
+
+func copyUser(user *model.User) *model.User {
+	u := *user
+	u.FavouritesSyncTimestamp = copyInt64(user.FavouritesSyncTimestamp)
+	u.HistorySyncTimestamp = copyInt64(user.HistorySyncTimestamp)
+	u.BookmarksSyncTimestamp = copyInt64(user.BookmarksSyncTimestamp)
+	u.VerifiedAt = copyInt64(user.VerifiedAt)
+	return &u
+}
+
+func copyInt64(v *int64) *int64 {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	return &c
+}
+
+func derefInt64(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
@@ -0,0 +1,134 @@
+package sqlstore
+
+import (
+	"context"
+	"strings"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+func (s *Store) SearchUsers(ctx context.Context, filter store.UserFilter) ([]model.UserSummary, error) {
+	conditions := []string{"id > ?"}
+	args := []any{filter.AfterID}
+	if filter.Query != "" {
+		conditions = append(conditions, "LOWER(email) LIKE ? ESCAPE '!'")
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(filter.Query))+"%")
+	}
+	if filter.Role != "" {
+		conditions = append(conditions, "role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.Disabled != nil {
+		if *filter.Disabled {
+			conditions = append(conditions, "disabled_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "disabled_at IS NULL")
+		}
+	}
+	clauses := "WHERE " + strings.Join(conditions, " AND ") + " ORDER BY id"
+	if filter.Limit > 0 {
+		clauses += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	users, err := s.db.QueryUsers(ctx, clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.summarizeUsers(ctx, users)
+}
+
+func (s *Store) GetUserSummary(ctx context.Context, userID int64) (*model.UserSummary, error) {
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	summaries, err := s.summarizeUsers(ctx, []model.User{*user})
+	if err != nil {
+		return nil, err
+	}
+	return &summaries[0], nil
+}
+
+// summarizeUsers counts the library rows and finds the last session activity
+// of the users, with one grouped query per table.
+func (s *Store) summarizeUsers(ctx context.Context, users []model.User) ([]model.UserSummary, error) {
+	summaries := make([]model.UserSummary, len(users))
+	if len(users) == 0 {
+		return summaries, nil
+	}
+	index := make(map[int64]*model.UserSummary, len(users))
+	ids := make([]any, len(users))
+	for i, user := range users {
+		summaries[i].User = user
+		index[user.ID] = &summaries[i]
+		ids[i] = user.ID
+	}
+
+	in := "user_id IN (" + makePlaceholders(len(ids)) + ")"
+	counts := []struct {
+		query string
+		set   func(summary *model.UserSummary, value int64)
+	}{
+		{`SELECT user_id, COUNT(*) FROM history WHERE deleted_at = 0 AND ` + in + ` GROUP BY user_id`,
+			func(summary *model.UserSummary, value int64) { summary.History = int(value) }},
+		{`SELECT user_id, COUNT(DISTINCT manga_id) FROM favourites WHERE deleted_at = 0 AND ` + in + ` GROUP BY user_id`,
+			func(summary *model.UserSummary, value int64) { summary.Favourites = int(value) }},
+		{`SELECT user_id, COUNT(*) FROM bookmarks WHERE deleted_at = 0 AND ` + in + ` GROUP BY user_id`,
+			func(summary *model.UserSummary, value int64) { summary.Bookmarks = int(value) }},
+		{`SELECT user_id, MAX(last_seen_at) FROM sessions WHERE ` + in + ` GROUP BY user_id`,
+			func(summary *model.UserSummary, value int64) { summary.LastSeenAt = &value }},
+	}
+	for _, count := range counts {
+		rows, err := s.db.QueryContext(ctx, count.query, ids...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var userID, value int64
+			if err := rows.Scan(&userID, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			count.set(index[userID], value)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return summaries, nil
+}
+
+func (s *Store) ServerStats(ctx context.Context, now int64) (*model.ServerStats, error) {
+	const day = 24 * 60 * 60
+	var stats model.ServerStats
+	err := s.db.QueryRowContext(ctx, `SELECT
+	(SELECT COUNT(*) FROM users),
+	(SELECT COUNT(*) FROM users WHERE verified_at IS NOT NULL),
+	(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+	(SELECT COUNT(*) FROM users WHERE role = ?),
+	(SELECT COUNT(*) FROM users WHERE deletion_scheduled_at IS NOT NULL),
+	(SELECT COUNT(DISTINCT user_id) FROM sessions WHERE last_seen_at >= ?),
+	(SELECT COUNT(DISTINCT user_id) FROM sessions WHERE last_seen_at >= ?),
+	(SELECT COUNT(DISTINCT user_id) FROM sessions WHERE last_seen_at >= ?),
+	(SELECT COUNT(*) FROM sessions WHERE revoked_at IS NULL AND expires_at > ?),
+	(SELECT COUNT(*) FROM manga),
+	(SELECT COUNT(*) FROM history WHERE deleted_at = 0),
+	(SELECT COUNT(*) FROM favourites WHERE deleted_at = 0),
+	(SELECT COUNT(*) FROM bookmarks WHERE deleted_at = 0),
+	(SELECT COUNT(*) FROM reading_events),
+	(SELECT COUNT(*) FROM webhooks)`,
+		store.RoleAdmin, now-day, now-7*day, now-30*day, now).Scan(
+		&stats.Users, &stats.VerifiedUsers, &stats.DisabledUsers, &stats.Admins, &stats.PendingDeletions,
+		&stats.ActiveUsers.Day, &stats.ActiveUsers.Week, &stats.ActiveUsers.Month, &stats.ActiveSessions,
+		&stats.Manga, &stats.History, &stats.Favourites, &stats.Bookmarks, &stats.ReadingEvents, &stats.Webhooks)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// likeEscaper escapes the LIKE wildcards with the ESCAPE character '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
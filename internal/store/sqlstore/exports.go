@@ -0,0 +1,65 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const exportColumns = `id, user_id, token_hash, status, size, created_at, completed_at, expires_at`
+
+func (s *Store) CreateExport(ctx context.Context, export *model.Export) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO exports (`+exportColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		export.ID, export.UserID, export.TokenHash, export.Status, export.Size, export.CreatedAt, export.CompletedAt, export.ExpiresAt)
+	return err
+}
+
+func (s *Store) GetExport(ctx context.Context, id string) (*model.Export, error) {
+	export, err := scanExport(s.db.QueryRowContext(ctx, `SELECT `+exportColumns+` FROM exports WHERE id = ?`, id))
+	return export, notFound(err)
+}
+
+func (s *Store) GetExportByToken(ctx context.Context, tokenHash string) (*model.Export, error) {
+	export, err := scanExport(s.db.QueryRowContext(ctx, `SELECT `+exportColumns+` FROM exports WHERE token_hash = ?`, tokenHash))
+	return export, notFound(err)
+}
+
+func (s *Store) GetPendingExport(ctx context.Context, userID int64) (*model.Export, error) {
+	export, err := scanExport(s.db.QueryRowContext(ctx, `SELECT `+exportColumns+` FROM exports
+	WHERE user_id = ? AND status = ? ORDER BY created_at DESC LIMIT 1`, userID, store.ExportPending))
+	return export, notFound(err)
+}
+
+func (s *Store) FinishExport(ctx context.Context, id string, status string, size int64, now int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE exports SET status = ?, size = ?, completed_at = ? WHERE id = ?`, status, size, now, id)
+	return err
+}
+
+func (s *Store) DeleteExpiredExports(ctx context.Context, now int64) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM exports WHERE expires_at <= ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
+
+func (s *Store) CountLibraryEntries(ctx context.Context, userID int64) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT
+	(SELECT COUNT(*) FROM history WHERE user_id = ?) + (SELECT COUNT(*) FROM favourites WHERE user_id = ?) +
+	(SELECT COUNT(*) FROM bookmarks WHERE user_id = ?) + (SELECT COUNT(*) FROM reading_events WHERE user_id = ?)`,
+		userID, userID, userID, userID).Scan(&count)
+	return count, err
+}
+
+func scanExport(row scanner) (*model.Export, error) {
+	var export model.Export
+	err := row.Scan(&export.ID, &export.UserID, &export.TokenHash, &export.Status, &export.Size,
+		&export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
@@ -0,0 +1,80 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const inviteColumns = `id, code_hash, note, max_uses, uses, created_at, expires_at, revoked_at`
+
+func (s *Store) CreateInvite(ctx context.Context, invite *model.Invite) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO invites (`+inviteColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		invite.ID, invite.CodeHash, invite.Note, invite.MaxUses, invite.Uses, invite.CreatedAt, invite.ExpiresAt, invite.RevokedAt)
+	return err
+}
+
+func (s *Store) ListInvites(ctx context.Context) ([]model.Invite, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+inviteColumns+` FROM invites ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []model.Invite
+	for rows.Next() {
+		var invite model.Invite
+		if err := rows.Scan(&invite.ID, &invite.CodeHash, &invite.Note, &invite.MaxUses, &invite.Uses,
+			&invite.CreatedAt, &invite.ExpiresAt, &invite.RevokedAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (s *Store) RevokeInvite(ctx context.Context, id string, now int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE invites SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, now, id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) CreateUserWithInvite(ctx context.Context, email, passwordHash, codeHash string, now int64) (int64, error) {
+	var userID int64
+	err := s.withTxRetry(ctx, func(tx *db.Tx) error {
+		// The use count condition lets concurrent registrations race for the
+		// last use of an invite.
+		res, err := tx.ExecContext(ctx, `UPDATE invites SET uses = uses + 1
+		WHERE code_hash = ? AND revoked_at IS NULL AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)`,
+			codeHash, now)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return store.ErrInvalidInvite
+		}
+
+		// A taken email rolls the use of the invite back with the transaction.
+		userID, err = tx.CreateUser(ctx, email, passwordHash)
+		return alreadyExists(err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
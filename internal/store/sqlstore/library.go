@@ -0,0 +1,223 @@
+package sqlstore
+
+import (
+	"context"
+	"strings"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// searchVector is the expression indexed by idx_manga_search on Postgres.
+const searchVector = `to_tsvector('simple', m.title || ' ' || COALESCE(m.alt_title, '') || ' ' || COALESCE(m.author, ''))`
+
+func (s *Store) SearchLibrary(ctx context.Context, userID int64, query store.LibraryQuery) ([]model.LibraryEntry, error) {
+	// Favourite rows are folded into one row per manga before filtering on
+	// the manga, so a manga in several categories is listed once.
+	favourites := `SELECT manga_id, MIN(created_at) AS added_at FROM favourites WHERE user_id = ? AND deleted_at = 0`
+	args := []any{userID}
+	if query.CategoryID != 0 {
+		favourites += " AND category_id = ?"
+		args = append(args, query.CategoryID)
+	}
+	if query.Pinned != nil {
+		favourites += " AND pinned = ?"
+		args = append(args, *query.Pinned)
+	}
+	favourites += " GROUP BY manga_id"
+
+	var where []string
+	if len(query.Terms) > 0 {
+		condition, arg := s.searchCondition(query.Terms)
+		where = append(where, condition)
+		args = append(args, arg)
+	}
+	if query.Source != "" {
+		where = append(where, "m.source = ?")
+		args = append(args, query.Source)
+	}
+	if query.State != "" {
+		where = append(where, "m.state = ?")
+		args = append(args, query.State)
+	}
+	if query.NSFW != nil {
+		if *query.NSFW {
+			where = append(where, "m.nsfw = ?")
+		} else {
+			where = append(where, "(m.nsfw = ? OR m.nsfw IS NULL)")
+		}
+		args = append(args, *query.NSFW)
+	}
+	if query.TagKey != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM manga_tags mt JOIN tags t ON t.id = mt.tag_id WHERE mt.manga_id = m.id AND t.`key` = ?)")
+		args = append(args, query.TagKey)
+	}
+
+	column, op, order := "lib.added_at", "<", "DESC"
+	var after any
+	if query.After != nil {
+		after = query.After.AddedAt
+	}
+	switch query.Sort {
+	case store.LibraryOldest:
+		op, order = ">", "ASC"
+	case store.LibraryAlphabetic, store.LibraryAlphabeticReverse:
+		column = "m.title"
+		if query.Sort == store.LibraryAlphabetic {
+			op, order = ">", "ASC"
+		}
+		if query.After != nil {
+			after = query.After.Title
+		}
+	case store.LibraryRating:
+		column = "m.rating"
+		if query.After != nil {
+			after = query.After.Rating
+		}
+	}
+	if query.After != nil {
+		where = append(where, "("+column+" "+op+" ? OR ("+column+" = ? AND m.id "+op+" ?))")
+		args = append(args, after, after, query.After.MangaID)
+	}
+
+	sqlQuery := `SELECT m.id, lib.added_at FROM (` + favourites + `) lib JOIN manga m ON m.id = lib.manga_id`
+	if len(where) > 0 {
+		sqlQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlQuery += " ORDER BY " + column + " " + order + ", m.id " + order
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []model.LibraryEntry
+	var mangaIDs []int64
+	for rows.Next() {
+		var entry model.LibraryEntry
+		var mangaID int64
+		if err := rows.Scan(&mangaID, &entry.AddedAt); err != nil {
+			return nil, err
+		}
+		entry.Manga = &model.Manga{ID: mangaID}
+		entries = append(entries, entry)
+		mangaIDs = append(mangaIDs, mangaID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	mangaByID, err := s.fetchMangaMap(ctx, mangaIDs)
+	if err != nil {
+		return nil, err
+	}
+	categoryArgs := []any{userID}
+	for _, id := range mangaIDs {
+		categoryArgs = append(categoryArgs, id)
+	}
+	rows, err = s.db.QueryContext(ctx, `SELECT manga_id, category_id, pinned FROM favourites
+	WHERE user_id = ? AND deleted_at = 0 AND manga_id IN (`+makePlaceholders(len(mangaIDs))+`) ORDER BY category_id`, categoryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	index := make(map[int64]int, len(entries))
+	for i := range entries {
+		index[entries[i].Manga.ID] = i
+		entries[i].Manga = mangaByID[entries[i].Manga.ID]
+		entries[i].Categories = []int64{}
+	}
+	for rows.Next() {
+		var mangaID, categoryID int64
+		var pinned bool
+		if err := rows.Scan(&mangaID, &categoryID, &pinned); err != nil {
+			return nil, err
+		}
+		entry := &entries[index[mangaID]]
+		entry.Categories = append(entry.Categories, categoryID)
+		entry.Pinned = entry.Pinned || pinned
+	}
+	return entries, rows.Err()
+}
+
+func (s *Store) ListCategories(ctx context.Context, userID int64) ([]model.Category, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, created_at, sort_key, title, `order`, track, show_in_lib, deleted_at FROM categories\n"+
+		`WHERE user_id = ? AND COALESCE(deleted_at, 0) = 0 ORDER BY sort_key, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []model.Category
+	for rows.Next() {
+		cat := model.Category{UserID: userID}
+		if err := rows.Scan(&cat.ID, &cat.CreatedAt, &cat.SortKey, &cat.Title, &cat.Order, &cat.Track, &cat.ShowInLib, &cat.DeletedAt); err != nil {
+			return nil, err
+		}
+		categories = append(categories, cat)
+	}
+	return categories, rows.Err()
+}
+
+func (s *Store) CategoryIDs(ctx context.Context, userID int64) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM categories WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) AddManga(ctx context.Context, manga []model.Manga) error {
+	return s.withTxRetry(ctx, func(tx *db.Tx) error {
+		for i := range manga {
+			if err := insertManga(tx, &manga[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// searchCondition matches every term as a word prefix using the full-text
+// index of the dialect. Terms only contain letters and digits, so they need
+// no escaping beyond the quotes FTS5 takes.
+func (s *Store) searchCondition(terms []string) (string, string) {
+	parts := make([]string, len(terms))
+	switch s.db.Dialect {
+	case db.DialectMySQL:
+		for i, term := range terms {
+			parts[i] = "+" + term + "*"
+		}
+		return "MATCH (m.title, m.alt_title, m.author) AGAINST (? IN BOOLEAN MODE)", strings.Join(parts, " ")
+	case db.DialectPostgres:
+		for i, term := range terms {
+			parts[i] = term + ":*"
+		}
+		return searchVector + " @@ to_tsquery('simple', ?)", strings.Join(parts, " & ")
+	default:
+		for i, term := range terms {
+			parts[i] = `"` + term + `"*`
+		}
+		return "m.id IN (SELECT rowid FROM manga_fts WHERE manga_fts MATCH ?)", strings.Join(parts, " ")
+	}
+}
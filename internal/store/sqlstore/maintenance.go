@@ -0,0 +1,68 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+func (s *Store) PurgeDeletedUsers(ctx context.Context, now int64) (int, error) {
+	var purged int64
+	err := s.withTxRetry(ctx, func(tx *db.Tx) error {
+		// History, categories, favourites, bookmarks, reading events,
+		// sessions and webhooks go with the user via ON DELETE CASCADE.
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?`, now)
+		if err != nil {
+			return err
+		}
+		if purged, err = res.RowsAffected(); err != nil || purged == 0 {
+			return err
+		}
+		return deleteOrphans(ctx, tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(purged), nil
+}
+
+func (s *Store) PurgeUser(ctx context.Context, userID int64) error {
+	return s.withTxRetry(ctx, func(tx *db.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return store.ErrNotFound
+		}
+		return deleteOrphans(ctx, tx)
+	})
+}
+
+func (s *Store) PurgeSessions(ctx context.Context, before int64) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ? OR revoked_at <= ?`, before, before)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	return int(purged), err
+}
+
+// deleteOrphans drops the manga and tags nobody references anymore, as they
+// are shared between users. manga_tags rows cascade with their manga.
+func deleteOrphans(ctx context.Context, tx *db.Tx) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM manga
+	WHERE NOT EXISTS (SELECT 1 FROM history h WHERE h.manga_id = manga.id)
+	AND NOT EXISTS (SELECT 1 FROM favourites f WHERE f.manga_id = manga.id)
+	AND NOT EXISTS (SELECT 1 FROM bookmarks b WHERE b.manga_id = manga.id)
+	AND NOT EXISTS (SELECT 1 FROM reading_events e WHERE e.manga_id = manga.id)`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE NOT EXISTS (SELECT 1 FROM manga_tags mt WHERE mt.tag_id = tags.id)`)
+	return err
+}
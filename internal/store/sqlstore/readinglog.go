@@ -0,0 +1,96 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+func (s *Store) ListReadingEvents(ctx context.Context, userID int64, filter store.ReadingEventFilter) ([]model.ReadingEvent, error) {
+	query := `SELECT e.id, e.manga_id, e.chapter_id, e.page, e.percent, e.session_id, e.read_at, s.device_name
+	FROM reading_events e LEFT JOIN sessions s ON s.id = e.session_id
+	WHERE e.user_id = ?`
+	args := []any{userID}
+	if filter.From != 0 {
+		query += " AND e.read_at >= ?"
+		args = append(args, filter.From)
+	}
+	if filter.To != 0 {
+		query += " AND e.read_at < ?"
+		args = append(args, filter.To)
+	}
+	if filter.MangaID != 0 {
+		query += " AND e.manga_id = ?"
+		args = append(args, filter.MangaID)
+	}
+	query += " ORDER BY e.read_at DESC, e.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.ReadingEvent
+	mangaSet := make(map[int64]struct{})
+	for rows.Next() {
+		var e model.ReadingEvent
+		e.UserID = userID
+		if err := rows.Scan(&e.ID, &e.MangaID, &e.ChapterID, &e.Page, &e.Percent, &e.SessionID, &e.ReadAt, &e.DeviceName); err != nil {
+			return nil, err
+		}
+		mangaSet[e.MangaID] = struct{}{}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	mangaByID, err := s.fetchMangaMap(ctx, keysFromSet(mangaSet))
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		events[i].Manga = mangaByID[events[i].MangaID]
+	}
+	return events, nil
+}
+
+// recordReadingEvent appends item to the reading log when upsertHistory is
+// going to accept it and it moves the reading position. It has to run before
+// the upsert, which overwrites the row it compares against.
+func recordReadingEvent(tx *db.Tx, userID int64, sessionID string, item model.History) error {
+	if item.DeletedAt != 0 {
+		return nil
+	}
+
+	var updatedAt, chapterID, deletedAt int64
+	var page int
+	err := tx.QueryRow(`SELECT updated_at, chapter_id, page, deleted_at FROM history WHERE user_id = ? AND manga_id = ?`,
+		userID, item.MangaID).Scan(&updatedAt, &chapterID, &page, &deletedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case item.UpdatedAt < updatedAt:
+		return nil
+	case deletedAt == 0 && item.ChapterID == chapterID && item.Page == page:
+		return nil
+	}
+
+	var session *string
+	if sessionID != "" {
+		session = &sessionID
+	}
+	_, err = tx.Exec(`INSERT INTO reading_events (user_id, manga_id, chapter_id, page, percent, session_id, read_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, item.MangaID, item.ChapterID, item.Page, item.Percent, session, item.UpdatedAt)
+	return err
+}
@@ -0,0 +1,207 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const sessionColumns = `id, user_id, refresh_token_hash, previous_refresh_token_hash, created_at, refreshed_at, expires_at, revoked_at,
+	device_name, user_agent, ip_address, last_seen_at, last_history_sync_at, last_favourites_sync_at,
+	last_bookmarks_sync_at`
+
+func (s *Store) CreateSession(ctx context.Context, session *model.Session) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.RefreshTokenHash, session.PreviousRefreshTokenHash,
+		session.CreatedAt, session.RefreshedAt, session.ExpiresAt, session.RevokedAt,
+		session.DeviceName, session.UserAgent, session.IPAddress, session.LastSeenAt,
+		session.LastHistorySyncAt, session.LastFavouritesSyncAt, session.LastBookmarksSyncAt)
+	return err
+}
+
+func (s *Store) GetSession(ctx context.Context, id string) (*model.Session, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id)
+	session, err := scanSession(row)
+	return session, notFound(err)
+}
+
+func (s *Store) RotateSession(ctx context.Context, refreshTokenHash, newRefreshTokenHash string, now, expiresAt int64) (*model.Session, error) {
+	var session *model.Session
+	err := s.withTxRetry(ctx, func(tx *db.Tx) error {
+		var err error
+		session, err = scanSession(tx.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE refresh_token_hash = ?`, refreshTokenHash))
+		if errors.Is(err, sql.ErrNoRows) {
+			// A rotated token presented again means two parties hold it.
+			_, err = tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE previous_refresh_token_hash = ? AND revoked_at IS NULL`, now, refreshTokenHash)
+			if err != nil {
+				return err
+			}
+			session = nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if session.RevokedAt != nil || session.ExpiresAt <= now {
+			session = nil
+			return nil
+		}
+		var disabledAt *int64
+		if err := tx.QueryRowContext(ctx, `SELECT disabled_at FROM users WHERE id = ?`, session.UserID).Scan(&disabledAt); err != nil {
+			return err
+		}
+		if disabledAt != nil {
+			session = nil
+			return nil
+		}
+
+		// The token condition makes concurrent refreshes of the same token race
+		// for a single winner.
+		res, err := tx.ExecContext(ctx, `UPDATE sessions SET refresh_token_hash = ?, previous_refresh_token_hash = ?, refreshed_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ?`,
+			newRefreshTokenHash, refreshTokenHash, now, expiresAt, session.ID, refreshTokenHash)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			session = nil
+			return nil
+		}
+
+		session.PreviousRefreshTokenHash = &refreshTokenHash
+		session.RefreshTokenHash = newRefreshTokenHash
+		session.RefreshedAt = now
+		session.ExpiresAt = expiresAt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, store.ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *Store) ListSessions(ctx context.Context, userID int64, now int64) ([]model.Session, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sessionColumns+` FROM sessions
+	WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	ORDER BY last_seen_at DESC, created_at DESC`, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *Store) RenewSession(ctx context.Context, id, refreshTokenHash string, now, expiresAt int64, ipAddress string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET refresh_token_hash = ?, previous_refresh_token_hash = NULL, refreshed_at = ?, expires_at = ?,
+	ip_address = ?, last_seen_at = ? WHERE id = ? AND revoked_at IS NULL AND expires_at > ?`,
+		refreshTokenHash, now, expiresAt, ipAddress, now, id, now)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) TouchSession(ctx context.Context, id string, now int64, ipAddress, userAgent string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sessions SET last_seen_at = ?, ip_address = ?, user_agent = ? WHERE id = ?`,
+		now, ipAddress, userAgent, id)
+	return err
+}
+
+func (s *Store) RecordSessionSync(ctx context.Context, id string, resource string, now int64) error {
+	var query string
+	switch resource {
+	case store.SyncResourceHistory:
+		query = `UPDATE sessions SET last_history_sync_at = ? WHERE id = ?`
+	case store.SyncResourceFavourites:
+		query = `UPDATE sessions SET last_favourites_sync_at = ? WHERE id = ?`
+	case store.SyncResourceBookmarks:
+		query = `UPDATE sessions SET last_bookmarks_sync_at = ? WHERE id = ?`
+	default:
+		return fmt.Errorf("unknown sync resource %q", resource)
+	}
+	_, err := s.db.ExecContext(ctx, query, now, id)
+	return err
+}
+
+func (s *Store) RevokeSession(ctx context.Context, userID int64, id string, now int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`, now, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) RevokeOtherSessions(ctx context.Context, userID int64, keepID string, now int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL`, now, userID, keepID)
+	return err
+}
+
+func (s *Store) ListAllSessions(ctx context.Context, userID int64) ([]model.Session, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row scanner) (*model.Session, error) {
+	var session model.Session
+	err := row.Scan(&session.ID, &session.UserID, &session.RefreshTokenHash, &session.PreviousRefreshTokenHash,
+		&session.CreatedAt, &session.RefreshedAt, &session.ExpiresAt, &session.RevokedAt,
+		&session.DeviceName, &session.UserAgent, &session.IPAddress, &session.LastSeenAt,
+		&session.LastHistorySyncAt, &session.LastFavouritesSyncAt, &session.LastBookmarksSyncAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
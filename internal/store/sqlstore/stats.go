@@ -0,0 +1,171 @@
+package sqlstore
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const dayMillis = 24 * 60 * 60 * 1000
+
+// Activity is bucketed by integer division of read_at, which keeps the queries
+// portable: day 0 is 1970-01-01, a Thursday, so weeks are shifted by three
+// days to start on Monday.
+const (
+	dayBucket  = "read_at / 86400000"
+	weekBucket = "(read_at / 86400000 + 3) / 7"
+)
+
+func (s *Store) ReadingStats(ctx context.Context, userID int64, now int64) (*model.ReadingStats, error) {
+	stats := &model.ReadingStats{
+		Sources:  []model.SourceStats{},
+		Tags:     []model.TagStats{},
+		Activity: model.ReadingActivity{Days: []model.ActivityStats{}, Weeks: []model.ActivityStats{}},
+	}
+
+	// Percent is -1 while the app does not know the progress.
+	const completed = `SUM(CASE WHEN h.percent >= ? THEN 1 ELSE 0 END)`
+	const chapters = `SUM(CASE WHEN h.percent > 0 THEN h.percent * h.chapters ELSE 0 END)`
+
+	var chaptersRead float64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(`+completed+`, 0), COALESCE(`+chapters+`, 0)
+	FROM history h WHERE h.user_id = ? AND h.deleted_at = 0`, store.CompletedPercent, userID).
+		Scan(&stats.Totals.MangaStarted, &stats.Totals.MangaCompleted, &chaptersRead)
+	if err != nil {
+		return nil, err
+	}
+	stats.Totals.ChaptersRead = int64(math.Round(chaptersRead))
+
+	// Placeholder manga created for rows synced without their details have no source.
+	rows, err := s.db.QueryContext(ctx, `SELECT m.source, COUNT(*), `+completed+`, `+chapters+`
+	FROM history h JOIN manga m ON m.id = h.manga_id
+	WHERE h.user_id = ? AND h.deleted_at = 0 AND m.source <> ''
+	GROUP BY m.source ORDER BY COUNT(*) DESC, m.source`, store.CompletedPercent, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source model.SourceStats
+		if err := rows.Scan(&source.Source, &source.MangaStarted, &source.MangaCompleted, &chaptersRead); err != nil {
+			return nil, err
+		}
+		source.ChaptersRead = int64(math.Round(chaptersRead))
+		stats.Sources = append(stats.Sources, source)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `SELECT t.id, t.title, t.source, COUNT(*)
+	FROM history h JOIN manga_tags mt ON mt.manga_id = h.manga_id JOIN tags t ON t.id = mt.tag_id
+	WHERE h.user_id = ? AND h.deleted_at = 0
+	GROUP BY t.id, t.title, t.source ORDER BY COUNT(*) DESC, t.title LIMIT ?`, userID, store.StatsTags)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag model.TagStats
+		if err := rows.Scan(&tag.ID, &tag.Title, &tag.Source, &tag.MangaStarted); err != nil {
+			return nil, err
+		}
+		stats.Tags = append(stats.Tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	today := now / dayMillis
+	thisWeek := (today + 3) / 7
+	stats.Activity.Days, err = s.readingActivity(ctx, userID, dayBucket, (today-store.StatsDays+1)*dayMillis, func(day int64) int64 {
+		return day
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Activity.Weeks, err = s.readingActivity(ctx, userID, weekBucket, ((thisWeek-store.StatsWeeks+1)*7-3)*dayMillis, func(week int64) int64 {
+		return week*7 - 3
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Streaks, err = s.readingStreaks(ctx, userID, today)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readingActivity counts the manga and chapters read per bucket from the
+// reading log, starting at from. firstDay maps a bucket to the day it starts.
+func (s *Store) readingActivity(ctx context.Context, userID int64, bucket string, from int64, firstDay func(int64) int64) ([]model.ActivityStats, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+s.intDiv(bucket)+` AS bucket, COUNT(DISTINCT manga_id), COUNT(DISTINCT chapter_id)
+	FROM reading_events WHERE user_id = ? AND read_at >= ?
+	GROUP BY bucket ORDER BY bucket`, userID, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity := []model.ActivityStats{}
+	for rows.Next() {
+		var bucket int64
+		var stats model.ActivityStats
+		if err := rows.Scan(&bucket, &stats.Manga, &stats.Chapters); err != nil {
+			return nil, err
+		}
+		stats.Date = statsDate(firstDay(bucket))
+		activity = append(activity, stats)
+	}
+	return activity, rows.Err()
+}
+
+// readingStreaks finds the runs of consecutive reading days: numbering the
+// distinct days in order, the difference between a day and its number is the
+// same within a run. One is added so the difference cannot go below zero,
+// which MySQL refuses for the unsigned ROW_NUMBER. Events without a read time
+// are left out for the same reason.
+func (s *Store) readingStreaks(ctx context.Context, userID int64, today int64) (model.ReadingStreaks, error) {
+	var streaks model.ReadingStreaks
+	rows, err := s.db.QueryContext(ctx, `SELECT MAX(reading_day), COUNT(*) FROM (
+		SELECT reading_day, reading_day + 1 - ROW_NUMBER() OVER (ORDER BY reading_day) AS run
+		FROM (SELECT DISTINCT `+s.intDiv(dayBucket)+` AS reading_day FROM reading_events WHERE user_id = ? AND read_at > 0) days
+	) runs GROUP BY run`, userID)
+	if err != nil {
+		return streaks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var last int64
+		var length int
+		if err := rows.Scan(&last, &length); err != nil {
+			return streaks, err
+		}
+		streaks.Longest = max(streaks.Longest, length)
+		if last >= today-1 {
+			streaks.Current = length
+		}
+	}
+	return streaks, rows.Err()
+}
+
+// intDiv rewrites the integer divisions of expr for MySQL, where / always
+// yields a decimal.
+func (s *Store) intDiv(expr string) string {
+	if s.db.Dialect == db.DialectMySQL {
+		return strings.ReplaceAll(expr, " / ", " DIV ")
+	}
+	return expr
+}
+
+func statsDate(day int64) string {
+	return time.UnixMilli(day * dayMillis).UTC().Format(time.DateOnly)
+}
@@ -0,0 +1,77 @@
+// Package sqlstore implements the store interfaces on top of db.DB for every
+// supported SQL dialect.
+package sqlstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/metrics"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// Store is the SQL-backed implementation of the store interfaces.
+type Store struct {
+	db *db.DB
+}
+
+var (
+	_ store.UserStore        = (*Store)(nil)
+	_ store.InviteStore      = (*Store)(nil)
+	_ store.SessionStore     = (*Store)(nil)
+	_ store.HistoryStore     = (*Store)(nil)
+	_ store.LibraryStore     = (*Store)(nil)
+	_ store.BookmarkStore    = (*Store)(nil)
+	_ store.ImportStore      = (*Store)(nil)
+	_ store.ReadingLogStore  = (*Store)(nil)
+	_ store.StatsStore       = (*Store)(nil)
+	_ store.WebhookStore     = (*Store)(nil)
+	_ store.ExportStore      = (*Store)(nil)
+	_ store.AdminStore       = (*Store)(nil)
+	_ store.MaintenanceStore = (*Store)(nil)
+)
+
+func New(database *db.DB) *Store {
+	return &Store{db: database}
+}
+
+// withTxRetry runs fn in a transaction, retrying deadlocks and serialization
+// failures on server databases. SQLite serializes writers and never retries.
+func (s *Store) withTxRetry(ctx context.Context, fn func(tx *db.Tx) error) error {
+	maxAttempts := 1
+	if s.db.Dialect != db.DialectSQLite {
+		maxAttempts = 3
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		tx, txErr := s.db.BeginTx(ctx, nil)
+		if txErr != nil {
+			return txErr
+		}
+
+		err = fn(tx)
+		if err == nil {
+			if commitErr := tx.Commit(); commitErr == nil {
+				return nil
+			} else {
+				err = commitErr
+			}
+		}
+
+		_ = tx.Rollback()
+		reason := db.TxConflictReason(err)
+		if reason != "" {
+			metrics.TxConflicts.Inc(reason)
+		}
+		if reason == "" || attempt == maxAttempts {
+			return err
+		}
+		metrics.TxRetries.Inc()
+
+		time.Sleep(time.Duration(attempt*50) * time.Millisecond)
+	}
+
+	return err
+}
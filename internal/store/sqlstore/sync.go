@@ -0,0 +1,720 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+func (s *Store) HistoryTimestamp(ctx context.Context, userID int64) (*int64, error) {
+	return s.syncTimestamp(ctx, userID, "history_sync_timestamp")
+}
+
+func (s *Store) GetHistory(ctx context.Context, userID int64, since *int64) ([]model.History, error) {
+	return s.fetchHistory(ctx, userID, since)
+}
+
+func (s *Store) SyncHistory(ctx context.Context, userID int64, sessionID string, history []model.History, expected []int64) (int64, error) {
+	var now int64
+	err := s.withTxRetry(ctx, func(tx *db.Tx) error {
+		var err error
+		now, err = syncHistory(tx, userID, sessionID, history, expected)
+		return err
+	})
+	return now, err
+}
+
+func syncHistory(tx *db.Tx, userID int64, sessionID string, history []model.History, expected []int64) (int64, error) {
+	now, err := reserveSyncTimestamp(tx, userID, "history_sync_timestamp", time.Now().UnixMilli(), expected)
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range history {
+		if item.Manga != nil {
+			if err := upsertManga(tx, item.Manga); err != nil {
+				return 0, err
+			}
+		}
+		if err := recordReadingEvent(tx, userID, sessionID, item); err != nil {
+			return 0, err
+		}
+		if err := upsertHistory(tx, userID, item, now); err != nil {
+			return 0, err
+		}
+	}
+	return now, nil
+}
+
+func (s *Store) FavouritesTimestamp(ctx context.Context, userID int64) (*int64, error) {
+	return s.syncTimestamp(ctx, userID, "favourites_sync_timestamp")
+}
+
+func (s *Store) GetFavourites(ctx context.Context, userID int64, since *int64) ([]model.Favourite, []model.Category, error) {
+	return s.fetchFavouritesAndCategories(ctx, userID, since)
+}
+
+func (s *Store) SyncFavourites(ctx context.Context, userID int64, categories []model.Category, favourites []model.Favourite, expected []int64) (int64, error) {
+	var now int64
+	err := s.withTxRetry(ctx, func(tx *db.Tx) error {
+		var err error
+		now, err = syncFavourites(tx, userID, categories, favourites, expected)
+		return err
+	})
+	return now, err
+}
+
+func syncFavourites(tx *db.Tx, userID int64, categories []model.Category, favourites []model.Favourite, expected []int64) (int64, error) {
+	// Stable lock order reduces deadlock probability on concurrent sync requests.
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].ID < categories[j].ID
+	})
+	sort.Slice(favourites, func(i, j int) bool {
+		if favourites[i].MangaID != favourites[j].MangaID {
+			return favourites[i].MangaID < favourites[j].MangaID
+		}
+		return favourites[i].CategoryID < favourites[j].CategoryID
+	})
+
+	now, err := reserveSyncTimestamp(tx, userID, "favourites_sync_timestamp", time.Now().UnixMilli(), expected)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, category := range categories {
+		if err := upsertCategory(tx, userID, category, now); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, fav := range favourites {
+		if fav.Manga != nil {
+			if err := upsertManga(tx, fav.Manga); err != nil {
+				return 0, err
+			}
+		} else if fav.MangaID != 0 {
+			// Ensure manga record exists for foreign key constraint
+			if err := ensureMangaExists(tx, fav.MangaID); err != nil {
+				return 0, err
+			}
+		}
+
+		// Ensure category exists before inserting favourite
+		// This handles race conditions when multiple devices sync simultaneously
+		if err := ensureCategoryExists(tx, fav.CategoryID, userID, now); err != nil {
+			return 0, err
+		}
+
+		if err := upsertFavourite(tx, userID, fav, now); err != nil {
+			return 0, err
+		}
+	}
+	return now, nil
+}
+
+func (s *Store) BookmarksTimestamp(ctx context.Context, userID int64) (*int64, error) {
+	return s.syncTimestamp(ctx, userID, "bookmarks_sync_timestamp")
+}
+
+func (s *Store) GetBookmarks(ctx context.Context, userID int64, since *int64) ([]model.Bookmark, error) {
+	return s.fetchBookmarks(ctx, userID, since)
+}
+
+func (s *Store) SyncBookmarks(ctx context.Context, userID int64, bookmarks []model.Bookmark, expected []int64) (int64, error) {
+	var now int64
+	err := s.withTxRetry(ctx, func(tx *db.Tx) error {
+		var err error
+		now, err = syncBookmarks(tx, userID, bookmarks, expected)
+		return err
+	})
+	return now, err
+}
+
+func syncBookmarks(tx *db.Tx, userID int64, bookmarks []model.Bookmark, expected []int64) (int64, error) {
+	// Stable lock order reduces deadlock probability on concurrent sync requests.
+	sort.Slice(bookmarks, func(i, j int) bool {
+		if bookmarks[i].MangaID != bookmarks[j].MangaID {
+			return bookmarks[i].MangaID < bookmarks[j].MangaID
+		}
+		return bookmarks[i].PageID < bookmarks[j].PageID
+	})
+
+	now, err := reserveSyncTimestamp(tx, userID, "bookmarks_sync_timestamp", time.Now().UnixMilli(), expected)
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range bookmarks {
+		if item.Manga != nil {
+			if err := upsertManga(tx, item.Manga); err != nil {
+				return 0, err
+			}
+		} else if err := ensureMangaExists(tx, item.MangaID); err != nil {
+			return 0, err
+		}
+		if err := upsertBookmark(tx, userID, item, now); err != nil {
+			return 0, err
+		}
+	}
+	return now, nil
+}
+
+// ImportLibrary writes every resource of the import like its sync, in one
+// transaction.
+func (s *Store) ImportLibrary(ctx context.Context, userID int64, data store.LibraryImport) (map[string]int64, error) {
+	var timestamps map[string]int64
+	err := s.withTxRetry(ctx, func(tx *db.Tx) error {
+		timestamps = make(map[string]int64)
+		if len(data.Categories) > 0 || len(data.Favourites) > 0 {
+			now, err := syncFavourites(tx, userID, data.Categories, data.Favourites, nil)
+			if err != nil {
+				return err
+			}
+			timestamps[store.SyncResourceFavourites] = now
+		}
+		if len(data.History) > 0 {
+			now, err := syncHistory(tx, userID, "", data.History, nil)
+			if err != nil {
+				return err
+			}
+			timestamps[store.SyncResourceHistory] = now
+		}
+		if len(data.Bookmarks) > 0 {
+			now, err := syncBookmarks(tx, userID, data.Bookmarks, nil)
+			if err != nil {
+				return err
+			}
+			timestamps[store.SyncResourceBookmarks] = now
+		}
+		return nil
+	})
+	return timestamps, err
+}
+
+func (s *Store) syncTimestamp(ctx context.Context, userID int64, column string) (*int64, error) {
+	var query string
+	switch column {
+	case "history_sync_timestamp":
+		query = "SELECT history_sync_timestamp FROM users WHERE id = ?"
+	case "favourites_sync_timestamp":
+		query = "SELECT favourites_sync_timestamp FROM users WHERE id = ?"
+	case "bookmarks_sync_timestamp":
+		query = "SELECT bookmarks_sync_timestamp FROM users WHERE id = ?"
+	default:
+		return nil, fmt.Errorf("unknown sync timestamp column %q", column)
+	}
+
+	var timestamp sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if timestamp.Valid {
+		return &timestamp.Int64, nil
+	}
+	return nil, nil
+}
+
+// reserveSyncTimestamp bumps the user's sync timestamp at the start of a sync
+// transaction and returns it for use as the modification time of every row
+// written. Locking the user row first serializes concurrent syncs of the same
+// user and keeps timestamps strictly increasing, so a delta request never
+// misses rows committed by a slower concurrent transaction.
+// When expected is non-nil, the bump only happens if the current timestamp is
+// one of the expected versions; otherwise store.ErrPreconditionFailed is returned.
+func reserveSyncTimestamp(tx *db.Tx, userID int64, column string, now int64, expected []int64) (int64, error) {
+	switch column {
+	case "history_sync_timestamp", "favourites_sync_timestamp", "bookmarks_sync_timestamp":
+	default:
+		return 0, fmt.Errorf("unknown sync timestamp column %q", column)
+	}
+
+	update := fmt.Sprintf("UPDATE users SET %[1]s = CASE WHEN COALESCE(%[1]s, 0) >= ? THEN %[1]s + 1 ELSE ? END WHERE id = ?", column)
+	args := []any{now, now, userID}
+	if expected != nil {
+		if len(expected) == 0 {
+			return 0, store.ErrPreconditionFailed
+		}
+		update += fmt.Sprintf(" AND COALESCE(%s, 0) IN (%s)", column, makePlaceholders(len(expected)))
+		for _, version := range expected {
+			args = append(args, version)
+		}
+	}
+
+	res, err := tx.Exec(update, args...)
+	if err != nil {
+		return 0, err
+	}
+	if expected != nil {
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if affected == 0 {
+			return 0, store.ErrPreconditionFailed
+		}
+	}
+
+	var timestamp int64
+	if err := tx.QueryRow(fmt.Sprintf("SELECT %s FROM users WHERE id = ?", column), userID).Scan(&timestamp); err != nil {
+		return 0, err
+	}
+	return timestamp, nil
+}
+
+func upsertManga(tx *db.Tx, manga *model.Manga) error {
+	query := `INSERT INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+	title=excluded.title, alt_title=excluded.alt_title, url=excluded.url, public_url=excluded.public_url, rating=excluded.rating, content_rating=excluded.content_rating,
+	cover_url=excluded.cover_url, large_cover_url=excluded.large_cover_url, state=excluded.state, author=excluded.author, source=excluded.source, nsfw=excluded.nsfw`
+	if tx.Dialect == db.DialectMySQL {
+		query = `INSERT INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		title=VALUES(title), alt_title=VALUES(alt_title), url=VALUES(url), public_url=VALUES(public_url), rating=VALUES(rating), content_rating=VALUES(content_rating),
+		cover_url=VALUES(cover_url), large_cover_url=VALUES(large_cover_url), state=VALUES(state), author=VALUES(author), source=VALUES(source), nsfw=VALUES(nsfw)`
+	}
+
+	_, err := tx.Exec(query, manga.ID, manga.Title, manga.AltTitle, manga.URL, manga.PublicURL, manga.Rating, manga.ContentRating, manga.CoverURL, manga.LargeCoverURL, manga.State, manga.Author, manga.Source, manga.NSFW)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range manga.Tags {
+		if err := upsertTag(tx, tag); err != nil {
+			return err
+		}
+		tagLinkQuery := "INSERT INTO manga_tags (manga_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING"
+		if tx.Dialect == db.DialectMySQL {
+			tagLinkQuery = "INSERT IGNORE INTO manga_tags (manga_id, tag_id) VALUES (?, ?)"
+		}
+		if _, err := tx.Exec(tagLinkQuery, manga.ID, tag.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertManga adds a manga and its tags unless the manga is already stored,
+// in which case the stored row is left as it is.
+func insertManga(tx *db.Tx, manga *model.Manga) error {
+	query := `INSERT INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO NOTHING`
+	tagQuery := "INSERT INTO tags (id, title, `key`, source, pinned) VALUES (?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING"
+	tagLinkQuery := "INSERT INTO manga_tags (manga_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING"
+	if tx.Dialect == db.DialectMySQL {
+		query = `INSERT IGNORE INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		tagQuery = "INSERT IGNORE INTO tags (id, title, `key`, source, pinned) VALUES (?, ?, ?, ?, ?)"
+		tagLinkQuery = "INSERT IGNORE INTO manga_tags (manga_id, tag_id) VALUES (?, ?)"
+	}
+
+	res, err := tx.Exec(query, manga.ID, manga.Title, manga.AltTitle, manga.URL, manga.PublicURL, manga.Rating, manga.ContentRating, manga.CoverURL, manga.LargeCoverURL, manga.State, manga.Author, manga.Source, manga.NSFW)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil || inserted == 0 {
+		return err
+	}
+
+	for _, tag := range manga.Tags {
+		if _, err := tx.Exec(tagQuery, tag.ID, tag.Title, tag.Key, tag.Source, tag.Pinned); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(tagLinkQuery, manga.ID, tag.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureMangaExists inserts a placeholder manga record if it doesn't exist
+// This is needed when the app sends manga_id without manga object (for already-synced manga)
+func ensureMangaExists(tx *db.Tx, mangaID int64) error {
+	query := `INSERT INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO NOTHING`
+	if tx.Dialect == db.DialectMySQL {
+		query = `INSERT IGNORE INTO manga (id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	}
+	_, err := tx.Exec(query, mangaID, "", "", "", "", -1.0, "", "", "", "", "", "", false)
+	return err
+}
+
+// ensureCategoryExists inserts a placeholder category if it doesn't exist
+// This handles cases where favourites reference categories that haven't been synced yet
+func ensureCategoryExists(tx *db.Tx, categoryID int64, userID int64, modifiedAt int64) error {
+	query := `INSERT INTO categories (id, user_id, created_at, sort_key, title, ` + "`order`" + `, track, show_in_lib, deleted_at, modified_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id, user_id) DO NOTHING`
+	if tx.Dialect == db.DialectMySQL {
+		query = "INSERT IGNORE INTO categories (id, user_id, created_at, sort_key, title, `order`, track, show_in_lib, deleted_at, modified_at)\n" +
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	}
+	_, err := tx.Exec(query, categoryID, userID, 0, 0, "Unknown", "NEWEST", true, true, 0, modifiedAt)
+	return err
+}
+
+func upsertTag(tx *db.Tx, tag model.Tag) error {
+	query := "INSERT INTO tags (id, title, `key`, source, pinned) VALUES (?, ?, ?, ?, ?)\n" +
+		"ON CONFLICT(id) DO UPDATE SET title=excluded.title, `key`=excluded.`key`, source=excluded.source, pinned=excluded.pinned"
+	if tx.Dialect == db.DialectMySQL {
+		query = "INSERT INTO tags (id, title, `key`, source, pinned) VALUES (?, ?, ?, ?, ?)\n" +
+			"ON DUPLICATE KEY UPDATE title=VALUES(title), `key`=VALUES(`key`), source=VALUES(source), pinned=VALUES(pinned)"
+	}
+	_, err := tx.Exec(query, tag.ID, tag.Title, tag.Key, tag.Source, tag.Pinned)
+	return err
+}
+
+func upsertHistory(tx *db.Tx, userID int64, history model.History, modifiedAt int64) error {
+	query := `INSERT INTO history (manga_id, user_id, created_at, updated_at, chapter_id, page, scroll, percent, chapters, deleted_at, modified_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(user_id, manga_id) DO UPDATE SET
+	created_at=excluded.created_at, updated_at=excluded.updated_at, chapter_id=excluded.chapter_id, page=excluded.page,
+	scroll=excluded.scroll, percent=excluded.percent, chapters=excluded.chapters, deleted_at=excluded.deleted_at,
+	modified_at=excluded.modified_at
+	WHERE excluded.updated_at >= history.updated_at`
+	if tx.Dialect == db.DialectMySQL {
+		query = `INSERT INTO history (manga_id, user_id, created_at, updated_at, chapter_id, page, scroll, percent, chapters, deleted_at, modified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		modified_at=IF(VALUES(updated_at) >= updated_at, VALUES(modified_at), modified_at),
+		created_at=IF(VALUES(updated_at) >= updated_at, VALUES(created_at), created_at),
+		chapter_id=IF(VALUES(updated_at) >= updated_at, VALUES(chapter_id), chapter_id),
+		page=IF(VALUES(updated_at) >= updated_at, VALUES(page), page),
+		scroll=IF(VALUES(updated_at) >= updated_at, VALUES(scroll), scroll),
+		percent=IF(VALUES(updated_at) >= updated_at, VALUES(percent), percent),
+		chapters=IF(VALUES(updated_at) >= updated_at, VALUES(chapters), chapters),
+		deleted_at=IF(VALUES(updated_at) >= updated_at, VALUES(deleted_at), deleted_at),
+		updated_at=IF(VALUES(updated_at) >= updated_at, VALUES(updated_at), updated_at)`
+	}
+	_, err := tx.Exec(query, history.MangaID, userID, history.CreatedAt, history.UpdatedAt, history.ChapterID, history.Page, history.Scroll, history.Percent, history.Chapters, history.DeletedAt, modifiedAt)
+	return err
+}
+
+func upsertCategory(tx *db.Tx, userID int64, cat model.Category, modifiedAt int64) error {
+	query := "INSERT INTO categories (id, user_id, created_at, sort_key, title, `order`, track, show_in_lib, deleted_at, modified_at)\n" +
+		`VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id, user_id) DO UPDATE SET
+		created_at=excluded.created_at, sort_key=excluded.sort_key, title=excluded.title, ` + "`order`=excluded.`order`," + `
+		track=excluded.track, show_in_lib=excluded.show_in_lib, deleted_at=excluded.deleted_at, modified_at=excluded.modified_at
+		WHERE excluded.created_at > categories.created_at
+		OR (excluded.created_at = categories.created_at AND COALESCE(excluded.deleted_at, 0) >= COALESCE(categories.deleted_at, 0))`
+	if tx.Dialect == db.DialectMySQL {
+		query = `INSERT INTO categories (id, user_id, created_at, sort_key, title, ` + "`order`" + `, track, show_in_lib, deleted_at, modified_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE
+    modified_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(modified_at), modified_at),
+    sort_key=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(sort_key), sort_key),
+    title=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(title), title),
+    ` + "`order`" + `=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(` + "`order`" + `), ` + "`order`" + `),
+    track=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(track), track),
+    show_in_lib=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(show_in_lib), show_in_lib),
+    deleted_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(deleted_at), deleted_at),
+    created_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND COALESCE(VALUES(deleted_at), 0) >= COALESCE(deleted_at, 0)), VALUES(created_at), created_at)`
+	}
+	_, err := tx.Exec(query, cat.ID, userID, cat.CreatedAt, cat.SortKey, cat.Title, cat.Order, cat.Track, cat.ShowInLib, cat.DeletedAt, modifiedAt)
+	return err
+}
+
+func upsertFavourite(tx *db.Tx, userID int64, fav model.Favourite, modifiedAt int64) error {
+	query := `INSERT INTO favourites (manga_id, category_id, user_id, sort_key, pinned, created_at, deleted_at, modified_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(manga_id, category_id, user_id) DO UPDATE SET
+    category_id=excluded.category_id,
+    sort_key=excluded.sort_key, pinned=excluded.pinned,
+    created_at=excluded.created_at, deleted_at=excluded.deleted_at, modified_at=excluded.modified_at
+    WHERE excluded.created_at > favourites.created_at OR (excluded.created_at = favourites.created_at AND excluded.deleted_at > favourites.deleted_at)`
+	if tx.Dialect == db.DialectMySQL {
+		query = `INSERT INTO favourites (manga_id, category_id, user_id, sort_key, pinned, created_at, deleted_at, modified_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE
+    modified_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(modified_at), modified_at),
+    category_id=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(category_id), category_id),
+    sort_key=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(sort_key), sort_key),
+    pinned=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(pinned), pinned),
+    deleted_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(deleted_at), deleted_at),
+    created_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(created_at), created_at)`
+	}
+	_, err := tx.Exec(query, fav.MangaID, fav.CategoryID, userID, fav.SortKey, fav.Pinned, fav.CreatedAt, fav.DeletedAt, modifiedAt)
+	return err
+}
+
+func upsertBookmark(tx *db.Tx, userID int64, bookmark model.Bookmark, modifiedAt int64) error {
+	query := `INSERT INTO bookmarks (manga_id, page_id, user_id, chapter_id, page, scroll, image_url, percent, created_at, deleted_at, modified_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(user_id, manga_id, page_id) DO UPDATE SET
+    chapter_id=excluded.chapter_id, page=excluded.page, scroll=excluded.scroll, image_url=excluded.image_url, percent=excluded.percent,
+    created_at=excluded.created_at, deleted_at=excluded.deleted_at, modified_at=excluded.modified_at
+    WHERE excluded.created_at > bookmarks.created_at OR (excluded.created_at = bookmarks.created_at AND excluded.deleted_at > bookmarks.deleted_at)`
+	if tx.Dialect == db.DialectMySQL {
+		query = `INSERT INTO bookmarks (manga_id, page_id, user_id, chapter_id, page, scroll, image_url, percent, created_at, deleted_at, modified_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE
+    modified_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(modified_at), modified_at),
+    chapter_id=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(chapter_id), chapter_id),
+    page=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(page), page),
+    scroll=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(scroll), scroll),
+    image_url=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(image_url), image_url),
+    percent=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(percent), percent),
+    deleted_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(deleted_at), deleted_at),
+    created_at=IF(VALUES(created_at) > created_at OR (VALUES(created_at) = created_at AND VALUES(deleted_at) > deleted_at), VALUES(created_at), created_at)`
+	}
+	_, err := tx.Exec(query, bookmark.MangaID, bookmark.PageID, userID, bookmark.ChapterID, bookmark.Page, bookmark.Scroll, bookmark.ImageURL, bookmark.Percent, bookmark.CreatedAt, bookmark.DeletedAt, modifiedAt)
+	return err
+}
+
+// fetchHistory returns the user's history. When since is set, only rows
+// modified after it are returned, tombstones included.
+func (s *Store) fetchHistory(ctx context.Context, userID int64, since *int64) ([]model.History, error) {
+	query := `SELECT manga_id, created_at, updated_at, chapter_id, page, scroll, percent, chapters, deleted_at FROM history WHERE user_id = ?`
+	args := []any{userID}
+	if since != nil {
+		query += " AND modified_at > ?"
+		args = append(args, *since)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []model.History
+	mangaSet := make(map[int64]struct{})
+	for rows.Next() {
+		var hItem model.History
+		hItem.UserID = userID
+		if err := rows.Scan(&hItem.MangaID, &hItem.CreatedAt, &hItem.UpdatedAt, &hItem.ChapterID, &hItem.Page, &hItem.Scroll, &hItem.Percent, &hItem.Chapters, &hItem.DeletedAt); err != nil {
+			return nil, err
+		}
+		mangaSet[hItem.MangaID] = struct{}{}
+		history = append(history, hItem)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	mangaByID, err := s.fetchMangaMap(ctx, keysFromSet(mangaSet))
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		history[i].Manga = mangaByID[history[i].MangaID]
+	}
+
+	return history, nil
+}
+
+// fetchBookmarks returns the user's bookmarks. When since is set, only rows
+// modified after it are returned, tombstones included.
+func (s *Store) fetchBookmarks(ctx context.Context, userID int64, since *int64) ([]model.Bookmark, error) {
+	query := `SELECT manga_id, page_id, chapter_id, page, scroll, image_url, percent, created_at, deleted_at FROM bookmarks WHERE user_id = ?`
+	args := []any{userID}
+	if since != nil {
+		query += " AND modified_at > ?"
+		args = append(args, *since)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookmarks []model.Bookmark
+	mangaSet := make(map[int64]struct{})
+	for rows.Next() {
+		var b model.Bookmark
+		b.UserID = userID
+		if err := rows.Scan(&b.MangaID, &b.PageID, &b.ChapterID, &b.Page, &b.Scroll, &b.ImageURL, &b.Percent, &b.CreatedAt, &b.DeletedAt); err != nil {
+			return nil, err
+		}
+		mangaSet[b.MangaID] = struct{}{}
+		bookmarks = append(bookmarks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	mangaByID, err := s.fetchMangaMap(ctx, keysFromSet(mangaSet))
+	if err != nil {
+		return nil, err
+	}
+	for i := range bookmarks {
+		bookmarks[i].Manga = mangaByID[bookmarks[i].MangaID]
+	}
+
+	return bookmarks, nil
+}
+
+// fetchFavouritesAndCategories returns the user's favourites and categories.
+// When since is set, only rows modified after it are returned.
+func (s *Store) fetchFavouritesAndCategories(ctx context.Context, userID int64, since *int64) ([]model.Favourite, []model.Category, error) {
+	filter := ""
+	args := []any{userID}
+	if since != nil {
+		filter = " AND modified_at > ?"
+		args = append(args, *since)
+	}
+
+	// Categories
+	rows, err := s.db.QueryContext(ctx, "SELECT id, created_at, sort_key, title, `order`, track, show_in_lib, deleted_at FROM categories WHERE user_id = ?"+filter, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var categories []model.Category
+	for rows.Next() {
+		var cat model.Category
+		cat.UserID = userID
+		if err := rows.Scan(&cat.ID, &cat.CreatedAt, &cat.SortKey, &cat.Title, &cat.Order, &cat.Track, &cat.ShowInLib, &cat.DeletedAt); err != nil {
+			return nil, nil, err
+		}
+		categories = append(categories, cat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Favourites
+	favRows, err := s.db.QueryContext(ctx, `SELECT manga_id, category_id, sort_key, pinned, created_at, deleted_at FROM favourites WHERE user_id = ?`+filter, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer favRows.Close()
+
+	var favourites []model.Favourite
+	mangaSet := make(map[int64]struct{})
+	for favRows.Next() {
+		var fav model.Favourite
+		fav.UserID = userID
+		if err := favRows.Scan(&fav.MangaID, &fav.CategoryID, &fav.SortKey, &fav.Pinned, &fav.CreatedAt, &fav.DeletedAt); err != nil {
+			return nil, nil, err
+		}
+		mangaSet[fav.MangaID] = struct{}{}
+		favourites = append(favourites, fav)
+	}
+	if err := favRows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	mangaByID, err := s.fetchMangaMap(ctx, keysFromSet(mangaSet))
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range favourites {
+		favourites[i].Manga = mangaByID[favourites[i].MangaID]
+	}
+
+	return favourites, categories, nil
+}
+
+func keysFromSet(set map[int64]struct{}) []int64 {
+	keys := make([]int64, 0, len(set))
+	for id := range set {
+		keys = append(keys, id)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func makePlaceholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.TrimRight(strings.Repeat("?,", n), ",")
+}
+
+func (s *Store) fetchMangaMap(ctx context.Context, mangaIDs []int64) (map[int64]*model.Manga, error) {
+	mangaByID := make(map[int64]*model.Manga, len(mangaIDs))
+	if len(mangaIDs) == 0 {
+		return mangaByID, nil
+	}
+
+	args := make([]any, 0, len(mangaIDs))
+	for _, id := range mangaIDs {
+		args = append(args, id)
+	}
+
+	query := `SELECT id, title, alt_title, url, public_url, rating, content_rating, cover_url, large_cover_url, state, author, source, nsfw
+		FROM manga WHERE id IN (` + makePlaceholders(len(mangaIDs)) + `)`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m model.Manga
+		if err := rows.Scan(&m.ID, &m.Title, &m.AltTitle, &m.URL, &m.PublicURL, &m.Rating, &m.ContentRating, &m.CoverURL, &m.LargeCoverURL, &m.State, &m.Author, &m.Source, &m.NSFW); err != nil {
+			return nil, err
+		}
+		mCopy := m
+		mangaByID[m.ID] = &mCopy
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tagsByMangaID, err := s.fetchTagsByMangaIDs(ctx, mangaIDs)
+	if err != nil {
+		return nil, err
+	}
+	for mangaID, tags := range tagsByMangaID {
+		if manga := mangaByID[mangaID]; manga != nil {
+			manga.Tags = tags
+		}
+	}
+
+	return mangaByID, nil
+}
+
+func (s *Store) fetchTagsByMangaIDs(ctx context.Context, mangaIDs []int64) (map[int64][]model.Tag, error) {
+	tagsByMangaID := make(map[int64][]model.Tag, len(mangaIDs))
+	if len(mangaIDs) == 0 {
+		return tagsByMangaID, nil
+	}
+
+	args := make([]any, 0, len(mangaIDs))
+	for _, id := range mangaIDs {
+		args = append(args, id)
+	}
+
+	query := "SELECT mt.manga_id, t.id, t.title, t.`key`, t.source, t.pinned " +
+		"FROM tags t JOIN manga_tags mt ON t.id = mt.tag_id " +
+		"WHERE mt.manga_id IN (" + makePlaceholders(len(mangaIDs)) + ")"
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mangaID int64
+		var t model.Tag
+		if err := rows.Scan(&mangaID, &t.ID, &t.Title, &t.Key, &t.Source, &t.Pinned); err != nil {
+			return nil, err
+		}
+		tagsByMangaID[mangaID] = append(tagsByMangaID[mangaID], t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tagsByMangaID, nil
+}
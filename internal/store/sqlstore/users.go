@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// User queries live in db.DB so that tooling can share them; these methods
+// adapt them to store.UserStore.
+
+func (s *Store) CreateUser(ctx context.Context, email, passwordHash string) (int64, error) {
+	id, err := s.db.CreateUser(ctx, email, passwordHash)
+	return id, alreadyExists(err)
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id int64) (*model.User, error) {
+	user, err := s.db.GetUserByID(ctx, id)
+	return user, notFound(err)
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	user, err := s.db.GetUserByEmail(ctx, email)
+	return user, notFound(err)
+}
+
+func (s *Store) GetUserByResetToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	user, err := s.db.GetUserByResetToken(ctx, tokenHash)
+	return user, notFound(err)
+}
+
+func (s *Store) UserExists(ctx context.Context, id int64) (bool, error) {
+	return s.db.UserExists(ctx, id)
+}
+
+func (s *Store) ListUsers(ctx context.Context) ([]model.User, error) {
+	return s.db.ListUsers(ctx)
+}
+
+func (s *Store) SetUserRole(ctx context.Context, userID int64, role string) error {
+	return s.db.SetUserRole(ctx, userID, role)
+}
+
+func (s *Store) SetUserDisabled(ctx context.Context, userID int64, disabledAt *int64) error {
+	return s.db.SetUserDisabled(ctx, userID, disabledAt)
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	return s.db.UpdatePassword(ctx, userID, passwordHash)
+}
+
+func (s *Store) SetPasswordResetToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error {
+	return s.db.SetPasswordResetToken(ctx, userID, tokenHash, expiresAt)
+}
+
+func (s *Store) ClearResetToken(ctx context.Context, userID int64) error {
+	return s.db.ClearResetToken(ctx, userID)
+}
+
+func (s *Store) SetVerificationToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error {
+	return s.db.SetVerificationToken(ctx, userID, tokenHash, expiresAt)
+}
+
+func (s *Store) GetUserByVerificationToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	user, err := s.db.GetUserByVerificationToken(ctx, tokenHash)
+	return user, notFound(err)
+}
+
+func (s *Store) MarkEmailVerified(ctx context.Context, userID int64, verifiedAt int64) error {
+	return s.db.MarkEmailVerified(ctx, userID, verifiedAt)
+}
+
+func (s *Store) SetEmailChangeToken(ctx context.Context, userID int64, newEmail, tokenHash string, expiresAt int64) error {
+	return s.db.SetEmailChangeToken(ctx, userID, newEmail, tokenHash, expiresAt)
+}
+
+func (s *Store) GetUserByEmailChangeToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	user, err := s.db.GetUserByEmailChangeToken(ctx, tokenHash)
+	return user, notFound(err)
+}
+
+func (s *Store) ChangeEmail(ctx context.Context, userID int64, email string, verifiedAt int64) error {
+	return alreadyExists(s.db.ChangeEmail(ctx, userID, email, verifiedAt))
+}
+
+func (s *Store) ScheduleDeletion(ctx context.Context, userID int64, purgeAt int64, tokenHash string) error {
+	return s.db.ScheduleDeletion(ctx, userID, purgeAt, tokenHash)
+}
+
+func (s *Store) GetUserByDeletionToken(ctx context.Context, tokenHash string) (*model.User, error) {
+	user, err := s.db.GetUserByDeletionToken(ctx, tokenHash)
+	return user, notFound(err)
+}
+
+func (s *Store) CancelDeletion(ctx context.Context, userID int64) error {
+	return s.db.CancelDeletion(ctx, userID)
+}
+
+// notFound maps sql.ErrNoRows to store.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// alreadyExists maps unique index violations, such as of users.email, to
+// store.ErrAlreadyExists.
+func alreadyExists(err error) error {
+	if db.IsUniqueViolation(err) {
+		return store.ErrAlreadyExists
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package sqlstore
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+const (
+	webhookColumns  = `id, user_id, url, secret, events, enabled, created_at`
+	deliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.last_attempt_at, d.response_status, d.last_error, d.created_at`
+)
+
+func (s *Store) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO webhooks (`+webhookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		webhook.ID, webhook.UserID, webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","), webhook.Enabled, webhook.CreatedAt)
+	return err
+}
+
+func (s *Store) ListWebhooks(ctx context.Context, userID int64) ([]model.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []model.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *Store) GetWebhook(ctx context.Context, userID int64, id string) (*model.Webhook, error) {
+	webhook, err := scanWebhook(s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ? AND user_id = ?`, id, userID))
+	return webhook, notFound(err)
+}
+
+func (s *Store) UpdateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE webhooks SET url = ?, events = ?, enabled = ? WHERE id = ? AND user_id = ?`,
+		webhook.URL, strings.Join(webhook.Events, ","), webhook.Enabled, webhook.ID, webhook.UserID)
+	return err
+}
+
+func (s *Store) DeleteWebhook(ctx context.Context, userID int64, id string) error {
+	// Deliveries go with the webhook via ON DELETE CASCADE.
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) EnqueueWebhookDeliveries(ctx context.Context, userID int64, event, payload string, now int64) (int, error) {
+	webhooks, err := s.ListWebhooks(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	err = s.withTxRetry(ctx, func(tx *db.Tx) error {
+		queued = 0
+		for _, webhook := range webhooks {
+			if !webhook.Enabled || !slices.Contains(webhook.Events, event) {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO webhook_deliveries
+			(webhook_id, event, payload, status, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, 0, ?, ?)`,
+				webhook.ID, event, payload, store.WebhookPending, now, now); err != nil {
+				return err
+			}
+			queued++
+		}
+		return nil
+	})
+	return queued, err
+}
+
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID string, limit int) ([]model.WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries d
+	WHERE d.webhook_id = ? ORDER BY d.created_at DESC, d.id DESC LIMIT ?`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		if err := scanDelivery(rows, &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil int64, limit int) ([]model.WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+deliveryColumns+`, w.user_id, w.url, w.secret, w.events, w.enabled, w.created_at
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.status = ? AND d.next_attempt_at <= ? AND w.enabled = ?
+	ORDER BY d.next_attempt_at, d.id LIMIT ?`, store.WebhookPending, now, true, limit)
+	if err != nil {
+		return nil, err
+	}
+	var due []model.WebhookDelivery
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		var webhook model.Webhook
+		var events string
+		if err := scanDelivery(rows, &delivery, &webhook.UserID, &webhook.URL, &webhook.Secret, &events, &webhook.Enabled, &webhook.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		webhook.ID = delivery.WebhookID
+		webhook.Events = splitEvents(events)
+		delivery.Webhook = &webhook
+		due = append(due, delivery)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A delivery belongs to the worker whose lease update matched it; the
+	// others saw it moved away and leave it alone.
+	claimed := []model.WebhookDelivery{}
+	for _, delivery := range due {
+		res, err := s.db.ExecContext(ctx, `UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id = ? AND status = ? AND next_attempt_at = ?`, leaseUntil, delivery.ID, store.WebhookPending, delivery.NextAttemptAt)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if affected == 1 {
+			delivery.NextAttemptAt = leaseUntil
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *Store) FinishWebhookAttempt(ctx context.Context, delivery *model.WebhookDelivery) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?,
+	last_attempt_at = ?, response_status = ?, last_error = ? WHERE id = ?`,
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt,
+		delivery.ResponseStatus, delivery.LastError, delivery.ID)
+	return err
+}
+
+func (s *Store) DeleteWebhookDeliveries(ctx context.Context, before int64) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
+
+func scanWebhook(row scanner) (*model.Webhook, error) {
+	var webhook model.Webhook
+	var events string
+	err := row.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret, &events, &webhook.Enabled, &webhook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Events = splitEvents(events)
+	return &webhook, nil
+}
+
+// scanDelivery scans deliveryColumns into delivery, followed by extra.
+func scanDelivery(row scanner, delivery *model.WebhookDelivery, extra ...any) error {
+	dest := []any{&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status,
+		&delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastAttemptAt, &delivery.ResponseStatus,
+		&delivery.LastError, &delivery.CreatedAt}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// Events are stored as a comma-separated list of names.
+func splitEvents(events string) []string {
+	if events == "" {
+		return []string{}
+	}
+	return strings.Split(events, ",")
+}
@@ -0,0 +1,360 @@
+// Package store defines the persistence interfaces used by the HTTP handlers.
+// The SQL implementation lives in sqlstore and an in-memory one in memstore.
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when a unique value such as an email is taken.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrPreconditionFailed is returned by sync writes when the library version
+	// no longer matches one of the versions the client expected.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrInvalidInvite is returned when an invite code is unknown, revoked,
+	// expired or used up.
+	ErrInvalidInvite = errors.New("invalid invite")
+)
+
+// UserStore manages accounts, their password reset, email verification,
+// email change and deletion tokens.
+type UserStore interface {
+	CreateUser(ctx context.Context, email, passwordHash string) (int64, error)
+	GetUserByID(ctx context.Context, id int64) (*model.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
+	GetUserByResetToken(ctx context.Context, tokenHash string) (*model.User, error)
+	UserExists(ctx context.Context, id int64) (bool, error)
+	// ListUsers returns every account ordered by ID.
+	ListUsers(ctx context.Context) ([]model.User, error)
+	// SetUserRole sets the role of the user, one of the Role constants.
+	SetUserRole(ctx context.Context, userID int64, role string) error
+	// SetUserDisabled disables the account at disabledAt, or enables it when nil.
+	SetUserDisabled(ctx context.Context, userID int64, disabledAt *int64) error
+	UpdatePassword(ctx context.Context, userID int64, passwordHash string) error
+	SetPasswordResetToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error
+	ClearResetToken(ctx context.Context, userID int64) error
+	SetVerificationToken(ctx context.Context, userID int64, tokenHash string, expiresAt int64) error
+	GetUserByVerificationToken(ctx context.Context, tokenHash string) (*model.User, error)
+	// MarkEmailVerified records the verification and clears the token.
+	MarkEmailVerified(ctx context.Context, userID int64, verifiedAt int64) error
+	// SetEmailChangeToken stores newEmail as pending until the token is confirmed.
+	SetEmailChangeToken(ctx context.Context, userID int64, newEmail, tokenHash string, expiresAt int64) error
+	GetUserByEmailChangeToken(ctx context.Context, tokenHash string) (*model.User, error)
+	// ChangeEmail switches the user to a confirmed address, returning
+	// ErrAlreadyExists if another account uses it.
+	ChangeEmail(ctx context.Context, userID int64, email string, verifiedAt int64) error
+	// ScheduleDeletion marks the account for purging at purgeAt; the token
+	// cancels the deletion until then.
+	ScheduleDeletion(ctx context.Context, userID int64, purgeAt int64, tokenHash string) error
+	GetUserByDeletionToken(ctx context.Context, tokenHash string) (*model.User, error)
+	CancelDeletion(ctx context.Context, userID int64) error
+}
+
+// User roles. Admins can use the /admin endpoints.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// InviteStore manages invite codes for invite-only registration. Only code
+// hashes are stored. Times are Unix seconds.
+type InviteStore interface {
+	CreateInvite(ctx context.Context, invite *model.Invite) error
+	ListInvites(ctx context.Context) ([]model.Invite, error)
+	// RevokeInvite returns ErrNotFound if there is no unrevoked invite with that ID.
+	RevokeInvite(ctx context.Context, id string, now int64) error
+	// CreateUserWithInvite consumes one use of the invite and creates the user
+	// atomically. It returns ErrInvalidInvite or ErrAlreadyExists without
+	// consuming the invite.
+	CreateUserWithInvite(ctx context.Context, email, passwordHash, codeHash string, now int64) (int64, error)
+}
+
+// SessionStore manages login sessions and their rotating refresh tokens.
+// Only token hashes are stored. Times are Unix seconds.
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *model.Session) error
+	// GetSession returns the session with the given ID, revoked or not.
+	GetSession(ctx context.Context, id string) (*model.Session, error)
+	// RotateSession swaps the refresh token of the active session holding
+	// refreshTokenHash and extends it to expiresAt. Presenting an already
+	// rotated token revokes the session, as it indicates the token leaked.
+	// Unknown, expired, revoked and reused tokens, and tokens of disabled
+	// users, yield ErrNotFound.
+	RotateSession(ctx context.Context, refreshTokenHash, newRefreshTokenHash string, now, expiresAt int64) (*model.Session, error)
+	// RenewSession gives an active session whose device signs in again a new
+	// refresh token, without a previous one, and extends it to expiresAt. It
+	// returns ErrNotFound if the session is no longer active.
+	RenewSession(ctx context.Context, id, refreshTokenHash string, now, expiresAt int64, ipAddress string) error
+	// ListSessions returns the user's sessions that are neither revoked nor expired.
+	ListSessions(ctx context.Context, userID int64, now int64) ([]model.Session, error)
+	// TouchSession records activity on a session from the given client.
+	TouchSession(ctx context.Context, id string, now int64, ipAddress, userAgent string) error
+	// RecordSessionSync records a successful sync of resource, one of the
+	// SyncResource constants.
+	RecordSessionSync(ctx context.Context, id string, resource string, now int64) error
+	// RevokeSession revokes one of the user's active sessions, returning
+	// ErrNotFound if there is none with that ID.
+	RevokeSession(ctx context.Context, userID int64, id string, now int64) error
+	// RevokeOtherSessions revokes every active session of the user except keepID.
+	RevokeOtherSessions(ctx context.Context, userID int64, keepID string, now int64) error
+	// ListAllSessions returns every session of the user, including revoked
+	// and expired ones, oldest first.
+	ListAllSessions(ctx context.Context, userID int64) ([]model.Session, error)
+}
+
+// Resources tracked by RecordSessionSync.
+const (
+	SyncResourceHistory    = "history"
+	SyncResourceFavourites = "favourites"
+	SyncResourceBookmarks  = "bookmarks"
+)
+
+// HistoryStore manages the synchronized reading history.
+//
+// Sync writes follow last-writer-wins on updated_at, stamp every accepted row
+// with the new sync timestamp and return it. A non-nil expected slice makes
+// the write conditional on the current timestamp being one of its values.
+// Accepted rows that move the reading position are also appended to the
+// reading log, attributed to sessionID ("" when not known).
+type HistoryStore interface {
+	// HistoryTimestamp returns the user's history sync timestamp, nil if never synced.
+	HistoryTimestamp(ctx context.Context, userID int64) (*int64, error)
+	// GetHistory returns the user's history; with since set, only rows
+	// modified after it, tombstones included.
+	GetHistory(ctx context.Context, userID int64, since *int64) ([]model.History, error)
+	SyncHistory(ctx context.Context, userID int64, sessionID string, history []model.History, expected []int64) (int64, error)
+}
+
+// ReadingEventFilter narrows ListReadingEvents; zero values do not filter.
+// Times are Unix milliseconds.
+type ReadingEventFilter struct {
+	// From is the inclusive lower bound of read_at.
+	From int64
+	// To is the exclusive upper bound of read_at.
+	To      int64
+	MangaID int64
+	Limit   int
+}
+
+// ReadingLogStore reads the append-only log written by HistoryStore.SyncHistory.
+type ReadingLogStore interface {
+	// ListReadingEvents returns the user's reading events, newest first, with
+	// the name of the device that recorded them when it is known.
+	ListReadingEvents(ctx context.Context, userID int64, filter ReadingEventFilter) ([]model.ReadingEvent, error)
+}
+
+const (
+	// CompletedPercent is the history percent from which a manga counts as
+	// completed, leaving room for rounding on the last page.
+	CompletedPercent = 0.99
+	// StatsDays and StatsWeeks are how far back ReadingStats reports daily
+	// and weekly activity, the current day or week included.
+	StatsDays  = 90
+	StatsWeeks = 52
+	// StatsTags is the number of most read tags ReadingStats reports.
+	StatsTags = 20
+)
+
+// StatsStore aggregates the history and the reading log.
+type StatsStore interface {
+	// ReadingStats summarizes the user's history, tombstones excluded, and
+	// reading log as of now (Unix milliseconds).
+	ReadingStats(ctx context.Context, userID int64, now int64) (*model.ReadingStats, error)
+}
+
+// LibraryStore manages the synchronized favourites and categories.
+//
+// Categories win on newer created_at, favourites on newer created_at or a
+// newer tombstone. Timestamps and preconditions behave as in HistoryStore.
+type LibraryStore interface {
+	// FavouritesTimestamp returns the user's favourites sync timestamp, nil if never synced.
+	FavouritesTimestamp(ctx context.Context, userID int64) (*int64, error)
+	// GetFavourites returns the user's favourites and categories; with since
+	// set, only rows modified after it.
+	GetFavourites(ctx context.Context, userID int64, since *int64) ([]model.Favourite, []model.Category, error)
+	SyncFavourites(ctx context.Context, userID int64, categories []model.Category, favourites []model.Favourite, expected []int64) (int64, error)
+	// SearchLibrary returns a page of the user's favourite manga, one entry
+	// per manga whatever the number of categories it is in.
+	SearchLibrary(ctx context.Context, userID int64, query LibraryQuery) ([]model.LibraryEntry, error)
+	// ListCategories returns the user's categories that are not deleted, in
+	// the order of the app.
+	ListCategories(ctx context.Context, userID int64) ([]model.Category, error)
+	// CategoryIDs returns the IDs of all the user's categories, deleted ones
+	// included.
+	CategoryIDs(ctx context.Context, userID int64) ([]int64, error)
+	// AddManga stores the manga the server does not know yet, with their
+	// tags. Manga already stored are left unchanged, so that imported data
+	// cannot overwrite what the app synced.
+	AddManga(ctx context.Context, manga []model.Manga) error
+}
+
+// Library sort orders, named like the category orders of the app.
+const (
+	// LibraryNewest lists the most recently added favourites first; it is the default.
+	LibraryNewest            = "NEWEST"
+	LibraryOldest            = "OLDEST"
+	LibraryAlphabetic        = "ALPHABETIC"
+	LibraryAlphabeticReverse = "ALPHABETIC_REVERSE"
+	LibraryRating            = "RATING"
+)
+
+// LibraryQuery filters, sorts and pages SearchLibrary; zero values do not filter.
+type LibraryQuery struct {
+	// Terms are matched as word prefixes against the title, alternative
+	// title and author; all of them have to match.
+	Terms      []string
+	Source     string
+	TagKey     string
+	CategoryID int64
+	NSFW       *bool
+	State      string
+	// Pinned matches manga with a favourite row pinned, or not pinned, in
+	// any of their categories.
+	Pinned *bool
+	Sort   string
+	// After continues the listing behind the last entry of a previous page
+	// with the same sort order.
+	After *LibraryCursor
+	Limit int
+}
+
+// LibraryCursor is the position of a library entry in its sort order.
+type LibraryCursor struct {
+	MangaID int64
+	AddedAt int64
+	Title   string
+	Rating  float64
+}
+
+// BookmarkStore manages the synchronized page bookmarks.
+//
+// Bookmarks win on newer created_at or a newer tombstone, like favourites.
+// Timestamps and preconditions behave as in HistoryStore.
+type BookmarkStore interface {
+	// BookmarksTimestamp returns the user's bookmarks sync timestamp, nil if never synced.
+	BookmarksTimestamp(ctx context.Context, userID int64) (*int64, error)
+	// GetBookmarks returns the user's bookmarks; with since set, only rows
+	// modified after it, tombstones included.
+	GetBookmarks(ctx context.Context, userID int64, since *int64) ([]model.Bookmark, error)
+	SyncBookmarks(ctx context.Context, userID int64, bookmarks []model.Bookmark, expected []int64) (int64, error)
+}
+
+// LibraryImport holds the resources of a restored backup.
+type LibraryImport struct {
+	History    []model.History
+	Categories []model.Category
+	Favourites []model.Favourite
+	Bookmarks  []model.Bookmark
+}
+
+// ImportStore restores backups.
+type ImportStore interface {
+	// ImportLibrary merges every resource of the import like its sync, all
+	// or nothing, and returns the new sync timestamps of the resources it
+	// wrote, keyed by SyncResource.
+	ImportLibrary(ctx context.Context, userID int64, data LibraryImport) (map[string]int64, error)
+}
+
+// Export statuses.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// ExportStore tracks personal data archives built in the background.
+type ExportStore interface {
+	CreateExport(ctx context.Context, export *model.Export) error
+	GetExport(ctx context.Context, id string) (*model.Export, error)
+	GetExportByToken(ctx context.Context, tokenHash string) (*model.Export, error)
+	// GetPendingExport returns the user's export that is still being built,
+	// or ErrNotFound.
+	GetPendingExport(ctx context.Context, userID int64) (*model.Export, error)
+	// FinishExport records the outcome of a pending export.
+	FinishExport(ctx context.Context, id string, status string, size int64, now int64) error
+	// DeleteExpiredExports removes exports whose download link has expired.
+	DeleteExpiredExports(ctx context.Context, now int64) (int, error)
+	// CountLibraryEntries returns the number of history, favourite, bookmark
+	// and reading log rows of the user, tombstones included.
+	CountLibraryEntries(ctx context.Context, userID int64) (int, error)
+}
+
+// Webhook delivery statuses.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookStore manages the users' webhooks and the durable queue of their
+// deliveries. Times are Unix seconds.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook *model.Webhook) error
+	ListWebhooks(ctx context.Context, userID int64) ([]model.Webhook, error)
+	// GetWebhook returns ErrNotFound if the user has no webhook with that ID.
+	GetWebhook(ctx context.Context, userID int64, id string) (*model.Webhook, error)
+	// UpdateWebhook saves the URL, events and enabled flag of an existing webhook.
+	UpdateWebhook(ctx context.Context, webhook *model.Webhook) error
+	// DeleteWebhook removes the webhook with its deliveries, returning
+	// ErrNotFound if the user has no webhook with that ID.
+	DeleteWebhook(ctx context.Context, userID int64, id string) error
+	// EnqueueWebhookDeliveries queues the payload for every enabled webhook of
+	// the user subscribed to event and returns the number of deliveries.
+	EnqueueWebhookDeliveries(ctx context.Context, userID int64, event, payload string, now int64) (int, error)
+	// ListWebhookDeliveries returns the latest deliveries of a webhook, newest first.
+	ListWebhookDeliveries(ctx context.Context, webhookID string, limit int) ([]model.WebhookDelivery, error)
+	// ClaimWebhookDeliveries returns up to limit pending deliveries of enabled
+	// webhooks that are due at now, with their webhook set. Claimed deliveries
+	// are postponed to leaseUntil, so that concurrent workers skip them and a
+	// crashed worker's deliveries are retried after it.
+	ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil int64, limit int) ([]model.WebhookDelivery, error)
+	// FinishWebhookAttempt saves the status, attempts, next attempt and outcome
+	// of the latest attempt of a delivery.
+	FinishWebhookAttempt(ctx context.Context, delivery *model.WebhookDelivery) error
+	// DeleteWebhookDeliveries prunes the deliveries created before the given
+	// time, whatever their status, and returns their number.
+	DeleteWebhookDeliveries(ctx context.Context, before int64) (int, error)
+}
+
+// UserFilter narrows SearchUsers; zero values do not filter.
+type UserFilter struct {
+	// Query matches a part of the email, ignoring case.
+	Query    string
+	Role     string
+	Disabled *bool
+	// AfterID continues the listing behind the user with that ID.
+	AfterID int64
+	Limit   int
+}
+
+// AdminStore backs the admin API.
+type AdminStore interface {
+	// SearchUsers returns a page of accounts ordered by ID.
+	SearchUsers(ctx context.Context, filter UserFilter) ([]model.UserSummary, error)
+	// GetUserSummary returns ErrNotFound if there is no user with that ID.
+	GetUserSummary(ctx context.Context, userID int64) (*model.UserSummary, error)
+	// ServerStats counts the accounts and library rows of the server; users
+	// are active when one of their sessions was seen in the period before
+	// now (Unix seconds).
+	ServerStats(ctx context.Context, now int64) (*model.ServerStats, error)
+}
+
+// MaintenanceStore runs the background cleanup jobs.
+type MaintenanceStore interface {
+	// PurgeDeletedUsers removes accounts whose scheduled deletion time has
+	// passed, together with manga and tags no user references anymore. It
+	// returns the number of removed accounts.
+	PurgeDeletedUsers(ctx context.Context, now int64) (int, error)
+	// PurgeUser removes one account right away, together with manga and tags
+	// no user references anymore, or returns ErrNotFound.
+	PurgeUser(ctx context.Context, userID int64) error
+	// PurgeSessions removes sessions that expired or were revoked before
+	// before, returning their number.
+	PurgeSessions(ctx context.Context, before int64) (int, error)
+}
@@ -0,0 +1,467 @@
+// Package tachiyomi reads and writes Tachiyomi/Mihon backup files (.tachibk,
+// .proto.gz), which are gzip-compressed protobuf Backup messages. Only the
+// fields that have a Kotatsu counterpart are decoded; everything else is
+// skipped.
+package tachiyomi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MaxBackupSize limits the decompressed size of a backup.
+const MaxBackupSize = 256 << 20
+
+// Backup is the root message of a backup file.
+type Backup struct {
+	Manga      []Manga
+	Categories []Category
+	Sources    []Source
+}
+
+type Manga struct {
+	Source       int64
+	URL          string
+	Title        string
+	Artist       string
+	Author       string
+	Description  string
+	Genres       []string
+	Status       int64
+	ThumbnailURL string
+	DateAdded    int64
+	Chapters     []Chapter
+	// Categories holds the Order of the categories the manga belongs to.
+	Categories []int64
+	Favorite   bool
+	History    []History
+}
+
+type Category struct {
+	Name  string
+	Order int64
+	Flags int64
+}
+
+type Chapter struct {
+	URL           string
+	Name          string
+	Scanlator     string
+	Read          bool
+	Bookmark      bool
+	LastPageRead  int64
+	DateFetch     int64
+	DateUpload    int64
+	ChapterNumber float32
+	SourceOrder   int64
+}
+
+type History struct {
+	// URL is the URL of the chapter that was read.
+	URL          string
+	LastRead     int64
+	ReadDuration int64
+}
+
+type Source struct {
+	Name     string
+	SourceID int64
+}
+
+// Manga statuses of Tachiyomi's SManga.
+const (
+	StatusUnknown            = 0
+	StatusOngoing            = 1
+	StatusCompleted          = 2
+	StatusLicensed           = 3
+	StatusPublishingFinished = 4
+	StatusCancelled          = 5
+	StatusOnHiatus           = 6
+)
+
+// Field numbers of the backup messages.
+const (
+	fieldBackupManga      = 1
+	fieldBackupCategories = 2
+	fieldBackupSources    = 101
+
+	fieldMangaSource       = 1
+	fieldMangaURL          = 2
+	fieldMangaTitle        = 3
+	fieldMangaArtist       = 4
+	fieldMangaAuthor       = 5
+	fieldMangaDescription  = 6
+	fieldMangaGenre        = 7
+	fieldMangaStatus       = 8
+	fieldMangaThumbnailURL = 9
+	fieldMangaDateAdded    = 13
+	fieldMangaChapters     = 16
+	fieldMangaCategories   = 17
+	fieldMangaFavorite     = 100
+	fieldMangaHistory      = 104
+
+	fieldCategoryName  = 1
+	fieldCategoryOrder = 2
+	fieldCategoryFlags = 100
+
+	fieldChapterURL          = 1
+	fieldChapterName         = 2
+	fieldChapterScanlator    = 3
+	fieldChapterRead         = 4
+	fieldChapterBookmark     = 5
+	fieldChapterLastPageRead = 6
+	fieldChapterDateFetch    = 7
+	fieldChapterDateUpload   = 8
+	fieldChapterNumber       = 9
+	fieldChapterSourceOrder  = 10
+	fieldHistoryURL          = 1
+	fieldHistoryLastRead     = 2
+	fieldHistoryReadDuration = 3
+	fieldSourceName          = 1
+	fieldSourceID            = 2
+)
+
+var errBackupTooLarge = errors.New("backup is too large")
+
+// Read decodes a backup, gzip-compressed or not.
+func Read(r io.Reader) (*Backup, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxBackupSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		if data, err = io.ReadAll(io.LimitReader(zr, MaxBackupSize+1)); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > MaxBackupSize {
+		return nil, errBackupTooLarge
+	}
+	return Unmarshal(data)
+}
+
+// Write encodes the backup gzip-compressed, as Tachiyomi writes .tachibk files.
+func Write(w io.Writer, b *Backup) error {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(Marshal(b)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Unmarshal decodes an uncompressed Backup message.
+func Unmarshal(data []byte) (*Backup, error) {
+	var b Backup
+	err := eachField(data, func(num protowire.Number, f field) error {
+		switch num {
+		case fieldBackupManga:
+			m, err := unmarshalManga(f.bytes)
+			if err != nil {
+				return fmt.Errorf("manga: %w", err)
+			}
+			b.Manga = append(b.Manga, m)
+		case fieldBackupCategories:
+			var c Category
+			err := eachField(f.bytes, func(num protowire.Number, f field) error {
+				switch num {
+				case fieldCategoryName:
+					c.Name = string(f.bytes)
+				case fieldCategoryOrder:
+					c.Order = int64(f.varint)
+				case fieldCategoryFlags:
+					c.Flags = int64(f.varint)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("category: %w", err)
+			}
+			b.Categories = append(b.Categories, c)
+		case fieldBackupSources:
+			var s Source
+			err := eachField(f.bytes, func(num protowire.Number, f field) error {
+				switch num {
+				case fieldSourceName:
+					s.Name = string(f.bytes)
+				case fieldSourceID:
+					s.SourceID = int64(f.varint)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("source: %w", err)
+			}
+			b.Sources = append(b.Sources, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func unmarshalManga(data []byte) (Manga, error) {
+	var m Manga
+	err := eachField(data, func(num protowire.Number, f field) error {
+		switch num {
+		case fieldMangaSource:
+			m.Source = int64(f.varint)
+		case fieldMangaURL:
+			m.URL = string(f.bytes)
+		case fieldMangaTitle:
+			m.Title = string(f.bytes)
+		case fieldMangaArtist:
+			m.Artist = string(f.bytes)
+		case fieldMangaAuthor:
+			m.Author = string(f.bytes)
+		case fieldMangaDescription:
+			m.Description = string(f.bytes)
+		case fieldMangaGenre:
+			m.Genres = append(m.Genres, string(f.bytes))
+		case fieldMangaStatus:
+			m.Status = int64(f.varint)
+		case fieldMangaThumbnailURL:
+			m.ThumbnailURL = string(f.bytes)
+		case fieldMangaDateAdded:
+			m.DateAdded = int64(f.varint)
+		case fieldMangaChapters:
+			c, err := unmarshalChapter(f.bytes)
+			if err != nil {
+				return fmt.Errorf("chapter: %w", err)
+			}
+			m.Chapters = append(m.Chapters, c)
+		case fieldMangaCategories:
+			values, err := f.varints()
+			if err != nil {
+				return err
+			}
+			for _, v := range values {
+				m.Categories = append(m.Categories, int64(v))
+			}
+		case fieldMangaFavorite:
+			m.Favorite = f.varint != 0
+		case fieldMangaHistory:
+			var h History
+			err := eachField(f.bytes, func(num protowire.Number, f field) error {
+				switch num {
+				case fieldHistoryURL:
+					h.URL = string(f.bytes)
+				case fieldHistoryLastRead:
+					h.LastRead = int64(f.varint)
+				case fieldHistoryReadDuration:
+					h.ReadDuration = int64(f.varint)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("history: %w", err)
+			}
+			m.History = append(m.History, h)
+		}
+		return nil
+	})
+	return m, err
+}
+
+func unmarshalChapter(data []byte) (Chapter, error) {
+	var c Chapter
+	err := eachField(data, func(num protowire.Number, f field) error {
+		switch num {
+		case fieldChapterURL:
+			c.URL = string(f.bytes)
+		case fieldChapterName:
+			c.Name = string(f.bytes)
+		case fieldChapterScanlator:
+			c.Scanlator = string(f.bytes)
+		case fieldChapterRead:
+			c.Read = f.varint != 0
+		case fieldChapterBookmark:
+			c.Bookmark = f.varint != 0
+		case fieldChapterLastPageRead:
+			c.LastPageRead = int64(f.varint)
+		case fieldChapterDateFetch:
+			c.DateFetch = int64(f.varint)
+		case fieldChapterDateUpload:
+			c.DateUpload = int64(f.varint)
+		case fieldChapterNumber:
+			c.ChapterNumber = math.Float32frombits(f.fixed32)
+		case fieldChapterSourceOrder:
+			c.SourceOrder = int64(f.varint)
+		}
+		return nil
+	})
+	return c, err
+}
+
+// field is a decoded field value; which member is set depends on the wire type.
+type field struct {
+	typ     protowire.Type
+	varint  uint64
+	fixed32 uint32
+	bytes   []byte
+}
+
+// varints returns the values of a repeated integer field, which may be packed.
+func (f field) varints() ([]uint64, error) {
+	if f.typ == protowire.VarintType {
+		return []uint64{f.varint}, nil
+	}
+	if f.typ != protowire.BytesType {
+		return nil, fmt.Errorf("unexpected wire type %d for repeated integer", f.typ)
+	}
+	var values []uint64
+	for b := f.bytes; len(b) > 0; {
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		values = append(values, v)
+		b = b[n:]
+	}
+	return values, nil
+}
+
+// eachField calls fn for every field of a message.
+func eachField(b []byte, fn func(num protowire.Number, f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			f.fixed32, n = protowire.ConsumeFixed32(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marshal encodes an uncompressed Backup message. Like proto3, fields with
+// default values are omitted.
+func Marshal(b *Backup) []byte {
+	var out []byte
+	for _, m := range b.Manga {
+		out = appendMessage(out, fieldBackupManga, marshalManga(m))
+	}
+	for _, c := range b.Categories {
+		var msg []byte
+		msg = appendString(msg, fieldCategoryName, c.Name)
+		msg = appendVarint(msg, fieldCategoryOrder, uint64(c.Order))
+		msg = appendVarint(msg, fieldCategoryFlags, uint64(c.Flags))
+		out = appendMessage(out, fieldBackupCategories, msg)
+	}
+	for _, s := range b.Sources {
+		var msg []byte
+		msg = appendString(msg, fieldSourceName, s.Name)
+		msg = appendVarint(msg, fieldSourceID, uint64(s.SourceID))
+		out = appendMessage(out, fieldBackupSources, msg)
+	}
+	return out
+}
+
+func marshalManga(m Manga) []byte {
+	var out []byte
+	out = appendVarint(out, fieldMangaSource, uint64(m.Source))
+	out = appendString(out, fieldMangaURL, m.URL)
+	out = appendString(out, fieldMangaTitle, m.Title)
+	out = appendString(out, fieldMangaArtist, m.Artist)
+	out = appendString(out, fieldMangaAuthor, m.Author)
+	out = appendString(out, fieldMangaDescription, m.Description)
+	for _, genre := range m.Genres {
+		out = protowire.AppendTag(out, fieldMangaGenre, protowire.BytesType)
+		out = protowire.AppendString(out, genre)
+	}
+	out = appendVarint(out, fieldMangaStatus, uint64(m.Status))
+	out = appendString(out, fieldMangaThumbnailURL, m.ThumbnailURL)
+	out = appendVarint(out, fieldMangaDateAdded, uint64(m.DateAdded))
+	for _, c := range m.Chapters {
+		out = appendMessage(out, fieldMangaChapters, marshalChapter(c))
+	}
+	for _, category := range m.Categories {
+		out = protowire.AppendTag(out, fieldMangaCategories, protowire.VarintType)
+		out = protowire.AppendVarint(out, uint64(category))
+	}
+	if m.Favorite {
+		out = appendVarint(out, fieldMangaFavorite, 1)
+	}
+	for _, h := range m.History {
+		var msg []byte
+		msg = appendString(msg, fieldHistoryURL, h.URL)
+		msg = appendVarint(msg, fieldHistoryLastRead, uint64(h.LastRead))
+		msg = appendVarint(msg, fieldHistoryReadDuration, uint64(h.ReadDuration))
+		out = appendMessage(out, fieldMangaHistory, msg)
+	}
+	return out
+}
+
+func marshalChapter(c Chapter) []byte {
+	var out []byte
+	out = appendString(out, fieldChapterURL, c.URL)
+	out = appendString(out, fieldChapterName, c.Name)
+	out = appendString(out, fieldChapterScanlator, c.Scanlator)
+	if c.Read {
+		out = appendVarint(out, fieldChapterRead, 1)
+	}
+	if c.Bookmark {
+		out = appendVarint(out, fieldChapterBookmark, 1)
+	}
+	out = appendVarint(out, fieldChapterLastPageRead, uint64(c.LastPageRead))
+	out = appendVarint(out, fieldChapterDateFetch, uint64(c.DateFetch))
+	out = appendVarint(out, fieldChapterDateUpload, uint64(c.DateUpload))
+	if c.ChapterNumber != 0 {
+		out = protowire.AppendTag(out, fieldChapterNumber, protowire.Fixed32Type)
+		out = protowire.AppendFixed32(out, math.Float32bits(c.ChapterNumber))
+	}
+	out = appendVarint(out, fieldChapterSourceOrder, uint64(c.SourceOrder))
+	return out
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
@@ -0,0 +1,372 @@
+package tachiyomi
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+)
+
+// DefaultCategory receives favourites that are in no category in the backup,
+// since every Kotatsu favourite belongs to one.
+const DefaultCategory = "Default"
+
+// Skip explains why a backup entry was not imported.
+type Skip struct {
+	Title  string `json:"title"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// Library is the Kotatsu side of an imported backup, ready to be written
+// through the sync stores.
+type Library struct {
+	// Categories are the categories the backup needs that the user does not have yet.
+	Categories []model.Category
+	Favourites []model.Favourite
+	History    []model.History
+	// Manga is the number of imported manga.
+	Manga   int
+	Skipped []Skip
+}
+
+// ToLibrary maps a backup onto Kotatsu models. Backup categories are matched
+// to the user's existing ones by title; missing ones get new IDs. now, in
+// milliseconds, stamps created categories and favourites without a date.
+func ToLibrary(b *Backup, existing []model.Category, now int64) *Library {
+	lib := &Library{}
+
+	sources := make(map[int64]string, len(b.Sources))
+	for _, s := range b.Sources {
+		sources[s.SourceID] = s.Name
+	}
+
+	categoryIDs := make(map[string]int64)
+	var nextID int64
+	for _, c := range existing {
+		if c.DeletedAt == nil {
+			categoryIDs[c.Title] = c.ID
+		}
+		nextID = max(nextID, c.ID)
+	}
+	categoryID := func(title string, sortKey int) int64 {
+		if id, ok := categoryIDs[title]; ok {
+			return id
+		}
+		nextID++
+		categoryIDs[title] = nextID
+		lib.Categories = append(lib.Categories, model.Category{
+			ID:        nextID,
+			CreatedAt: now,
+			SortKey:   sortKey,
+			Title:     title,
+			Order:     "NEWEST",
+			Track:     true,
+			ShowInLib: true,
+		})
+		return nextID
+	}
+	categoryTitles := make(map[int64]string, len(b.Categories))
+	for _, c := range b.Categories {
+		categoryTitles[c.Order] = c.Name
+	}
+
+	for _, m := range b.Manga {
+		if m.Source == 0 {
+			lib.Skipped = append(lib.Skipped, Skip{Title: m.Title, Source: "Local source", Reason: "local manga are stored on the device"})
+			continue
+		}
+		name, ok := sources[m.Source]
+		if !ok {
+			lib.Skipped = append(lib.Skipped, Skip{Title: m.Title, Source: fmt.Sprint(m.Source), Reason: "source is not listed in the backup"})
+			continue
+		}
+		source := KotatsuSource(name)
+		if source == "" {
+			lib.Skipped = append(lib.Skipped, Skip{Title: m.Title, Source: name, Reason: "source has no Kotatsu equivalent"})
+			continue
+		}
+		if m.URL == "" {
+			lib.Skipped = append(lib.Skipped, Skip{Title: m.Title, Source: name, Reason: "manga has no URL"})
+			continue
+		}
+
+		manga := toManga(m, source)
+		history := toHistory(m, manga)
+		if !m.Favorite && history == nil {
+			lib.Skipped = append(lib.Skipped, Skip{Title: m.Title, Source: name, Reason: "manga is neither a favourite nor read"})
+			continue
+		}
+		lib.Manga++
+
+		if history != nil {
+			lib.History = append(lib.History, *history)
+		}
+		if !m.Favorite {
+			continue
+		}
+
+		createdAt := m.DateAdded
+		if createdAt == 0 {
+			createdAt = now
+		}
+		var titles []string
+		for _, order := range m.Categories {
+			if title, ok := categoryTitles[order]; ok {
+				titles = append(titles, title)
+			}
+		}
+		if len(titles) == 0 {
+			titles = []string{DefaultCategory}
+		}
+		for _, title := range titles {
+			lib.Favourites = append(lib.Favourites, model.Favourite{
+				MangaID:    manga.ID,
+				Manga:      manga,
+				CategoryID: categoryID(title, len(categoryIDs)),
+				CreatedAt:  createdAt,
+			})
+		}
+	}
+	return lib
+}
+
+func toManga(m Manga, source string) *model.Manga {
+	manga := &model.Manga{
+		ID:       generateUID(source, m.URL),
+		Title:    m.Title,
+		URL:      m.URL,
+		Rating:   -1,
+		CoverURL: m.ThumbnailURL,
+		Source:   source,
+	}
+	if u, err := url.Parse(m.URL); err == nil && u.IsAbs() {
+		manga.PublicURL = m.URL
+	}
+	if state := kotatsuState(m.Status); state != "" {
+		manga.State = &state
+	}
+	if m.Author != "" {
+		manga.Author = &m.Author
+	}
+	seen := make(map[string]bool)
+	for _, genre := range m.Genres {
+		genre = strings.TrimSpace(genre)
+		key := strings.ToLower(genre)
+		if genre == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		manga.Tags = append(manga.Tags, model.Tag{
+			ID:     generateUID(source, key),
+			Title:  genre,
+			Key:    key,
+			Source: source,
+		})
+	}
+	return manga
+}
+
+// toHistory returns the reading progress of a manga: the most recently read
+// chapter, or the furthest read one when the backup has no history entries.
+func toHistory(m Manga, manga *model.Manga) *model.History {
+	if len(m.Chapters) == 0 {
+		return nil
+	}
+
+	var current *Chapter
+	var updatedAt int64
+	chapters := make(map[string]*Chapter, len(m.Chapters))
+	read := 0
+	for i := range m.Chapters {
+		c := &m.Chapters[i]
+		chapters[c.URL] = c
+		if c.Read {
+			read++
+			// Sources list the newest chapter first.
+			if current == nil || c.SourceOrder < current.SourceOrder {
+				current = c
+				updatedAt = c.DateFetch
+			}
+		}
+	}
+	for _, h := range m.History {
+		if c, ok := chapters[h.URL]; ok && h.LastRead > updatedAt {
+			current = c
+			updatedAt = h.LastRead
+		}
+	}
+	if current == nil {
+		return nil
+	}
+
+	createdAt := m.DateAdded
+	if createdAt == 0 || createdAt > updatedAt {
+		createdAt = updatedAt
+	}
+	return &model.History{
+		MangaID:   manga.ID,
+		Manga:     manga,
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+		ChapterID: generateUID(manga.Source, current.URL),
+		Page:      int(current.LastPageRead),
+		Percent:   float64(read) / float64(len(m.Chapters)),
+		Chapters:  len(m.Chapters),
+	}
+}
+
+// FromLibrary builds a backup of the user's favourites. Kotatsu only keeps the
+// ID of the current chapter, which cannot be turned back into a chapter URL,
+// so reading progress is not exported.
+func FromLibrary(favourites []model.Favourite, categories []model.Category) *Backup {
+	b := &Backup{}
+
+	var active []model.Category
+	for _, c := range categories {
+		if c.DeletedAt == nil {
+			active = append(active, c)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool { return active[i].SortKey < active[j].SortKey })
+	orders := make(map[int64]int64, len(active))
+	for i, c := range active {
+		orders[c.ID] = int64(i)
+		b.Categories = append(b.Categories, Category{Name: c.Title, Order: int64(i)})
+	}
+
+	mangaIndex := make(map[int64]int)
+	sourceIDs := make(map[string]int64)
+	for _, f := range favourites {
+		if f.DeletedAt != 0 || f.Manga == nil {
+			continue
+		}
+		i, ok := mangaIndex[f.MangaID]
+		if !ok {
+			sourceID, ok := sourceIDs[f.Manga.Source]
+			if !ok {
+				sourceID = SourceID(f.Manga.Source)
+				sourceIDs[f.Manga.Source] = sourceID
+				b.Sources = append(b.Sources, Source{Name: f.Manga.Source, SourceID: sourceID})
+			}
+			i = len(b.Manga)
+			mangaIndex[f.MangaID] = i
+			b.Manga = append(b.Manga, fromManga(f.Manga, sourceID, f.CreatedAt))
+		}
+		if order, ok := orders[f.CategoryID]; ok {
+			b.Manga[i].Categories = append(b.Manga[i].Categories, order)
+		}
+		b.Manga[i].DateAdded = min(b.Manga[i].DateAdded, f.CreatedAt)
+	}
+	return b
+}
+
+func fromManga(manga *model.Manga, sourceID, dateAdded int64) Manga {
+	m := Manga{
+		Source:       sourceID,
+		URL:          manga.URL,
+		Title:        manga.Title,
+		ThumbnailURL: manga.CoverURL,
+		DateAdded:    dateAdded,
+		Favorite:     true,
+	}
+	if manga.Author != nil {
+		m.Author = *manga.Author
+	}
+	if manga.State != nil {
+		m.Status = tachiyomiStatus(*manga.State)
+	}
+	for _, tag := range manga.Tags {
+		m.Genres = append(m.Genres, tag.Title)
+	}
+	return m
+}
+
+// kotatsuSources maps Tachiyomi source names, lower-cased without spaces or
+// punctuation, to the Kotatsu parsers known to build the same manga and
+// chapter URLs. Other sources are not imported: their IDs would not match the
+// ones the app assigns.
+var kotatsuSources = map[string]string{
+	"asurascans":   "ASURASCANS",
+	"batoto":       "BATOTO",
+	"comick":       "COMICK_FUN",
+	"desu":         "DESUME",
+	"mangadex":     "MANGADEX",
+	"mangakakalot": "MANGAKAKALOT",
+	"mangalib":     "MANGALIB",
+	"manganato":    "MANGANATO",
+	"mangapark":    "MANGAPARK",
+	"mintmanga":    "MINTMANGA",
+	"nhentai":      "NHENTAI",
+	"readmanga":    "READMANGA_RU",
+	"remanga":      "REMANGA",
+	"weebcentral":  "WEEBCENTRAL",
+}
+
+// KotatsuSource maps a Tachiyomi source name to the name of the Kotatsu parser
+// (e.g. "Bato.to" to "BATOTO"). It returns "" for sources without a known
+// equivalent.
+func KotatsuSource(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return kotatsuSources[b.String()]
+}
+
+// SourceID returns the ID Tachiyomi derives for a source from its name,
+// language and version, the first 8 bytes of MD5("name/lang/version"). Kotatsu
+// sources are exported as multi-language sources of version 1.
+func SourceID(name string) int64 {
+	sum := md5.Sum([]byte(strings.ToLower(name) + "/all/1"))
+	return int64(binary.BigEndian.Uint64(sum[:8]) & math.MaxInt64)
+}
+
+// generateUID returns the ID Kotatsu parsers assign to manga, chapters and
+// tags: a Java-style string hash over the source name and the URL or key.
+func generateUID(source, key string) int64 {
+	h := int64(1125899906842597)
+	for _, c := range utf16.Encode([]rune(source)) {
+		h = 31*h + int64(c)
+	}
+	for _, c := range utf16.Encode([]rune(key)) {
+		h = 31*h + int64(c)
+	}
+	return h
+}
+
+func kotatsuState(status int64) string {
+	switch status {
+	case StatusOngoing:
+		return "ONGOING"
+	case StatusCompleted, StatusPublishingFinished:
+		return "FINISHED"
+	case StatusCancelled:
+		return "ABANDONED"
+	case StatusOnHiatus:
+		return "PAUSED"
+	}
+	return ""
+}
+
+func tachiyomiStatus(state string) int64 {
+	switch state {
+	case "ONGOING":
+		return StatusOngoing
+	case "FINISHED":
+		return StatusCompleted
+	case "ABANDONED":
+		return StatusCancelled
+	case "PAUSED":
+		return StatusOnHiatus
+	}
+	return StatusUnknown
+}
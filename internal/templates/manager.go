@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"html/template"
 	"path/filepath"
+	"sync"
 )
 
 type Manager struct {
 	templatesDir string
+	mu           sync.Mutex
 	cache        map[string]*template.Template
 }
 
@@ -20,21 +22,39 @@ func NewManager(templatesDir string) *Manager {
 }
 
 func (m *Manager) Render(templateName string, data interface{}) (string, error) {
-	tmpl, ok := m.cache[templateName]
+	return m.render(templateName, []string{templateName}, data)
+}
+
+// RenderPage renders a page within a layout: the layout is executed with the
+// templates the page defines, such as its title and content.
+func (m *Manager) RenderPage(layoutName, pageName string, data interface{}) (string, error) {
+	return m.render(pageName, []string{layoutName, pageName}, data)
+}
+
+// render executes the first of the files, parsed together.
+func (m *Manager) render(name string, files []string, data interface{}) (string, error) {
+	key := fmt.Sprint(files)
+	m.mu.Lock()
+	tmpl, ok := m.cache[key]
 	if !ok {
 		// Lazily load template
-		path := filepath.Join(m.templatesDir, templateName)
+		paths := make([]string, len(files))
+		for i, file := range files {
+			paths[i] = filepath.Join(m.templatesDir, file)
+		}
 		var err error
-		tmpl, err = template.ParseFiles(path)
+		tmpl, err = template.ParseFiles(paths...)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse template %s: %w", templateName, err)
+			m.mu.Unlock()
+			return "", fmt.Errorf("failed to parse template %s: %w", name, err)
 		}
-		m.cache[templateName] = tmpl
+		m.cache[key] = tmpl
 	}
+	m.mu.Unlock()
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute template %s: %w", templateName, err)
+		return "", fmt.Errorf("failed to execute template %s: %w", name, err)
 	}
 
 	return buf.String(), nil
@@ -39,7 +39,14 @@ func resetMySQLTables(t *testing.T, database *db.DB) {
 		"TRUNCATE TABLE tags",
 		"TRUNCATE TABLE favourites",
 		"TRUNCATE TABLE history",
+		"TRUNCATE TABLE bookmarks",
+		"TRUNCATE TABLE reading_events",
+		"TRUNCATE TABLE webhook_deliveries",
+		"TRUNCATE TABLE webhooks",
 		"TRUNCATE TABLE categories",
+		"TRUNCATE TABLE sessions",
+		"TRUNCATE TABLE invites",
+		"TRUNCATE TABLE exports",
 		"TRUNCATE TABLE manga",
 		"TRUNCATE TABLE users",
 		"SET FOREIGN_KEY_CHECKS=1",
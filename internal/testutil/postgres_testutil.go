@@ -0,0 +1,44 @@
+package testutil
+
+import (
+	"os"
+	"testing"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/db"
+)
+
+// SetupPostgresTestDB initializes a PostgreSQL-backed DB for integration tests.
+// It skips tests when POSTGRES_TEST_DSN is not set.
+func SetupPostgresTestDB(t *testing.T) *db.DB {
+	t.Helper()
+
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set; skipping PostgreSQL integration tests")
+	}
+
+	database, err := db.OpenDialect(db.DialectPostgres, dsn)
+	if err != nil {
+		t.Fatalf("failed to open postgres test db: %v", err)
+	}
+	if _, err := database.MigrateUp(t.Context()); err != nil {
+		database.Close()
+		t.Fatalf("failed to migrate postgres test db: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	resetPostgresTables(t, database)
+	return database
+}
+
+func resetPostgresTables(t *testing.T, database *db.DB) {
+	t.Helper()
+
+	stmt := "TRUNCATE TABLE manga_tags, tags, favourites, history, bookmarks, reading_events, webhook_deliveries, webhooks, categories, sessions, invites, exports, manga, users RESTART IDENTITY CASCADE"
+	if _, err := database.Exec(stmt); err != nil {
+		t.Fatalf("postgres reset failed: %v", err)
+	}
+}
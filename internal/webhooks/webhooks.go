@@ -0,0 +1,328 @@
+// Package webhooks queues the events of a user's account for the webhooks
+// they registered and delivers them in the background. Deliveries are stored
+// before they are sent, so they survive restarts and are retried with
+// exponential backoff until the endpoint accepts them.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/theLastOfCats/kotatsu-go-server/internal/auth"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/model"
+	"github.com/theLastOfCats/kotatsu-go-server/internal/store"
+)
+
+// Event names a webhook can subscribe to.
+const (
+	EventHistoryUpdated   = "history.updated"
+	EventFavouriteAdded   = "favourite.added"
+	EventFavouriteRemoved = "favourite.removed"
+	EventCategoryCreated  = "category.created"
+	EventPasswordChanged  = "password.changed"
+)
+
+// Events lists every event name.
+var Events = []string{
+	EventHistoryUpdated,
+	EventFavouriteAdded,
+	EventFavouriteRemoved,
+	EventCategoryCreated,
+	EventPasswordChanged,
+}
+
+const (
+	// MaxAttempts is the number of attempts after which a delivery is given up.
+	MaxAttempts = 12
+	// RequestTimeout bounds a single attempt.
+	RequestTimeout = 10 * time.Second
+
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+	// claimLease is how long a claimed delivery is hidden from other workers;
+	// it has to outlast an attempt.
+	claimLease = 2 * RequestTimeout
+	batchSize  = 50
+	// maxErrorLength bounds the error or response excerpt kept in the log.
+	maxErrorLength = 512
+)
+
+// Payload is the JSON body POSTed to the webhooks.
+type Payload struct {
+	// ID identifies the event; it is the same for every webhook it is
+	// delivered to and across retries.
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	CreatedAt int64  `json:"created_at"`
+	Data      any    `json:"data,omitempty"`
+}
+
+// Dispatcher queues and delivers webhook events.
+type Dispatcher struct {
+	Store store.WebhookStore
+	// Client sends the deliveries. When nil, a client refusing to connect to
+	// addresses that are not globally reachable is used.
+	Client *http.Client
+	// Retention is how long deliveries stay in the log; zero keeps a week.
+	Retention time.Duration
+}
+
+// Subscribed tells whether the user has an enabled webhook for any of the
+// events, so callers can skip building payloads nobody receives.
+func (d *Dispatcher) Subscribed(ctx context.Context, userID int64, events ...string) (bool, error) {
+	webhooks, err := d.Store.ListWebhooks(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, webhook := range webhooks {
+		for _, event := range events {
+			if webhook.Enabled && slices.Contains(webhook.Events, event) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Enqueue queues the event with data for the user's subscribed webhooks.
+func (d *Dispatcher) Enqueue(ctx context.Context, userID int64, event string, data any) error {
+	id, err := auth.GenerateSessionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	body, err := json.Marshal(Payload{ID: id, Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = d.Store.EnqueueWebhookDeliveries(ctx, userID, event, string(body), now)
+	return err
+}
+
+// Run delivers due deliveries every interval and prunes the delivery log
+// once an hour, until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil {
+			slog.ErrorContext(ctx, "Webhook delivery failed", "error", err)
+		}
+		if time.Since(lastPrune) >= time.Hour {
+			lastPrune = time.Now()
+			retention := d.Retention
+			if retention <= 0 {
+				retention = 7 * 24 * time.Hour
+			}
+			if _, err := d.Store.DeleteWebhookDeliveries(ctx, time.Now().Add(-retention).Unix()); err != nil {
+				slog.ErrorContext(ctx, "Webhook delivery log cleanup failed", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts the deliveries that are due, batch after batch, and
+// returns the number of attempts made.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	attempted := 0
+	for {
+		now := time.Now()
+		deliveries, err := d.Store.ClaimWebhookDeliveries(ctx, now.Unix(), now.Add(claimLease).Unix(), batchSize)
+		if err != nil {
+			return attempted, err
+		}
+		for i := range deliveries {
+			if err := d.attempt(ctx, &deliveries[i]); err != nil {
+				return attempted, err
+			}
+			attempted++
+		}
+		if len(deliveries) < batchSize {
+			return attempted, nil
+		}
+	}
+}
+
+// attempt sends the delivery once and records the outcome: delivered on a
+// 2xx response, otherwise retried later or failed after MaxAttempts.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *model.WebhookDelivery) error {
+	status, err := d.send(ctx, delivery)
+	now := time.Now()
+	delivery.Attempts++
+	attemptedAt := now.Unix()
+	delivery.LastAttemptAt = &attemptedAt
+	delivery.ResponseStatus = nil
+	delivery.LastError = nil
+	if status != 0 {
+		delivery.ResponseStatus = &status
+	}
+	switch {
+	case err == nil:
+		delivery.Status = store.WebhookDelivered
+	case delivery.Attempts >= MaxAttempts:
+		delivery.Status = store.WebhookFailed
+	default:
+		delivery.NextAttemptAt = now.Add(RetryDelay(delivery.Attempts)).Unix()
+	}
+	if err != nil {
+		message := err.Error()
+		if len(message) > maxErrorLength {
+			message = message[:maxErrorLength]
+		}
+		delivery.LastError = &message
+	}
+	return d.Store.FinishWebhookAttempt(ctx, delivery)
+}
+
+// send POSTs the payload and returns the response status, 0 when there was
+// no response.
+func (d *Dispatcher) send(ctx context.Context, delivery *model.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
+	defer cancel()
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kotatsu-go-server-webhooks")
+	req.Header.Set("X-Kotatsu-Event", delivery.Event)
+	req.Header.Set("X-Kotatsu-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Kotatsu-Timestamp", timestamp)
+	req.Header.Set("X-Kotatsu-Signature", "sha256="+Sign(delivery.Webhook.Secret, timestamp, body))
+
+	client := d.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(excerpt))
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the webhook secret, as sent in X-Kotatsu-Signature. Receivers compare
+// it with hmac.Equal and reject old timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RetryDelay is the wait after the given number of failed attempts: 30s,
+// doubling each time, at most 6h.
+func RetryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// nonPublicPrefixes are the special-purpose ranges of the IANA registries
+// that are not globally reachable, such as private, carrier-grade NAT and
+// benchmarking networks.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// IPv4-compatible addresses, including the unspecified and loopback ones.
+	netip.MustParsePrefix("::/96"),
+	// NAT64 and 6to4 addresses reach IPv4 networks through a translator.
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// publicAddress reports whether addr is globally reachable. IPv4-mapped IPv6
+// addresses are checked as the IPv4 address they carry.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultClient does not follow redirects and only connects to public
+// addresses, so webhooks cannot be pointed at the server's own network.
+var defaultClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: RequestTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !publicAddress(addrPort.Addr()) {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: RequestTimeout,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
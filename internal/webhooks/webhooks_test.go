@@ -0,0 +1,40 @@
+package webhooks
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	for address, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::1111":      true,
+		"100.64.0.1":           false,
+		"100.127.255.254":      false,
+		"0.1.2.3":              false,
+		"10.0.0.1":             false,
+		"127.0.0.1":            false,
+		"169.254.169.254":      false,
+		"198.18.0.1":           false,
+		"255.255.255.255":      false,
+		"::1":                  false,
+		"::ffff:100.64.0.1":    false,
+		"::ffff:93.184.216.34": true,
+		"64:ff9b::a00:1":       false,
+		"fd00::1":              false,
+		"fe80::1%eth0":         false,
+	} {
+		if got := publicAddress(netip.MustParseAddr(address)); got != want {
+			t.Errorf("publicAddress(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestDefaultClientRefusesCarrierNAT(t *testing.T) {
+	// The address is refused before a connection is attempted.
+	_, err := defaultClient.Get("http://100.64.0.1/hook")
+	if !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("expected a 100.64.0.0/10 target to be refused, got %v", err)
+	}
+}